@@ -0,0 +1,55 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"fmt"
+	"time"
+)
+
+// afPacketHandle stub, AF_PACKET only exists on linux
+type afPacketHandle struct{}
+
+// afPacketFanout stub
+type afPacketFanout struct{}
+
+func newAFPacketRX(iface string, group *afPacketFanout) (*afPacketHandle, error) {
+	return nil, fmt.Errorf("AF_PACKET not available on this system")
+}
+
+func newAFPacketTX(iface string) (*afPacketHandle, error) {
+	return nil, fmt.Errorf("AF_PACKET not available on this system")
+}
+
+func (h *afPacketHandle) ReadPacket() ([]byte, time.Time, bool, error) {
+	return nil, time.Time{}, false, fmt.Errorf("AF_PACKET not available on this system")
+}
+
+func (h *afPacketHandle) WritePacket(data []byte) error {
+	return fmt.Errorf("AF_PACKET not available on this system")
+}
+
+func (h *afPacketHandle) Stats() (Stats, error) {
+	return Stats{}, fmt.Errorf("AF_PACKET not available on this system")
+}
+
+func (h *afPacketHandle) Close() error {
+	return nil
+}
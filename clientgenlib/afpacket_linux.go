@@ -0,0 +1,434 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+// AF_PACKET backend, mmap'd TPACKET_V3 RX and TX rings
+// works on any stock linux kernel, no pf_ring module needed
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/google/gopacket/layers"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// RX ring, kernel fills whole blocks of packets and hands them over
+	afPacketRXBlockSize = 1 << 20
+	afPacketRXNumBlocks = 64
+	afPacketRXFrameSize = 2048
+	// retire a partially filled block after this long so low rates aren't delayed
+	afPacketRXBlockTimeoutMs = 1
+
+	// TX ring, fixed size frames, kernel walks them on send()
+	afPacketTXFrameSize = 2048
+	afPacketTXBlockSize = 1 << 20
+	afPacketTXNumBlocks = 8
+	// aligned tpacket3_hdr, RX frames have their sockaddr_ll right after it
+	afPacketHdrLen = (unix.SizeofTpacket3Hdr + unix.TPACKET_ALIGNMENT - 1) &^ (unix.TPACKET_ALIGNMENT - 1)
+	// frame data starts right after the aligned tpacket3_hdr for TX
+	afPacketTXDataOffset = afPacketHdrLen
+
+	// how long one read / write waits on poll before giving up
+	afPacketPollTimeoutMs = 100
+
+	// offset of tpacket_hdr_v1 inside tpacket_block_desc
+	afPacketBlockHdrOffset = 8
+)
+
+// afPacketHandle is one AF_PACKET socket with either an RX or a TX ring mapped
+type afPacketHandle struct {
+	fd    int
+	iface string
+	ring  []byte
+
+	blockSize int
+	numBlocks int
+
+	// RX walking state
+	curBlock   int
+	blockInUse bool
+	pktsLeft   uint32
+	pktOffset  uint32
+
+	// TX walking state
+	frameSize int
+	numFrames int
+	curFrame  int
+
+	hwTimestamps bool
+
+	rxPackets uint64
+	rxDropped uint64
+}
+
+func htons(v uint16) uint16 {
+	return (v << 8) | (v >> 8)
+}
+
+// afPacketFanout is the fanout group the RX sockets of one run share. the kernel picks a free ID
+// for the first socket, so other processes and interfaces never end up in the same group
+type afPacketFanout struct {
+	mu  sync.Mutex
+	id  int
+	set bool
+}
+
+// join adds fd to the group, creating it the first time
+func (g *afPacketFanout) join(fd int) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.set {
+		return unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_FANOUT, g.id|(unix.PACKET_FANOUT_CBPF<<16))
+	}
+	if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_FANOUT, (unix.PACKET_FANOUT_CBPF|unix.PACKET_FANOUT_FLAG_UNIQUEID)<<16); err != nil {
+		return err
+	}
+	v, err := unix.GetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_FANOUT)
+	if err != nil {
+		return err
+	}
+	g.id, g.set = v&0xffff, true
+	return nil
+}
+
+// afPacketFanoutProg picks the RX socket of a frame by the rxClientKey of its client, so a client's Sync
+// and FollowUp land on the same RX worker. PACKET_FANOUT_HASH won't do, its flow hash includes the UDP ports
+// and event and general messages go to 319 and 320. the kernel takes the return value modulo the group size
+var afPacketFanoutProg = []unix.SockFilter{
+	{Code: unix.BPF_LDX | unix.BPF_IMM, K: 0},
+	{Code: unix.BPF_LD | unix.BPF_H | unix.BPF_IND, K: ethTypeOffset},
+	{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: uint32(layers.EthernetTypeDot1Q), Jt: 0, Jf: 2},
+	{Code: unix.BPF_LDX | unix.BPF_IMM, K: dot1QTagSize},
+	{Code: unix.BPF_LD | unix.BPF_H | unix.BPF_IND, K: ethTypeOffset},
+	{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: uint32(layers.EthernetTypeIPv4), Jt: 0, Jf: 2},
+	{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_IND, K: ethTypeOffset + 2 + ip4DstOffset},
+	{Code: unix.BPF_RET | unix.BPF_A},
+	{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: uint32(layers.EthernetTypeIPv6), Jt: 0, Jf: 2},
+	{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_IND, K: ethTypeOffset + 2 + ip6DstOffset + ip6AddrSize - 4},
+	{Code: unix.BPF_RET | unix.BPF_A},
+	{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: uint32(ethernetTypePTP), Jt: 0, Jf: 2},
+	{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: macSize - 4},
+	{Code: unix.BPF_RET | unix.BPF_A},
+	// ARP, NDP to a multicast address and the like, any worker will do
+	{Code: unix.BPF_RET | unix.BPF_K, K: 0},
+}
+
+// newAFPacketRX opens an AF_PACKET socket with a TPACKET_V3 RX ring on iface
+// all RX sockets join the same fanout group, frames of a client always go to the same socket
+func newAFPacketRX(iface string, group *afPacketFanout) (*afPacketHandle, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("afpacket interface %s: %w", iface, err)
+	}
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		return nil, fmt.Errorf("afpacket socket: %w", err)
+	}
+	h := &afPacketHandle{
+		fd:        fd,
+		iface:     iface,
+		blockSize: afPacketRXBlockSize,
+		numBlocks: afPacketRXNumBlocks,
+	}
+	if err = unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_VERSION, unix.TPACKET_V3); err != nil {
+		h.Close()
+		return nil, fmt.Errorf("afpacket set TPACKET_V3: %w", err)
+	}
+	h.enableHWTimestamps()
+
+	req := unix.TpacketReq3{
+		Block_size:     afPacketRXBlockSize,
+		Block_nr:       afPacketRXNumBlocks,
+		Frame_size:     afPacketRXFrameSize,
+		Frame_nr:       (afPacketRXBlockSize / afPacketRXFrameSize) * afPacketRXNumBlocks,
+		Retire_blk_tov: afPacketRXBlockTimeoutMs,
+	}
+	if err = unix.SetsockoptTpacketReq3(fd, unix.SOL_PACKET, unix.PACKET_RX_RING, &req); err != nil {
+		h.Close()
+		return nil, fmt.Errorf("afpacket PACKET_RX_RING: %w", err)
+	}
+	if err = h.mmap(); err != nil {
+		h.Close()
+		return nil, err
+	}
+	// don't read back what we transmit ourselves, same as PF_RING_DISCARD_INJECTED_PKTS
+	if err = unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_IGNORE_OUTGOING, 1); err != nil {
+		log.Warnf("afpacket PACKET_IGNORE_OUTGOING not supported: %v", err)
+	}
+	if err = unix.Bind(fd, &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ALL),
+		Ifindex:  ifi.Index,
+	}); err != nil {
+		h.Close()
+		return nil, fmt.Errorf("afpacket bind %s: %w", iface, err)
+	}
+	if err = group.join(fd); err != nil {
+		h.Close()
+		return nil, fmt.Errorf("afpacket PACKET_FANOUT: %w", err)
+	}
+	prog := unix.SockFprog{Len: uint16(len(afPacketFanoutProg)), Filter: &afPacketFanoutProg[0]}
+	if err = unix.SetsockoptSockFprog(fd, unix.SOL_PACKET, unix.PACKET_FANOUT_DATA, &prog); err != nil {
+		h.Close()
+		return nil, fmt.Errorf("afpacket PACKET_FANOUT_DATA: %w", err)
+	}
+	return h, nil
+}
+
+// newAFPacketTX opens an AF_PACKET socket with a TPACKET_V3 TX ring on iface
+// protocol 0 so the socket never receives anything
+func newAFPacketTX(iface string) (*afPacketHandle, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("afpacket interface %s: %w", iface, err)
+	}
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, 0)
+	if err != nil {
+		return nil, fmt.Errorf("afpacket socket: %w", err)
+	}
+	h := &afPacketHandle{
+		fd:        fd,
+		iface:     iface,
+		blockSize: afPacketTXBlockSize,
+		numBlocks: afPacketTXNumBlocks,
+		frameSize: afPacketTXFrameSize,
+		numFrames: (afPacketTXBlockSize / afPacketTXFrameSize) * afPacketTXNumBlocks,
+	}
+	if err = unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_VERSION, unix.TPACKET_V3); err != nil {
+		h.Close()
+		return nil, fmt.Errorf("afpacket set TPACKET_V3: %w", err)
+	}
+	// skip the qdisc layer, we do our own queueing
+	if err = unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_QDISC_BYPASS, 1); err != nil {
+		log.Warnf("afpacket PACKET_QDISC_BYPASS not supported: %v", err)
+	}
+	// TX ring with V3 is frame based, block timeout / priv / features must be 0
+	req := unix.TpacketReq3{
+		Block_size: afPacketTXBlockSize,
+		Block_nr:   afPacketTXNumBlocks,
+		Frame_size: afPacketTXFrameSize,
+		Frame_nr:   uint32(h.numFrames),
+	}
+	if err = unix.SetsockoptTpacketReq3(fd, unix.SOL_PACKET, unix.PACKET_TX_RING, &req); err != nil {
+		h.Close()
+		return nil, fmt.Errorf("afpacket PACKET_TX_RING: %w", err)
+	}
+	if err = h.mmap(); err != nil {
+		h.Close()
+		return nil, err
+	}
+	if err = unix.Bind(fd, &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ALL),
+		Ifindex:  ifi.Index,
+	}); err != nil {
+		h.Close()
+		return nil, fmt.Errorf("afpacket bind %s: %w", iface, err)
+	}
+	return h, nil
+}
+
+// enableHWTimestamps asks the NIC for hardware RX timestamps and the ring to report them
+// falls back to kernel software timestamps if the NIC or driver can't do it
+func (h *afPacketHandle) enableHWTimestamps() {
+	if err := ptp.IoctlTimestamp(h.fd, h.iface); err != nil {
+		log.Warnf("afpacket %s no hardware timestamps, using software: %v", h.iface, err)
+		return
+	}
+	if err := unix.SetsockoptInt(h.fd, unix.SOL_PACKET, unix.PACKET_TIMESTAMP,
+		unix.SOF_TIMESTAMPING_RAW_HARDWARE); err != nil {
+		log.Warnf("afpacket %s PACKET_TIMESTAMP failed, using software: %v", h.iface, err)
+		return
+	}
+	h.hwTimestamps = true
+}
+
+func (h *afPacketHandle) mmap() error {
+	ring, err := unix.Mmap(h.fd, 0, h.blockSize*h.numBlocks,
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		return fmt.Errorf("afpacket mmap: %w", err)
+	}
+	h.ring = ring
+	return nil
+}
+
+func (h *afPacketHandle) poll(events int16) (bool, error) {
+	fds := []unix.PollFd{{Fd: int32(h.fd), Events: events}}
+	n, err := unix.Poll(fds, afPacketPollTimeoutMs)
+	if err != nil && err != unix.EINTR {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (h *afPacketHandle) blockStatus(block int) *uint32 {
+	return (*uint32)(unsafe.Pointer(&h.ring[block*h.blockSize+afPacketBlockHdrOffset]))
+}
+
+func (h *afPacketHandle) blockHdr(block int) *unix.TpacketHdrV1 {
+	return (*unix.TpacketHdrV1)(unsafe.Pointer(&h.ring[block*h.blockSize+afPacketBlockHdrOffset]))
+}
+
+// ReadPacket returns a copy of the next received frame and its kernel RX timestamp
+// hw reports whether the timestamp came from the NIC
+// returns nil data with no error if nothing arrived within the poll timeout
+func (h *afPacketHandle) ReadPacket() ([]byte, time.Time, bool, error) {
+	for {
+		data, ts, hw, outgoing, err := h.readFrame()
+		// PACKET_IGNORE_OUTGOING isn't honoured once the socket joins a fanout group,
+		// so drop what we sent ourselves here
+		if !outgoing {
+			return data, ts, hw, err
+		}
+	}
+}
+
+func (h *afPacketHandle) readFrame() ([]byte, time.Time, bool, bool, error) {
+	for h.pktsLeft == 0 {
+		if h.blockInUse {
+			// done with this block, hand it back to the kernel
+			atomic.StoreUint32(h.blockStatus(h.curBlock), unix.TP_STATUS_KERNEL)
+			h.curBlock = (h.curBlock + 1) % h.numBlocks
+			h.blockInUse = false
+		}
+		if atomic.LoadUint32(h.blockStatus(h.curBlock))&unix.TP_STATUS_USER == 0 {
+			ready, err := h.poll(unix.POLLIN | unix.POLLERR)
+			if err != nil {
+				return nil, time.Time{}, false, false, err
+			}
+			if !ready || atomic.LoadUint32(h.blockStatus(h.curBlock))&unix.TP_STATUS_USER == 0 {
+				return nil, time.Time{}, false, false, nil
+			}
+		}
+		bh := h.blockHdr(h.curBlock)
+		h.blockInUse = true
+		h.pktsLeft = bh.Num_pkts
+		h.pktOffset = bh.Offset_to_first_pkt
+	}
+
+	base := h.curBlock*h.blockSize + int(h.pktOffset)
+	hdr := (*unix.Tpacket3Hdr)(unsafe.Pointer(&h.ring[base]))
+	sll := (*unix.RawSockaddrLinklayer)(unsafe.Pointer(&h.ring[base+afPacketHdrLen]))
+	h.pktsLeft--
+	h.pktOffset += hdr.Next_offset
+	if sll.Pkttype == unix.PACKET_OUTGOING {
+		return nil, time.Time{}, false, true, nil
+	}
+
+	start := base + int(hdr.Mac)
+	data := make([]byte, hdr.Snaplen)
+	copy(data, h.ring[start:start+int(hdr.Snaplen)])
+	if hdr.Status&unix.TP_STATUS_VLAN_VALID != 0 {
+		// VLAN offload moved the tag into the header, clients are matched by it
+		tpid := uint16(unix.ETH_P_8021Q)
+		if hdr.Status&unix.TP_STATUS_VLAN_TPID_VALID != 0 {
+			tpid = hdr.Hv1.Vlan_tpid
+		}
+		data = insertVLANTag(data, tpid, uint16(hdr.Hv1.Vlan_tci))
+	}
+	ts := time.Unix(int64(hdr.Sec), int64(hdr.Nsec))
+	hw := hdr.Status&unix.TP_STATUS_TS_RAW_HARDWARE != 0
+	return data, ts, hw, false, nil
+}
+
+func (h *afPacketHandle) txFrame(i int) []byte {
+	off := i * h.frameSize
+	return h.ring[off : off+h.frameSize]
+}
+
+// queuePacket copies data into the next free TX frame, doesn't kick the kernel
+func (h *afPacketHandle) queuePacket(data []byte) error {
+	if len(data) > h.frameSize-afPacketTXDataOffset {
+		return fmt.Errorf("afpacket packet too big for TX frame %d", len(data))
+	}
+	frame := h.txFrame(h.curFrame)
+	hdr := (*unix.Tpacket3Hdr)(unsafe.Pointer(&frame[0]))
+	for atomic.LoadUint32(&hdr.Status)&(unix.TP_STATUS_SEND_REQUEST|unix.TP_STATUS_SENDING) != 0 {
+		// ring is full, flush and wait for the kernel to free this frame
+		h.flush()
+		ready, err := h.poll(unix.POLLOUT)
+		if err != nil {
+			return err
+		}
+		if !ready && atomic.LoadUint32(&hdr.Status)&(unix.TP_STATUS_SEND_REQUEST|unix.TP_STATUS_SENDING) != 0 {
+			return fmt.Errorf("afpacket TX ring full")
+		}
+	}
+	copy(frame[afPacketTXDataOffset:], data)
+	hdr.Len = uint32(len(data))
+	hdr.Snaplen = uint32(len(data))
+	hdr.Next_offset = 0
+	atomic.StoreUint32(&hdr.Status, unix.TP_STATUS_SEND_REQUEST)
+	h.curFrame = (h.curFrame + 1) % h.numFrames
+	return nil
+}
+
+// flush asks the kernel to transmit every frame marked TP_STATUS_SEND_REQUEST
+func (h *afPacketHandle) flush() error {
+	_, _, errno := unix.Syscall6(unix.SYS_SENDTO, uintptr(h.fd), 0, 0, unix.MSG_DONTWAIT, 0, 0)
+	if errno != 0 && errno != unix.EAGAIN && errno != unix.ENOBUFS {
+		return fmt.Errorf("afpacket send: %w", errno)
+	}
+	return nil
+}
+
+// WritePacket transmits a single frame through the TX ring
+func (h *afPacketHandle) WritePacket(data []byte) error {
+	if err := h.queuePacket(data); err != nil {
+		return err
+	}
+	return h.flush()
+}
+
+// WritePackets fills the TX ring with all frames and kicks the kernel once
+func (h *afPacketHandle) WritePackets(frames [][]byte) (int, error) {
+	for i, data := range frames {
+		if err := h.queuePacket(data); err != nil {
+			h.flush()
+			return i, err
+		}
+	}
+	return len(frames), h.flush()
+}
+
+// Stats returns totals since the socket was opened
+// PACKET_STATISTICS is reset by the kernel on every read, so accumulate here
+func (h *afPacketHandle) Stats() (Stats, error) {
+	st, err := unix.GetsockoptTpacketStatsV3(h.fd, unix.SOL_PACKET, unix.PACKET_STATISTICS)
+	if err != nil {
+		return Stats{}, err
+	}
+	rx := atomic.AddUint64(&h.rxPackets, uint64(st.Packets))
+	drop := atomic.AddUint64(&h.rxDropped, uint64(st.Drops))
+	return Stats{Received: rx, Dropped: drop}, nil
+}
+
+// Close unmaps the ring and closes the socket
+func (h *afPacketHandle) Close() error {
+	if h.ring != nil {
+		unix.Munmap(h.ring)
+		h.ring = nil
+	}
+	return unix.Close(h.fd)
+}
@@ -0,0 +1,129 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// runFanoutProg runs the few cBPF instructions afPacketFanoutProg uses, 0 when a load is out of the frame
+func runFanoutProg(t *testing.T, data []byte) uint32 {
+	var a, x uint32
+	for pc := 0; pc < len(afPacketFanoutProg); pc++ {
+		ins := afPacketFanoutProg[pc]
+		off := ins.K
+		if ins.Code&0xe0 == unix.BPF_IND {
+			off += x
+		}
+		switch ins.Code {
+		case unix.BPF_LDX | unix.BPF_IMM:
+			x = ins.K
+		case unix.BPF_LD | unix.BPF_H | unix.BPF_IND:
+			if int(off)+2 > len(data) {
+				return 0
+			}
+			a = uint32(binary.BigEndian.Uint16(data[off:]))
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_IND, unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			if int(off)+4 > len(data) {
+				return 0
+			}
+			a = binary.BigEndian.Uint32(data[off:])
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K:
+			if a == ins.K {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case unix.BPF_RET | unix.BPF_A:
+			return a
+		case unix.BPF_RET | unix.BPF_K:
+			return ins.K
+		default:
+			t.Fatalf("unexpected instruction %#x", ins.Code)
+		}
+	}
+	t.Fatal("fell off the program")
+	return 0
+}
+
+func Test_afPacketFanoutProg(t *testing.T) {
+	// the kernel checks the program the same way for socket filters, no privileges needed
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	require.NoError(t, err)
+	defer unix.Close(fd)
+	prog := unix.SockFprog{Len: uint16(len(afPacketFanoutProg)), Filter: &afPacketFanoutProg[0]}
+	require.NoError(t, unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &prog))
+
+	// and picks the RX socket by the same key as the packet parser dispatch
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	frame := func(vlan uint16, etherType layers.EthernetType, l ...gopacket.SerializableLayer) []byte {
+		eth := layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{2, 0, 0, 0, 0, 0xfe},
+			DstMAC:       net.HardwareAddr{2, 0, 0, 0, 0x12, 0x34},
+			EthernetType: etherType,
+		}
+		buf := gopacket.NewSerializeBuffer()
+		require.NoError(t, serializeFrame(buf, opts, &eth, vlan, 0, l...))
+		return buf.Bytes()
+	}
+	payload := gopacket.Payload(make([]byte, 44))
+	for _, vlan := range []uint16{0, 100} {
+		frames := [][]byte{
+			frame(vlan, layers.EthernetTypeIPv4, &layers.IPv4{SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 1, 7},
+				Version: 4, TTL: 255, Protocol: layers.IPProtocolUDP}, payload),
+			frame(vlan, layers.EthernetTypeIPv6, &layers.IPv6{SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::1:7"),
+				Version: 6, HopLimit: 255, NextHeader: layers.IPProtocolUDP}, payload),
+			frame(vlan, ethernetTypePTP, payload),
+		}
+		for _, data := range frames {
+			key, ok := rxClientKey(data, false)
+			require.True(t, ok)
+			require.Equal(t, key, runFanoutProg(t, data))
+		}
+		require.Equal(t, uint32(0), runFanoutProg(t, frame(vlan, layers.EthernetTypeARP, payload)))
+	}
+}
+
+func Test_afPacketFanoutUniqueID(t *testing.T) {
+	// two runs on the same interface get their own group, the second socket of a run joins the first's
+	var a, b afPacketFanout
+	h1, err := newAFPacketRX("lo", &a)
+	if err != nil {
+		t.Skipf("can't open AF_PACKET sockets: %v", err)
+	}
+	defer h1.Close()
+	h2, err := newAFPacketRX("lo", &a)
+	require.NoError(t, err)
+	defer h2.Close()
+	h3, err := newAFPacketRX("lo", &b)
+	require.NoError(t, err)
+	defer h3.Close()
+
+	require.NotEqual(t, a.id, b.id)
+	for _, h := range []*afPacketHandle{h1, h2} {
+		v, err := unix.GetsockoptInt(h.fd, unix.SOL_PACKET, unix.PACKET_FANOUT)
+		require.NoError(t, err)
+		require.Equal(t, a.id, v&0xffff)
+	}
+}
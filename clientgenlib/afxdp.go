@@ -0,0 +1,26 @@
+//go:build !linux || !(amd64 || arm64 || ppc64le || riscv64)
+// +build !linux !amd64,!arm64,!ppc64le,!riscv64
+
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import "fmt"
+
+func newAFXDP(iface string, queue int) (PacketIO, error) {
+	return nil, fmt.Errorf("AF_XDP not available on this system")
+}
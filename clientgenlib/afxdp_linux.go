@@ -0,0 +1,697 @@
+//go:build linux && (amd64 || arm64 || ppc64le || riscv64)
+// +build linux
+// +build amd64 arm64 ppc64le riscv64
+
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+// AF_XDP backend, one XSK socket and UMEM per NIC queue shared by RX and TX worker
+// with the same number. A tiny XDP program stamps every frame with bpf_ktime_get_ns in
+// its metadata area and redirects it to the socket of its queue
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/kpango/fastime"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// UMEM per queue, first half of the frames is for RX, second for TX
+	afXDPNumFrames = 4096
+	afXDPFrameSize = 2048
+	// entries in each of the fill / completion / RX / TX rings
+	afXDPRingSize = 2048
+	// max queues the redirect map can hold
+	afXDPMaxQueues = 64
+	// bytes of metadata the XDP program puts in front of each frame
+	afXDPMetaLen = 8
+
+	afXDPPollTimeoutMs = 100
+)
+
+// afXDPQueue is one XSK socket with its UMEM, shared by RX and TX worker of a queue
+// RX side only touches fill and rx rings, TX side only tx and completion, so they don't lock
+type afXDPQueue struct {
+	fd    int
+	iface string
+	queue int
+	umem  []byte
+	prog  *afXDPProg
+
+	fill xdpAddrRing
+	comp xdpAddrRing
+	rx   xdpDescRing
+	tx   xdpDescRing
+
+	// TX frames free for use
+	txFree []uint64
+
+	// CLOCK_REALTIME - CLOCK_MONOTONIC in ns, to turn bpf_ktime_get_ns into wall time
+	monoToWall int64
+
+	rxPackets uint64
+	refs      int
+}
+
+type xdpRing struct {
+	mem      []byte
+	producer *uint32
+	consumer *uint32
+	flags    *uint32
+	mask     uint32
+}
+
+// xdpAddrRing is a fill or completion ring of UMEM addresses
+type xdpAddrRing struct {
+	xdpRing
+	addrs []uint64
+}
+
+// xdpDescRing is an RX or TX ring of frame descriptors
+type xdpDescRing struct {
+	xdpRing
+	descs []unix.XDPDesc
+}
+
+type afXDPKey struct {
+	iface string
+	queue int
+}
+
+var (
+	afXDPMu     sync.Mutex
+	afXDPQueues = map[afXDPKey]*afXDPQueue{}
+	afXDPProgs  = map[string]*afXDPProg{}
+)
+
+// getAFXDPQueue returns the socket for iface / queue, creating it on first use
+func getAFXDPQueue(iface string, queue int) (*afXDPQueue, error) {
+	afXDPMu.Lock()
+	defer afXDPMu.Unlock()
+	key := afXDPKey{iface: iface, queue: queue}
+	if q, ok := afXDPQueues[key]; ok {
+		q.refs++
+		return q, nil
+	}
+	prog, ok := afXDPProgs[iface]
+	if !ok {
+		var err error
+		if prog, err = loadAFXDPProg(iface); err != nil {
+			return nil, err
+		}
+		afXDPProgs[iface] = prog
+	}
+	prog.refs++
+	q, err := newAFXDPQueue(iface, queue, prog)
+	if err != nil {
+		prog.release()
+		return nil, err
+	}
+	q.refs = 1
+	afXDPQueues[key] = q
+	return q, nil
+}
+
+// release drops one user of q, the last one closes the socket
+func (q *afXDPQueue) release() {
+	afXDPMu.Lock()
+	defer afXDPMu.Unlock()
+	q.refs--
+	if q.refs > 0 {
+		return
+	}
+	delete(afXDPQueues, afXDPKey{iface: q.iface, queue: q.queue})
+	q.close()
+	q.prog.release()
+}
+
+func newAFXDPQueue(iface string, queue int, prog *afXDPProg) (*afXDPQueue, error) {
+	if queue >= afXDPMaxQueues {
+		return nil, fmt.Errorf("afxdp queue %d, max %d", queue, afXDPMaxQueues)
+	}
+	fd, err := unix.Socket(unix.AF_XDP, unix.SOCK_RAW, 0)
+	if err != nil {
+		return nil, fmt.Errorf("afxdp socket: %w", err)
+	}
+	q := &afXDPQueue{fd: fd, iface: iface, queue: queue, prog: prog}
+	q.updateMonoToWall()
+
+	q.umem, err = unix.Mmap(-1, 0, afXDPNumFrames*afXDPFrameSize,
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS|unix.MAP_POPULATE)
+	if err != nil {
+		q.close()
+		return nil, fmt.Errorf("afxdp umem mmap: %w", err)
+	}
+	reg := unix.XDPUmemReg{
+		Addr: uint64(uintptr(unsafe.Pointer(&q.umem[0]))),
+		Len:  uint64(len(q.umem)),
+		Size: afXDPFrameSize,
+	}
+	if err = xdpSetsockopt(fd, unix.XDP_UMEM_REG, unsafe.Pointer(&reg), unsafe.Sizeof(reg)); err != nil {
+		q.close()
+		return nil, fmt.Errorf("afxdp XDP_UMEM_REG: %w", err)
+	}
+	for _, opt := range []int{unix.XDP_UMEM_FILL_RING, unix.XDP_UMEM_COMPLETION_RING, unix.XDP_RX_RING, unix.XDP_TX_RING} {
+		if err = unix.SetsockoptInt(fd, unix.SOL_XDP, opt, afXDPRingSize); err != nil {
+			q.close()
+			return nil, fmt.Errorf("afxdp ring size opt %d: %w", opt, err)
+		}
+	}
+	var off unix.XDPMmapOffsets
+	offLen := uint32(unsafe.Sizeof(off))
+	if _, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd), unix.SOL_XDP, unix.XDP_MMAP_OFFSETS,
+		uintptr(unsafe.Pointer(&off)), uintptr(unsafe.Pointer(&offLen)), 0); errno != 0 {
+		q.close()
+		return nil, fmt.Errorf("afxdp XDP_MMAP_OFFSETS: %w", errno)
+	}
+	if err = q.fill.mmap(fd, unix.XDP_UMEM_PGOFF_FILL_RING, off.Fr); err != nil {
+		q.close()
+		return nil, err
+	}
+	if err = q.comp.mmap(fd, unix.XDP_UMEM_PGOFF_COMPLETION_RING, off.Cr); err != nil {
+		q.close()
+		return nil, err
+	}
+	if err = q.rx.mmap(fd, unix.XDP_PGOFF_RX_RING, off.Rx); err != nil {
+		q.close()
+		return nil, err
+	}
+	if err = q.tx.mmap(fd, unix.XDP_PGOFF_TX_RING, off.Tx); err != nil {
+		q.close()
+		return nil, err
+	}
+
+	q.initFrames()
+
+	// zero copy needs driver support, copy mode works everywhere
+	sa := &unix.SockaddrXDP{
+		Flags:   unix.XDP_USE_NEED_WAKEUP | unix.XDP_ZEROCOPY,
+		Ifindex: uint32(prog.ifindex),
+		QueueID: uint32(queue),
+	}
+	if prog.skbMode {
+		sa.Flags = unix.XDP_USE_NEED_WAKEUP | unix.XDP_COPY
+	}
+	if err = unix.Bind(fd, sa); err != nil && !prog.skbMode {
+		sa.Flags = unix.XDP_USE_NEED_WAKEUP | unix.XDP_COPY
+		err = unix.Bind(fd, sa)
+	}
+	if err != nil {
+		q.close()
+		return nil, fmt.Errorf("afxdp bind %s queue %d (one worker per NIC queue): %w", iface, queue, err)
+	}
+	if sa.Flags&unix.XDP_COPY != 0 {
+		log.Infof("afxdp %s queue %d in copy mode", iface, queue)
+	}
+	if err = prog.setQueue(queue, fd); err != nil {
+		q.close()
+		return nil, err
+	}
+	return q, nil
+}
+
+// initFrames hands the RX half of the UMEM to the kernel on the fill ring, keeps the TX half in txFree
+func (q *afXDPQueue) initFrames() {
+	prod := *q.fill.producer
+	for i := 0; i < afXDPNumFrames/2; i++ {
+		q.fill.addrs[(prod+uint32(i))&q.fill.mask] = uint64(i * afXDPFrameSize)
+	}
+	atomic.StoreUint32(q.fill.producer, prod+afXDPNumFrames/2)
+	q.txFree = make([]uint64, 0, afXDPNumFrames/2)
+	for i := afXDPNumFrames / 2; i < afXDPNumFrames; i++ {
+		q.txFree = append(q.txFree, uint64(i*afXDPFrameSize))
+	}
+}
+
+func xdpSetsockopt(fd, opt int, val unsafe.Pointer, size uintptr) error {
+	if _, _, errno := unix.Syscall6(unix.SYS_SETSOCKOPT, uintptr(fd), unix.SOL_XDP, uintptr(opt),
+		uintptr(val), size, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (r *xdpRing) mmapRing(fd int, pgoff int64, off unix.XDPRingOffset, descSize int) error {
+	mem, err := unix.Mmap(fd, pgoff, int(off.Desc)+afXDPRingSize*descSize,
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		return fmt.Errorf("afxdp ring mmap: %w", err)
+	}
+	r.mem = mem
+	r.producer = (*uint32)(unsafe.Pointer(&mem[off.Producer]))
+	r.consumer = (*uint32)(unsafe.Pointer(&mem[off.Consumer]))
+	r.flags = (*uint32)(unsafe.Pointer(&mem[off.Flags]))
+	r.mask = afXDPRingSize - 1
+	return nil
+}
+
+func (r *xdpAddrRing) mmap(fd int, pgoff int64, off unix.XDPRingOffset) error {
+	if err := r.mmapRing(fd, pgoff, off, 8); err != nil {
+		return err
+	}
+	r.addrs = (*[afXDPRingSize]uint64)(unsafe.Pointer(&r.mem[off.Desc]))[:]
+	return nil
+}
+
+func (r *xdpDescRing) mmap(fd int, pgoff int64, off unix.XDPRingOffset) error {
+	if err := r.mmapRing(fd, pgoff, off, int(unsafe.Sizeof(unix.XDPDesc{}))); err != nil {
+		return err
+	}
+	r.descs = (*[afXDPRingSize]unix.XDPDesc)(unsafe.Pointer(&r.mem[off.Desc]))[:]
+	return nil
+}
+
+func (r *xdpRing) unmap() {
+	if r.mem != nil {
+		unix.Munmap(r.mem)
+		r.mem = nil
+	}
+}
+
+func (q *afXDPQueue) updateMonoToWall() {
+	var mono, wall unix.Timespec
+	unix.ClockGettime(unix.CLOCK_MONOTONIC, &mono)
+	unix.ClockGettime(unix.CLOCK_REALTIME, &wall)
+	atomic.StoreInt64(&q.monoToWall, wall.Nano()-mono.Nano())
+}
+
+func (q *afXDPQueue) poll(events int16) (bool, error) {
+	fds := []unix.PollFd{{Fd: int32(q.fd), Events: events}}
+	n, err := unix.Poll(fds, afXDPPollTimeoutMs)
+	if err != nil && err != unix.EINTR {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// kick wakes the kernel up to process the TX ring
+func (q *afXDPQueue) kick() error {
+	_, _, errno := unix.Syscall6(unix.SYS_SENDTO, uintptr(q.fd), 0, 0, unix.MSG_DONTWAIT, 0, 0)
+	if errno != 0 && errno != unix.EAGAIN && errno != unix.EBUSY && errno != unix.ENOBUFS {
+		return fmt.Errorf("afxdp sendto: %w", errno)
+	}
+	return nil
+}
+
+// readPacket returns a copy of the next frame of the RX ring and the time the XDP program saw it
+func (q *afXDPQueue) readPacket() ([]byte, time.Time, error) {
+	cons := *q.rx.consumer
+	if atomic.LoadUint32(q.rx.producer) == cons {
+		if atomic.LoadUint32(q.fill.flags)&unix.XDP_RING_NEED_WAKEUP != 0 {
+			// kernel ran out of fill entries and wants to be told there are new ones
+			unix.Syscall6(unix.SYS_RECVFROM, uintptr(q.fd), 0, 0, unix.MSG_DONTWAIT, 0, 0)
+		}
+		if _, err := q.poll(unix.POLLIN); err != nil {
+			return nil, time.Time{}, err
+		}
+		if atomic.LoadUint32(q.rx.producer) == cons {
+			return nil, time.Time{}, nil
+		}
+	}
+	desc := q.rx.descs[cons&q.rx.mask]
+	data := make([]byte, desc.Len)
+	copy(data, q.umem[desc.Addr:desc.Addr+uint64(desc.Len)])
+
+	// metadata sits right in front of the frame, 0 if the XDP program couldn't write it
+	var ts time.Time
+	meta := q.umem[desc.Addr-afXDPMetaLen : desc.Addr]
+	if mono := int64(binary.LittleEndian.Uint64(meta)); mono != 0 {
+		ts = time.Unix(0, mono+atomic.LoadInt64(&q.monoToWall))
+		binary.LittleEndian.PutUint64(meta, 0)
+	} else {
+		ts = fastime.Now()
+	}
+
+	// give the frame straight back to the kernel
+	prod := *q.fill.producer
+	q.fill.addrs[prod&q.fill.mask] = desc.Addr &^ (afXDPFrameSize - 1)
+	atomic.StoreUint32(q.fill.producer, prod+1)
+	atomic.StoreUint32(q.rx.consumer, cons+1)
+	atomic.AddUint64(&q.rxPackets, 1)
+	return data, ts, nil
+}
+
+// reclaimTX moves sent frames from the completion ring back to txFree
+func (q *afXDPQueue) reclaimTX() {
+	cons := *q.comp.consumer
+	prod := atomic.LoadUint32(q.comp.producer)
+	for ; cons != prod; cons++ {
+		q.txFree = append(q.txFree, q.comp.addrs[cons&q.comp.mask])
+	}
+	atomic.StoreUint32(q.comp.consumer, cons)
+}
+
+// writePacket puts one frame on the TX ring and kicks the kernel
+func (q *afXDPQueue) writePacket(data []byte) error {
+	if err := q.queueTX(data); err != nil {
+		return err
+	}
+	return q.flushTX()
+}
+
+// writePackets puts all frames on the TX ring before waking the kernel
+func (q *afXDPQueue) writePackets(frames [][]byte) (int, error) {
+	for i, data := range frames {
+		if err := q.queueTX(data); err != nil {
+			q.flushTX()
+			return i, err
+		}
+	}
+	return len(frames), q.flushTX()
+}
+
+// queueTX copies data into a free UMEM frame and adds it to the TX ring
+func (q *afXDPQueue) queueTX(data []byte) error {
+	if len(data) > afXDPFrameSize {
+		return fmt.Errorf("afxdp packet too big for frame %d", len(data))
+	}
+	q.reclaimTX()
+	if len(q.txFree) == 0 {
+		q.kick()
+		if _, err := q.poll(unix.POLLOUT); err != nil {
+			return err
+		}
+		q.reclaimTX()
+		if len(q.txFree) == 0 {
+			return fmt.Errorf("afxdp TX ring full")
+		}
+	}
+	addr := q.txFree[len(q.txFree)-1]
+	q.txFree = q.txFree[:len(q.txFree)-1]
+	copy(q.umem[addr:], data)
+
+	prod := *q.tx.producer
+	q.tx.descs[prod&q.tx.mask] = unix.XDPDesc{Addr: addr, Len: uint32(len(data))}
+	atomic.StoreUint32(q.tx.producer, prod+1)
+	return nil
+}
+
+// flushTX copy mode always needs the syscall, zero copy only when the driver asks for it
+func (q *afXDPQueue) flushTX() error {
+	if q.prog.skbMode || atomic.LoadUint32(q.tx.flags)&unix.XDP_RING_NEED_WAKEUP != 0 {
+		return q.kick()
+	}
+	return nil
+}
+
+func (q *afXDPQueue) stats() (Stats, error) {
+	q.updateMonoToWall()
+	var st unix.XDPStatistics
+	stLen := uint32(unsafe.Sizeof(st))
+	if _, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(q.fd), unix.SOL_XDP, unix.XDP_STATISTICS,
+		uintptr(unsafe.Pointer(&st)), uintptr(unsafe.Pointer(&stLen)), 0); errno != 0 {
+		return Stats{}, errno
+	}
+	return Stats{
+		Received: atomic.LoadUint64(&q.rxPackets),
+		Dropped:  st.Rx_dropped + st.Rx_ring_full,
+	}, nil
+}
+
+func (q *afXDPQueue) close() {
+	q.fill.unmap()
+	q.comp.unmap()
+	q.rx.unmap()
+	q.tx.unmap()
+	unix.Close(q.fd)
+	if q.umem != nil {
+		unix.Munmap(q.umem)
+		q.umem = nil
+	}
+}
+
+// afXDPIO is the PacketIO of one worker, RX or TX side of an afXDPQueue
+type afXDPIO struct {
+	q *afXDPQueue
+}
+
+// ReadPacket timestamps are bpf_ktime_get_ns at XDP time, never from the NIC
+func (x *afXDPIO) ReadPacket() ([]byte, time.Time, bool, error) {
+	data, ts, err := x.q.readPacket()
+	return data, ts, false, err
+}
+
+func (x *afXDPIO) WritePacket(data []byte) error {
+	return x.q.writePacket(data)
+}
+
+func (x *afXDPIO) WritePackets(frames [][]byte) (int, error) {
+	return x.q.writePackets(frames)
+}
+
+func (x *afXDPIO) Stats() (Stats, error) {
+	return x.q.stats()
+}
+
+func (x *afXDPIO) Close() error {
+	x.q.release()
+	return nil
+}
+
+func newAFXDP(iface string, queue int) (PacketIO, error) {
+	q, err := getAFXDPQueue(iface, queue)
+	if err != nil {
+		return nil, err
+	}
+	return &afXDPIO{q: q}, nil
+}
+
+// afXDPProg is the XDP program attached to one interface and its XSKMAP
+type afXDPProg struct {
+	ifindex int
+	mapFd   int
+	progFd  int
+	linkFd  int
+	skbMode bool
+	refs    int
+}
+
+// bpf instruction encoding
+const (
+	bpfMov64Reg = 0xbf
+	bpfMov64Imm = 0xb7
+	bpfAdd64Imm = 0x07
+	bpfLdxW     = 0x61
+	bpfStxDW    = 0x7b
+	bpfLdImm64  = 0x18
+	bpfJneImm   = 0x55
+	bpfJgtReg   = 0x2d
+	bpfCall     = 0x85
+	bpfExit     = 0x95
+
+	bpfFuncKtimeGetNs    = 5
+	bpfFuncRedirectMap   = 51
+	bpfFuncXdpAdjustMeta = 54
+
+	xdpPass = 2
+
+	// struct xdp_md offsets
+	xdpMdData         = 0
+	xdpMdDataMeta     = 8
+	xdpMdRxQueueIndex = 16
+)
+
+func bpfInsn(code uint8, dst, src uint8, off int16, imm int32) []byte {
+	b := make([]byte, 8)
+	b[0] = code
+	b[1] = dst | src<<4
+	binary.LittleEndian.PutUint16(b[2:], uint16(off))
+	binary.LittleEndian.PutUint32(b[4:], uint32(imm))
+	return b
+}
+
+// afXDPProgInsns builds
+//
+//	if (!bpf_xdp_adjust_meta(ctx, -8) && ctx->data_meta + 8 <= ctx->data)
+//		*(u64 *)ctx->data_meta = bpf_ktime_get_ns();
+//	return bpf_redirect_map(&xsks, ctx->rx_queue_index, XDP_PASS);
+func afXDPProgInsns(mapFd int) []byte {
+	var p []byte
+	p = append(p, bpfInsn(bpfMov64Reg, 6, 1, 0, 0)...)
+	p = append(p, bpfInsn(bpfMov64Imm, 2, 0, 0, -afXDPMetaLen)...)
+	p = append(p, bpfInsn(bpfCall, 0, 0, 0, bpfFuncXdpAdjustMeta)...)
+	p = append(p, bpfInsn(bpfJneImm, 0, 0, 7, 0)...)
+	p = append(p, bpfInsn(bpfLdxW, 2, 6, xdpMdData, 0)...)
+	p = append(p, bpfInsn(bpfLdxW, 7, 6, xdpMdDataMeta, 0)...)
+	p = append(p, bpfInsn(bpfMov64Reg, 1, 7, 0, 0)...)
+	p = append(p, bpfInsn(bpfAdd64Imm, 1, 0, 0, afXDPMetaLen)...)
+	p = append(p, bpfInsn(bpfJgtReg, 1, 2, 2, 0)...)
+	p = append(p, bpfInsn(bpfCall, 0, 0, 0, bpfFuncKtimeGetNs)...)
+	p = append(p, bpfInsn(bpfStxDW, 7, 0, 0, 0)...)
+	// redirect
+	p = append(p, bpfInsn(bpfLdxW, 2, 6, xdpMdRxQueueIndex, 0)...)
+	p = append(p, bpfInsn(bpfLdImm64, 1, unix.BPF_PSEUDO_MAP_FD, 0, int32(mapFd))...)
+	p = append(p, bpfInsn(0, 0, 0, 0, 0)...)
+	p = append(p, bpfInsn(bpfMov64Imm, 3, 0, 0, xdpPass)...)
+	p = append(p, bpfInsn(bpfCall, 0, 0, 0, bpfFuncRedirectMap)...)
+	p = append(p, bpfInsn(bpfExit, 0, 0, 0, 0)...)
+	return p
+}
+
+func bpfSyscall(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	r, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(r), nil
+}
+
+type bpfMapCreateAttr struct {
+	mapType    uint32
+	keySize    uint32
+	valueSize  uint32
+	maxEntries uint32
+	mapFlags   uint32
+}
+
+// bpf attr pointer fields are 64 bit, unsafe.Pointer keeps what they point at alive
+// and has the right size on the 64 bit little endian arches this file builds for
+
+type bpfMapUpdateAttr struct {
+	mapFd uint32
+	_     uint32
+	key   unsafe.Pointer
+	value unsafe.Pointer
+	flags uint64
+}
+
+type bpfProgLoadAttr struct {
+	progType    uint32
+	insnCnt     uint32
+	insns       unsafe.Pointer
+	license     unsafe.Pointer
+	logLevel    uint32
+	logSize     uint32
+	logBuf      unsafe.Pointer
+	kernVersion uint32
+	progFlags   uint32
+	progName    [16]byte
+}
+
+type bpfLinkCreateAttr struct {
+	progFd     uint32
+	targetFd   uint32
+	attachType uint32
+	flags      uint32
+}
+
+// afXDPAttachModes are tried in order when attaching the XDP program
+var afXDPAttachModes = []uint32{unix.XDP_FLAGS_DRV_MODE, unix.XDP_FLAGS_SKB_MODE}
+
+// loadAFXDPProg loads the redirect program and attaches it to iface, native mode
+// if the driver has it, generic (SKB) mode otherwise so it also runs on veth
+func loadAFXDPProg(iface string) (*afXDPProg, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("afxdp interface %s: %w", iface, err)
+	}
+	p := &afXDPProg{ifindex: ifi.Index, mapFd: -1, progFd: -1, linkFd: -1}
+
+	mapAttr := bpfMapCreateAttr{
+		mapType:    unix.BPF_MAP_TYPE_XSKMAP,
+		keySize:    4,
+		valueSize:  4,
+		maxEntries: afXDPMaxQueues,
+	}
+	if p.mapFd, err = bpfSyscall(unix.BPF_MAP_CREATE, unsafe.Pointer(&mapAttr), unsafe.Sizeof(mapAttr)); err != nil {
+		return nil, fmt.Errorf("afxdp create XSKMAP: %w", err)
+	}
+
+	insns := afXDPProgInsns(p.mapFd)
+	license := []byte("Apache-2.0\x00")
+	logBuf := make([]byte, 4096)
+	progAttr := bpfProgLoadAttr{
+		progType: unix.BPF_PROG_TYPE_XDP,
+		insnCnt:  uint32(len(insns) / 8),
+		insns:    unsafe.Pointer(&insns[0]),
+		license:  unsafe.Pointer(&license[0]),
+		logLevel: 1,
+		logSize:  uint32(len(logBuf)),
+		logBuf:   unsafe.Pointer(&logBuf[0]),
+	}
+	copy(progAttr.progName[:], "clientgen_xsk")
+	if p.progFd, err = bpfSyscall(unix.BPF_PROG_LOAD, unsafe.Pointer(&progAttr), unsafe.Sizeof(progAttr)); err != nil {
+		p.close()
+		return nil, fmt.Errorf("afxdp load XDP program: %w: %s", err, unix.ByteSliceToString(logBuf))
+	}
+
+	linkAttr := bpfLinkCreateAttr{
+		progFd:     uint32(p.progFd),
+		targetFd:   uint32(p.ifindex),
+		attachType: unix.BPF_XDP,
+	}
+	for _, mode := range afXDPAttachModes {
+		linkAttr.flags = mode
+		if p.linkFd, err = bpfSyscall(unix.BPF_LINK_CREATE, unsafe.Pointer(&linkAttr), unsafe.Sizeof(linkAttr)); err == nil {
+			p.skbMode = mode == unix.XDP_FLAGS_SKB_MODE
+			return p, nil
+		}
+		if mode == unix.XDP_FLAGS_DRV_MODE {
+			log.Warnf("afxdp %s no native XDP (%v), using generic mode", iface, err)
+		}
+	}
+	p.close()
+	return nil, fmt.Errorf("afxdp attach XDP program to %s: %w", iface, err)
+}
+
+// setQueue points the XSKMAP entry for queue at the socket fd
+func (p *afXDPProg) setQueue(queue, fd int) error {
+	key := uint32(queue)
+	val := uint32(fd)
+	attr := bpfMapUpdateAttr{
+		mapFd: uint32(p.mapFd),
+		key:   unsafe.Pointer(&key),
+		value: unsafe.Pointer(&val),
+		flags: unix.BPF_ANY,
+	}
+	if _, err := bpfSyscall(unix.BPF_MAP_UPDATE_ELEM, unsafe.Pointer(&attr), unsafe.Sizeof(attr)); err != nil {
+		return fmt.Errorf("afxdp XSKMAP update queue %d: %w", queue, err)
+	}
+	return nil
+}
+
+// release drops one user, the last one detaches the program. Called with afXDPMu held
+func (p *afXDPProg) release() {
+	p.refs--
+	if p.refs > 0 {
+		return
+	}
+	for iface, prog := range afXDPProgs {
+		if prog == p {
+			delete(afXDPProgs, iface)
+		}
+	}
+	p.close()
+}
+
+// close, closing the link fd detaches the program from the interface
+func (p *afXDPProg) close() {
+	for _, fd := range []int{p.linkFd, p.progFd, p.mapFd} {
+		if fd >= 0 {
+			unix.Close(fd)
+		}
+	}
+}
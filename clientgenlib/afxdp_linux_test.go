@@ -0,0 +1,222 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// testAFXDPQueue is an afXDPQueue with its rings in plain memory, the test plays the kernel
+func testAFXDPQueue() *afXDPQueue {
+	q := &afXDPQueue{fd: -1, prog: &afXDPProg{}, umem: make([]byte, afXDPNumFrames*afXDPFrameSize)}
+	for _, r := range []*xdpRing{&q.fill.xdpRing, &q.comp.xdpRing, &q.rx.xdpRing, &q.tx.xdpRing} {
+		r.producer, r.consumer, r.flags = new(uint32), new(uint32), new(uint32)
+		r.mask = afXDPRingSize - 1
+	}
+	q.fill.addrs = make([]uint64, afXDPRingSize)
+	q.comp.addrs = make([]uint64, afXDPRingSize)
+	q.rx.descs = make([]unix.XDPDesc, afXDPRingSize)
+	q.tx.descs = make([]unix.XDPDesc, afXDPRingSize)
+	return q
+}
+
+// setRingIndex starts a ring at idx, to see the indexes wrap around
+func setRingIndex(r *xdpRing, idx uint32) {
+	*r.producer, *r.consumer = idx, idx
+}
+
+func Test_afXDPInitFrames(t *testing.T) {
+	q := testAFXDPQueue()
+	setRingIndex(&q.fill.xdpRing, math.MaxUint32-10)
+	q.initFrames()
+
+	// the fill ring is full of the RX half, txFree holds the TX half, no frame is in both
+	require.Equal(t, uint32(afXDPNumFrames/2), *q.fill.producer-*q.fill.consumer)
+	require.Len(t, q.txFree, afXDPNumFrames/2)
+	seen := map[uint64]bool{}
+	for _, addr := range q.fill.addrs {
+		require.Zero(t, addr%afXDPFrameSize)
+		require.Less(t, addr, uint64(afXDPNumFrames/2*afXDPFrameSize))
+		seen[addr] = true
+	}
+	for _, addr := range q.txFree {
+		require.Zero(t, addr%afXDPFrameSize)
+		require.GreaterOrEqual(t, addr, uint64(afXDPNumFrames/2*afXDPFrameSize))
+		require.Less(t, addr, uint64(len(q.umem)))
+		seen[addr] = true
+	}
+	require.Len(t, seen, afXDPNumFrames)
+}
+
+func Test_afXDPReadPacket(t *testing.T) {
+	q := testAFXDPQueue()
+	setRingIndex(&q.fill.xdpRing, math.MaxUint32-1)
+	setRingIndex(&q.rx.xdpRing, math.MaxUint32-1)
+	q.initFrames()
+	q.monoToWall = int64(time.Second)
+
+	// kernel side: take a fill frame, write metadata and the frame behind the headroom, post it on RX
+	const headroom = 256
+	receive := func(data []byte, mono uint64) uint64 {
+		cons := *q.fill.consumer
+		base := q.fill.addrs[cons&q.fill.mask]
+		*q.fill.consumer = cons + 1
+		addr := base + headroom
+		binary.LittleEndian.PutUint64(q.umem[addr-afXDPMetaLen:], mono)
+		copy(q.umem[addr:], data)
+		prod := *q.rx.producer
+		q.rx.descs[prod&q.rx.mask] = unix.XDPDesc{Addr: addr, Len: uint32(len(data))}
+		*q.rx.producer = prod + 1
+		return base
+	}
+
+	// across the wrap of both rings, every frame goes back to the fill ring right away
+	for i := 0; i < 4; i++ {
+		frame := bytes.Repeat([]byte{byte(i + 1)}, 60+i)
+		fillProd := *q.fill.producer
+		base := receive(frame, uint64(1000+i))
+		data, ts, err := q.readPacket()
+		require.NoError(t, err)
+		require.Equal(t, frame, data)
+		require.Equal(t, time.Unix(0, int64(time.Second)+1000+int64(i)), ts)
+		require.Zero(t, binary.LittleEndian.Uint64(q.umem[base+headroom-afXDPMetaLen:]))
+		require.Equal(t, base, q.fill.addrs[fillProd&q.fill.mask])
+		require.Equal(t, fillProd+1, *q.fill.producer)
+		require.Equal(t, *q.rx.producer, *q.rx.consumer)
+	}
+	require.Equal(t, uint64(4), q.rxPackets)
+	require.Equal(t, uint32(afXDPNumFrames/2), *q.fill.producer-*q.fill.consumer)
+
+	// no metadata, the frame still gets a time
+	receive([]byte{1, 2, 3}, 0)
+	_, ts, err := q.readPacket()
+	require.NoError(t, err)
+	require.False(t, ts.IsZero())
+
+	// nothing on the ring
+	data, _, err := q.readPacket()
+	require.NoError(t, err)
+	require.Nil(t, data)
+}
+
+func Test_afXDPTXRing(t *testing.T) {
+	q := testAFXDPQueue()
+	setRingIndex(&q.tx.xdpRing, math.MaxUint32-1)
+	setRingIndex(&q.comp.xdpRing, math.MaxUint32)
+	q.initFrames()
+
+	frames := [][]byte{{1}, {2, 2}, {3, 3, 3}}
+	sent, err := q.writePackets(frames)
+	require.NoError(t, err)
+	require.Equal(t, len(frames), sent)
+	require.Len(t, q.txFree, afXDPNumFrames/2-len(frames))
+	require.Equal(t, uint32(math.MaxUint32-1+len(frames)), *q.tx.producer)
+	var addrs []uint64
+	for i, frame := range frames {
+		desc := q.tx.descs[(math.MaxUint32-1+uint32(i))&q.tx.mask]
+		require.Equal(t, uint32(len(frame)), desc.Len)
+		require.Equal(t, frame, q.umem[desc.Addr:desc.Addr+uint64(desc.Len)])
+		addrs = append(addrs, desc.Addr)
+	}
+
+	// kernel completes them, the next send takes them back
+	for _, addr := range addrs {
+		q.comp.addrs[*q.comp.producer&q.comp.mask] = addr
+		*q.comp.producer++
+	}
+	require.NoError(t, q.writePacket([]byte{4}))
+	require.Len(t, q.txFree, afXDPNumFrames/2-1)
+	require.Equal(t, *q.comp.producer, *q.comp.consumer)
+
+	require.Error(t, q.writePacket(make([]byte, afXDPFrameSize+1)))
+
+	// without completions the TX frames run out
+	for len(q.txFree) > 0 {
+		require.NoError(t, q.queueTX([]byte{5}))
+	}
+	require.Error(t, q.queueTX([]byte{5}))
+}
+
+func Test_afXDPVethSKB(t *testing.T) {
+	if err := exec.Command("ip", "link", "add", "cgxdp0", "type", "veth", "peer", "name", "cgxdp1").Run(); err != nil {
+		t.Skipf("can't create a veth pair: %v", err)
+	}
+	defer exec.Command("ip", "link", "del", "cgxdp0").Run()
+	for _, iface := range []string{"cgxdp0", "cgxdp1"} {
+		require.NoError(t, exec.Command("ip", "link", "set", iface, "up").Run())
+	}
+	xdpIfi, err := net.InterfaceByName("cgxdp0")
+	require.NoError(t, err)
+	peerIfi, err := net.InterfaceByName("cgxdp1")
+	require.NoError(t, err)
+
+	// the generic mode fallback, what runs where the driver has no native XDP
+	modes := afXDPAttachModes
+	afXDPAttachModes = []uint32{unix.XDP_FLAGS_SKB_MODE}
+	defer func() { afXDPAttachModes = modes }()
+	h, err := newAFXDP("cgxdp0", 0)
+	if err != nil {
+		t.Skipf("no AF_XDP: %v", err)
+	}
+	defer h.Close()
+	require.True(t, h.(*afXDPIO).q.prog.skbMode)
+
+	// the peer end is a plain AF_PACKET socket
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(unix.ETH_P_ALL)))
+	require.NoError(t, err)
+	defer unix.Close(fd)
+	require.NoError(t, unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: peerIfi.Index}))
+	require.NoError(t, unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Usec: 100000}))
+
+	frame := func(dst, src net.HardwareAddr, payload string) []byte {
+		b := append(append(append([]byte{}, dst...), src...), 0x88, 0xb5)
+		return append(b, []byte(payload)...)
+	}
+
+	// peer to the XSK socket, timestamped by the XDP program
+	in := frame(xdpIfi.HardwareAddr, peerIfi.HardwareAddr, "clientgen afxdp rx")
+	before := time.Now()
+	require.NoError(t, unix.Sendto(fd, in, 0, &unix.SockaddrLinklayer{Ifindex: peerIfi.Index}))
+	var data []byte
+	var ts time.Time
+	for i := 0; i < 20 && !bytes.Equal(data, in); i++ {
+		data, ts, _, err = h.ReadPacket()
+		require.NoError(t, err)
+	}
+	require.Equal(t, in, data)
+	require.WithinDuration(t, before, ts, time.Second)
+
+	// and back out through the TX ring
+	out := frame(peerIfi.HardwareAddr, xdpIfi.HardwareAddr, "clientgen afxdp tx")
+	require.NoError(t, h.WritePacket(out))
+	buf := make([]byte, 1500)
+	got := false
+	for i := 0; i < 20 && !got; i++ {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		got = err == nil && bytes.Equal(buf[:n], out)
+	}
+	require.True(t, got)
+}
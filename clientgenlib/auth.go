@@ -0,0 +1,196 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+)
+
+// authKeyRef is how an AUTHENTICATION TLV names its key
+type authKeyRef struct {
+	spp   uint8
+	keyID uint32
+}
+
+// authConfig is the security associations of AuthKeyFile and the key the clients sign with
+type authConfig struct {
+	keys   map[authKeyRef]*ptp.AuthKey
+	spp    uint8
+	sign   *ptp.AuthKey
+	badICV bool
+}
+
+func (a *authConfig) lookup(spp uint8, keyID uint32) *ptp.AuthKey {
+	return a.keys[authKeyRef{spp: spp, keyID: keyID}]
+}
+
+// setupAuth loads AuthKeyFile, without one nothing is signed or checked
+func setupAuth(cfg *ClientGenConfig) error {
+	if cfg.AuthKeyFile == "" {
+		if cfg.AuthBadICV {
+			return fmt.Errorf("AuthBadICV needs AuthKeyFile")
+		}
+		return nil
+	}
+	f, err := os.Open(cfg.AuthKeyFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	keys, err := parseAuthKeys(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", cfg.AuthKeyFile, err)
+	}
+	a := &authConfig{keys: keys, spp: cfg.AuthSPP, badICV: cfg.AuthBadICV}
+	a.sign = a.lookup(cfg.AuthSPP, cfg.AuthKeyID)
+	if a.sign == nil {
+		return fmt.Errorf("no key %d with SPP %d in %s", cfg.AuthKeyID, cfg.AuthSPP, cfg.AuthKeyFile)
+	}
+	cfg.auth = a
+	return nil
+}
+
+// parseAuthKeys reads security associations in the format of linuxptp's sa_file, e.g.
+//
+//	[security_association]
+//	spp 1
+//	1 SHA256-128 HEX:00112233445566778899aabbccddeeff
+//	2 SHA256 ASCII:secret
+//
+// HMAC-SHA256 is the only algorithm, "SHA256-N" truncates the ICV to N bits.
+// Keys are ASCII:, HEX: or B64:, without a prefix they are ASCII.
+func parseAuthKeys(r io.Reader) (map[authKeyRef]*ptp.AuthKey, error) {
+	keys := map[authKeyRef]*ptp.AuthKey{}
+	scanner := bufio.NewScanner(r)
+	inSA := false
+	haveSPP := false
+	var spp uint8
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = strings.TrimSpace(text[:i])
+		}
+		if text == "" {
+			continue
+		}
+		if text == "[security_association]" {
+			inSA, haveSPP = true, false
+			continue
+		}
+		if !inSA {
+			return nil, fmt.Errorf("line %d: expected [security_association]", line)
+		}
+		fields := strings.Fields(text)
+		if fields[0] == "spp" {
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: expected spp <number>", line)
+			}
+			v, err := strconv.ParseUint(fields[1], 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: bad spp: %w", line, err)
+			}
+			spp, haveSPP = uint8(v), true
+			continue
+		}
+		if !haveSPP {
+			return nil, fmt.Errorf("line %d: key before spp", line)
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected <key id> <algorithm> <key>", line)
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad key id: %w", line, err)
+		}
+		icvLen, err := authICVLen(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		secret, err := authKeyBytes(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		key, err := ptp.NewAuthKey(uint32(id), secret, icvLen)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		ref := authKeyRef{spp: spp, keyID: key.ID}
+		if _, found := keys[ref]; found {
+			return nil, fmt.Errorf("line %d: key %d of SPP %d again", line, key.ID, spp)
+		}
+		keys[ref] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys")
+	}
+	return keys, nil
+}
+
+// authICVLen is the ICV length in bytes of an algorithm name
+func authICVLen(alg string) (int, error) {
+	if alg == "SHA256" {
+		return 32, nil
+	}
+	if strings.HasPrefix(alg, "SHA256-") {
+		bits, err := strconv.Atoi(strings.TrimPrefix(alg, "SHA256-"))
+		if err == nil && bits%8 == 0 {
+			return bits / 8, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported algorithm %q, only SHA256 and SHA256-<bits>", alg)
+}
+
+// authKeyBytes decodes a key with its ASCII:, HEX: or B64: prefix
+func authKeyBytes(s string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(s, "HEX:"):
+		return hex.DecodeString(strings.TrimPrefix(s, "HEX:"))
+	case strings.HasPrefix(s, "B64:"):
+		return base64.StdEncoding.DecodeString(strings.TrimPrefix(s, "B64:"))
+	}
+	return []byte(strings.TrimPrefix(s, "ASCII:")), nil
+}
+
+// verifyAuth checks the AUTHENTICATION TLV of a message from the server and counts why it failed
+func verifyAuth(cfg *ClientGenConfig, payload []byte) error {
+	err := ptp.VerifyAuthenticationTLV(payload, cfg.auth.lookup)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ptp.ErrAuthenticationMissing):
+		atomic.AddUint64(&cfg.Counters.TotalAuthMissing, 1)
+	case errors.Is(err, ptp.ErrAuthenticationUnknownKey):
+		atomic.AddUint64(&cfg.Counters.TotalAuthUnknownKey, 1)
+	default:
+		atomic.AddUint64(&cfg.Counters.TotalAuthBadICV, 1)
+	}
+	return fmt.Errorf("authentication: %w", err)
+}
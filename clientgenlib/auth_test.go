@@ -0,0 +1,142 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+const testAuthKeys = `# keys shared with the GM
+[security_association]
+spp 1
+1 SHA256-128 HEX:00112233445566778899aabbccddeeff
+2 SHA256 B64:c2VjcmV0
+
+[security_association]
+spp 2
+1 SHA256-96 ASCII:other # truncated to 12 bytes
+`
+
+func Test_parseAuthKeys(t *testing.T) {
+	keys, err := parseAuthKeys(strings.NewReader(testAuthKeys))
+	require.NoError(t, err)
+	require.Equal(t, 3, len(keys))
+	k := keys[authKeyRef{spp: 1, keyID: 1}]
+	require.NotNil(t, k)
+	require.Equal(t, 16, k.ICVLen)
+	require.Equal(t, []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}, k.Key)
+	k = keys[authKeyRef{spp: 1, keyID: 2}]
+	require.NotNil(t, k)
+	require.Equal(t, 32, k.ICVLen)
+	require.Equal(t, []byte("secret"), k.Key)
+	k = keys[authKeyRef{spp: 2, keyID: 1}]
+	require.NotNil(t, k)
+	require.Equal(t, 12, k.ICVLen)
+	require.Equal(t, []byte("other"), k.Key)
+
+	for _, bad := range []string{
+		"",
+		"spp 1\n1 SHA256 ASCII:secret\n",
+		"[security_association]\n1 SHA256 ASCII:secret\n",
+		"[security_association]\nspp 256\n1 SHA256 ASCII:secret\n",
+		"[security_association]\nspp 1\n1 AES128 ASCII:secret\n",
+		"[security_association]\nspp 1\n1 SHA256-100 ASCII:secret\n",
+		"[security_association]\nspp 1\n1 SHA256 HEX:xyz\n",
+		"[security_association]\nspp 1\n1 SHA256\n",
+		"[security_association]\nspp 1\n1 SHA256 ASCII:a\n1 SHA256 ASCII:b\n",
+	} {
+		_, err := parseAuthKeys(strings.NewReader(bad))
+		require.Error(t, err, bad)
+	}
+}
+
+// authTestConfig writes testAuthKeys for cfg to sign with key 1 of SPP 1
+func authTestConfig(t *testing.T, cfg *ClientGenConfig) {
+	cfg.AuthKeyFile = filepath.Join(t.TempDir(), "sa_file")
+	require.NoError(t, os.WriteFile(cfg.AuthKeyFile, []byte(testAuthKeys), 0600))
+	cfg.AuthSPP = 1
+	cfg.AuthKeyID = 1
+}
+
+func Test_setupAuth(t *testing.T) {
+	cfg := &ClientGenConfig{}
+	require.NoError(t, setupAuth(cfg))
+	require.Nil(t, cfg.auth)
+	cfg.AuthBadICV = true
+	require.Error(t, setupAuth(cfg))
+
+	authTestConfig(t, cfg)
+	require.NoError(t, setupAuth(cfg))
+	require.Equal(t, uint32(1), cfg.auth.sign.ID)
+	require.Equal(t, 16, cfg.auth.sign.ICVLen)
+	cfg.AuthKeyID = 3
+	require.Error(t, setupAuth(cfg))
+	cfg.AuthKeyFile = filepath.Join(t.TempDir(), "missing")
+	require.Error(t, setupAuth(cfg))
+}
+
+func Test_verifyAuth(t *testing.T) {
+	cfg := &ClientGenConfig{}
+	authTestConfig(t, cfg)
+	require.NoError(t, setupAuth(cfg))
+	raw, err := ptp.Bytes(&ptp.Announce{Header: ptp.Header{
+		SdoIDAndMsgType: ptp.NewSdoIDAndMsgType(ptp.MessageAnnounce, 0),
+		Version:         ptp.Version,
+		MessageLength:   ptp.HeaderSize + 30,
+	}})
+	require.NoError(t, err)
+	require.Error(t, verifyAuth(cfg, raw))
+	require.Equal(t, uint64(1), cfg.Counters.TotalAuthMissing)
+
+	signed, err := ptp.AppendAuthenticationTLV(raw, 1, cfg.auth.sign)
+	require.NoError(t, err)
+	require.NoError(t, verifyAuth(cfg, signed))
+
+	other, err := ptp.AppendAuthenticationTLV(raw, 3, cfg.auth.sign)
+	require.NoError(t, err)
+	require.Error(t, verifyAuth(cfg, other))
+	require.Equal(t, uint64(1), cfg.Counters.TotalAuthUnknownKey)
+
+	signed[len(signed)-1] ^= 0xff
+	require.Error(t, verifyAuth(cfg, signed))
+	require.Equal(t, uint64(1), cfg.Counters.TotalAuthBadICV)
+}
+
+func Test_ptpTemplateAuth(t *testing.T) {
+	cfg := &ClientGenConfig{}
+	authTestConfig(t, cfg)
+	require.NoError(t, setupAuth(cfg))
+	tmpl, err := newPTPTemplate(reqDelay(0), cfg.auth)
+	require.NoError(t, err)
+	buf := make([]byte, maxPayloadTemplate)
+	b := tmpl.put(buf, ptp.ClockIdentity(42), 7)
+	require.NoError(t, ptp.VerifyAuthenticationTLV(b, cfg.auth.lookup))
+	req := &ptp.SyncDelayReq{}
+	require.NoError(t, ptp.FromBytes(b, req))
+	require.Equal(t, uint16(7), req.SequenceID)
+	require.Equal(t, ptp.ClockIdentity(42), req.SourcePortIdentity.ClockIdentity)
+
+	tmpl.badICV = true
+	b = tmpl.put(buf, ptp.ClockIdentity(42), 8)
+	require.ErrorIs(t, ptp.VerifyAuthenticationTLV(b, cfg.auth.lookup), ptp.ErrAuthenticationBadICV)
+}
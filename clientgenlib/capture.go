@@ -0,0 +1,327 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// pcapng enhanced packet block option codes and flags
+const (
+	ngBlockTypeEnhancedPacket = 6
+	ngOptionEndOfOpt          = 0
+	ngOptionComment           = 1
+	ngOptionEPBFlags          = 2
+
+	ngEPBFlagInbound  = 1
+	ngEPBFlagOutbound = 2
+)
+
+// capturePacket is a copy of one frame on its way to the capture file
+type capturePacket struct {
+	data []byte
+	ts   time.Time
+	tx   bool
+	hwTS bool
+}
+
+// captureSink writes the captured frames to a pcapng file, rotating it by size
+// frames come from the RX / TX workers through queue, written by a single goroutine
+type captureSink struct {
+	path     string
+	iface    string
+	maxBytes int64
+	maxFiles int
+	filter   map[string]struct{}
+
+	queue chan *capturePacket
+
+	file      *os.File
+	buf       *bufio.Writer
+	written   int64
+	rotations int
+
+	// per packet comments, [tx][hwTS]
+	comments [2][2][]byte
+	scratch  [32]byte
+}
+
+// newCaptureSink creates the capture file, clientIPs limits capture to frames to / from those IPs
+// maxBytes 0 never rotates, maxFiles 0 keeps every rotated file
+func newCaptureSink(path, iface string, clientIPs []string, maxBytes int64, maxFiles int) (*captureSink, error) {
+	s := &captureSink{
+		path:     path,
+		iface:    iface,
+		maxBytes: maxBytes,
+		maxFiles: maxFiles,
+		queue:    make(chan *capturePacket, captureQueueSize),
+	}
+	if len(clientIPs) > 0 {
+		s.filter = make(map[string]struct{}, len(clientIPs))
+		for _, str := range clientIPs {
+			ip := net.ParseIP(str)
+			if ip == nil {
+				return nil, fmt.Errorf("capture filter bad client IP %q", str)
+			}
+			s.filter[string(ip.To16())] = struct{}{}
+		}
+	}
+	for tx, dir := range []string{"rx", "tx"} {
+		for hw, src := range []string{ptp.SWTIMESTAMP, ptp.HWTIMESTAMP} {
+			s.comments[tx][hw] = []byte(fmt.Sprintf("%s %s, %s timestamp", dir, iface, src))
+		}
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open creates a fresh file at s.path and writes the section and interface headers
+func (s *captureSink) open() error {
+	f, err := os.Create(s.path)
+	if err != nil {
+		return fmt.Errorf("capture create %s: %w", s.path, err)
+	}
+	intf := pcapgo.DefaultNgInterface
+	intf.Name = s.iface
+	intf.LinkType = layers.LinkTypeEthernet
+	opts := pcapgo.DefaultNgWriterOptions
+	opts.SectionInfo.Application = "clientgen"
+	ng, err := pcapgo.NewNgWriterInterface(f, intf, opts)
+	if err == nil {
+		err = ng.Flush()
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("capture write header %s: %w", s.path, err)
+	}
+	if s.written, err = f.Seek(0, io.SeekCurrent); err != nil {
+		f.Close()
+		return err
+	}
+	s.file = f
+	s.buf = bufio.NewWriter(f)
+	return nil
+}
+
+// rotate moves path to path.1, path.1 to path.2 and so on, dropping what's over maxFiles
+func (s *captureSink) rotate() error {
+	if err := s.close(); err != nil {
+		return err
+	}
+	s.rotations++
+	keep := s.maxFiles
+	if keep == 0 || keep > s.rotations {
+		keep = s.rotations
+	}
+	os.Remove(fmt.Sprintf("%s.%d", s.path, keep))
+	for i := keep - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+// wanted applies the client IP filter to a raw ethernet frame
+func (s *captureSink) wanted(data []byte) bool {
+	if s.filter == nil {
+		return true
+	}
+	src, dst := frameIPs(data)
+	if src == nil {
+		return false
+	}
+	_, okSrc := s.filter[string(src.To16())]
+	_, okDst := s.filter[string(dst.To16())]
+	return okSrc || okDst
+}
+
+// write appends p as an enhanced packet block with direction flags and a comment
+func (s *captureSink) write(p *capturePacket) error {
+	tx, hw := 0, 0
+	flags := uint32(ngEPBFlagInbound)
+	if p.tx {
+		tx = 1
+		flags = ngEPBFlagOutbound
+	}
+	if p.hwTS {
+		hw = 1
+	}
+	comment := s.comments[tx][hw]
+	dataPad := (4 - len(p.data)&3) & 3
+	commentPad := (4 - len(comment)&3) & 3
+	length := 28 + len(p.data) + dataPad +
+		4 + len(comment) + commentPad + // opt_comment
+		8 + // epb_flags
+		4 + // opt_endofopt
+		4 // trailing length
+
+	b := s.scratch[:28]
+	ts := uint64(p.ts.UnixNano())
+	binary.LittleEndian.PutUint32(b[0:4], ngBlockTypeEnhancedPacket)
+	binary.LittleEndian.PutUint32(b[4:8], uint32(length))
+	binary.LittleEndian.PutUint32(b[8:12], 0) // interface id
+	binary.LittleEndian.PutUint32(b[12:16], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(b[16:20], uint32(ts))
+	binary.LittleEndian.PutUint32(b[20:24], uint32(len(p.data)))
+	binary.LittleEndian.PutUint32(b[24:28], uint32(len(p.data)))
+	s.buf.Write(b)
+	s.buf.Write(p.data)
+	s.buf.Write(s.scratch[28 : 28+dataPad])
+
+	b = s.scratch[:4]
+	binary.LittleEndian.PutUint16(b[0:2], ngOptionComment)
+	binary.LittleEndian.PutUint16(b[2:4], uint16(len(comment)))
+	s.buf.Write(b)
+	s.buf.Write(comment)
+	for i := 0; i < commentPad; i++ {
+		s.buf.WriteByte(0)
+	}
+	b = s.scratch[:16]
+	binary.LittleEndian.PutUint16(b[0:2], ngOptionEPBFlags)
+	binary.LittleEndian.PutUint16(b[2:4], 4)
+	binary.LittleEndian.PutUint32(b[4:8], flags)
+	binary.LittleEndian.PutUint32(b[8:12], ngOptionEndOfOpt)
+	binary.LittleEndian.PutUint32(b[12:16], uint32(length))
+	if _, err := s.buf.Write(b); err != nil {
+		return err
+	}
+
+	s.written += int64(length)
+	if s.maxBytes > 0 && s.written >= s.maxBytes {
+		return s.rotate()
+	}
+	return nil
+}
+
+func (s *captureSink) close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.buf.Flush()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.file = nil
+	return err
+}
+
+// frameIPs returns source and destination IP of an IPv4 / IPv6 ethernet frame, nil otherwise
+func frameIPs(data []byte) (net.IP, net.IP) {
+	if len(data) < 14 {
+		return nil, nil
+	}
+	off := 12
+	etherType := layers.EthernetType(binary.BigEndian.Uint16(data[off:]))
+	if etherType == layers.EthernetTypeDot1Q && len(data) >= 18 {
+		off += 4
+		etherType = layers.EthernetType(binary.BigEndian.Uint16(data[off:]))
+	}
+	ipHdr := data[off+2:]
+	switch etherType {
+	case layers.EthernetTypeIPv4:
+		if len(ipHdr) < 20 {
+			return nil, nil
+		}
+		return net.IP(ipHdr[12:16]), net.IP(ipHdr[16:20])
+	case layers.EthernetTypeIPv6:
+		if len(ipHdr) < 40 {
+			return nil, nil
+		}
+		return net.IP(ipHdr[8:24]), net.IP(ipHdr[24:40])
+	}
+	return nil, nil
+}
+
+// startCaptureWriter opens the capture file and starts the goroutine writing to it
+func startCaptureWriter(cfg *ClientGenConfig) error {
+	if cfg.CaptureFile == "" {
+		return nil
+	}
+	s, err := newCaptureSink(cfg.CaptureFile, cfg.Iface, cfg.CaptureClientIPs,
+		int64(cfg.CaptureMaxFileMB)<<20, cfg.CaptureMaxFiles)
+	if err != nil {
+		log.Errorf("Failed to start capture %v", err)
+		return err
+	}
+	cfg.RunData.capture = s
+	log.Infof("Capturing packets to %s", cfg.CaptureFile)
+
+	cfg.Eg.Go(func() error {
+		var profiler Profiler
+		profiler.Init(cfg.Eg, cfg.Ctx, true, "Capture writer")
+		cfg.PerfProfilers = append(cfg.PerfProfilers, &profiler)
+		for {
+			select {
+			case p := <-s.queue:
+				profiler.Tick()
+				if err := s.write(p); err != nil {
+					// workers never block on the queue, the rest just counts as dropped
+					log.Errorf("Capture write failed, stopping capture: %v", err)
+					s.close()
+					return nil
+				}
+				profiler.Tock()
+			case <-(*cfg.Ctx).Done():
+				// write out what's still queued
+				for len(s.queue) > 0 {
+					s.write(<-s.queue)
+				}
+				log.Infof("Capture writer done, closing %s", cfg.CaptureFile)
+				s.close()
+				return (*cfg.Ctx).Err()
+			}
+		}
+	})
+	return nil
+}
+
+// capturePacketData queues a copy of a sent or received frame for the capture file
+// never blocks, frames are counted as dropped if the writer can't keep up
+func capturePacketData(cfg *ClientGenConfig, data []byte, ts time.Time, tx bool, hwTS bool) {
+	s := cfg.RunData.capture
+	if s == nil || !s.wanted(data) {
+		return
+	}
+	p := &capturePacket{
+		data: append([]byte(nil), data...),
+		ts:   ts,
+		tx:   tx,
+		hwTS: hwTS,
+	}
+	select {
+	case s.queue <- p:
+		atomic.AddUint64(&cfg.Counters.TotalCapturePackets, 1)
+	default:
+		atomic.AddUint64(&cfg.Counters.TotalCaptureDropped, 1)
+	}
+}
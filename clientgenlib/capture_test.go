@@ -0,0 +1,110 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/require"
+)
+
+func captureTestFrame(t *testing.T, src, dst string) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true}
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP,
+		SrcIP: net.ParseIP(src), DstIP: net.ParseIP(dst)}
+	udp := &layers.UDP{SrcPort: 320, DstPort: 320}
+	udp.SetNetworkLayerForChecksum(ip)
+	require.Nil(t, gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload([]byte{1, 2, 3})))
+	return buf.Bytes()
+}
+
+// readCapture returns the frames of a capture file and their timestamps in ns
+func readCapture(t *testing.T, path string) ([][]byte, []int64) {
+	f, err := os.Open(path)
+	require.Nil(t, err)
+	defer f.Close()
+	r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	require.Nil(t, err)
+	var frames [][]byte
+	var stamps []int64
+	for {
+		data, ci, err := r.ReadPacketData()
+		if err != nil {
+			break
+		}
+		frames = append(frames, data)
+		stamps = append(stamps, ci.Timestamp.UnixNano())
+	}
+	return frames, stamps
+}
+
+func Test_captureSinkFilter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cap.pcapng")
+	s, err := newCaptureSink(path, "eth0", []string{"10.0.0.2"}, 0, 0)
+	require.Nil(t, err)
+
+	ts := time.Unix(1612028735, 717200436)
+	toClient := captureTestFrame(t, "10.0.0.1", "10.0.0.2")
+	fromClient := captureTestFrame(t, "10.0.0.2", "10.0.0.1")
+	other := captureTestFrame(t, "10.0.0.1", "10.0.0.3")
+	for _, frame := range [][]byte{toClient, other, fromClient} {
+		if s.wanted(frame) {
+			require.Nil(t, s.write(&capturePacket{data: frame, ts: ts, tx: frame[29] == 2, hwTS: true}))
+		}
+	}
+	require.Nil(t, s.close())
+
+	frames, stamps := readCapture(t, path)
+	require.Equal(t, [][]byte{toClient, fromClient}, frames)
+	// nanoseconds survive
+	require.Equal(t, ts.UnixNano(), stamps[0])
+}
+
+func Test_captureSinkRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cap.pcapng")
+	frame := captureTestFrame(t, "10.0.0.1", "10.0.0.2")
+	// headers are ~90 bytes and each packet block ~125, so every third packet rotates
+	s, err := newCaptureSink(path, "eth0", nil, 400, 2)
+	require.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		require.Nil(t, s.write(&capturePacket{data: frame, ts: time.Unix(int64(i), 0)}))
+	}
+	require.Nil(t, s.close())
+
+	_, stamps := readCapture(t, path)
+	require.Equal(t, []int64{9e9}, stamps)
+	_, stamps = readCapture(t, path+".1")
+	require.Equal(t, []int64{6e9, 7e9, 8e9}, stamps)
+	_, stamps = readCapture(t, path+".2")
+	require.Equal(t, []int64{3e9, 4e9, 5e9}, stamps)
+	// oldest one is gone, only 2 kept
+	_, err = os.Stat(path + ".3")
+	require.True(t, os.IsNotExist(err))
+}
@@ -38,54 +38,152 @@ const pktDecoderQueueSize = 10000
 const reTransHeapOpQueueSize = 10000
 const reTransHeapStartOversize = 10
 const reStartHeapOpQueueSize = 10000
+const captureQueueSize = 10000
 const reStartHeapStartOversize = 10
 
 // StartClientGen is the top level function for the client traffic generator, runs based on cfg argument
 func StartClientGen(cfg *ClientGenConfig) {
 	var err error
 	cfg.fastimeHolder = fastime.New().StartTimerD(*cfg.Ctx, 1*time.Microsecond)
-	// make the client data structures
-	var clientGenData ClientGenData
-	startIp := net.ParseIP(cfg.ClientIPStart)
-	endIp := net.ParseIP(cfg.ClientIPEnd)
-	i := 0
-	for ip := startIp; IpBetween(startIp, endIp, ip); ip =
-		NextIP(ip, cfg.ClientIPStep) {
-		single := SingleClientGen{
-			ClientIP:      ip,
-			state:         stateInit,
-			laststate:     stateNone,
-			genSequence:   0,
-			eventSequence: 0,
-			stateSem:      semaphore.NewWeighted(1),
-			timeDoneInit:  time.Time{},
-			index:         i,
-		}
-		single.RetransTimer.index = -1
-		single.RestartTimer.index = -1
-		clientGenData.clients = append(clientGenData.clients, single)
-		atomic.AddUint64(&cfg.Counters.TotalClients, 1)
-		i++
+	runs := []*ClientGenConfig{cfg}
+	if len(cfg.Interfaces) > 0 {
+		if runs, err = newInterfaceRuns(cfg); err != nil {
+			log.Errorf("Bad Interfaces config %v", err)
+			return
+		}
+		cfg.ifaceRuns = runs
+	}
+	// make the client data structures, one slice for all interfaces so the counters see every client
+	var clients []SingleClientGen
+	bounds := make([]int, len(runs)+1)
+	spans := make([][]clientSpan, len(runs))
+	for i, run := range runs {
+		ranges, err := clientRanges(run)
+		if err != nil {
+			log.Errorf("Bad client range %v", err)
+			return
+		}
+		clients, spans[i] = makeClients(run, ranges, clients)
+		bounds[i+1] = len(clients)
+	}
+	for i, run := range runs {
+		if err = setupClientGen(run, clients[bounds[i]:bounds[i+1]:bounds[i+1]], spans[i]); err != nil {
+			return
+		}
+	}
+	if len(cfg.ifaceRuns) > 0 {
+		cfg.RunData = &ClientGenData{clients: clients}
+		sumInterfaceCounters(cfg)
+		startCounterProcessor(cfg)
+		// every interface soft starts on its own
+		for _, run := range runs[1:] {
+			run := run
+			cfg.Eg.Go(func() error {
+				kickoffClients(run)
+				return nil
+			})
+		}
+	}
+	kickoffClients(runs[0])
+
+	waitval := cfg.Eg.Wait()
+	log.Infof("Eg wait ended! %v", waitval)
+	if len(cfg.ifaceRuns) > 0 {
+		sumInterfaceCounters(cfg)
+	}
+}
+
+// makeClients appends a client for every IP in ranges, index counts across all interfaces
+// the spans are relative to the first client cfg adds
+func makeClients(cfg *ClientGenConfig, ranges []ClientRange, clients []SingleClientGen) ([]SingleClientGen, []clientSpan) {
+	var spans []clientSpan
+	base := len(clients)
+	for _, r := range ranges {
+		span := clientSpan{step: uint64(r.ClientIPStep), first: len(clients) - base, vlan: r.VLAN, list: len(r.Addresses) > 0}
+		if !span.list {
+			span.start = net.ParseIP(r.ClientIPStart)
+			key, _ := makeClientKey(0, span.start)
+			span.hi, span.lo = ipHiLo(&key.addr)
+		}
+		clients = makeRangeClients(cfg, r, clients)
+		span.count = len(clients) - base - span.first
+		spans = append(spans, span)
 	}
 	if cfg.DebugPrint || cfg.DebugLogClient  {
-		log.Infof("Done making %v client data structures", len(clientGenData.clients))
+		log.Infof("Done making %v client data structures", len(clients)-base)
+	}
+	return clients, spans
+}
+
+func makeRangeClients(cfg *ClientGenConfig, r ClientRange, clients []SingleClientGen) []SingleClientGen {
+	if len(r.Addresses) > 0 {
+		for _, addr := range r.Addresses {
+			clients = appendClient(cfg, r, net.ParseIP(addr), clients)
+		}
+		return clients
 	}
+	startIp := net.ParseIP(r.ClientIPStart)
+	endIp := net.ParseIP(r.ClientIPEnd)
+	for ip := startIp; IpBetween(startIp, endIp, ip); ip =
+		NextIP(ip, r.ClientIPStep) {
+		clients = appendClient(cfg, r, ip, clients)
+	}
+	return clients
+}
 
-	cfg.RunData = &clientGenData
+// appendClient adds the client with ip of range r, its index is its place in clients
+func appendClient(cfg *ClientGenConfig, r ClientRange, ip net.IP, clients []SingleClientGen) []SingleClientGen {
+	single := SingleClientGen{
+		ClientIP:      ip,
+		vlan:          r.VLAN,
+		pcp:           r.PCP,
+		state:         stateInit,
+		laststate:     stateNone,
+		genSequence:   0,
+		eventSequence: 0,
+		stateSem:      semaphore.NewWeighted(1),
+		timeDoneInit:  time.Time{},
+		index:         len(clients),
+	}
+	single.RetransTimer.index = -1
+	single.RestartTimer.index = -1
+	atomic.AddUint64(&cfg.Counters.TotalClients, 1)
+	return append(clients, single)
+}
+
+// setupClientGen makes the run data for clients and starts all the workers of cfg
+func setupClientGen(cfg *ClientGenConfig, clients []SingleClientGen, spans []clientSpan) error {
+	var err error
+	if err = checkQueueOverflow(cfg); err != nil {
+		log.Errorf("Bad QueueOverflow config %v", err)
+		return err
+	}
+	cfg.RunData = &ClientGenData{clients: clients, spans: spans}
+	indexClients(cfg)
 	cfg.RunData.commonSerializeOp.FixLengths = true
 	cfg.RunData.commonSerializeOp.ComputeChecksums = true
 	cfg.RunData.rawInput = make([]chan *inPacket, cfg.NumPacketParsers) // direct packet data
 	cfg.RunData.rawOutput = make([]chan *outPacket, cfg.NumTXWorkers)
 	cfg.RunData.pktToProc = make([]chan *PktDecoder, cfg.NumPacketProcessors)
 
+	cfg.RunData.rawInputStats = make([]*queueStats, cfg.NumPacketParsers)
+	cfg.RunData.rawOutputStats = make([]*queueStats, cfg.NumTXWorkers)
+	cfg.RunData.pktToProcStats = make([]*queueStats, cfg.NumPacketProcessors)
+
 	for i := 0; i < cfg.NumTXWorkers; i++ {
-		cfg.RunData.rawOutput[i] = make(chan *outPacket, rawOutQueueSize)
+		ch := make(chan *outPacket, rawOutQueueSize)
+		cfg.RunData.rawOutput[i] = ch
+		cfg.RunData.rawOutputStats[i] = newQueueStats(cfg, QueueRawOutput, i, rawOutQueueSize, func() int { return len(ch) })
 	}
 	for i := 0; i < cfg.NumPacketParsers; i++ {
-		cfg.RunData.rawInput[i] = make(chan *inPacket, rawInQueueSize)
+		ch := make(chan *inPacket, rawInQueueSize)
+		cfg.RunData.rawInput[i] = ch
+		cfg.RunData.rawInputStats[i] = newQueueStats(cfg, QueueRawInput, i, rawInQueueSize, func() int { return len(ch) })
 	}
 	for i := 0; i < cfg.NumPacketProcessors; i++ {
-		cfg.RunData.pktToProc[i] = make(chan *PktDecoder, pktDecoderQueueSize)
+		ch := make(chan *PktDecoder, pktDecoderQueueSize)
+		cfg.RunData.pktToProc[i] = ch
+		cfg.RunData.pktToProcStats[i] = newQueueStats(cfg, QueuePktToProc, i, pktDecoderQueueSize, func() int { return len(ch) })
 	}
 	if cfg.DebugPrint {
 		log.Infof("Done making part of config")
@@ -97,12 +195,16 @@ func StartClientGen(cfg *ClientGenConfig) {
 	for i := 0; i < cfg.NumClientRetransmitProcs; i++ {
 		startCap := (len(cfg.RunData.clients) / cfg.NumClientRetransmitProcs) + reTransHeapStartOversize
 		cfg.RunData.retransmitHeap[i].heap.Init(startCap)
-		cfg.RunData.retransmitHeap[i].operationChan = make(chan *parallelHeapOp, reTransHeapOpQueueSize)
+		ch := make(chan *parallelHeapOp, reTransHeapOpQueueSize)
+		cfg.RunData.retransmitHeap[i].operationChan = ch
+		cfg.RunData.retransmitHeap[i].stats = newQueueStats(cfg, QueueRetransmitHeap, i, reTransHeapOpQueueSize, func() int { return len(ch) })
 	}
 	for i := 0; i < cfg.NumClientRestartProcs; i++ {
 		startCap := (len(cfg.RunData.clients) / cfg.NumClientRestartProcs) + reStartHeapStartOversize
 		cfg.RunData.restartHeap[i].heap.Init(startCap)
-		cfg.RunData.restartHeap[i].operationChan = make(chan *parallelHeapOp, reStartHeapOpQueueSize)
+		ch := make(chan *parallelHeapOp, reStartHeapOpQueueSize)
+		cfg.RunData.restartHeap[i].operationChan = ch
+		cfg.RunData.restartHeap[i].stats = newQueueStats(cfg, QueueRestartHeap, i, reStartHeapOpQueueSize, func() int { return len(ch) })
 	}
 
 	cfg.RunData.heapOpPool = sync.Pool{
@@ -135,8 +237,8 @@ func StartClientGen(cfg *ClientGenConfig) {
 		New: func() interface{} {
 			item := new(PktDecoder)
 			decoder := gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet,
-				&item.eth, &item.ip4, &item.ip6, &item.arp, &item.udp,
-				&item.icmpv6, &item.icmpv6ns, &item.icmpv6na)
+				&item.eth, &item.dot1q, &item.ip4, &item.ip6, &item.arp, &item.udp,
+				&item.icmpv6, &item.icmpv6ns, &item.icmpv6na, &item.icmpv6e, &item.icmp4)
 			item.layers = make([]gopacket.LayerType, 8)
 			item.parser = decoder
 			item.fromTX = false
@@ -146,23 +248,68 @@ func StartClientGen(cfg *ClientGenConfig) {
 	cfg.perIOTX = make([]uint64, cfg.NumTXWorkers)
 	cfg.perIORX = make([]uint64, cfg.NumRXWorkers)
 
-	cfg.parsedServerMac, err = net.ParseMAC(cfg.ServerMAC)
-	if err != nil {
-		log.Errorf("Failed to parse cfg ServerMAC %v", err)
-		return
+	if cfg.ServerMAC != "" {
+		cfg.parsedServerMac, err = net.ParseMAC(cfg.ServerMAC)
+		if err != nil {
+			log.Errorf("Failed to parse cfg ServerMAC %v", err)
+			return err
+		}
 	}
-	srcInterface, err := net.InterfaceByName(cfg.Iface)
-	if err != nil {
-		log.Errorf("Failed to get MAC from interface %v", cfg.Iface)
-		return
+	if cfg.ClientMAC != "" {
+		cfg.srcMAC, err = net.ParseMAC(cfg.ClientMAC)
+		if err != nil {
+			log.Errorf("Failed to parse cfg ClientMAC %v", err)
+			return err
+		}
+	} else {
+		srcInterface, err := net.InterfaceByName(cfg.Iface)
+		if err != nil {
+			log.Errorf("Failed to get MAC from interface %v", cfg.Iface)
+			return err
+		}
+		cfg.srcMAC = srcInterface.HardwareAddr
+	}
+	if err = setClientMACs(cfg); err != nil {
+		log.Errorf("Failed to make client MACs %v", err)
+		return err
+	}
+	if err = checkTransport(cfg); err != nil {
+		log.Errorf("Bad Transport config %v", err)
+		return err
+	}
+	if err = checkDelayMechanism(cfg); err != nil {
+		log.Errorf("Bad DelayMechanism config %v", err)
+		return err
+	}
+	if err = setupAuth(cfg); err != nil {
+		log.Errorf("Bad authentication config %v", err)
+		return err
+	}
+	if err = setupTemplates(cfg); err != nil {
+		log.Errorf("Failed to build packet templates %v", err)
+		return err
+	}
+	if err = setupServerMACResolver(cfg); err != nil {
+		log.Errorf("Failed to set up server MAC resolver %v", err)
+		return err
 	}
-	cfg.srcMAC = srcInterface.HardwareAddr
-
 
 	/**** Start worker goroutines *****/
 	if cfg.DebugPrint {
 		log.Infof("Starting workers")
 	}
+	if err = startCaptureWriter(cfg); err != nil {
+		return err
+	}
+	if err = startLoopbackGM(cfg); err != nil {
+		return err
+	}
+	if err = startKernelSockets(cfg); err != nil {
+		return err
+	}
+	if err = startPromisc(cfg); err != nil {
+		return err
+	}
 	startIOWorker(cfg)
 
 	// takes data from raw_in_data, parses it into gopackets, puts in parsed_pkts channel
@@ -174,12 +321,21 @@ func StartClientGen(cfg *ClientGenConfig) {
 
 	startCounterProcessor(cfg)
 
+	// needs the workers above to send and to see the answer
+	return startServerMACResolver(cfg)
+}
+
+// kickoffClients puts each client of cfg into the client processor, SoftStartRate at a time
+func kickoffClients(cfg *ClientGenConfig) {
 	/**** Start it, put each client into client processor with retransmit time of now ****/
 	// do it this way so it isn't single threaded
 	startCount := cfg.SoftStartRate
 	log.Infof("Starting clients at %v", fastime.Now())
 
 	// use goroutines, kick off some quantity in a separate goroutine
+	// they're done before kickoffClients returns
+	var kicks sync.WaitGroup
+	defer kicks.Wait()
 	for start := uint64(0); ; {
 		if (*cfg.Ctx).Err() != nil {
 			log.Errorf("Ending kickoff early because context error %v", (*cfg.Ctx).Err())
@@ -194,8 +350,11 @@ func StartClientGen(cfg *ClientGenConfig) {
 			end = uint64(len(cfg.RunData.clients)) - 1
 		} // at the end, go till the end
 		// kick off clients in this index range
+		kicks.Add(1)
 		go func(start uint64, end uint64) {
-			for i := start; i <= end; i++ {
+			defer kicks.Done()
+			for i := start; i <= end && (*cfg.Ctx).Err() == nil; i++ {
+				announceClient(cfg, &cfg.RunData.clients[i])
 				pushClientRetransmit(cfg, &cfg.RunData.clients[i], fastime.Now())
 			}
 		}(start, end)
@@ -207,46 +366,17 @@ func StartClientGen(cfg *ClientGenConfig) {
 		// move start to end
 		start = end + 1
 		if startCount <= 0 && end != uint64(len(cfg.RunData.clients))-1 {
-			time.Sleep(1 * time.Second) // exceeding soft start, sleep
+			// exceeding soft start, sleep
+			select {
+			case <-time.After(1 * time.Second):
+			case <-(*cfg.Ctx).Done():
+			}
 			startCount = cfg.SoftStartRate
 		}
 		if end == uint64(len(cfg.RunData.clients))-1 {
 			break
 		}
 	}
-
-	waitval := cfg.Eg.Wait()
-	log.Infof("Eg wait ended! %v", waitval)
-
-}
-
-func getClientFromIP(cfg *ClientGenConfig, ip net.IP) (*SingleClientGen, error) {
-
-	// if cfg is IPv6, make sure the argument is IPv6
-	if cfg.RunData.clients[0].ClientIP.To4() == nil {
-		if ip.To4() != nil {
-			return nil, fmt.Errorf("getClientFromIP ipv4 ipv6 mismatch %v", ip)
-		}
-	}
-
-	// do the byte subtraction
-
-	diff, _ := ipSubtract(ip, cfg.RunData.clients[0].ClientIP)
-	// check the subtract is a multiple of step
-
-	if cfg.DebugPrint {
-		log.Infof("getClientFromIP frompkt %v start %v diff %v ", ip,
-			cfg.RunData.clients[0].ClientIP, diff)
-	}
-	if diff%uint64(cfg.ClientIPStep) == 0 {
-		index := int(diff / uint64(cfg.ClientIPStep))
-		if index >= len(cfg.RunData.clients) {
-			return nil, fmt.Errorf("Could not find client with ip %v", ip)
-		}
-		return &cfg.RunData.clients[index], nil
-	}
-
-	return nil, fmt.Errorf("Could not find client with ip %v", ip)
 }
 
 func getTxChanNumToUse(cfg *ClientGenConfig) uint32 {
@@ -254,16 +384,6 @@ func getTxChanNumToUse(cfg *ClientGenConfig) uint32 {
 	return newVal % uint32(cfg.NumTXWorkers)
 }
 
-func getRxChanNumToUse(cfg *ClientGenConfig) uint32 {
-	newVal := atomic.AddUint32(&cfg.RunData.inChanToUse, 1)
-	return newVal % uint32(cfg.NumPacketParsers)
-}
-
-func getPacketProcChanNumToUse(cfg *ClientGenConfig) uint32 {
-	newVal := atomic.AddUint32(&cfg.RunData.pktProcChanToUse, 1)
-	return newVal % uint32(cfg.NumPacketProcessors)
-}
-
 func pushClientRetransmit(cfg *ClientGenConfig, cl *SingleClientGen, t time.Time) {
 	procNum := cl.index % cfg.NumClientRetransmitProcs
 	opItem := cfg.RunData.heapOpPool.Get().(*parallelHeapOp)
@@ -275,7 +395,7 @@ func pushClientRetransmit(cfg *ClientGenConfig, cl *SingleClientGen, t time.Time
 		log.Infof("pushClientRetransmit %v procNum %v t %v",
 			opItem.value.(*SingleClientGen).ClientIP, procNum, opItem.priorityReq)
 	}
-	cfg.RunData.retransmitHeap[procNum].operationChan <- opItem
+	sendHeapOp(cfg, &cfg.RunData.retransmitHeap[procNum], opItem)
 
 }
 
@@ -288,7 +408,7 @@ func removeClientRetransmit(cfg *ClientGenConfig, cl *SingleClientGen) {
 	opItem.item = &cl.RetransTimer
 	opItem.operation = heapRemove
 
-	cfg.RunData.retransmitHeap[procNum].operationChan <- opItem
+	sendHeapOp(cfg, &cfg.RunData.retransmitHeap[procNum], opItem)
 
 }
 
@@ -302,7 +422,7 @@ func pushClientRestart(cfg *ClientGenConfig, cl *SingleClientGen, t time.Time) {
 	opItem.priorityReq = t
 	opItem.value = cl
 
-	cfg.RunData.restartHeap[procNum].operationChan <- opItem
+	sendHeapOp(cfg, &cfg.RunData.restartHeap[procNum], opItem)
 
 }
 
@@ -318,107 +438,47 @@ func removeClientRestart(cfg *ClientGenConfig, cl *SingleClientGen) {
 }
 */
 
-func craftSinglePktToGM(cfg *ClientGenConfig, cl *SingleClientGen, udpport uint16, payload []byte, out *outPacket) {
-	var isIP6 bool
-	var eth layers.Ethernet
-	var udp layers.UDP
-	var err error
-	if cl.ClientIP.To4() == nil {
-		isIP6 = true
-	} else {
-		isIP6 = false
-	}
-	// a bit out of order, but these layers aren't affected by ipv6 vs ipv4
-	buf := out.data
-	payloadBuf := gopacket.Payload(payload)
-	udp = layers.UDP{
-		SrcPort: layers.UDPPort(udpport),
-		DstPort: layers.UDPPort(udpport),
-	}
-
-	if isIP6 {
-		eth = layers.Ethernet{
-			SrcMAC:       cfg.srcMAC,
-			DstMAC:       cfg.parsedServerMac,
-			EthernetType: layers.EthernetTypeIPv6,
-		}
-	} else {
-		eth = layers.Ethernet{
-			SrcMAC:       cfg.srcMAC,
-			DstMAC:       cfg.parsedServerMac,
-			EthernetType: layers.EthernetTypeIPv4,
-		}
-	}
-
-	if isIP6 {
-		ip := layers.IPv6{
-			SrcIP:      cl.ClientIP,
-			DstIP:      net.ParseIP(cfg.ServerAddress),
-			Version:    6,
-			HopLimit:   255,
-			NextHeader: layers.IPProtocolUDP,
-		}
-		err = udp.SetNetworkLayerForChecksum(&ip)
-		if err != nil {
-			log.Errorf("SetNetworkLayerForChecksum failed %v", err)
-		}
-		err = gopacket.SerializeLayers(*buf,
-			cfg.RunData.commonSerializeOp, &eth, &ip, &udp, payloadBuf)
-		if err != nil {
-			log.Errorf("SerializeLayers failed %v", err)
-		}
-	} else {
-		ip := layers.IPv4{
-			SrcIP:    cl.ClientIP,
-			DstIP:    net.ParseIP(cfg.ServerAddress),
-			Version:  4,
-			TTL:      255,
-			Protocol: layers.IPProtocolUDP,
-		}
-		err = udp.SetNetworkLayerForChecksum(&ip)
-		if err != nil {
-			log.Errorf("SetNetworkLayerForChecksum failed %v", err)
-		}
-		err = gopacket.SerializeLayers(*buf,
-			cfg.RunData.commonSerializeOp, &eth, &ip, &udp, payloadBuf)
-		if err != nil {
-			log.Errorf("SerializeLayers failed %v", err)
-		}
+func craftSinglePktToGM(cfg *ClientGenConfig, cl *SingleClientGen, udpport uint16, tmpl *ptpTemplate, clockID ptp.ClockIdentity, seq uint16, out *outPacket) {
+	// the headers come from the client's template, see setupTemplates
+	if err := cl.tmpl.writePTP(*out.data, serverMAC(cfg), udpport, tmpl, clockID, seq); err != nil {
+		log.Errorf("Frame template write failed %v", err)
 	}
-
 }
 
 func handleRetransmit(cfg *ClientGenConfig, cl *SingleClientGen, fromPop bool, clientProcNum int) {
 	// basically need to send out a packet for this client, figure out what packet
-	var payload *ptp.Signaling
+	var what ptp.MessageType // unicast grant to request
 	var pktType uint8
 
+	// the packet is built while holding the semaphore, it's sent after releasing it
 	err := cl.stateSem.Acquire(*cfg.Ctx, 1)
 	if err != nil {
 		log.Errorf("handleRetransmit semaphore acquire error %v", err)
+		return
 	}
 	curState := cl.state
-	// technically race condition but I dont think its a problem
 
 	if curState == stateDone {
-		cl.stateSem.Release(1)
 		cl.CountRetransmitDone++
 		// check if duration is over
 		if time.Since(cl.timeDoneInit) > time.Duration(cfg.DurationSec*float64(time.Second)) {
+			cl.stateSem.Release(1)
 			// do nothing , handled by restart client
 			if cfg.DebugPrint || cfg.DebugLogClient {
 				log.Infof("Retransmit client %v, but time since init elapsed duration", cl.ClientIP)
 			}
 			return
+		} else if cfg.DelayMechanism == DelayMechanismP2P {
+			out := newPDelayReq(cfg, cl)
+			cl.stateSem.Release(1)
+			sendRawOutput(cfg, out)
+			pushClientRetransmit(cfg, cl, fastime.Now().Add(time.Duration(cfg.TimeBetweenDelayReqSec*float64(time.Second))))
+			return
 		} else {
 			// client is still valid and done, basically only thing is to
 			// do DelayReq
-			payload := reqDelay(0)
-			payload.SetSequence(cl.eventSequence)
-			b, _ := ptp.Bytes(payload)
-
 			out := cfg.RunData.outPacketPool.Get().(*outPacket)
-			craftSinglePktToGM(cfg, cl, ptp.PortEvent, b, out)
+			craftSinglePktToGM(cfg, cl, ptp.PortEvent, &cfg.RunData.payloads.delayReq, 0, cl.eventSequence, out)
 			out.getTS = true
 			out.pktType = pktDelayReq
 			out.cl = cl
@@ -428,18 +488,18 @@ func handleRetransmit(cfg *ClientGenConfig, cl *SingleClientGen, fromPop bool, c
 			if cfg.DebugLogClient || cfg.DebugPrint {
 				log.Infof("Client %v reqDelay seq %v", cl.ClientIP, cl.eventSequence)
 			}
+			cl.stateSem.Release(1)
 			// push to transmit and add to retransmit
 			pushClientRetransmit(cfg, cl, fastime.Now().Add(time.Duration(cfg.TimeBetweenDelayReqSec*float64(time.Second))))
-			cfg.RunData.rawOutput[getTxChanNumToUse(cfg)] <- out
+			sendRawOutput(cfg, out)
 			return
 		}
 	} else if curState == stateInit {
-		cl.stateSem.Release(1)
 		// need to request announce grant
 		if cfg.DebugLogClient || cfg.DebugPrint {
 			log.Infof("Init state cl %v state %v, reqUnicast MessageAnnounce seq=%d ", cl.ClientIP, curState, cl.genSequence)
 		}
-		payload = reqUnicast(ptp.ClockIdentity(cl.index), time.Duration(float64(time.Second)*cfg.DurationSec), ptp.MessageAnnounce)
+		what = ptp.MessageAnnounce
 		pktType = pktAnnounceGrantReq
 		atomic.AddUint64(&cfg.Counters.TotalGenMsgSent, 1)
 		atomic.AddUint64(&cfg.Counters.TotalClientAnnounceReq, 1)
@@ -448,12 +508,11 @@ func handleRetransmit(cfg *ClientGenConfig, cl *SingleClientGen, fromPop bool, c
 			atomic.AddUint64(&cfg.Counters.TotalClientAnnounceReqResend, 1)
 		}
 	} else if curState == stateGotGrantAnnounce {
-		cl.stateSem.Release(1)
 		// need to request sync grant
 		if cfg.DebugLogClient || cfg.DebugPrint {
 			log.Infof("GotGrantAnnounce cl %v state %v, reqUnicast MessageSync seq=%d", cl.ClientIP, curState, cl.genSequence)
 		}
-		payload = reqUnicast(ptp.ClockIdentity(cl.index), time.Duration(float64(time.Second)*cfg.DurationSec), ptp.MessageSync)
+		what = ptp.MessageSync
 		pktType = pktSyncGrantReq
 		atomic.AddUint64(&cfg.Counters.TotalGenMsgSent, 1)
 		atomic.AddUint64(&cfg.Counters.TotalClientSyncReq, 1)
@@ -462,44 +521,40 @@ func handleRetransmit(cfg *ClientGenConfig, cl *SingleClientGen, fromPop bool, c
 			atomic.AddUint64(&cfg.Counters.TotalClientSyncReqResend, 1)
 		}
 	} else if curState == stateGotGrantSync {
-		cl.stateSem.Release(1)
-		// need to request DelayResp grant
+		// need to request DelayResp grant, PDelayResp for peer delay
+		what = delayRespType(cfg)
 		if cfg.DebugLogClient || cfg.DebugPrint {
-			log.Infof("GotGrantSync cl %v state %v, reqUnicast MessageDelayResp seq=%d", cl.ClientIP, curState, cl.genSequence)
+			log.Infof("GotGrantSync cl %v state %v, reqUnicast %s seq=%d", cl.ClientIP, curState, what, cl.genSequence)
 		}
-		payload = reqUnicast(ptp.ClockIdentity(cl.index), time.Duration(float64(time.Second)*cfg.DurationSec), ptp.MessageDelayResp)
 		pktType = pktDelayRespGrantReq
 		atomic.AddUint64(&cfg.Counters.TotalGenMsgSent, 1)
-		atomic.AddUint64(&cfg.Counters.TotalClientDelayRespReq, 1)
 		cl.CountDelayRespGrantReq++
-		if cl.laststate == curState {
-			atomic.AddUint64(&cfg.Counters.TotalClientDelayRespReqResend, 1)
+		if what == ptp.MessagePDelayResp {
+			atomic.AddUint64(&cfg.Counters.TotalClientPDelayRespReq, 1)
+			if cl.laststate == curState {
+				atomic.AddUint64(&cfg.Counters.TotalClientPDelayRespReqResend, 1)
+			}
+		} else {
+			atomic.AddUint64(&cfg.Counters.TotalClientDelayRespReq, 1)
+			if cl.laststate == curState {
+				atomic.AddUint64(&cfg.Counters.TotalClientDelayRespReqResend, 1)
+			}
 		}
 	} else {
-		cl.stateSem.Release(1)
 		cl.CountRetransmitWierdState++
+		cl.stateSem.Release(1)
 		log.Infof("Client in wierd state during retransmit %v", curState)
 		return
 	}
 
 	cl.laststate = cl.state
-	payload.SetSequence(cl.genSequence)
-	b, err := ptp.Bytes(payload)
-	if err != nil {
-		log.Errorf("handleRetransmit ptp.Bytes error %v", err)
-	}
 	out := cfg.RunData.outPacketPool.Get().(*outPacket)
-	craftSinglePktToGM(cfg, cl, ptp.PortGeneral, b, out)
+	craftSinglePktToGM(cfg, cl, ptp.PortGeneral, cfg.RunData.payloads.grantReq[what], ptp.ClockIdentity(cl.index), cl.genSequence, out)
 	out.getTS = true
 	out.pktType = pktType
 	out.cl = cl
 
-	// push to transmit and add to retransmit
-	cfg.RunData.rawOutput[getTxChanNumToUse(cfg)] <- out
-
 	clRetransTime := fastime.Now().Add( time.Duration(float64(time.Second)*cfg.ClientRetranTimeWhenNoResponseSec) )
-
-	pushClientRetransmit(cfg, cl, clRetransTime)
 	if pktType == pktAnnounceGrantReq {
 		cl.timeAnnounceGrantReqRetransmit = clRetransTime
 	} else if pktType == pktSyncGrantReq {
@@ -507,7 +562,11 @@ func handleRetransmit(cfg *ClientGenConfig, cl *SingleClientGen, fromPop bool, c
 	} else if pktType == pktDelayRespGrantReq {
 		cl.timeDelayRespGrantReqRetransmit = clRetransTime
 	}
+	cl.stateSem.Release(1)
 
+	// push to transmit and add to retransmit
+	sendRawOutput(cfg, out)
+	pushClientRetransmit(cfg, cl, clRetransTime)
 }
 
 func handleRestart(cfg *ClientGenConfig, cl *SingleClientGen) {
@@ -515,6 +574,7 @@ func handleRestart(cfg *ClientGenConfig, cl *SingleClientGen) {
 	err := cl.stateSem.Acquire(*cfg.Ctx, 1)
 	if err != nil {
 		log.Errorf("handleRestart client semaphore acquire err %v", err)
+		return
 	}
 	cl.state = stateInit
 	cl.genSequence = 0
@@ -524,6 +584,7 @@ func handleRestart(cfg *ClientGenConfig, cl *SingleClientGen) {
 		cl.lastSyncTimes[i] = time.Time{}
 		cl.lastFollowupTimes[i] = time.Time{}
 	}
+	cl.pdelay = pdelayExchange{}
 	cl.stateSem.Release(1)
 
 	removeClientRetransmit(cfg, cl)
@@ -545,13 +606,31 @@ func singleClientHandleIncomingPTP(cfg *ClientGenConfig, cl *SingleClientGen, in
 		return nil, err
 	}
 
+	// the fields of cl belong to whoever holds stateSem, the retransmit and restart procs and the
+	// TX workers change them too. Anything that queues work for them runs after releasing it
+	if err = cl.stateSem.Acquire(*cfg.Ctx, 1); err != nil {
+		return nil, err
+	}
+	var retransmit bool
+	var restartAt time.Time
+	defer func() {
+		cl.stateSem.Release(1)
+		if !restartAt.IsZero() {
+			pushClientRestart(cfg, cl, restartAt)
+		}
+		if retransmit {
+			removeClientRetransmit(cfg, cl)
+			handleRetransmit(cfg, cl, false, 0)
+		}
+	}()
+
 	if in.fromTX {
 		// similar to below code, but do it here in the beginning
 		// basically just need to figure out where to put TX timestamp
 		switch msgType {
 		case ptp.MessageSignaling: // sent a signaling message
-			signaling := &ptp.Signaling{}
-			if err := ptp.FromBytes(payload, signaling); err != nil {
+			signaling := &in.ptp.signaling
+			if err := signaling.UnmarshalBinary(payload); err != nil {
 				log.Errorf(`Failed to get signaling from payload err %v\n
 					eth: %v\n
 					ipv4: %v\n 
@@ -576,7 +655,7 @@ func singleClientHandleIncomingPTP(cfg *ClientGenConfig, cl *SingleClientGen, in
 						if cfg.DebugPrint {
 							log.Debugf("Client %v HW SentSyncGrantReqTime %v", cl.ClientIP, in.Timestamp)
 						}
-					case ptp.MessageDelayResp: // sending delay resp grant req
+					case ptp.MessageDelayResp, ptp.MessagePDelayResp: // sending delay resp grant req, PDelayResp for peer delay
 						cl.SentDelayRespGrantReqTime = in.Timestamp
 						if cfg.DebugPrint {
 							log.Debugf("Client %v HW SentDelayRespGrantReqTime %v", cl.ClientIP, in.Timestamp)
@@ -589,18 +668,36 @@ func singleClientHandleIncomingPTP(cfg *ClientGenConfig, cl *SingleClientGen, in
 			if cfg.DebugPrint {
 				log.Debugf("Client %v HW SentDelayReqTime %v", cl.ClientIP, in.Timestamp)
 			}
+		case ptp.MessagePDelayReq: // sent a PDelayReq
+			req := &in.ptp.pdelayReq
+			if err := req.UnmarshalBinary(payload); err != nil {
+				return nil, fmt.Errorf("reading pdelay_req msg: %w", err)
+			}
+			if cfg.DebugPrint {
+				log.Debugf("Client %v HW SentPDelayReqTime %v", cl.ClientIP, in.Timestamp)
+			}
+			handlePDelayReqSent(cfg, cl, req, in.Timestamp)
 		}
 		return nil, nil
 	}
 
+	if cfg.auth != nil {
+		if err := verifyAuth(cfg, payload); err != nil {
+			if cfg.DebugLogClient || cfg.DebugPrint {
+				log.Debugf("Client %v dropping %s: %v", cl.ClientIP, msgType, err)
+			}
+			return nil, err
+		}
+	}
+
 	var toSendPayload []byte
 	cl.CountIncomingPTPPackets++
 
 	switch msgType {
 	case ptp.MessageSignaling:
 		atomic.AddUint64(&cfg.Counters.TotalGenMsgRcvd, 1)
-		signaling := &ptp.Signaling{}
-		if err := ptp.FromBytes(payload, signaling); err != nil {
+		signaling := &in.ptp.signaling
+		if err := signaling.UnmarshalBinary(payload); err != nil {
 			log.Infof("Failed to get signaling from payload")
 			return nil, fmt.Errorf("reading signaling msg: %w", err)
 		}
@@ -619,13 +716,8 @@ func singleClientHandleIncomingPTP(cfg *ClientGenConfig, cl *SingleClientGen, in
 						log.Debugf("Got Announce grant %v", cl.ClientIP)
 					}
 					atomic.AddUint64(&cfg.Counters.TotalClientAnnounceGrant, 1)
-					err := cl.stateSem.Acquire(*cfg.Ctx, 1)
-					if err != nil {
-						log.Errorf("singleClientHandleIncomingPTP cl semaphore acquire err %v", err)
-					}
 					cl.state = stateGotGrantAnnounce
 					cl.genSequence++
-					cl.stateSem.Release(1)
 					// one comment for these removeClientRetransmit
 					// retransmit is only valid for certain cases
 					// I only want to remove it for some packets, not for all
@@ -637,8 +729,7 @@ func singleClientHandleIncomingPTP(cfg *ClientGenConfig, cl *SingleClientGen, in
 							cl.RetransTimer.index, in.Timestamp)
 					}
 					cl.CountAnnounceGrant++
-					removeClientRetransmit(cfg, cl)
-					handleRetransmit(cfg, cl, false, 0)
+					retransmit = true
 					// handle statistic
 					cl.GotAnnounceGrantReqTime = in.Timestamp
 
@@ -646,46 +737,37 @@ func singleClientHandleIncomingPTP(cfg *ClientGenConfig, cl *SingleClientGen, in
 					if cfg.DebugLogClient || cfg.DebugPrint {
 						log.Debugf("Got Sync grant %v", cl.ClientIP)
 					}
-					err := cl.stateSem.Acquire(*cfg.Ctx, 1)
-					if err != nil {
-						log.Errorf("singleClientHandleIncomingPTP cl semaphore acquire err %v", err)
-					}
 					cl.state = stateGotGrantSync
 					cl.genSequence++
-					cl.stateSem.Release(1)
 					cl.CountSyncGrant++
 					atomic.AddUint64(&cfg.Counters.TotalClientSyncGrant, 1)
 					if cfg.DebugPrint || cfg.DebugRetransProc {
 						log.Debugf("removeClientRetransmit in singleClientHandleIncomingPTP got sync grant")
 					}
-					removeClientRetransmit(cfg, cl)
-					handleRetransmit(cfg, cl, false, 0)
+					retransmit = true
 					// handle statistics
 					cl.GotlastSyncGrantReqTime = in.Timestamp
-				case ptp.MessageDelayResp:
+				case ptp.MessageDelayResp, ptp.MessagePDelayResp:
 					if cfg.DebugLogClient || cfg.DebugPrint {
-						log.Debugf("Got DelayResp grant %v", cl.ClientIP)
-					}
-					err := cl.stateSem.Acquire(*cfg.Ctx, 1)
-					if err != nil {
-						log.Errorf("singleClientHandleIncomingPTP cl semaphore acquire err %v", err)
+						log.Debugf("Got %s grant %v", msgType, cl.ClientIP)
 					}
 					cl.state = stateDone
 					cl.genSequence++
 					cl.timeDoneInit = fastime.Now()
-					cl.stateSem.Release(1)
 					cl.CountDelayRespGrant++
 					if cfg.RestartClientsAfterDuration {
-						pushClientRestart(cfg, cl,
-							fastime.Now().Add(time.Duration(float64(time.Second)*cfg.TimeAfterDurationBeforeRestartSec)).Add(
-								time.Duration(cfg.DurationSec*float64(time.Second))))
+						restartAt = fastime.Now().Add(time.Duration(float64(time.Second)*cfg.TimeAfterDurationBeforeRestartSec)).Add(
+							time.Duration(cfg.DurationSec * float64(time.Second)))
+					}
+					if msgType == ptp.MessagePDelayResp {
+						atomic.AddUint64(&cfg.Counters.TotalClientPDelayRespGrant, 1)
+					} else {
+						atomic.AddUint64(&cfg.Counters.TotalClientDelayRespGrant, 1)
 					}
-					atomic.AddUint64(&cfg.Counters.TotalClientDelayRespGrant, 1)
 					if cfg.DebugPrint || cfg.DebugRetransProc {
 						log.Debugf("removeClientRetransmit in singleClientHandleIncomingPTP got delayresp grant")
 					}
-					removeClientRetransmit(cfg, cl)
-					handleRetransmit(cfg, cl, false, 0)
+					retransmit = true
 					// handle statistics
 					cl.GotDelayRespGrantReqTime = in.Timestamp
 				}
@@ -696,16 +778,24 @@ func singleClientHandleIncomingPTP(cfg *ClientGenConfig, cl *SingleClientGen, in
 				if err != nil {
 					return nil, err
 				}
+			case *ptp.AuthenticationTLV:
+				// checked above with the rest of the message, unless there is no AuthKeyFile
+				if cfg.auth == nil {
+					atomic.AddUint64(&cfg.Counters.TotalSignalingTLVsIgnored, 1)
+				}
 			default:
-				log.Infof("!!!!!!!!!!!!!! Got unsupported TLV !!!!!!!!!!!!!!")
-				return nil, fmt.Errorf("got unsupported TLV type %s(%d)", tlv.Type(), tlv.Type())
+				// PATH_TRACE, ORGANIZATION_EXTENSION and such don't change the negotiation
+				atomic.AddUint64(&cfg.Counters.TotalSignalingTLVsIgnored, 1)
+				if cfg.DebugLogClient || cfg.DebugPrint {
+					log.Debugf("Ignoring signaling TLV %s(%d) from %v", tlv.Type(), tlv.Type(), cl.ClientIP)
+				}
 			}
 		}
 	case ptp.MessageAnnounce:
 		atomic.AddUint64(&cfg.Counters.TotalGenMsgRcvd, 1)
 		atomic.AddUint64(&cfg.Counters.TotalAnnounceRcvd, 1)
-		announce := &ptp.Announce{}
-		if err := ptp.FromBytes(payload, announce); err != nil {
+		announce := &in.ptp.announce
+		if err := announce.UnmarshalBinary(payload); err != nil {
 			return nil, fmt.Errorf("reading announce msg: %w", err)
 		}
 		if cfg.DebugLogClient || cfg.DebugPrint {
@@ -721,8 +811,8 @@ func singleClientHandleIncomingPTP(cfg *ClientGenConfig, cl *SingleClientGen, in
 	case ptp.MessageSync:
 		atomic.AddUint64(&cfg.Counters.TotalEventMsgRcvd, 1)
 		atomic.AddUint64(&cfg.Counters.TotalSyncRcvd, 1)
-		b := &ptp.SyncDelayReq{}
-		if err := ptp.FromBytes(payload, b); err != nil {
+		b := &in.ptp.sync
+		if err := b.UnmarshalBinary(payload); err != nil {
 			return nil, fmt.Errorf("reading sync msg: %w", err)
 		}
 		if cfg.DebugLogClient || cfg.DebugPrint {
@@ -738,8 +828,8 @@ func singleClientHandleIncomingPTP(cfg *ClientGenConfig, cl *SingleClientGen, in
 		atomic.AddUint64(&cfg.Counters.TotalEventMsgRcvd, 1)
 		atomic.AddUint64(&cfg.Counters.TotalDelayRespRcvd, 1)
 		cl.CountDelayResp++
-		b := &ptp.DelayResp{}
-		if err := ptp.FromBytes(payload, b); err != nil {
+		b := &in.ptp.delayResp
+		if err := b.UnmarshalBinary(payload); err != nil {
 			return nil, fmt.Errorf("reading delay_resp msg: %w", err)
 		}
 		// increase genSequence here so next DelayReq is one higher
@@ -755,8 +845,8 @@ func singleClientHandleIncomingPTP(cfg *ClientGenConfig, cl *SingleClientGen, in
 	case ptp.MessageFollowUp:
 		atomic.AddUint64(&cfg.Counters.TotalGenMsgRcvd, 1)
 		atomic.AddUint64(&cfg.Counters.TotalFollowUpRcvd, 1)
-		b := &ptp.FollowUp{}
-		if err := ptp.FromBytes(payload, b); err != nil {
+		b := &in.ptp.followUp
+		if err := b.UnmarshalBinary(payload); err != nil {
 			return nil, fmt.Errorf("reading follow_up msg: %w", err)
 		}
 		if cfg.DebugLogClient || cfg.DebugPrint {
@@ -769,6 +859,22 @@ func singleClientHandleIncomingPTP(cfg *ClientGenConfig, cl *SingleClientGen, in
 			cl.lastFollowupTimes[i] = cl.lastFollowupTimes[i-1]
 		}
 		cl.lastFollowupTimes[0] = in.Timestamp
+	case ptp.MessagePDelayResp:
+		atomic.AddUint64(&cfg.Counters.TotalEventMsgRcvd, 1)
+		atomic.AddUint64(&cfg.Counters.TotalPDelayRespRcvd, 1)
+		b := &in.ptp.pdelayResp
+		if err := b.UnmarshalBinary(payload); err != nil {
+			return nil, fmt.Errorf("reading pdelay_resp msg: %w", err)
+		}
+		handlePDelayResp(cfg, cl, b, in.Timestamp)
+	case ptp.MessagePDelayRespFollowUp:
+		atomic.AddUint64(&cfg.Counters.TotalGenMsgRcvd, 1)
+		atomic.AddUint64(&cfg.Counters.TotalPDelayRespFollowUpRcvd, 1)
+		b := &in.ptp.pdelayRespFollowUp
+		if err := b.UnmarshalBinary(payload); err != nil {
+			return nil, fmt.Errorf("reading pdelay_resp_follow_up msg: %w", err)
+		}
+		handlePDelayRespFollowUp(cfg, cl, b)
 	default:
 		return nil, fmt.Errorf("Unknown ptp message")
 	}
@@ -822,9 +928,9 @@ func handleUDPIncoming(cfg *ClientGenConfig, in *PktDecoder) {
 		// check if IP is in client range
 		// get the client structure for this
 		if !in.fromTX {
-			cl, err = getClientFromIP(cfg, ip4.DstIP)
+			cl, err = getClientFromIP(cfg, in.vlan(), ip4.DstIP)
 		} else {
-			cl, err = getClientFromIP(cfg, ip4.SrcIP)
+			cl, err = getClientFromIP(cfg, in.vlan(), ip4.SrcIP)
 		}
 		if cl == nil || err != nil {
 			if cfg.DebugPrint {
@@ -838,9 +944,9 @@ func handleUDPIncoming(cfg *ClientGenConfig, in *PktDecoder) {
 			log.Debugf("Got ipv6 %+v", ip6)
 		}
 		if !in.fromTX {
-			cl, err = getClientFromIP(cfg, ip6.DstIP)
+			cl, err = getClientFromIP(cfg, in.vlan(), ip6.DstIP)
 		} else {
-			cl, err = getClientFromIP(cfg, ip6.SrcIP)
+			cl, err = getClientFromIP(cfg, in.vlan(), ip6.SrcIP)
 		}
 		if cl == nil || err != nil {
 			if cfg.DebugPrint {
@@ -858,13 +964,13 @@ func handleUDPIncoming(cfg *ClientGenConfig, in *PktDecoder) {
 		return
 	}
 	if toSend != nil {
-		cfg.RunData.rawOutput[getTxChanNumToUse(cfg)] <- toSend
+		sendRawOutput(cfg, toSend)
 	}
 
 }
 
 func startClientProcessor(cfg *ClientGenConfig) {
-	retransStartDone := make(chan bool)
+	retransStartDone := make(chan bool, cfg.NumClientRetransmitProcs)
 	for retransProc := 0; retransProc < cfg.NumClientRetransmitProcs; retransProc++ {
 		func(i int) {
 			cfg.Eg.Go(func() error {
@@ -884,6 +990,10 @@ func startClientProcessor(cfg *ClientGenConfig) {
 					retransStartDone <- true
 					for {
 						select {
+						case <-(*cfg.Ctx).Done():
+							// the heaps are polled, don't keep spinning after the run
+							doneChan <- nil
+							return
 						case heapOp = <-myHeap.operationChan:
 							if cfg.DebugPrint || cfg.DebugRetransProc {
 								log.Debugf("Retransmit proc %d operation %+v\n", i, heapOp)
@@ -926,6 +1036,7 @@ func startClientProcessor(cfg *ClientGenConfig) {
 				select {
 				case <-(*cfg.Ctx).Done():
 					log.Infof("Client Retransmit Proc %d cancelling", i)
+					<-doneChan
 					return (*cfg.Ctx).Err()
 				case err = <-doneChan:
 					log.Infof("Client Retransmit Proc %d donechan", i)
@@ -948,7 +1059,7 @@ func startClientProcessor(cfg *ClientGenConfig) {
 	if !cfg.RestartClientsAfterDuration {
 		return
 	}
-	restartStartDone := make(chan bool)
+	restartStartDone := make(chan bool, cfg.NumClientRestartProcs)
 	for restartProc := 0; restartProc < cfg.NumClientRestartProcs; restartProc++ {
 		func(i int) {
 			cfg.Eg.Go(func() error {
@@ -966,6 +1077,9 @@ func startClientProcessor(cfg *ClientGenConfig) {
 					restartStartDone <- true
 					for {
 						select {
+						case <-(*cfg.Ctx).Done():
+							doneChan <- nil
+							return
 						case heapOp := <-myHeap.operationChan:
 							profiler.Tick()
 							if heapOp.operation == heapRemove {
@@ -998,6 +1112,7 @@ func startClientProcessor(cfg *ClientGenConfig) {
 				select {
 				case <-(*cfg.Ctx).Done():
 					log.Infof("Client Restart Proc %d cancelling", i)
+					<-doneChan
 					return (*cfg.Ctx).Err()
 				case err := <-doneChan:
 					log.Infof("Client Restart Proc %d donechan", i)
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 	"math/big"
 
@@ -30,6 +31,7 @@ import (
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/kpango/fastime"
+	ptp "github.com/facebook/time/ptp/protocol"
 )
 
 type state int
@@ -48,6 +50,30 @@ const (
 	pktSyncGrantReq
 	pktDelayRespGrantReq
 	pktDelayReq
+	pktPDelayReq
+)
+
+// packet IO backends selectable with ClientGenConfig.IOBackend
+const (
+	IOBackendPFRing   = "pfring"
+	IOBackendAFPacket = "afpacket"
+	IOBackendAFXDP    = "afxdp"
+	IOBackendPcap     = "pcap"
+	IOBackendNull     = "null"
+	IOBackendLoopback = "loopback"
+	IOBackendKernel   = "kernel"
+)
+
+// PTP transports selectable with ClientGenConfig.Transport
+const (
+	TransportUDP = "udp"
+	TransportL2  = "l2"
+)
+
+// delay mechanisms selectable with ClientGenConfig.DelayMechanism
+const (
+	DelayMechanismE2E = "e2e"
+	DelayMechanismP2P = "p2p"
 )
 
 type RunningStatistics struct {
@@ -108,22 +134,114 @@ type GlobalStatistics struct {
 	PFRingTXPackets      uint64
 	PFRingTXBytes        uint64
 	PFRingHWTimestamps   uint64
+
+	TotalCapturePackets uint64
+	TotalCaptureDropped uint64
+
+	ServerMACResolveSent uint64
+	ServerMACResolved    uint64
+	ServerMACChanges     uint64
+
+	TotalGratuitousARPSent uint64
+	TotalUnsolicitedNASent uint64
+
+	TotalARPRequestsRcvd    uint64
+	TotalARPRepliesSent     uint64
+	TotalARPRequestsIgnored uint64
+	TotalNSRcvd             uint64
+	TotalNASent             uint64
+	TotalNSIgnored          uint64
+	TotalNARcvd             uint64
+	TotalDADProbesRcvd      uint64
+	TotalEchoRequestsRcvd   uint64
+	TotalEchoRepliesSent    uint64
+
+	TotalQueueFull      uint64
+	TotalQueueBlockedUs uint64
+	TotalQueueDropped   uint64
+
+	// peer delay, the DelayMechanism "p2p"
+	TotalClientPDelayRespReq       uint64
+	TotalClientPDelayRespReqResend uint64
+	TotalClientPDelayRespGrant     uint64
+	TotalPDelayReqSent             uint64
+	TotalPDelayRespIgnored         uint64
+	TotalMeanLinkDelays            uint64
+
+	// Signaling TLVs other than the unicast negotiation ones, skipped
+	TotalSignalingTLVsIgnored uint64
+
+	// server messages dropped for their AUTHENTICATION TLV, with AuthKeyFile
+	TotalAuthMissing    uint64
+	TotalAuthUnknownKey uint64
+	TotalAuthBadICV     uint64 // or a malformed TLV
+}
+
+// ClientRange is a range of client IPs, tagged with an 802.1Q VLAN if VLAN isn't 0
+// the clients are ClientIPStart to ClientIPEnd every ClientIPStep, every host of CIDR, or Addresses
+type ClientRange struct {
+	ClientIPStart string
+	ClientIPEnd   string
+	ClientIPStep  uint
+	CIDR          string   // e.g. "10.1.0.0/16", IPv4 without the network and broadcast addresses
+	Addresses     []string // explicit client IPs, need not be contiguous
+	VLAN          uint16 // VLAN ID, 0 sends untagged
+	PCP           uint8  // priority code point of the tagged frames
+}
+
+// InterfaceConfig is one interface of a multi interface run, empty or zero fields take the top level value
+type InterfaceConfig struct {
+	Iface         string
+	ClientMAC     string
+	ServerMAC     string
+	ServerAddress string
+	Gateway       string
+	ClientIPStart string
+	ClientIPEnd   string
+	ClientIPStep  uint
+	VLAN          uint16
+	PCP           uint8
+	ClientRanges  []ClientRange
+
+	NumTXWorkers             int
+	NumTXTSWorkerPerTx       int
+	NumRXWorkers             int
+	NumPacketParsers         int
+	NumPacketProcessors      int
+	NumClientRestartProcs    int
+	NumClientRetransmitProcs int
 }
 
 type ClientGenConfig struct {
-	ServerMAC       string
+	ServerMAC       string // resolved with ARP / NDP if empty
+	ClientMAC       string // source MAC of the client packets, Iface's MAC if empty
+	PerClientMAC    bool   // give every client its own MAC instead of ClientMAC / Iface's
+	ClientMACOUI    string // first three bytes of the per client MACs, locally administered 02 + index if empty
 	srcMAC          net.HardwareAddr
 	parsedServerMac net.HardwareAddr
 	// grand master IP address
 	ServerAddress string
+	// router between the clients and the GM, its MAC is resolved instead of the GM's
+	Gateway             string
+	ServerMACRefreshSec float64 // how often to resolve again when ServerMAC is empty, 60 if 0
 	// Define client IPs to run with
 	// Define start / stop / step
 	ClientIPStart string
 	ClientIPEnd   string
 	ClientIPStep  uint
-	SoftStartRate uint64 // how many clients / second to start
+	VLAN          uint16        // 802.1Q VLAN ID of the clients above, 0 sends untagged
+	PCP           uint8         // 802.1Q priority code point of the clients above
+	ClientRanges  []ClientRange // more clients, e.g. the same addresses in other VLANs
+	SoftStartRate uint64        // how many clients / second to start
+	// clients send a gratuitous ARP / unsolicited NA when kicked off so the switch and GM know them before the first request
+	AnnounceClients bool
 
 	Iface       string
+	IOBackend   string // PacketIO backend name, "pfring" (default), "afpacket", "afxdp", "pcap", "null", "loopback", "kernel" or one added with RegisterPacketIO
+	Transport   string            // PTP transport, "udp" (default) over IPv4 / IPv6 or "l2" straight over Ethernet
+	// "e2e" (default) measures the delay with DelayReq / DelayResp, "p2p" with PDelayReq / PDelayResp to the server
+	DelayMechanism string
+	Interfaces  []InterfaceConfig // run on all of these at once instead of just Iface, each with its own clients and workers
 	TimeoutSec  float64
 	DurationSec float64
 
@@ -134,6 +252,8 @@ type ClientGenConfig struct {
 	NumPacketProcessors int
 	NumClientRestartProcs int
 	NumClientRetransmitProcs int
+	TXBatchSize         int // how many queued packets a TX worker sends with one call, 0 or 1 sends one at a time
+	QueueOverflow       map[string]string // overflow policy by queue name, e.g. {"rawOutput": "drop-newest"}, unlisted queues block
 
 	DebugPrint       bool // very low level debug, prints everything
 	DebugLogClient   bool // prints higher level ptp protocol messages received or sent
@@ -149,6 +269,27 @@ type ClientGenConfig struct {
 	PrintClientData    bool
 	PrintClientReqData bool
 	PrintLatencyData   bool
+	PrintQueues        bool
+
+	CaptureFile      string   // pcapng file for every sent and received packet, empty disables capture
+	CaptureClientIPs []string // only capture packets to / from these client IPs, empty captures all
+	CaptureMaxFileMB int      // rotate the capture file when it reaches this size, 0 never rotates
+	CaptureMaxFiles  int      // how many rotated capture files to keep, 0 keeps all
+
+	ReplayFile  string // pcap or pcapng file the "pcap" IOBackend feeds in as RX traffic
+	ReplayPaced bool   // replay with the capture's gaps between packets instead of as fast as possible
+
+	// AUTHENTICATION TLV (IEEE 1588-2019 16.14) on every message the clients send, required on every one they get
+	AuthKeyFile string // security associations in linuxptp's sa_file format, empty disables authentication
+	AuthSPP     uint8  // security parameter pointer of the key the clients sign with
+	AuthKeyID   uint32 // ID of that key
+	AuthBadICV  bool   // send wrong ICVs, to see the GM reject them
+	auth        *authConfig
+
+	// simulated GM of the "loopback" IOBackend
+	LoopbackLatencySec     float64  // delay of every GM answer
+	LoopbackDenyGrants     []string // message types the GM denies grants for, e.g. "SYNC"
+	LoopbackCancelAfterSec float64  // GM cancels every grant this long after granting it, 0 never
 
 	RestartClientsAfterDuration       bool
 	TimeAfterDurationBeforeRestartSec float64
@@ -163,6 +304,7 @@ type ClientGenConfig struct {
 	CounterPrintIntervalSecs uint
 
 	PerfProfilers []*Profiler
+	TXBatchSizes  [txBatchBuckets]uint64 // TX worker batches by size, see txBatchBucket
 	perIOTX       []uint64
 	perIORX       []uint64
 
@@ -171,6 +313,8 @@ type ClientGenConfig struct {
 
 	RunData *ClientGenData
 
+	ifaceRuns []*ClientGenConfig // one per Interfaces entry, Counters is their sum
+
 	fastimeHolder *fastime.Fastime
 }
 
@@ -181,6 +325,10 @@ const (
 
 type SingleClientGen struct {
 	ClientIP net.IP
+	mac      net.HardwareAddr
+	vlan     uint16
+	pcp      uint8
+	tmpl     frameTemplate // headers of its packets to the GM
 
 	stateSem *semaphore.Weighted
 	state    state
@@ -205,6 +353,11 @@ type SingleClientGen struct {
 	SentDelayReqTime time.Time
 	GotDelayRespTime time.Time
 
+	SentPDelayReqTime time.Time
+	GotPDelayRespTime time.Time
+	MeanLinkDelay     time.Duration  // of the last complete peer delay exchange
+	pdelay            pdelayExchange // reset by the retransmit proc, filled in by the processor, both under stateSem
+
 	lastAnnounceTimes [latencyMeasCount]time.Time
 	lastSyncTimes     [latencyMeasCount]time.Time
 	lastFollowupTimes [latencyMeasCount]time.Time
@@ -224,12 +377,15 @@ type SingleClientGen struct {
 	CountSyncGrantReq uint64
 	CountSyncGrant    uint64
 
-	CountDelayRespGrantReq uint64
+	CountDelayRespGrantReq uint64 // DelayResp or PDelayResp grant, by DelayMechanism
 	CountDelayRespGrant    uint64
 
 	CountDelayReq  uint64
 	CountDelayResp uint64
 
+	CountPDelayReq  uint64
+	CountPDelayResp uint64
+
 	CountRetransmitDone       uint64
 	CountRetransmitWierdState uint64
 
@@ -254,10 +410,12 @@ const (
 type parallelHeap struct {
 	heap          timeHeap
 	operationChan chan *parallelHeapOp
+	stats         *queueStats
 }
 
 type PktDecoder struct {
 	eth       layers.Ethernet
+	dot1q     layers.Dot1Q
 	ip4       layers.IPv4
 	ip6       layers.IPv6
 	arp       layers.ARP
@@ -265,22 +423,57 @@ type PktDecoder struct {
 	icmpv6    layers.ICMPv6
 	icmpv6ns  layers.ICMPv6NeighborSolicitation
 	icmpv6na  layers.ICMPv6NeighborAdvertisement
+	icmpv6e   layers.ICMPv6Echo
+	icmp4     layers.ICMPv4
 	layers    []gopacket.LayerType
 	parser    *gopacket.DecodingLayerParser
 	rawData   []byte
 	Timestamp time.Time
 	fromTX    bool
+	hash      uint32 // picks the packet processor, see getRxChanNumToUse
+	ptp       ptpDecoder
+}
+
+// ptpDecoder holds the PTP messages of a PktDecoder, decoded into again for every packet
+// so the receive path doesn't allocate
+type ptpDecoder struct {
+	signaling ptp.Signaling
+	announce  ptp.Announce
+	sync      ptp.SyncDelayReq
+	delayResp ptp.DelayResp
+	followUp  ptp.FollowUp
+
+	pdelayReq          ptp.PDelayReq
+	pdelayResp         ptp.PDelayResp
+	pdelayRespFollowUp ptp.PDelayRespFollowUp
 }
 
 type ClientGenData struct {
 	clients   []SingleClientGen
+	spans     []clientSpan // where each ClientRange is in clients
+	scanSpans int          // spans getClientFromIP scans, 0 when byAddr has every client
+	byAddr    map[clientKey]int32
+	byMAC     map[string]*SingleClientGen // clients by MAC, only for the "l2" Transport
+
+	// resolved server MAC, nil unless ServerMAC is empty
+	serverMAC atomic.Value
+	resolver  *macResolver
+
+	serverIP net.IP // parsed ServerAddress, nil for the "l2" Transport
+	payloads payloadTemplates
+
 	rawInput  []chan *inPacket
 	rawOutput []chan *outPacket
 	pktToProc []chan *PktDecoder
 
+	// telemetry and overflow policy of the queues above and the heap operationChans
+	queues         []*queueStats
+	rawInputStats  []*queueStats
+	rawOutputStats []*queueStats
+	pktToProcStats []*queueStats
+
 	outChanToUse     uint32 // which rawOutput to write to, round robin basically
-	inChanToUse      uint32 // which rawInput chan to write to, round robin
-	pktProcChanToUse uint32
+	inChanToUse      uint32 // which rawInput chan to write frames of no client to, round robin
 
 	retransmitHeap []parallelHeap
 	restartHeap    []parallelHeap
@@ -299,6 +492,18 @@ type ClientGenData struct {
 
 	// create pool for generate byte array, used for TXTS stuff
 	bytePool sync.Pool
+
+	// nil unless CaptureFile is set
+	capture *captureSink
+
+	// nil unless IOBackend is "loopback"
+	loopback *loopbackGM
+
+	// nil unless IOBackend is "kernel"
+	kernel *kernelSockets
+
+	// the fanout group of the "afpacket" RX workers
+	afPacketFanout afPacketFanout
 }
 
 // ipSubtract returns the difference between two IPs
@@ -330,6 +535,9 @@ func ipSubtract(a net.IP, b net.IP) (uint64, error) {
 			return 0, fmt.Errorf("ipSubtractv6 a < b!")
 		} else {
 			sub := big.NewInt(0).Sub(aBig, bBig)
+			if !sub.IsUint64() {
+				return 0, fmt.Errorf("ipSubtractv6 difference over 64 bits")
+			}
 			return sub.Uint64(), nil
 		}
 	}
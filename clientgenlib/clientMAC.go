@@ -0,0 +1,82 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"fmt"
+	"net"
+)
+
+// clientMAC is the MAC of the client with index, under oui if it's set
+// otherwise locally administered with the index in the lower 40 bits
+func clientMAC(oui net.HardwareAddr, index int) (net.HardwareAddr, error) {
+	v := uint64(index)
+	mac := make(net.HardwareAddr, 6)
+	if oui == nil {
+		if v >= 1<<40 {
+			return nil, fmt.Errorf("client index %d too big for a MAC", index)
+		}
+		mac[0] = 0x02
+		for i := 5; i > 0; i-- {
+			mac[i] = byte(v)
+			v >>= 8
+		}
+		return mac, nil
+	}
+	if v >= 1<<24 {
+		return nil, fmt.Errorf("client index %d too big for a MAC under OUI %v", index, oui)
+	}
+	copy(mac, oui)
+	mac[3], mac[4], mac[5] = byte(v>>16), byte(v>>8), byte(v)
+	return mac, nil
+}
+
+// parseClientMACOUI parses ClientMACOUI, nil if it's empty
+func parseClientMACOUI(s string) (net.HardwareAddr, error) {
+	if s == "" {
+		return nil, nil
+	}
+	oui, err := net.ParseMAC(s + ":00:00:00")
+	if err != nil || len(oui) != 6 {
+		return nil, fmt.Errorf("bad ClientMACOUI %q", s)
+	}
+	if oui[0]&0x01 != 0 {
+		return nil, fmt.Errorf("ClientMACOUI %q is multicast", s)
+	}
+	return oui[:3], nil
+}
+
+// setClientMACs gives each client its MAC, cfg.srcMAC unless PerClientMAC is set
+func setClientMACs(cfg *ClientGenConfig) error {
+	if !cfg.PerClientMAC {
+		for i := range cfg.RunData.clients {
+			cfg.RunData.clients[i].mac = cfg.srcMAC
+		}
+		return nil
+	}
+	oui, err := parseClientMACOUI(cfg.ClientMACOUI)
+	if err != nil {
+		return err
+	}
+	for i := range cfg.RunData.clients {
+		cl := &cfg.RunData.clients[i]
+		if cl.mac, err = clientMAC(oui, cl.index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
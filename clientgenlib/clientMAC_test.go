@@ -0,0 +1,46 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_clientMAC(t *testing.T) {
+	mac, err := clientMAC(nil, 0x0102030405)
+	require.NoError(t, err)
+	require.Equal(t, net.HardwareAddr{0x02, 0x01, 0x02, 0x03, 0x04, 0x05}, mac)
+
+	oui, err := parseClientMACOUI("0c:42:a1")
+	require.NoError(t, err)
+	mac, err = clientMAC(oui, 0x010203)
+	require.NoError(t, err)
+	require.Equal(t, net.HardwareAddr{0x0c, 0x42, 0xa1, 0x01, 0x02, 0x03}, mac)
+
+	_, err = clientMAC(oui, 1<<24)
+	require.Error(t, err)
+	_, err = parseClientMACOUI("01:00:5e")
+	require.Error(t, err)
+	_, err = parseClientMACOUI("0c:42")
+	require.Error(t, err)
+	oui, err = parseClientMACOUI("")
+	require.NoError(t, err)
+	require.Nil(t, oui)
+}
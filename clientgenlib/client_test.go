@@ -0,0 +1,571 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+func loopbackTestConfig(clientStart, clientEnd, server string) *ClientGenConfig {
+	return &ClientGenConfig{
+		ServerMAC:     "0c:42:a1:80:31:66",
+		ClientMAC:     "02:00:00:00:00:01",
+		ServerAddress: server,
+		ClientIPStart: clientStart,
+		ClientIPEnd:   clientEnd,
+		ClientIPStep:  1,
+		SoftStartRate: 1000,
+		IOBackend:     IOBackendLoopback,
+
+		DurationSec:                       5,
+		TimeAfterDurationBeforeRestartSec: 0.1,
+		TimeBetweenDelayReqSec:            0.1,
+		ClientRetranTimeWhenNoResponseSec: 0.2,
+
+		NumTXWorkers:             1,
+		NumTXTSWorkerPerTx:       1,
+		NumRXWorkers:             1,
+		NumPacketParsers:         1,
+		NumPacketProcessors:      1,
+		NumClientRetransmitProcs: 1,
+		NumClientRestartProcs:    1,
+	}
+}
+
+// runLoopback runs the client generator against the simulated GM for d
+func runLoopback(cfg *ClientGenConfig, d time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	errg, ctx := errgroup.WithContext(ctx)
+	cfg.Eg = errg
+	cfg.Ctx = &ctx
+	StartClientGen(cfg)
+}
+
+// loopbackCase is one run against the simulated GM, setup changes the 4 IPv4 clients of
+// loopbackTestConfig into what's under test
+type loopbackCase struct {
+	name  string
+	d     time.Duration // a second if not set
+	setup func(t *testing.T, cfg *ClientGenConfig)
+	check func(t *testing.T, cfg *ClientGenConfig)
+}
+
+func loopbackIPv6(t *testing.T, cfg *ClientGenConfig) {
+	cfg.ClientIPStart, cfg.ClientIPEnd, cfg.ServerAddress = "2001:db8::1:1", "2001:db8::1:4", "2001:db8::1"
+}
+
+func requireClientsDone(t *testing.T, cfg *ClientGenConfig) {
+	for i := range cfg.RunData.clients {
+		cl := &cfg.RunData.clients[i]
+		require.Equal(t, state(stateDone), cl.state, "client %v %v vlan %d", cl.ClientIP, cl.mac, cl.vlan)
+	}
+}
+
+func requireNegotiated(t *testing.T, cfg *ClientGenConfig) {
+	require.Equal(t, uint64(4), atomic.LoadUint64(&cfg.Counters.TotalClients))
+	requireClientsDone(t, cfg)
+	require.Equal(t, uint64(4), atomic.LoadUint64(&cfg.Counters.TotalClientAnnounceGrant))
+	require.Equal(t, uint64(4), atomic.LoadUint64(&cfg.Counters.TotalClientSyncGrant))
+	require.Equal(t, uint64(4), atomic.LoadUint64(&cfg.Counters.TotalClientDelayRespGrant))
+	require.GreaterOrEqual(t, atomic.LoadUint64(&cfg.Counters.TotalAnnounceRcvd), uint64(4))
+	require.GreaterOrEqual(t, atomic.LoadUint64(&cfg.Counters.TotalSyncRcvd), uint64(4))
+	require.GreaterOrEqual(t, atomic.LoadUint64(&cfg.Counters.TotalFollowUpRcvd), uint64(4))
+	require.GreaterOrEqual(t, atomic.LoadUint64(&cfg.Counters.TotalDelayRespRcvd), uint64(4))
+	require.Equal(t, uint64(12), atomic.LoadUint64(&cfg.RunData.loopback.grantsSent))
+}
+
+// loopbackCases go through the whole client generator with the features turned on, the functions
+// behind them have their own tests
+var loopbackCases = []loopbackCase{
+	{
+		name:  "ipv4",
+		d:     1500 * time.Millisecond,
+		check: requireNegotiated,
+	},
+	{
+		name:  "ipv6",
+		d:     1500 * time.Millisecond,
+		setup: loopbackIPv6,
+		check: requireNegotiated,
+	},
+	{
+		name: "restart",
+		d:    2500 * time.Millisecond,
+		setup: func(t *testing.T, cfg *ClientGenConfig) {
+			cfg.DurationSec = 1
+			cfg.RestartClientsAfterDuration = true
+		},
+		check: func(t *testing.T, cfg *ClientGenConfig) {
+			// every client went through the whole negotiation at least twice
+			require.GreaterOrEqual(t, atomic.LoadUint64(&cfg.Counters.TotalClientAnnounceGrant), uint64(8))
+			require.GreaterOrEqual(t, atomic.LoadUint64(&cfg.Counters.TotalClientDelayRespGrant), uint64(8))
+			for i := range cfg.RunData.clients {
+				require.GreaterOrEqual(t, cfg.RunData.clients[i].CountDelayRespGrant, uint64(2))
+			}
+		},
+	},
+	{
+		name: "deny",
+		setup: func(t *testing.T, cfg *ClientGenConfig) {
+			cfg.LoopbackDenyGrants = []string{"SYNC"}
+		},
+		check: func(t *testing.T, cfg *ClientGenConfig) {
+			for i := range cfg.RunData.clients {
+				require.Equal(t, state(stateGotGrantAnnounce), cfg.RunData.clients[i].state)
+			}
+			require.Equal(t, uint64(4), atomic.LoadUint64(&cfg.Counters.TotalClientAnnounceGrant))
+			require.Equal(t, uint64(0), atomic.LoadUint64(&cfg.Counters.TotalClientSyncGrant))
+			// denied clients keep asking
+			require.Greater(t, atomic.LoadUint64(&cfg.Counters.TotalClientSyncReqResend), uint64(0))
+			require.Greater(t, atomic.LoadUint64(&cfg.RunData.loopback.grantsDenied), uint64(4))
+		},
+	},
+	{
+		name: "cancel",
+		setup: func(t *testing.T, cfg *ClientGenConfig) {
+			cfg.LoopbackCancelAfterSec = 0.3
+		},
+		check: func(t *testing.T, cfg *ClientGenConfig) {
+			require.Equal(t, uint64(4), atomic.LoadUint64(&cfg.Counters.TotalClientDelayRespGrant))
+			require.Equal(t, uint64(12), atomic.LoadUint64(&cfg.RunData.loopback.cancelsSent))
+			// DelayReqs after the cancel go unanswered
+			require.Less(t, atomic.LoadUint64(&cfg.Counters.TotalDelayRespRcvd), atomic.LoadUint64(&cfg.Counters.TotalDelayReqSent))
+		},
+	},
+	{
+		name: "latency",
+		setup: func(t *testing.T, cfg *ClientGenConfig) {
+			cfg.LoopbackLatencySec = 0.05
+			// a single DelayReq, so the send and receive times below belong together
+			cfg.TimeBetweenDelayReqSec = 10
+		},
+		check: func(t *testing.T, cfg *ClientGenConfig) {
+			// the answers are 50ms late. Send times come from the coarse fastime clock and are taken
+			// after the write returns, so only check the latency clearly shows up
+			requireClientsDone(t, cfg)
+			for i := range cfg.RunData.clients {
+				cl := &cfg.RunData.clients[i]
+				require.Greater(t, cl.GotAnnounceGrantReqTime.Sub(cl.SentAnnounceGrantReqTime), 10*time.Millisecond)
+				require.Greater(t, cl.GotDelayRespTime.Sub(cl.SentDelayReqTime), 10*time.Millisecond)
+			}
+		},
+	},
+	{
+		name: "tx-batch",
+		d:    1500 * time.Millisecond,
+		setup: func(t *testing.T, cfg *ClientGenConfig) {
+			cfg.ClientIPEnd = "10.0.1.64"
+			cfg.TXBatchSize = 16
+		},
+		check: func(t *testing.T, cfg *ClientGenConfig) {
+			require.Equal(t, uint64(64), atomic.LoadUint64(&cfg.Counters.TotalClientDelayRespGrant))
+			requireClientsDone(t, cfg)
+			// every packet is still accounted to its client
+			var perClient uint64
+			for i := range cfg.RunData.clients {
+				perClient += atomic.LoadUint64(&cfg.RunData.clients[i].CountOutgoingPackets)
+			}
+			require.Equal(t, atomic.LoadUint64(&cfg.Counters.TotalPacketsSent), perClient)
+			var batches uint64
+			for i := range cfg.TXBatchSizes {
+				batches += atomic.LoadUint64(&cfg.TXBatchSizes[i])
+			}
+			require.Greater(t, batches, uint64(0))
+			require.LessOrEqual(t, batches, atomic.LoadUint64(&cfg.Counters.TotalPacketsSent))
+		},
+	},
+	{
+		name: "interfaces",
+		setup: func(t *testing.T, cfg *ClientGenConfig) {
+			cfg.Interfaces = []InterfaceConfig{
+				{},
+				{ClientIPStart: "2001:db8::1:1", ClientIPEnd: "2001:db8::1:6", ServerAddress: "2001:db8::1", NumPacketProcessors: 2},
+			}
+		},
+		check: func(t *testing.T, cfg *ClientGenConfig) {
+			require.Len(t, cfg.ifaceRuns, 2)
+			require.Equal(t, uint64(4), atomic.LoadUint64(&cfg.ifaceRuns[0].Counters.TotalClients))
+			require.Equal(t, uint64(6), atomic.LoadUint64(&cfg.ifaceRuns[1].Counters.TotalClients))
+			require.Equal(t, uint64(10), atomic.LoadUint64(&cfg.Counters.TotalClients))
+			require.Len(t, cfg.RunData.clients, 10)
+			requireClientsDone(t, cfg)
+			for i := range cfg.RunData.clients {
+				require.Equal(t, i, cfg.RunData.clients[i].index)
+			}
+			// the totals add up the interfaces
+			require.Equal(t, atomic.LoadUint64(&cfg.ifaceRuns[0].Counters.TotalPacketsSent)+atomic.LoadUint64(&cfg.ifaceRuns[1].Counters.TotalPacketsSent),
+				atomic.LoadUint64(&cfg.Counters.TotalPacketsSent))
+			require.Equal(t, atomic.LoadUint64(&cfg.ifaceRuns[0].Counters.TotalDelayRespRcvd)+atomic.LoadUint64(&cfg.ifaceRuns[1].Counters.TotalDelayRespRcvd),
+				atomic.LoadUint64(&cfg.Counters.TotalDelayRespRcvd))
+		},
+	},
+	{
+		name: "vlan",
+		setup: func(t *testing.T, cfg *ClientGenConfig) {
+			// the same addresses in two VLANs, plus an untagged range
+			cfg.VLAN = 100
+			cfg.PCP = 5
+			cfg.ClientRanges = []ClientRange{
+				{ClientIPStart: "10.0.1.1", ClientIPEnd: "10.0.1.4", ClientIPStep: 1, VLAN: 200},
+				{ClientIPStart: "10.0.2.1", ClientIPEnd: "10.0.2.2", ClientIPStep: 1},
+			}
+		},
+		check: func(t *testing.T, cfg *ClientGenConfig) {
+			require.Equal(t, uint64(10), atomic.LoadUint64(&cfg.Counters.TotalClients))
+			requireClientsDone(t, cfg)
+			gm := cfg.RunData.loopback
+			gm.mu.Lock()
+			defer gm.mu.Unlock()
+			for _, vlan := range []uint16{0, 100, 200} {
+				key := loopbackGrantKey{vlan: vlan, addr: "10.0.1.1", msgType: 0}
+				if vlan == 0 {
+					key.addr = "10.0.2.1"
+				}
+				g, ok := gm.grants[key]
+				require.True(t, ok, "no grant in vlan %d", vlan)
+				if vlan == 100 {
+					require.Equal(t, uint8(5), g.pcp)
+				} else {
+					require.Equal(t, uint8(0), g.pcp)
+				}
+			}
+		},
+	},
+	{
+		name: "per-client-mac",
+		setup: func(t *testing.T, cfg *ClientGenConfig) {
+			cfg.PerClientMAC = true
+			cfg.ClientMACOUI = "02:1a:2b"
+		},
+		check: func(t *testing.T, cfg *ClientGenConfig) {
+			requireClientsDone(t, cfg)
+			gm := cfg.RunData.loopback
+			gm.mu.Lock()
+			defer gm.mu.Unlock()
+			for i := range cfg.RunData.clients {
+				cl := &cfg.RunData.clients[i]
+				// the GM answers the MAC the client sent from
+				g, ok := gm.grants[loopbackGrantKey{addr: cl.ClientIP.String(), msgType: 0}]
+				require.True(t, ok)
+				require.Equal(t, cl.mac, g.mac)
+			}
+		},
+	},
+	{
+		name: "l2",
+		setup: func(t *testing.T, cfg *ClientGenConfig) {
+			cfg.ServerAddress = ""
+			cfg.Transport = TransportL2
+			cfg.PerClientMAC = true
+		},
+		check: requireNegotiated,
+	},
+	{
+		name: "l2-vlan",
+		setup: func(t *testing.T, cfg *ClientGenConfig) {
+			cfg.ServerAddress = ""
+			cfg.Transport = TransportL2
+			cfg.PerClientMAC = true
+			cfg.VLAN = 100
+		},
+		check: requireNegotiated,
+	},
+	{
+		name: "resolve-server-mac-ipv4",
+		d:    1500 * time.Millisecond,
+		setup: func(t *testing.T, cfg *ClientGenConfig) {
+			cfg.ServerMAC = ""
+			cfg.VLAN = 100
+			cfg.ServerMACRefreshSec = 0.2
+		},
+		check: func(t *testing.T, cfg *ClientGenConfig) {
+			requireClientsDone(t, cfg)
+			require.Equal(t, loopbackDefaultMAC, serverMAC(cfg))
+			// refreshed, and still the same
+			require.Greater(t, atomic.LoadUint64(&cfg.Counters.ServerMACResolved), uint64(1))
+			require.Zero(t, atomic.LoadUint64(&cfg.Counters.ServerMACChanges))
+		},
+	},
+	{
+		name: "resolve-server-mac-ipv6",
+		d:    1500 * time.Millisecond,
+		setup: func(t *testing.T, cfg *ClientGenConfig) {
+			loopbackIPv6(t, cfg)
+			cfg.ServerMAC = ""
+			cfg.VLAN = 100
+			cfg.ServerMACRefreshSec = 0.2
+		},
+		check: func(t *testing.T, cfg *ClientGenConfig) {
+			requireClientsDone(t, cfg)
+			require.Equal(t, loopbackDefaultMAC, serverMAC(cfg))
+			require.Greater(t, atomic.LoadUint64(&cfg.Counters.ServerMACResolved), uint64(1))
+			require.Zero(t, atomic.LoadUint64(&cfg.Counters.ServerMACChanges))
+		},
+	},
+	{
+		name: "announce-clients-ipv4",
+		setup: func(t *testing.T, cfg *ClientGenConfig) {
+			cfg.AnnounceClients = true
+		},
+		check: func(t *testing.T, cfg *ClientGenConfig) {
+			requireClientsDone(t, cfg)
+			require.Equal(t, uint64(4), atomic.LoadUint64(&cfg.Counters.TotalGratuitousARPSent))
+			require.Zero(t, atomic.LoadUint64(&cfg.Counters.TotalUnsolicitedNASent))
+		},
+	},
+	{
+		name: "announce-clients-ipv6",
+		setup: func(t *testing.T, cfg *ClientGenConfig) {
+			loopbackIPv6(t, cfg)
+			cfg.AnnounceClients = true
+		},
+		check: func(t *testing.T, cfg *ClientGenConfig) {
+			requireClientsDone(t, cfg)
+			require.Equal(t, uint64(4), atomic.LoadUint64(&cfg.Counters.TotalUnsolicitedNASent))
+			require.Zero(t, atomic.LoadUint64(&cfg.Counters.TotalGratuitousARPSent))
+		},
+	},
+	{
+		name: "cidr-addresses",
+		setup: func(t *testing.T, cfg *ClientGenConfig) {
+			cfg.ClientRanges = []ClientRange{
+				{CIDR: "10.0.2.0/30"},
+				{Addresses: []string{"10.0.3.7", "10.0.5.1"}},
+			}
+		},
+		check: func(t *testing.T, cfg *ClientGenConfig) {
+			require.Equal(t, uint64(4+2+2), atomic.LoadUint64(&cfg.Counters.TotalClients))
+			requireClientsDone(t, cfg)
+		},
+	},
+	{
+		name: "p2p",
+		setup: func(t *testing.T, cfg *ClientGenConfig) {
+			cfg.DelayMechanism = DelayMechanismP2P
+			cfg.LoopbackLatencySec = 0.02
+		},
+		check: func(t *testing.T, cfg *ClientGenConfig) {
+			requireClientsDone(t, cfg)
+			require.Equal(t, uint64(4), atomic.LoadUint64(&cfg.Counters.TotalClientPDelayRespGrant))
+			// no E2E on the side
+			require.Zero(t, atomic.LoadUint64(&cfg.Counters.TotalClientDelayRespReq))
+			require.Zero(t, atomic.LoadUint64(&cfg.Counters.TotalDelayReqSent))
+			require.NotZero(t, atomic.LoadUint64(&cfg.Counters.TotalMeanLinkDelays))
+			for i := range cfg.RunData.clients {
+				cl := &cfg.RunData.clients[i]
+				// the GM answers 20ms late, so about half of it is the mean link delay. Scheduling and the
+				// coarse fastime send time move it either way, only check it's in the right range
+				require.Greater(t, cl.MeanLinkDelay, 2*time.Millisecond, "client %v", cl.ClientIP)
+				require.Less(t, cl.MeanLinkDelay, time.Second, "client %v", cl.ClientIP)
+			}
+		},
+	},
+	{
+		name: "p2p-tx-timestamps",
+		setup: func(t *testing.T, cfg *ClientGenConfig) {
+			useTXTSLoopback(t)
+			cfg.DelayMechanism = DelayMechanismP2P
+		},
+		check: func(t *testing.T, cfg *ClientGenConfig) {
+			requireClientsDone(t, cfg)
+			require.NotZero(t, atomic.LoadUint64(&cfg.Counters.TotalTXTSRead))
+			for i := range cfg.RunData.clients {
+				cl := &cfg.RunData.clients[i]
+				// grant requests with a TX timestamp only get their send time when it's read back,
+				// it's what the PDelayResp grant latency histogram is made of
+				require.False(t, cl.SentDelayRespGrantReqTime.IsZero(), "client %v", cl.ClientIP)
+				require.True(t, cl.GotDelayRespGrantReqTime.After(cl.SentDelayRespGrantReqTime), "client %v", cl.ClientIP)
+			}
+		},
+	},
+	{
+		name:  "auth",
+		d:     1500 * time.Millisecond,
+		setup: authTestConfig,
+		check: func(t *testing.T, cfg *ClientGenConfig) {
+			requireNegotiated(t, cfg)
+			require.Zero(t, atomic.LoadUint64(&cfg.Counters.TotalAuthMissing))
+			require.Zero(t, atomic.LoadUint64(&cfg.Counters.TotalAuthUnknownKey))
+			require.Zero(t, atomic.LoadUint64(&cfg.Counters.TotalAuthBadICV))
+			require.Zero(t, atomic.LoadUint64(&cfg.RunData.loopback.authRejected))
+		},
+	},
+	{
+		name: "auth-bad-icv",
+		setup: func(t *testing.T, cfg *ClientGenConfig) {
+			authTestConfig(t, cfg)
+			cfg.AuthBadICV = true
+		},
+		check: func(t *testing.T, cfg *ClientGenConfig) {
+			// the GM drops every request
+			require.NotZero(t, atomic.LoadUint64(&cfg.RunData.loopback.authRejected))
+			require.Zero(t, atomic.LoadUint64(&cfg.RunData.loopback.grantsSent))
+			require.Zero(t, atomic.LoadUint64(&cfg.Counters.TotalClientAnnounceGrant))
+		},
+	},
+}
+
+func Test_clientGenLoopback(t *testing.T) {
+	for _, tc := range loopbackCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := loopbackTestConfig("10.0.1.1", "10.0.1.4", "10.0.0.1")
+			if tc.setup != nil {
+				tc.setup(t, cfg)
+			}
+			d := tc.d
+			if d == 0 {
+				d = time.Second
+			}
+			runLoopback(cfg, d)
+			tc.check(t, cfg)
+		})
+	}
+}
+
+type rxPayload struct {
+	name    string
+	payload []byte
+	fromTX  bool
+	// the client sends its next request, through the pools
+	sends bool
+}
+
+// rxPayloads are PTP messages a client gets, the -tx ones are our own requests seen on TX
+func rxPayloads(t testing.TB) []rxPayload {
+	head := func(msgType ptp.MessageType) ptp.Header {
+		return ptp.Header{SdoIDAndMsgType: ptp.NewSdoIDAndMsgType(msgType, 0), Version: ptp.Version}
+	}
+	grant := &ptp.Signaling{
+		Header: ptp.Header{
+			SdoIDAndMsgType: ptp.NewSdoIDAndMsgType(ptp.MessageSignaling, 0),
+			Version:         ptp.Version,
+			MessageLength:   uint16(ptp.HeaderSize + 10 + loopbackGrantTLVSize),
+		},
+		TLVs: []ptp.TLV{
+			&ptp.GrantUnicastTransmissionTLV{
+				TLVHead:            ptp.TLVHead{TLVType: ptp.TLVGrantUnicastTransmission, LengthField: loopbackGrantTLVSize - ptp.TlvHeadSize},
+				MsgTypeAndReserved: ptp.NewUnicastMsgTypeAndFlags(ptp.MessageSync, 0),
+				DurationField:      60,
+			},
+		},
+	}
+	var out []rxPayload
+	for _, m := range []struct {
+		name   string
+		p      ptp.Packet
+		fromTX bool
+		sends  bool
+	}{
+		{"sync", &ptp.SyncDelayReq{Header: head(ptp.MessageSync)}, false, false},
+		{"followup", &ptp.FollowUp{Header: head(ptp.MessageFollowUp)}, false, false},
+		{"delayresp", &ptp.DelayResp{Header: head(ptp.MessageDelayResp)}, false, false},
+		{"announce", &ptp.Announce{Header: head(ptp.MessageAnnounce)}, false, false},
+		{"signaling-tx", reqUnicast(1, time.Minute, ptp.MessageSync), true, false},
+		{"grant", grant, false, true},
+		{"pdelayreq-tx", reqPDelay(1), true, false},
+		{"pdelayresp", &ptp.PDelayResp{Header: head(ptp.MessagePDelayResp)}, false, false},
+		{"pdelayrespfollowup", &ptp.PDelayRespFollowUp{Header: head(ptp.MessagePDelayRespFollowUp)}, false, false},
+	} {
+		b, err := ptp.Bytes(m.p)
+		require.NoError(t, err)
+		out = append(out, rxPayload{m.name, b, m.fromTX, m.sends})
+	}
+	return out
+}
+
+// handlerTestClient is a config and a client for calling the client handlers directly
+func handlerTestClient() (*ClientGenConfig, *SingleClientGen) {
+	ctx := context.Background()
+	return &ClientGenConfig{Ctx: &ctx}, &SingleClientGen{stateSem: semaphore.NewWeighted(1)}
+}
+
+func Test_singleClientHandleIncomingPTPNoAllocs(t *testing.T) {
+	cfg, cl := retransmitTestConfig(t)
+	cfg.DelayMechanism = DelayMechanismP2P
+	in := &PktDecoder{}
+	for _, m := range rxPayloads(t) {
+		in.fromTX = m.fromTX
+		allocs := testing.AllocsPerRun(100, func() {
+			_, err := singleClientHandleIncomingPTP(cfg, cl, in, m.payload)
+			require.NoError(t, err)
+			drainRetransmit(cfg)
+		})
+		if m.sends && raceEnabled {
+			// the pools drop what's put back
+			continue
+		}
+		require.Zero(t, allocs, m.name)
+	}
+	require.NotZero(t, cl.CountDelayResp)
+	require.NotZero(t, cfg.Counters.TotalAnnounceRcvd)
+	require.NotZero(t, cl.CountSyncGrant)
+	require.NotZero(t, cfg.Counters.TotalClientPDelayRespReq)
+	require.NotZero(t, cfg.Counters.TotalPDelayRespFollowUpRcvd)
+}
+
+func Test_singleClientHandleIncomingPTPExtraTLVs(t *testing.T) {
+	cfg, cl := handlerTestClient()
+	in := &PktDecoder{}
+	signaling := &ptp.Signaling{
+		Header: ptp.Header{
+			SdoIDAndMsgType: ptp.NewSdoIDAndMsgType(ptp.MessageSignaling, 0),
+			Version:         ptp.Version,
+			MessageLength:   uint16(ptp.HeaderSize + 10 + 12 + 10),
+		},
+		TLVs: []ptp.TLV{
+			&ptp.PathTraceTLV{
+				TLVHead:      ptp.TLVHead{TLVType: ptp.TLVPathTrace, LengthField: 8},
+				PathSequence: []ptp.ClockIdentity{1},
+			},
+			&ptp.OrganizationExtensionTLV{
+				TLVHead:        ptp.TLVHead{TLVType: ptp.TLVOrganizationExtension, LengthField: 6},
+				OrganizationID: [3]uint8{0x00, 0x1b, 0x19},
+			},
+		},
+	}
+	b, err := ptp.Bytes(signaling)
+	require.NoError(t, err)
+	out, err := singleClientHandleIncomingPTP(cfg, cl, in, b)
+	require.NoError(t, err)
+	require.Nil(t, out)
+	require.Equal(t, uint64(2), cfg.Counters.TotalSignalingTLVsIgnored)
+}
+
+func Benchmark_singleClientHandleIncomingPTP(b *testing.B) {
+	cfg, cl := retransmitTestConfig(b)
+	cfg.DelayMechanism = DelayMechanismP2P
+	in := &PktDecoder{}
+	for _, m := range rxPayloads(b) {
+		in.fromTX = m.fromTX
+		b.Run(m.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _ = singleClientHandleIncomingPTP(cfg, cl, in, m.payload)
+				drainRetransmit(cfg)
+			}
+		})
+	}
+}
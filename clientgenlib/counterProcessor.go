@@ -61,6 +61,44 @@ func printSliceHistogram(cfg *ClientGenConfig, data []uint64, heapName string) {
 	fmt.Printf("\n")
 }
 
+// printCounters prints every counter of data with its rate since prevData
+func printCounters(data *GlobalStatistics, prevData *GlobalStatistics, intervalSecs uint) {
+	s := reflect.ValueOf(data).Elem()
+	oldS := reflect.ValueOf(prevData).Elem()
+
+	typeOfData := s.Type()
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Field(i)
+		oldF := oldS.Field(i)
+		fmt.Printf("%d: %s = %v, rate %v\n", i,
+			typeOfData.Field(i).Name,
+			f.Interface(),
+			(f.Interface().(uint64)-oldF.Interface().(uint64))/
+				uint64(intervalSecs))
+	}
+}
+
+// printQueues prints the depth, high water mark, full / blocked time and drops of every queue of cfg
+func printQueues(cfg *ClientGenConfig) {
+	for _, q := range cfg.RunData.queues {
+		fmt.Printf("%s %d: depth %d/%d, high water %d, full %d, blocked %v, dropped %d, policy %s\n",
+			q.name, q.index, q.depth(), q.capacity,
+			atomic.LoadUint64(&q.highWater),
+			atomic.LoadUint64(&q.blocked),
+			time.Duration(atomic.LoadUint64(&q.blockedNs)),
+			atomic.LoadUint64(&q.dropped),
+			q.policy)
+	}
+}
+
+// counterRuns is what the counter processor prints per interface, cfg itself unless it runs several
+func counterRuns(cfg *ClientGenConfig) []*ClientGenConfig {
+	if len(cfg.ifaceRuns) > 0 {
+		return cfg.ifaceRuns
+	}
+	return []*ClientGenConfig{cfg}
+}
+
 func startCounterProcessor(cfg *ClientGenConfig) {
 	if cfg.CounterPrintIntervalSecs == 0 {
 		return
@@ -75,8 +113,12 @@ func startCounterProcessor(cfg *ClientGenConfig) {
 		var clientSyncGrantReq []uint64
 		var clientDelayRespGrantReq []uint64
 		var clientDelayReq []uint64
+		var clientPDelayReq []uint64
 		var clientStates []uint64
 		var clientLatencyHistogram *tachymeter.Tachymeter
+		runs := counterRuns(cfg)
+		ifaceData := make([]GlobalStatistics, len(cfg.ifaceRuns))
+		prevIfaceData := make([]GlobalStatistics, len(cfg.ifaceRuns))
 
 		profiler.Init(cfg.Eg, cfg.Ctx, true, "CounterProcessor")
 		cfg.PerfProfilers = append(cfg.PerfProfilers, &profiler)
@@ -87,6 +129,7 @@ func startCounterProcessor(cfg *ClientGenConfig) {
 			clientSyncGrantReq = make([]uint64, len(cfg.RunData.clients))
 			clientDelayRespGrantReq = make([]uint64, len(cfg.RunData.clients))
 			clientDelayReq = make([]uint64, len(cfg.RunData.clients))
+			clientPDelayReq = make([]uint64, len(cfg.RunData.clients))
 		}
 		if cfg.PrintClientData {
 			clientStates = make([]uint64, len(cfg.RunData.clients))
@@ -99,24 +142,20 @@ func startCounterProcessor(cfg *ClientGenConfig) {
 		go func() {
 			for {
 				profiler.Tick()
+				if len(cfg.ifaceRuns) > 0 {
+					sumInterfaceCounters(cfg)
+				}
 				data = cfg.Counters // local copy
 
-				s := reflect.ValueOf(&data).Elem()
-				oldS := reflect.ValueOf(&prevData).Elem()
-
-				typeOfData := s.Type()
-
 				fmt.Printf("========================Statistics after %v============\n", fastime.Now().Sub(startTime))
 				if cfg.PrintClientData {
 					fmt.Printf("==ClientData=============\n")
-					for i := 0; i < s.NumField(); i++ {
-						f := s.Field(i)
-						oldF := oldS.Field(i)
-						fmt.Printf("%d: %s = %v, rate %v\n", i,
-							typeOfData.Field(i).Name,
-							f.Interface(),
-							(f.Interface().(uint64)-oldF.Interface().(uint64))/
-								uint64(cfg.CounterPrintIntervalSecs))
+					printCounters(&data, &prevData, cfg.CounterPrintIntervalSecs)
+					for i, run := range cfg.ifaceRuns {
+						ifaceData[i] = run.Counters
+						fmt.Printf("==ClientData interface %s=============\n", interfaceName(run, i))
+						printCounters(&ifaceData[i], &prevIfaceData[i], cfg.CounterPrintIntervalSecs)
+						prevIfaceData[i] = ifaceData[i]
 					}
 					for i := 0; i < len(cfg.RunData.clients); i++ {
 						clientStates[i] = uint64(cfg.RunData.clients[i].state)
@@ -127,13 +166,25 @@ func startCounterProcessor(cfg *ClientGenConfig) {
 
 				if cfg.PrintTxRxCounts {
 					fmt.Printf("==Tx Rx Counters=============\n")
-					for i := 0; i < len(cfg.perIOTX); i++ {
-						fmt.Printf("TX worker %d pkt send: %v\n",
-							i, cfg.perIOTX[i])
+					for r, run := range runs {
+						if len(cfg.ifaceRuns) > 0 {
+							fmt.Printf("Interface %s\n", interfaceName(run, r))
+						}
+						for i := 0; i < len(run.perIOTX); i++ {
+							fmt.Printf("TX worker %d pkt send: %v\n",
+								i, run.perIOTX[i])
+						}
+						for i := 0; i < len(run.perIORX); i++ {
+							fmt.Printf("RX worker %d pkt recv: %v\n",
+								i, run.perIORX[i])
+						}
 					}
-					for i := 0; i < len(cfg.perIORX); i++ {
-						fmt.Printf("RX worker %d pkt recv: %v\n",
-							i, cfg.perIORX[i])
+					if cfg.TXBatchSize > 1 {
+						fmt.Printf("TX batch sizes\n")
+						for i := 0; i <= txBatchBucket(cfg.TXBatchSize); i++ {
+							fmt.Printf("<=%d:%d,", 1<<i, atomic.LoadUint64(&cfg.TXBatchSizes[i]))
+						}
+						fmt.Printf("\n")
 					}
 					
 					// PF_RING статистика
@@ -143,6 +194,15 @@ func startCounterProcessor(cfg *ClientGenConfig) {
 					fmt.Printf("PF_RING TX packets: %v\n", atomic.LoadUint64(&cfg.Counters.PFRingTXPackets))
 					fmt.Printf("PF_RING HW timestamps: %v\n", atomic.LoadUint64(&cfg.Counters.PFRingHWTimestamps))
 				}
+				if cfg.PrintQueues {
+					fmt.Printf("==Queues=============\n")
+					for r, run := range runs {
+						if len(cfg.ifaceRuns) > 0 {
+							fmt.Printf("Interface %s\n", interfaceName(run, r))
+						}
+						printQueues(run)
+					}
+				}
 				prevData = data
 
 				if cfg.PrintClientReqData {
@@ -155,6 +215,7 @@ func startCounterProcessor(cfg *ClientGenConfig) {
 						clientSyncGrantReq[i] = cfg.RunData.clients[i].CountSyncGrantReq
 						clientDelayRespGrantReq[i] = cfg.RunData.clients[i].CountDelayRespGrantReq
 						clientDelayReq[i] = cfg.RunData.clients[i].CountDelayReq
+						clientPDelayReq[i] = cfg.RunData.clients[i].CountPDelayReq
 					}
 					fmt.Printf("Announce Grant Requests sent\n")
 					printSliceHistogram(cfg, clientAnnounceGrantReq, "AnnounceGrantReqs")
@@ -164,6 +225,10 @@ func startCounterProcessor(cfg *ClientGenConfig) {
 					printSliceHistogram(cfg, clientDelayRespGrantReq, "DelayRespGrantReqs")
 					fmt.Printf("Delay Requests sent\n")
 					printSliceHistogram(cfg, clientDelayReq, "DelayReqs")
+					if cfg.DelayMechanism == DelayMechanismP2P {
+						fmt.Printf("PDelay Requests sent\n")
+						printSliceHistogram(cfg, clientPDelayReq, "PDelayReqs")
+					}
 
 				}
 				if cfg.PrintLatencyData {
@@ -219,6 +284,29 @@ func startCounterProcessor(cfg *ClientGenConfig) {
 					}
 					fmt.Println("Delay Req Latency\n", clientLatencyHistogram.Calc())
 
+					if cfg.DelayMechanism == DelayMechanismP2P {
+						clientLatencyHistogram.Reset()
+						for i := 0; i < len(cfg.RunData.clients); i++ {
+							cl := &cfg.RunData.clients[i]
+							if !cl.GotPDelayRespTime.IsZero() &&
+								!cl.SentPDelayReqTime.IsZero() {
+								latency := cl.GotPDelayRespTime.Sub(cl.SentPDelayReqTime)
+								if latency > 0 {
+									clientLatencyHistogram.AddTime(latency)
+								}
+							}
+						}
+						fmt.Println("PDelay Req Latency\n", clientLatencyHistogram.Calc())
+
+						clientLatencyHistogram.Reset()
+						for i := 0; i < len(cfg.RunData.clients); i++ {
+							if d := cfg.RunData.clients[i].MeanLinkDelay; d > 0 {
+								clientLatencyHistogram.AddTime(d)
+							}
+						}
+						fmt.Println("Mean Link Delay\n", clientLatencyHistogram.Calc())
+					}
+
 					clientLatencyHistogram.Reset()
 					for i := 0; i < len(cfg.RunData.clients); i++ {
 						cl := &cfg.RunData.clients[i]
@@ -257,29 +345,42 @@ func startCounterProcessor(cfg *ClientGenConfig) {
 				profiler.Tock()
 				if cfg.PrintPerformance {
 					fmt.Printf("==Software Performance=============\n")
-					for index := range cfg.PerfProfilers {
-						name := cfg.PerfProfilers[index].Name
-						if (strings.Contains(name, "syscallwrite") ||
-							strings.Contains(name, "readTxTimestamp")) &&
-							!cfg.DebugDetailPerf {
-							continue
+					// the counter processor is on cfg, the workers on the run of their interface
+					perfRuns := append([]*ClientGenConfig{cfg}, cfg.ifaceRuns...)
+					for r, run := range perfRuns {
+						if r > 0 {
+							fmt.Printf("Interface %s\n", interfaceName(run, r-1))
 						}
+						for index := range run.PerfProfilers {
+							name := run.PerfProfilers[index].Name
+							if (strings.Contains(name, "syscallwrite") ||
+								strings.Contains(name, "readTxTimestamp")) &&
+								!cfg.DebugDetailPerf {
+								continue
+							}
 
-						fmt.Printf("Profiler %s last busy %.2f%%\n",
-							cfg.PerfProfilers[index].Name,
-							cfg.PerfProfilers[index].GetLastBusy())
-						if cfg.DebugProfilers {
-							log.Infof("Debug %v", cfg.PerfProfilers[index])
+							fmt.Printf("Profiler %s last busy %.2f%%\n",
+								run.PerfProfilers[index].Name,
+								run.PerfProfilers[index].GetLastBusy())
+							if cfg.DebugProfilers {
+								log.Infof("Debug %v", run.PerfProfilers[index])
+							}
 						}
 					}
 				}
 				fmt.Printf("========================Statistics end at %v============\n", fastime.Now().Sub(startTime))
-				time.Sleep(time.Duration(cfg.CounterPrintIntervalSecs) * time.Second)
+				select {
+				case <-time.After(time.Duration(cfg.CounterPrintIntervalSecs) * time.Second):
+				case <-(*cfg.Ctx).Done():
+					doneChan <- nil
+					return
+				}
 			}
 		}()
 		select {
 		case <-(*cfg.Ctx).Done():
 			log.Infof("Counter processor done due to context")
+			<-doneChan
 			return (*cfg.Ctx).Err()
 		case err := <-doneChan:
 			log.Errorf("Counter processor done due to err %v", err)
@@ -0,0 +1,111 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+
+	"github.com/google/gopacket/layers"
+)
+
+// offsets into the frames the RX dispatch looks at
+const (
+	ethTypeOffset   = 12
+	dot1QTagSize    = 4
+	ip4SrcOffset    = 12
+	ip4DstOffset    = 16
+	ip6SrcOffset    = 8
+	ip6DstOffset    = 24
+	ip6AddrSize     = 16
+	ethSrcMACOffset = 6
+	macSize         = 6
+)
+
+// rxClientKey finds what identifies the client of a frame without decoding it: the low 4 bytes of the
+// destination IP (the source for frames read back from TX), or of the client MAC for the "l2" Transport
+// false if the frame has neither, e.g. ARP
+func rxClientKey(data []byte, fromTX bool) (uint32, bool) {
+	off := ethTypeOffset
+	if len(data) < off+2 {
+		return 0, false
+	}
+	etherType := layers.EthernetType(binary.BigEndian.Uint16(data[off:]))
+	for etherType == layers.EthernetTypeDot1Q || etherType == layers.EthernetTypeQinQ {
+		off += dot1QTagSize
+		if len(data) < off+2 {
+			return 0, false
+		}
+		etherType = layers.EthernetType(binary.BigEndian.Uint16(data[off:]))
+	}
+	ip := off + 2
+	var addr int
+	switch etherType {
+	case layers.EthernetTypeIPv4:
+		addr = ip + ip4DstOffset
+		if fromTX {
+			addr = ip + ip4SrcOffset
+		}
+		addr += 4
+	case layers.EthernetTypeIPv6:
+		addr = ip + ip6DstOffset
+		if fromTX {
+			addr = ip + ip6SrcOffset
+		}
+		addr += ip6AddrSize
+	case ethernetTypePTP:
+		addr = macSize
+		if fromTX {
+			addr = ethSrcMACOffset + macSize
+		}
+	default:
+		return 0, false
+	}
+	if len(data) < addr {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(data[addr-4 : addr]), true
+}
+
+// dispatchHash spreads the client keys over the workers, consecutive or strided addresses alike
+func dispatchHash(key uint32) uint32 {
+	// murmur3 finalizer, every key bit reaches the low bits used for the modulo
+	key ^= key >> 16
+	key *= 0x85ebca6b
+	key ^= key >> 13
+	key *= 0xc2b2ae35
+	key ^= key >> 16
+	return key
+}
+
+// getRxChanNumToUse picks the packet parser for pkt, the same one for every frame of a client, so frames
+// one RX worker reads stay in order up to the packet processor. the pf_ring cluster and afpacket fanout
+// keep a client on one RX worker. TX timestamps come from other goroutines and may still arrive after
+// the answer to their packet. frames of no client go round robin
+func getRxChanNumToUse(cfg *ClientGenConfig, pkt *inPacket) uint32 {
+	if key, ok := rxClientKey(pkt.data, pkt.fromTX); ok {
+		pkt.hash = dispatchHash(key)
+	} else {
+		pkt.hash = atomic.AddUint32(&cfg.RunData.inChanToUse, 1)
+	}
+	return pkt.hash % uint32(cfg.NumPacketParsers)
+}
+
+// getPacketProcChanNumToUse picks the packet processor with the hash of getRxChanNumToUse
+func getPacketProcChanNumToUse(cfg *ClientGenConfig, in *PktDecoder) uint32 {
+	return in.hash % uint32(cfg.NumPacketProcessors)
+}
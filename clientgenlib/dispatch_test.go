@@ -0,0 +1,92 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_rxClientKey(t *testing.T) {
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	clientMAC := net.HardwareAddr{2, 0, 0, 0, 0x12, 0x34}
+	gmMAC := net.HardwareAddr{2, 0, 0, 0, 0, 0xfe}
+	frame := func(fromTX bool, vlan uint16, etherType layers.EthernetType, l ...gopacket.SerializableLayer) []byte {
+		eth := layers.Ethernet{SrcMAC: gmMAC, DstMAC: clientMAC, EthernetType: etherType}
+		if fromTX {
+			eth.SrcMAC, eth.DstMAC = clientMAC, gmMAC
+		}
+		buf := gopacket.NewSerializeBuffer()
+		require.NoError(t, serializeFrame(buf, opts, &eth, vlan, 0, l...))
+		return buf.Bytes()
+	}
+	payload := gopacket.Payload(make([]byte, 44))
+
+	for _, vlan := range []uint16{0, 100} {
+		client, gm := net.IP{10, 0, 1, 7}, net.IP{10, 0, 0, 1}
+		rx := frame(false, vlan, layers.EthernetTypeIPv4,
+			&layers.IPv4{SrcIP: gm, DstIP: client, Version: 4, TTL: 255, Protocol: layers.IPProtocolUDP}, payload)
+		tx := frame(true, vlan, layers.EthernetTypeIPv4,
+			&layers.IPv4{SrcIP: client, DstIP: gm, Version: 4, TTL: 255, Protocol: layers.IPProtocolUDP}, payload)
+		key, ok := rxClientKey(rx, false)
+		require.True(t, ok)
+		require.Equal(t, uint32(0x0a000107), key)
+		key, ok = rxClientKey(tx, true)
+		require.True(t, ok)
+		require.Equal(t, uint32(0x0a000107), key)
+
+		client, gm = net.ParseIP("2001:db8::1:7"), net.ParseIP("2001:db8::1")
+		rx = frame(false, vlan, layers.EthernetTypeIPv6,
+			&layers.IPv6{SrcIP: gm, DstIP: client, Version: 6, HopLimit: 255, NextHeader: layers.IPProtocolUDP}, payload)
+		tx = frame(true, vlan, layers.EthernetTypeIPv6,
+			&layers.IPv6{SrcIP: client, DstIP: gm, Version: 6, HopLimit: 255, NextHeader: layers.IPProtocolUDP}, payload)
+		key, ok = rxClientKey(rx, false)
+		require.True(t, ok)
+		require.Equal(t, uint32(0x00010007), key)
+		key, ok = rxClientKey(tx, true)
+		require.True(t, ok)
+		require.Equal(t, uint32(0x00010007), key)
+
+		// "l2" Transport goes by the client MAC
+		key, ok = rxClientKey(frame(false, vlan, ethernetTypePTP, payload), false)
+		require.True(t, ok)
+		require.Equal(t, uint32(0x00001234), key)
+		key, ok = rxClientKey(frame(true, vlan, ethernetTypePTP, payload), true)
+		require.True(t, ok)
+		require.Equal(t, uint32(0x00001234), key)
+
+		_, ok = rxClientKey(frame(false, vlan, layers.EthernetTypeARP, payload), false)
+		require.False(t, ok)
+	}
+	_, ok := rxClientKey(make([]byte, 20), false)
+	require.False(t, ok)
+}
+
+func Test_dispatchHash(t *testing.T) {
+	// consecutive and strided client addresses both use every worker
+	for _, step := range []uint32{1, 4} {
+		seen := map[uint32]int{}
+		for ip := uint32(0x0a000101); ip < 0x0a000101+step*64; ip += step {
+			seen[dispatchHash(ip)%4]++
+		}
+		require.Len(t, seen, 4, "step %d", step)
+	}
+}
@@ -0,0 +1,233 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// newInterfaceRuns makes a config per cfg.Interfaces entry, they share cfg's errgroup and context
+func newInterfaceRuns(cfg *ClientGenConfig) ([]*ClientGenConfig, error) {
+	runs := make([]*ClientGenConfig, 0, len(cfg.Interfaces))
+	seen := make(map[string]bool)
+	for i, ic := range cfg.Interfaces {
+		run := newInterfaceRun(cfg, ic)
+		if run.Iface != "" {
+			// RX workers of one interface all share its fanout group / cluster
+			if seen[run.Iface] {
+				return nil, fmt.Errorf("interface %s listed twice, give it one client range", run.Iface)
+			}
+			seen[run.Iface] = true
+		}
+		if cfg.CaptureFile != "" {
+			run.CaptureFile = interfaceCaptureFile(cfg.CaptureFile, i)
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// newInterfaceRun copies the settings of cfg and applies the non empty fields of ic. the
+// run state, counters and statistics start empty, slices and maps are copied so runs don't share them
+func newInterfaceRun(cfg *ClientGenConfig, ic InterfaceConfig) *ClientGenConfig {
+	run := &ClientGenConfig{
+		ServerMAC:           cfg.ServerMAC,
+		ClientMAC:           cfg.ClientMAC,
+		PerClientMAC:        cfg.PerClientMAC,
+		ClientMACOUI:        cfg.ClientMACOUI,
+		ServerAddress:       cfg.ServerAddress,
+		Gateway:             cfg.Gateway,
+		ServerMACRefreshSec: cfg.ServerMACRefreshSec,
+
+		ClientIPStart:   cfg.ClientIPStart,
+		ClientIPEnd:     cfg.ClientIPEnd,
+		ClientIPStep:    cfg.ClientIPStep,
+		VLAN:            cfg.VLAN,
+		PCP:             cfg.PCP,
+		ClientRanges:    copyClientRanges(cfg.ClientRanges),
+		SoftStartRate:   cfg.SoftStartRate,
+		AnnounceClients: cfg.AnnounceClients,
+
+		Iface:          cfg.Iface,
+		IOBackend:      cfg.IOBackend,
+		Transport:      cfg.Transport,
+		DelayMechanism: cfg.DelayMechanism,
+		TimeoutSec:     cfg.TimeoutSec,
+		DurationSec:    cfg.DurationSec,
+
+		NumTXWorkers:             cfg.NumTXWorkers,
+		NumTXTSWorkerPerTx:       cfg.NumTXTSWorkerPerTx,
+		NumRXWorkers:             cfg.NumRXWorkers,
+		NumPacketParsers:         cfg.NumPacketParsers,
+		NumPacketProcessors:      cfg.NumPacketProcessors,
+		NumClientRestartProcs:    cfg.NumClientRestartProcs,
+		NumClientRetransmitProcs: cfg.NumClientRetransmitProcs,
+		TXBatchSize:              cfg.TXBatchSize,
+		QueueOverflow:            make(map[string]string, len(cfg.QueueOverflow)),
+
+		DebugPrint:       cfg.DebugPrint,
+		DebugLogClient:   cfg.DebugLogClient,
+		DebugIoWkrRX:     cfg.DebugIoWkrRX,
+		DebugIoWkrTX:     cfg.DebugIoWkrTX,
+		DebugDetailPerf:  cfg.DebugDetailPerf,
+		DebugRestartProc: cfg.DebugRestartProc,
+		DebugRetransProc: cfg.DebugRetransProc,
+		DebugProfilers:   cfg.DebugProfilers,
+
+		PrintTxRxCounts:    cfg.PrintTxRxCounts,
+		PrintPerformance:   cfg.PrintPerformance,
+		PrintClientData:    cfg.PrintClientData,
+		PrintClientReqData: cfg.PrintClientReqData,
+		PrintLatencyData:   cfg.PrintLatencyData,
+		PrintQueues:        cfg.PrintQueues,
+
+		CaptureFile:      cfg.CaptureFile,
+		CaptureClientIPs: append([]string(nil), cfg.CaptureClientIPs...),
+		CaptureMaxFileMB: cfg.CaptureMaxFileMB,
+		CaptureMaxFiles:  cfg.CaptureMaxFiles,
+
+		ReplayFile:  cfg.ReplayFile,
+		ReplayPaced: cfg.ReplayPaced,
+
+		AuthKeyFile: cfg.AuthKeyFile,
+		AuthSPP:     cfg.AuthSPP,
+		AuthKeyID:   cfg.AuthKeyID,
+		AuthBadICV:  cfg.AuthBadICV,
+
+		LoopbackLatencySec:     cfg.LoopbackLatencySec,
+		LoopbackDenyGrants:     append([]string(nil), cfg.LoopbackDenyGrants...),
+		LoopbackCancelAfterSec: cfg.LoopbackCancelAfterSec,
+
+		RestartClientsAfterDuration:       cfg.RestartClientsAfterDuration,
+		TimeAfterDurationBeforeRestartSec: cfg.TimeAfterDurationBeforeRestartSec,
+		TimeBetweenDelayReqSec:            cfg.TimeBetweenDelayReqSec,
+		ClientRetranTimeWhenNoResponseSec: cfg.ClientRetranTimeWhenNoResponseSec,
+
+		// the parent prints for all interfaces
+		CounterPrintIntervalSecs: 0,
+
+		Eg:            cfg.Eg,
+		Ctx:           cfg.Ctx,
+		fastimeHolder: cfg.fastimeHolder,
+	}
+	for k, v := range cfg.QueueOverflow {
+		run.QueueOverflow[k] = v
+	}
+
+	overrideString(&run.Iface, ic.Iface)
+	overrideString(&run.ClientMAC, ic.ClientMAC)
+	overrideString(&run.ServerMAC, ic.ServerMAC)
+	overrideString(&run.ServerAddress, ic.ServerAddress)
+	overrideString(&run.Gateway, ic.Gateway)
+	overrideString(&run.ClientIPStart, ic.ClientIPStart)
+	overrideString(&run.ClientIPEnd, ic.ClientIPEnd)
+	if ic.ClientIPStep != 0 {
+		run.ClientIPStep = ic.ClientIPStep
+	}
+	if ic.VLAN != 0 {
+		run.VLAN = ic.VLAN
+	}
+	if ic.PCP != 0 {
+		run.PCP = ic.PCP
+	}
+	if len(ic.ClientRanges) > 0 {
+		run.ClientRanges = copyClientRanges(ic.ClientRanges)
+	}
+	overrideInt(&run.NumTXWorkers, ic.NumTXWorkers)
+	overrideInt(&run.NumTXTSWorkerPerTx, ic.NumTXTSWorkerPerTx)
+	overrideInt(&run.NumRXWorkers, ic.NumRXWorkers)
+	overrideInt(&run.NumPacketParsers, ic.NumPacketParsers)
+	overrideInt(&run.NumPacketProcessors, ic.NumPacketProcessors)
+	overrideInt(&run.NumClientRestartProcs, ic.NumClientRestartProcs)
+	overrideInt(&run.NumClientRetransmitProcs, ic.NumClientRetransmitProcs)
+	return run
+}
+
+// copyClientRanges copies the ranges and their address lists
+func copyClientRanges(ranges []ClientRange) []ClientRange {
+	if ranges == nil {
+		return nil
+	}
+	out := make([]ClientRange, len(ranges))
+	for i, r := range ranges {
+		out[i] = r
+		out[i].Addresses = append([]string(nil), r.Addresses...)
+	}
+	return out
+}
+
+func overrideString(dst *string, v string) {
+	if v != "" {
+		*dst = v
+	}
+}
+
+func overrideInt(dst *int, v int) {
+	if v != 0 {
+		*dst = v
+	}
+}
+
+// interfaceCaptureFile gives each interface its own capture file, capture.pcapng becomes capture-0.pcapng
+func interfaceCaptureFile(path string, i int) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + strconv.Itoa(i) + ext
+}
+
+// interfaceName is how the counters name a run
+func interfaceName(run *ClientGenConfig, i int) string {
+	if run.Iface != "" {
+		return run.Iface
+	}
+	return strconv.Itoa(i)
+}
+
+// sumInterfaceCounters sets cfg.Counters and cfg.TXBatchSizes to the total over cfg.ifaceRuns, Max counters take the max
+func sumInterfaceCounters(cfg *ClientGenConfig) {
+	var sum GlobalStatistics
+	var batches [txBatchBuckets]uint64
+	s := reflect.ValueOf(&sum).Elem()
+	typeOfData := s.Type()
+	for _, run := range cfg.ifaceRuns {
+		r := reflect.ValueOf(&run.Counters).Elem()
+		for i := 0; i < s.NumField(); i++ {
+			v := atomic.LoadUint64(r.Field(i).Addr().Interface().(*uint64))
+			if strings.HasPrefix(typeOfData.Field(i).Name, "Max") {
+				if v > s.Field(i).Uint() {
+					s.Field(i).SetUint(v)
+				}
+				continue
+			}
+			s.Field(i).SetUint(s.Field(i).Uint() + v)
+		}
+		for i := range batches {
+			batches[i] += atomic.LoadUint64(&run.TXBatchSizes[i])
+		}
+	}
+	c := reflect.ValueOf(&cfg.Counters).Elem()
+	for i := 0; i < c.NumField(); i++ {
+		atomic.StoreUint64(c.Field(i).Addr().Interface().(*uint64), s.Field(i).Uint())
+	}
+	for i := range batches {
+		atomic.StoreUint64(&cfg.TXBatchSizes[i], batches[i])
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newInterfaceRuns(t *testing.T) {
+	cfg := loopbackTestConfig("10.0.1.1", "10.0.1.4", "10.0.0.1")
+	cfg.Iface = "eth0"
+	cfg.CaptureFile = "/tmp/run.pcapng"
+	cfg.CounterPrintIntervalSecs = 1
+	cfg.Interfaces = []InterfaceConfig{
+		{},
+		{Iface: "eth1", ServerMAC: "0c:42:a1:80:31:67", ClientIPStart: "10.0.2.1", ClientIPEnd: "10.0.2.8", NumRXWorkers: 4},
+	}
+	runs, err := newInterfaceRuns(cfg)
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+
+	require.Equal(t, "eth0", runs[0].Iface)
+	require.Equal(t, cfg.ServerMAC, runs[0].ServerMAC)
+	require.Equal(t, "/tmp/run-0.pcapng", runs[0].CaptureFile)
+	require.Equal(t, uint(0), runs[0].CounterPrintIntervalSecs)
+	require.Nil(t, runs[0].Interfaces)
+
+	require.Equal(t, "eth1", runs[1].Iface)
+	require.Equal(t, "0c:42:a1:80:31:67", runs[1].ServerMAC)
+	require.Equal(t, "10.0.2.1", runs[1].ClientIPStart)
+	require.Equal(t, "10.0.2.8", runs[1].ClientIPEnd)
+	require.Equal(t, 4, runs[1].NumRXWorkers)
+	require.Equal(t, cfg.NumTXWorkers, runs[1].NumTXWorkers)
+	require.Equal(t, cfg.ServerAddress, runs[1].ServerAddress)
+	require.Equal(t, "/tmp/run-1.pcapng", runs[1].CaptureFile)
+
+	cfg.Interfaces = append(cfg.Interfaces, InterfaceConfig{Iface: "eth1"})
+	_, err = newInterfaceRuns(cfg)
+	require.Error(t, err)
+}
+
+func Test_newInterfaceRunCopiesSettings(t *testing.T) {
+	// run state that every interface starts fresh with
+	perRun := map[string]bool{
+		"Interfaces": true, "CounterPrintIntervalSecs": true, "Counters": true, "StatMu": true,
+		"Statistics": true, "PerfProfilers": true, "TXBatchSizes": true, "Eg": true, "Ctx": true, "RunData": true,
+	}
+	cfg := &ClientGenConfig{}
+	v := reflect.ValueOf(cfg).Elem()
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath != "" || perRun[f.Name] {
+			continue
+		}
+		val, ok := quick.Value(f.Type, r)
+		require.True(t, ok, f.Name)
+		v.Field(i).Set(val)
+	}
+	cfg.QueueOverflow = map[string]string{QueueRawOutput: OverflowDropNewest}
+	cfg.ClientRanges = []ClientRange{{Addresses: []string{"10.0.1.1"}}}
+
+	// a setting added to ClientGenConfig but not to newInterfaceRun shows up here
+	run := newInterfaceRun(cfg, InterfaceConfig{})
+	rv := reflect.ValueOf(run).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath != "" || perRun[f.Name] {
+			continue
+		}
+		require.Equal(t, v.Field(i).Interface(), rv.Field(i).Interface(), f.Name)
+	}
+
+	// and nothing is shared with the parent
+	run.QueueOverflow[QueueRawOutput] = OverflowBlock
+	run.ClientRanges[0].Addresses[0] = "10.0.2.1"
+	require.Equal(t, OverflowDropNewest, cfg.QueueOverflow[QueueRawOutput])
+	require.Equal(t, "10.0.1.1", cfg.ClientRanges[0].Addresses[0])
+}
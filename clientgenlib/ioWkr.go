@@ -18,127 +18,23 @@ package clientgenlib
 
 import (
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	ptp "github.com/facebook/time/ptp/protocol"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/kpango/fastime"
 	log "github.com/sirupsen/logrus"
 )
 
-// PF_RING stub types for Linux compatibility
-type Ring struct {
-	closed bool
-}
-
-type Flag int
-
-const (
-	FlagPromisc     Flag = 1
-	FlagHWTimestamp Flag = 2
-	FlagLongHeader  Flag = 4
-)
-
-type ClusterType int
-
-const (
-	ClusterRoundRobin ClusterType = 1
-)
-
-type Direction int
-
-const (
-	ReceiveOnly Direction = 1
-	ReadOnly    Direction = 2
-)
-
-type ExtendedPacketHeader struct {
-	Timestamp struct {
-		Sec  int64
-		Nsec int64
-	}
-}
-
-func NewRing(iface string, bufferSize int, flags Flag) (*Ring, error) {
-	return &Ring{}, fmt.Errorf("PF_RING not available on this system")
-}
-
-func (r *Ring) Close() error {
-	r.closed = true
-	return nil
-}
-
-func (r *Ring) SetApplicationName(name string) error {
-	return nil
-}
-
-func (r *Ring) SetCluster(id int, clusterType ClusterType) error {
-	return nil
-}
-
-func (r *Ring) SetDirection(direction Direction) error {
-	return nil
-}
-
-func (r *Ring) SetPollWatermark(watermark int) error {
-	return nil
-}
-
-func (r *Ring) SetPollDuration(duration int) error {
-	return nil
-}
-
-func (r *Ring) SetSamplingRate(rate int) error {
-	return nil
-}
-
-func (r *Ring) Enable() error {
-	return fmt.Errorf("PF_RING not available on this system")
-}
-
-func (r *Ring) Disable() error {
-	return nil
-}
-
-func (r *Ring) GetNextPacket() ([]byte, *ExtendedPacketHeader, error) {
-	return nil, nil, fmt.Errorf("PF_RING not available on this system")
-}
-
-func (r *Ring) SendPacket(data []byte) error {
-	return fmt.Errorf("PF_RING not available on this system")
-}
-
-func (r *Ring) Stats() (Stats, error) {
-	return Stats{}, fmt.Errorf("PF_RING not available on this system")
-}
-
-type Stats struct {
-	Received uint64
-	Dropped  uint64
-}
-
-func (r *Ring) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
-	return nil, gopacket.CaptureInfo{}, fmt.Errorf("PF_RING not available on this system")
-}
-
-func (r *Ring) ReadPacketDataExtended() (ExtendedPacketHeader, error) {
-	return ExtendedPacketHeader{}, fmt.Errorf("PF_RING not available on this system")
-}
-
-func (r *Ring) WritePacketData(data []byte) error {
-	return fmt.Errorf("PF_RING not available on this system")
-}
-
-func (r *Ring) SetSocketMode(mode int) error {
-	return nil
-}
-
 // inPacket is input packet data + receive timestamp
 type inPacket struct {
 	data   []byte
 	ts     time.Time
 	fromTX bool
+	hash   uint32 // picks the parser and processor, see getRxChanNumToUse
 }
 
 type outPacket struct {
@@ -150,126 +46,94 @@ type outPacket struct {
 }
 
 func startIOWorker(cfg *ClientGenConfig) {
-	rxStartDone := make(chan bool)
+	// buffered, a worker stopped by the context before the handshake must not block on it
+	rxStartDone := make(chan bool, cfg.NumRXWorkers)
 	for rxwkr := 0; rxwkr < cfg.NumRXWorkers; rxwkr++ {
 		func(i int) {
 			cfg.Eg.Go(func() error {
 				doneChan := make(chan error, 1)
 				go func() {
+					var err error
+					// sent last, after the handle is closed
+					defer func() { doneChan <- err }()
 					var profiler Profiler
 					profiler.Init(cfg.Eg, cfg.Ctx, true, fmt.Sprintf("RX Worker %d", i))
 					cfg.PerfProfilers = append(cfg.PerfProfilers, &profiler)
-					var ring *Ring
 					var rawIn *inPacket
-					var err error
-					// 1<<24 is PF_RING_DISCARD_INJECTED_PKTS , if you transmit a packet via the ring, doesn't read it back
-					// Добавляем флаги для поддержки hardware timestamps
-					flags := (1 << 24) | FlagPromisc | FlagHWTimestamp | FlagLongHeader
-					if ring, err = NewRing(cfg.Iface, 65536, flags); err != nil {
-						log.Errorf("pfring ring creation error: %v", err)
-						doneChan <- err
-						return
-					}
-					defer ring.Close()
-
-					// Устанавливаем размер буфера для улучшения производительности
-					if err = ring.SetApplicationName("clientgen"); err != nil {
-						log.Warnf("pfring SetApplicationName error: %v", err)
-					}
-
-					// just use fixed cluster number 1, round robin packets
-					if err = ring.SetCluster(1, ClusterType(ClusterRoundRobin)); err != nil {
-						log.Errorf("pfring SetCluster error: %v", err)
-						doneChan <- err
-						return
-					}
-					if err = ring.SetDirection(ReceiveOnly); err != nil {
-						log.Errorf("pfring failed to set direction: %v", err)
-						doneChan <- err
-						return
-					}
-					// Оптимизируем параметры для низкой задержки
-					if err = ring.SetPollWatermark(1); err != nil {
-						log.Errorf("pfring failed to set poll watermark: %v", err)
-						doneChan <- err
-						return
-					}
-					if err = ring.SetPollDuration(0); err != nil {
-						log.Errorf("pfring failed to set poll duration: %v", err)
-						doneChan <- err
-						return
-					}
-					if err = ring.SetSamplingRate(1); err != nil {
-						log.Errorf("pfring failed to set sample rate: %v", err)
-						doneChan <- err
-						return
-					}
-					// only using read for now
-					if err = ring.SetSocketMode(0); err != nil { // ReadOnly = 0
-						log.Errorf("pfring SetSocketMode error: %v", err)
-						doneChan <- err
-						return
-					} else if err = ring.Enable(); err != nil {
-						log.Errorf("pfring Enable error: %v", err)
-						doneChan <- err
+					handle, err := openPacketIO(cfg, PacketIORX, i)
+					if err != nil {
+						log.Errorf("RX wkr %d %s open error: %v", i, cfg.IOBackend, err)
 						return
 					}
+					defer handle.Close()
 					if cfg.DebugPrint || cfg.DebugIoWkrRX {
-						log.Debugf("RX wkr %d pfring done!", i)
+						log.Debugf("RX wkr %d %s done!", i, cfg.IOBackend)
 					}
 
 					var data []byte
-					var ci gopacket.CaptureInfo
-					var pktHdr ExtendedPacketHeader
+					var ts time.Time
+					var hwTS bool
 					rxStartDone <- true
 
-					// Статистика PF_RING
+					// transport statistics, each RX worker adds its own share
+					// stopped before the deferred Close above, Stats must not see a closed fd
+					statsDone := make(chan struct{})
+					statsStopped := make(chan struct{})
+					defer func() {
+						close(statsDone)
+						<-statsStopped
+					}()
 					go func() {
+						defer close(statsStopped)
+						var prev Stats
 						ticker := time.NewTicker(5 * time.Second)
 						defer ticker.Stop()
 						for {
 							select {
 							case <-ticker.C:
-								stats, err := ring.Stats()
+								stats, err := handle.Stats()
 								if err == nil {
-									atomic.StoreUint64(&cfg.Counters.PFRingRXPackets, stats.Received)
-									atomic.StoreUint64(&cfg.Counters.PFRingRXDropped, stats.Dropped)
+									atomic.AddUint64(&cfg.Counters.PFRingRXPackets, stats.Received-prev.Received)
+									atomic.AddUint64(&cfg.Counters.PFRingRXDropped, stats.Dropped-prev.Dropped)
+									prev = stats
 								}
+							case <-statsDone:
+								return
 							case <-(*cfg.Ctx).Done():
 								return
 							}
 						}
 					}()
 
-					for {
+					for (*cfg.Ctx).Err() == nil {
 						// try to read from handle
-						// Используем ReadPacketDataExtended для получения расширенной информации включая hardware timestamps
-						data, ci, err = ring.ReadPacketData()
-						if err != nil || data == nil || len(data) == 0 {
+						data, ts, hwTS, err = handle.ReadPacket()
+						if err != nil {
+							if (*cfg.Ctx).Err() != nil {
+								err = nil
+								return
+							}
+							log.Errorf("RX wkr %d read error: %v", i, err)
+							return
+						}
+						if len(data) == 0 {
 							continue
 						}
-
-						// Получаем расширенную информацию о пакете для hardware timestamps
-						pktHdr, err = ring.ReadPacketDataExtended()
-						if err == nil && pktHdr.Timestamp.Sec > 0 {
-							// Используем hardware timestamp если доступен
-							ci.Timestamp = time.Unix(int64(pktHdr.Timestamp.Sec), int64(pktHdr.Timestamp.Nsec))
+						if hwTS {
 							atomic.AddUint64(&cfg.Counters.PFRingHWTimestamps, 1)
-							if cfg.DebugPrint || cfg.DebugIoWkrRX {
-								log.Debugf("PFring listener %d got HW timestamp: %v", i, ci.Timestamp)
-							}
 						}
+						capturePacketData(cfg, data, ts, false, hwTS)
 
 						profiler.Tick()
 						if cfg.DebugPrint || cfg.DebugIoWkrRX {
-							log.Debugf("PFring listener %d got data ts %v, len %d", i, ci.Timestamp, len(data))
+							log.Debugf("RX wkr %d got data ts %v hw %v, len %d", i, ts, hwTS, len(data))
 						}
 						rawIn = cfg.RunData.inPacketPool.Get().(*inPacket)
 						rawIn.data = data
-						rawIn.ts = ci.Timestamp
+						rawIn.ts = ts
 						rawIn.fromTX = false
 
-						cfg.RunData.rawInput[getRxChanNumToUse(cfg)] <- rawIn
+						sendRawInput(cfg, rawIn)
 						atomic.AddUint64(&cfg.Counters.TotalPacketsRcvd, 1)
 						atomic.AddUint64(&cfg.perIORX[i], 1)
 						profiler.Tock()
@@ -278,6 +142,7 @@ func startIOWorker(cfg *ClientGenConfig) {
 				select {
 				case <-(*cfg.Ctx).Done():
 					log.Errorf("RX %d done due to context", i)
+					<-doneChan
 					return (*cfg.Ctx).Err()
 				case err := <-doneChan:
 					return err
@@ -296,15 +161,14 @@ func startIOWorker(cfg *ClientGenConfig) {
 		}
 	}
 
-	txStartDone := make(chan bool)
+	txStartDone := make(chan bool, cfg.NumTXWorkers)
 	for txwkr := 0; txwkr < cfg.NumTXWorkers; txwkr++ {
 		func(i int) {
 			cfg.Eg.Go(func() error {
 				doneChan := make(chan error, 1)
 				go func() {
-					// PFring doesn't implement TX timestamps actually
-					// API documentation lists it, but at a low level, its not actually used
-					// create a raw socket and send packets via it , read TS similar to Oleg's method
+					var err error
+					defer func() { doneChan <- err }()
 					var profiler Profiler
 					profiler.Init(cfg.Eg, cfg.Ctx, true, fmt.Sprintf("TX worker %d", i))
 					cfg.PerfProfilers = append(cfg.PerfProfilers, &profiler)
@@ -315,99 +179,133 @@ func startIOWorker(cfg *ClientGenConfig) {
 						cfg.PerfProfilers = append(cfg.PerfProfilers, &txTSworker[j])
 					}
 
-					// Создаем PF_RING для передачи пакетов
-					var txRing *Ring
-					var err error
-					// Флаги для TX: без DISCARD_INJECTED_PKTS чтобы можно было читать свои пакеты для timestamp
-					txFlags := FlagPromisc | FlagHWTimestamp | FlagLongHeader
-					if txRing, err = NewRing(cfg.Iface, 65536, txFlags); err != nil {
-						log.Errorf("pfring TX ring creation error: %v", err)
-						doneChan <- err
+					handle, err := openPacketIO(cfg, PacketIOTX, i)
+					if err != nil {
+						log.Errorf("TX wkr %d %s open error: %v", i, cfg.IOBackend, err)
 						return
 					}
-					defer txRing.Close()
-
-					if err = txRing.SetApplicationName("clientgen-tx"); err != nil {
-						log.Warnf("pfring TX SetApplicationName error: %v", err)
-					}
-
-					if err = txRing.SetDirection(1); err != nil { // TransmitOnly = 1
-						log.Errorf("pfring TX failed to set direction: %v", err)
-						doneChan <- err
-						return
-					}
-
-					if err = txRing.SetSocketMode(1); err != nil { // WriteOnly = 1
-						log.Errorf("pfring TX SetSocketMode error: %v", err)
-						doneChan <- err
-						return
+					defer handle.Close()
+
+					// packets that want a TX timestamp go out on their own raw socket, the kernel
+					// hands them back on its error queue with the timestamp for the TS read workers.
+					// backends that timestamp themselves feed them back on their own
+					var tsSock *txTSSocket
+					tsWriter, _ := handle.(txTSWriterIO)
+					if _, ok := handle.(noTXTSSocketIO); ok {
+						// sending through the raw socket would bypass the backend
+					} else if tsSock, err = newTXTSSocket(cfg.Iface); err != nil {
+						log.Warnf("TX wkr %d no TX timestamps, using software send time: %v", i, err)
+					} else {
+						defer tsSock.Close()
+						if cfg.DebugPrint || cfg.DebugIoWkrTX {
+							log.Debugf("TX wkr %d using %s TX timestamps", i, tsSock.tsType)
+						}
+						// the readers are done with tsSock before it's closed
+						var readers sync.WaitGroup
+						defer readers.Wait()
+						for j := 0; j < cfg.NumTXTSWorkerPerTx; j++ {
+							readers.Add(1)
+							go func(j int) {
+								defer readers.Done()
+								txTSReader(cfg, i, j, tsSock, &txTSworker[j])
+							}(j)
+						}
 					}
 
-					if err = txRing.Enable(); err != nil {
-						log.Errorf("pfring TX Enable error: %v", err)
-						doneChan <- err
-						return
+					batchSize := cfg.TXBatchSize
+					if batchSize < 1 {
+						batchSize = 1
 					}
-
-					// Note: Raw socket timestamping is not supported on Windows
-					// This would normally create raw sockets for hardware timestamping
-					// Hardware timestamping not supported on Windows
-					// This would normally enable hardware timestamping capabilities
-
 					var out *outPacket
+					var outstanding int64
+					var sent int
+					batch := make([]*outPacket, 0, batchSize)
+					tsFrames := make([][]byte, 0, batchSize)
+					frames := make([][]byte, 0, batchSize)
 					txStartDone <- true
 					for {
-						out = <-(cfg.RunData.rawOutput[i]) // want to send a packet
-						if out == nil || len((*out.data).Bytes()) == 0 {
-							log.Infof("empty data bad!")
-							continue
-						}
-						if cfg.DebugPrint || cfg.DebugIoWkrTX {
-							// debug print
-							debugPkt := gopacket.NewPacket((*out.data).Bytes(), layers.LinkTypeEthernet, gopacket.Default)
-							log.Debugf("Debug txWkr %d send packet %v", i, debugPkt)
+						select {
+						case out = <-(cfg.RunData.rawOutput[i]): // want to send a packet
+						case <-(*cfg.Ctx).Done():
+							return
 						}
 						profiler.Tick()
-						if out.getTS {
-							// Используем PF_RING для отправки с timestamp
-							err = txRing.WritePacketData((*out.data).Bytes())
-							if err != nil {
-								log.Errorf("txWkr %d send packet TS failed: %v", i, err)
+						// take whatever else is already queued, up to a batch
+						batch = append(batch[:0], out)
+					drain:
+						for len(batch) < batchSize {
+							select {
+							case out = <-(cfg.RunData.rawOutput[i]):
+								batch = append(batch, out)
+							default:
+								break drain
 							}
-							if out.cl != nil {
-								out.cl.CountOutgoingPackets++
+						}
+						tsFrames = tsFrames[:0]
+						frames = frames[:0]
+						for _, out = range batch {
+							if out == nil || len((*out.data).Bytes()) == 0 {
+								log.Infof("empty data bad!")
+								continue
 							}
-							atomic.AddUint64(&cfg.Counters.TotalTXTSPacketsSent, 1)
-						} else {
-							// Используем PF_RING для обычной отправки
-							err = txRing.WritePacketData((*out.data).Bytes())
-							if err != nil {
-								log.Errorf("txWkr %d send packet failed: %v", i, err)
+							if cfg.DebugPrint || cfg.DebugIoWkrTX {
+								// debug print
+								debugPkt := gopacket.NewPacket((*out.data).Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+								log.Debugf("Debug txWkr %d send packet %v", i, debugPkt)
 							}
-							if out.cl != nil {
-								out.cl.CountOutgoingPackets++
-								out.sentTS = fastime.Now()
-								if out.pktType == pktAnnounceGrantReq {
-									out.cl.SentAnnounceGrantReqTime = out.sentTS
-								} else if out.pktType == pktSyncGrantReq {
-									out.cl.SentlastSyncGrantReqTime = out.sentTS
-								} else if out.pktType == pktDelayRespGrantReq {
-									out.cl.SentDelayRespGrantReqTime = out.sentTS
-								} else if out.pktType == pktDelayReq {
-									out.cl.SentDelayReqTime = out.sentTS
+							if out.getTS && (tsSock != nil || tsWriter != nil) {
+								tsFrames = append(tsFrames, (*out.data).Bytes())
+							} else {
+								frames = append(frames, (*out.data).Bytes())
+							}
+						}
+						if tsSock == nil {
+							for _, data := range tsFrames {
+								if err = tsWriter.WritePacketTS(data); err != nil {
+									log.Errorf("txWkr %d send packet TS failed: %v", i, err)
 								}
 							}
-							if cfg.DebugPrint || cfg.DebugIoWkrTX {
-								log.Debugf("Debug txWkr %d send packet via PF_RING", i)
+						} else if len(tsFrames) == 1 {
+							// send time gets filled in when the TX timestamp is read back
+							if outstanding, err = tsSock.WritePacket(tsFrames[0]); err != nil {
+								log.Errorf("txWkr %d send packet TS failed: %v", i, err)
+							}
+							atomicMaxUint64(&cfg.Counters.MaxTXTSBytesOutstanding, uint64(outstanding))
+						} else if len(tsFrames) > 1 {
+							if sent, outstanding, err = tsSock.WritePackets(tsFrames); err != nil {
+								log.Errorf("txWkr %d send %d of %d packets TS failed: %v", i, len(tsFrames)-sent, len(tsFrames), err)
+							}
+							atomicMaxUint64(&cfg.Counters.MaxTXTSBytesOutstanding, uint64(outstanding))
+						}
+						if len(frames) > 0 {
+							if sent, err = writePackets(handle, frames); err != nil {
+								log.Errorf("txWkr %d send %d of %d packets failed: %v", i, len(frames)-sent, len(frames), err)
+							}
+						}
+						// one send time for the whole batch, it went out with one call
+						sentTS := fastime.Now()
+						for _, out = range batch {
+							if out == nil || len((*out.data).Bytes()) == 0 {
+								continue
 							}
-							if err != nil {
-								log.Errorf("PF_RING write packet data failed %v", err)
-								// Не прерываем работу, продолжаем с следующим пакетом
+							if out.getTS && (tsSock != nil || tsWriter != nil) {
+								atomic.AddUint64(&cfg.Counters.TotalTXTSPacketsSent, 1)
+								if out.cl != nil {
+									atomic.AddUint64(&out.cl.CountOutgoingPackets, 1)
+								}
+							} else {
+								out.sentTS = sentTS
+								if out.cl != nil {
+									atomic.AddUint64(&out.cl.CountOutgoingPackets, 1)
+									setClientSentTime(cfg, out)
+								}
+								capturePacketData(cfg, (*out.data).Bytes(), out.sentTS, true, false)
 							}
+							atomic.AddUint64(&cfg.Counters.TotalPacketsSent, 1)
+							atomic.AddUint64(&cfg.perIOTX[i], 1)
+							cfg.RunData.outPacketPool.Put(out)
 						}
-						atomic.AddUint64(&cfg.Counters.TotalPacketsSent, 1)
-						atomic.AddUint64(&cfg.perIOTX[i], 1)
-						cfg.RunData.outPacketPool.Put(out)
+						atomic.AddUint64(&cfg.TXBatchSizes[txBatchBucket(len(batch))], 1)
 						profiler.Tock()
 					}
 				}()
@@ -415,6 +313,7 @@ func startIOWorker(cfg *ClientGenConfig) {
 				select {
 				case <-(*cfg.Ctx).Done():
 					log.Infof("TX worker %d cancelling due to context done", i)
+					<-doneChan
 					return (*cfg.Ctx).Err()
 				case err = <-doneChan:
 					return err
@@ -433,3 +332,81 @@ func startIOWorker(cfg *ClientGenConfig) {
 		}
 	}
 }
+
+// number of buckets in ClientGenConfig.TXBatchSizes
+const txBatchBuckets = 12
+
+// txBatchBucket bucket i counts the batches of (1<<(i-1), 1<<i] packets, the last one everything larger
+func txBatchBucket(n int) int {
+	b := 0
+	for b < txBatchBuckets-1 && n > 1<<b {
+		b++
+	}
+	return b
+}
+
+// setClientSentTime records out.sentTS as the send time of the request it carries
+func setClientSentTime(cfg *ClientGenConfig, out *outPacket) {
+	if err := out.cl.stateSem.Acquire(*cfg.Ctx, 1); err != nil {
+		return
+	}
+	defer out.cl.stateSem.Release(1)
+	if out.pktType == pktAnnounceGrantReq {
+		out.cl.SentAnnounceGrantReqTime = out.sentTS
+	} else if out.pktType == pktSyncGrantReq {
+		out.cl.SentlastSyncGrantReqTime = out.sentTS
+	} else if out.pktType == pktDelayRespGrantReq {
+		out.cl.SentDelayRespGrantReqTime = out.sentTS
+	} else if out.pktType == pktDelayReq {
+		out.cl.SentDelayReqTime = out.sentTS
+	} else if out.pktType == pktPDelayReq {
+		out.cl.SentPDelayReqTime = out.sentTS
+	}
+}
+
+// txTSReader reads sent frames and their TX timestamps back from tsSock and feeds them
+// to the packet parsers with fromTX set, so the client gets its send times from the wire
+func txTSReader(cfg *ClientGenConfig, i, j int, tsSock *txTSSocket, profiler *Profiler) {
+	oob := make([]byte, ptp.ControlSizeBytes)
+	var rawIn *inPacket
+	var buf []byte
+	for {
+		buf = cfg.RunData.bytePool.Get().([]byte)
+		buf = buf[:cap(buf)]
+		n, ts, err := tsSock.ReadTimestamp(buf, oob)
+		if err != nil || n == 0 {
+			cfg.RunData.bytePool.Put(buf)
+			if (*cfg.Ctx).Err() != nil {
+				return
+			}
+			if err != nil && (cfg.DebugPrint || cfg.DebugIoWkrTX) {
+				log.Debugf("TX wkr %d TSRead worker %d read error: %v", i, j, err)
+			}
+			continue
+		}
+		profiler.Tick()
+		if cfg.DebugPrint || cfg.DebugIoWkrTX {
+			log.Debugf("TX wkr %d TSRead worker %d got TX ts %v, len %d", i, j, ts, n)
+		}
+		// captured here rather than in the TX worker so it carries the real send time
+		capturePacketData(cfg, buf[:n], ts, true, tsSock.tsType == ptp.HWTIMESTAMP)
+		rawIn = cfg.RunData.inPacketPool.Get().(*inPacket)
+		rawIn.data = buf[:n]
+		rawIn.ts = ts
+		rawIn.fromTX = true
+
+		sendRawInput(cfg, rawIn)
+		atomic.AddUint64(&cfg.Counters.TotalTXTSRead, 1)
+		profiler.Tock()
+	}
+}
+
+// atomicMaxUint64 raises *addr to v if v is larger
+func atomicMaxUint64(addr *uint64, v uint64) {
+	for {
+		cur := atomic.LoadUint64(addr)
+		if v <= cur || atomic.CompareAndSwapUint64(addr, cur, v) {
+			return
+		}
+	}
+}
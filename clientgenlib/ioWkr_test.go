@@ -0,0 +1,89 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_txBatchBucket(t *testing.T) {
+	for n, want := range map[int]int{1: 0, 2: 1, 3: 2, 4: 2, 5: 3, 8: 3, 9: 4, 1024: 10, 1025: 11, 100000: 11} {
+		require.Equal(t, want, txBatchBucket(n), "batch of %d", n)
+	}
+}
+
+// recordIO keeps what's written to it and fails the write of frame fail
+type recordIO struct {
+	frames  [][]byte
+	batches int
+	fail    int
+}
+
+func (r *recordIO) ReadPacket() ([]byte, time.Time, bool, error) { return nil, time.Time{}, false, nil }
+func (r *recordIO) Stats() (Stats, error)                        { return Stats{}, nil }
+func (r *recordIO) Close() error                                 { return nil }
+
+func (r *recordIO) WritePacket(data []byte) error {
+	if len(r.frames) == r.fail {
+		return errors.New("write failed")
+	}
+	r.frames = append(r.frames, data)
+	return nil
+}
+
+// recordBatchIO is recordIO that takes whole batches
+type recordBatchIO struct {
+	recordIO
+}
+
+func (r *recordBatchIO) WritePackets(frames [][]byte) (int, error) {
+	r.batches++
+	for i, data := range frames {
+		if err := r.WritePacket(data); err != nil {
+			return i, err
+		}
+	}
+	return len(frames), nil
+}
+
+func Test_writePackets(t *testing.T) {
+	frames := [][]byte{{1}, {2}, {3}}
+
+	one := &recordIO{fail: -1}
+	sent, err := writePackets(one, frames)
+	require.NoError(t, err)
+	require.Equal(t, 3, sent)
+	require.Equal(t, frames, one.frames)
+
+	// the frames after a failed one aren't sent
+	one = &recordIO{fail: 1}
+	sent, err = writePackets(one, frames)
+	require.Error(t, err)
+	require.Equal(t, 1, sent)
+	require.Equal(t, frames[:1], one.frames)
+
+	batch := &recordBatchIO{recordIO{fail: -1}}
+	sent, err = writePackets(batch, frames)
+	require.NoError(t, err)
+	require.Equal(t, 3, sent)
+	require.Equal(t, 1, batch.batches)
+	require.Equal(t, frames, batch.frames)
+}
@@ -0,0 +1,42 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// kernelSockets stub, the "kernel" IOBackend is linux only
+type kernelSockets struct{}
+
+func startKernelSockets(cfg *ClientGenConfig) error {
+	if cfg.IOBackend != IOBackendKernel {
+		return nil
+	}
+	err := fmt.Errorf("kernel sockets not available on this system")
+	log.Errorf("Failed to open kernel sockets: %v", err)
+	return err
+}
+
+func openKernel(cfg *ClientGenConfig, dir PacketIODirection, wkr int) (PacketIO, error) {
+	return nil, fmt.Errorf("kernel sockets not available on this system")
+}
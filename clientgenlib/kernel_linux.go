@@ -0,0 +1,374 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// how long a kernel RX read waits for a socket to become readable
+const kernelPollTimeoutMs = 100
+
+// kernelSockKey finds the socket of a client address and PTP port
+type kernelSockKey struct {
+	ip   [16]byte
+	port int
+}
+
+func newKernelSockKey(ip net.IP, port int) kernelSockKey {
+	k := kernelSockKey{port: port}
+	copy(k.ip[:], ip.To16())
+	return k
+}
+
+// kernelSocket is the UDP socket of one client on one PTP port
+type kernelSocket struct {
+	conn *net.UDPConn
+	fd   int
+	ip   net.IP
+	port int
+	mac  net.HardwareAddr // the client's, the rebuilt frames carry it like the raw ones do
+	vlan uint16           // frames to the client are tagged like the ones it sends
+	pcp  uint8
+}
+
+// kernelSockets are the sockets of all clients for the "kernel" IOBackend, shared by the
+// RX / TX workers. The maps are only written before the workers start
+type kernelSockets struct {
+	cfg    *ClientGenConfig
+	epfd   int
+	byFD   map[int32]*kernelSocket
+	byAddr map[kernelSockKey]*kernelSocket
+}
+
+// startKernelSockets opens the client sockets when the "kernel" IOBackend is used
+// they are closed once the context is done
+func startKernelSockets(cfg *ClientGenConfig) error {
+	if cfg.IOBackend != IOBackendKernel {
+		return nil
+	}
+	k, err := newKernelSockets(cfg)
+	if err != nil {
+		log.Errorf("Failed to open kernel sockets: %v", err)
+		return err
+	}
+	cfg.RunData.kernel = k
+	cfg.Eg.Go(func() error {
+		<-(*cfg.Ctx).Done()
+		k.close()
+		return nil
+	})
+	return nil
+}
+
+func newKernelSockets(cfg *ClientGenConfig) (*kernelSockets, error) {
+	epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("kernel epoll: %w", err)
+	}
+	k := &kernelSockets{
+		cfg:    cfg,
+		epfd:   epfd,
+		byFD:   map[int32]*kernelSocket{},
+		byAddr: map[kernelSockKey]*kernelSocket{},
+	}
+	for i := range cfg.RunData.clients {
+		for _, port := range []int{ptp.PortEvent, ptp.PortGeneral} {
+			if err = k.open(&cfg.RunData.clients[i], port); err != nil {
+				k.close()
+				return nil, err
+			}
+		}
+	}
+	return k, nil
+}
+
+func (k *kernelSockets) open(cl *SingleClientGen, port int) error {
+	ip := cl.ClientIP
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: port})
+	if err != nil {
+		return fmt.Errorf("kernel listen %v port %d (client addresses must be on the interface): %w", ip, port, err)
+	}
+	fd, err := ptp.ConnFd(conn)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("kernel socket fd %v: %w", ip, err)
+	}
+	// RX timestamps for every datagram, TX ones only for what WritePacketTS asks for. the
+	// kernel hands those back on the error queue with the whole sent frame
+	flags := unix.SOF_TIMESTAMPING_RX_SOFTWARE | unix.SOF_TIMESTAMPING_SOFTWARE
+	if err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMPING, flags); err != nil {
+		conn.Close()
+		return fmt.Errorf("kernel enable timestamps %v: %w", ip, err)
+	}
+	if err = unix.EpollCtl(k.epfd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(fd)}); err != nil {
+		conn.Close()
+		return fmt.Errorf("kernel epoll add %v: %w", ip, err)
+	}
+	s := &kernelSocket{conn: conn, fd: fd, ip: ip, port: port, mac: cl.mac, vlan: cl.vlan, pcp: cl.pcp}
+	k.byFD[int32(fd)] = s
+	k.byAddr[newKernelSockKey(ip, port)] = s
+	return nil
+}
+
+func (k *kernelSockets) close() {
+	for _, s := range k.byFD {
+		s.conn.Close()
+	}
+	unix.Close(k.epfd)
+}
+
+// kernelIO is the PacketIO of one worker, every frame goes through the client sockets
+// so the kernel builds the headers, answers ARP / ND and timestamps RX in software
+type kernelIO struct {
+	k      *kernelSockets
+	events [1]unix.EpollEvent
+	buf    []byte
+	oob    []byte
+	txTS   []byte // control message asking for a TX timestamp
+
+	// TX frame decoding
+	parser  *gopacket.DecodingLayerParser
+	decoded []gopacket.LayerType
+	eth     layers.Ethernet
+	dot1q   layers.Dot1Q
+	ip4     layers.IPv4
+	ip6     layers.IPv6
+	udp     layers.UDP
+}
+
+func openKernel(cfg *ClientGenConfig, dir PacketIODirection, wkr int) (PacketIO, error) {
+	if cfg.RunData.kernel == nil {
+		return nil, fmt.Errorf("kernel sockets not open")
+	}
+	h := &kernelIO{
+		k:    cfg.RunData.kernel,
+		buf:  make([]byte, ptp.PayloadSizeBytes),
+		oob:  make([]byte, ptp.ControlSizeBytes),
+		txTS: make([]byte, unix.CmsgSpace(4)),
+	}
+	cmsg := (*unix.Cmsghdr)(unsafe.Pointer(&h.txTS[0]))
+	cmsg.Level = unix.SOL_SOCKET
+	cmsg.Type = unix.SO_TIMESTAMPING
+	cmsg.SetLen(unix.CmsgLen(4))
+	*(*uint32)(unsafe.Pointer(&h.txTS[unix.CmsgLen(0)])) = unix.SOF_TIMESTAMPING_TX_SOFTWARE
+	h.parser = gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet, &h.eth, &h.dot1q, &h.ip4, &h.ip6, &h.udp)
+	h.parser.IgnoreUnsupported = true
+	return h, nil
+}
+
+// ReadPacket returns the next datagram of any client socket, rebuilt into the frame
+// the raw backends would have received
+func (h *kernelIO) ReadPacket() ([]byte, time.Time, bool, error) {
+	n, err := unix.EpollWait(h.k.epfd, h.events[:], kernelPollTimeoutMs)
+	if err != nil {
+		if (*h.k.cfg.Ctx).Err() != nil {
+			return nil, time.Time{}, false, (*h.k.cfg.Ctx).Err()
+		}
+		if err == unix.EINTR {
+			return nil, time.Time{}, false, nil
+		}
+		return nil, time.Time{}, false, fmt.Errorf("kernel epoll wait: %w", err)
+	}
+	if n == 0 {
+		return nil, time.Time{}, false, nil
+	}
+	s := h.k.byFD[h.events[0].Fd]
+	if h.events[0].Events&unix.EPOLLERR != 0 {
+		h.readTXTimestamps(s)
+	}
+	if h.events[0].Events&unix.EPOLLIN == 0 {
+		return nil, time.Time{}, false, nil
+	}
+	n, sa, ts, err := ptp.ReadPacketWithRXTimestampBuf(s.fd, h.buf, h.oob)
+	if errors.Is(err, unix.EAGAIN) {
+		// another RX worker got it first
+		return nil, time.Time{}, false, nil
+	}
+	if n == 0 {
+		log.Errorf("kernel read %v port %d: %v", s.ip, s.port, err)
+		return nil, time.Time{}, false, nil
+	}
+	if err != nil {
+		// no timestamp control message
+		ts = time.Now()
+	}
+	var gmPort int
+	switch a := sa.(type) {
+	case *unix.SockaddrInet4:
+		gmPort = a.Port
+	case *unix.SockaddrInet6:
+		gmPort = a.Port
+	}
+	data, err := h.frame(s, ptp.SockaddrToIP(sa), gmPort, false, h.buf[:n])
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	return data, ts, false, nil
+}
+
+// readTXTimestamps hands the frames sent with WritePacketTS back to the packet parsers with
+// their kernel TX timestamp and fromTX set, like txTSReader does for the raw backends
+func (h *kernelIO) readTXTimestamps(s *kernelSocket) {
+	cfg := h.k.cfg
+	for {
+		n, ts, err := ptp.ReadTXtimestampPacketBuf(s.fd, h.buf, h.oob)
+		if n == 0 {
+			// error queue is empty
+			return
+		}
+		if err != nil {
+			log.Errorf("kernel TX timestamp %v port %d: %v", s.ip, s.port, err)
+			continue
+		}
+		// the kernel returns the frame it sent, link layer included
+		_, dst, ok := h.decodeUDP(h.buf[:n])
+		if !ok {
+			continue
+		}
+		data, err := h.frame(s, dst, int(h.udp.DstPort), true, h.udp.Payload)
+		if err != nil {
+			log.Errorf("kernel TX timestamp %v port %d: %v", s.ip, s.port, err)
+			continue
+		}
+		buf := cfg.RunData.bytePool.Get().([]byte)
+		buf = append(buf[:0], data...)
+		capturePacketData(cfg, buf, ts, true, false)
+		rawIn := cfg.RunData.inPacketPool.Get().(*inPacket)
+		rawIn.data = buf
+		rawIn.ts = ts
+		rawIn.fromTX = true
+
+		sendRawInput(cfg, rawIn)
+		atomic.AddUint64(&cfg.Counters.TotalTXTSRead, 1)
+	}
+}
+
+// frame wraps a datagram between the GM and s into Ethernet / IP / UDP headers,
+// from the GM unless fromTX
+func (h *kernelIO) frame(s *kernelSocket, gm net.IP, gmPort int, fromTX bool, payload []byte) ([]byte, error) {
+	eth := layers.Ethernet{
+		SrcMAC:       h.k.cfg.parsedServerMac,
+		DstMAC:       s.mac,
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	udp := layers.UDP{
+		SrcPort: layers.UDPPort(gmPort),
+		DstPort: layers.UDPPort(s.port),
+	}
+	src, dst := gm, s.ip
+	if fromTX {
+		eth.SrcMAC, eth.DstMAC = eth.DstMAC, eth.SrcMAC
+		udp.SrcPort, udp.DstPort = udp.DstPort, udp.SrcPort
+		src, dst = dst, src
+	}
+	var network gopacket.SerializableLayer
+	var err error
+	if s.ip.To4() == nil {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip6 := &layers.IPv6{
+			SrcIP:      src,
+			DstIP:      dst,
+			Version:    6,
+			HopLimit:   255,
+			NextHeader: layers.IPProtocolUDP,
+		}
+		err = udp.SetNetworkLayerForChecksum(ip6)
+		network = ip6
+	} else {
+		ip4 := &layers.IPv4{
+			SrcIP:    src.To4(),
+			DstIP:    dst.To4(),
+			Version:  4,
+			TTL:      255,
+			Protocol: layers.IPProtocolUDP,
+		}
+		err = udp.SetNetworkLayerForChecksum(ip4)
+		network = ip4
+	}
+	if err != nil {
+		return nil, fmt.Errorf("kernel SetNetworkLayerForChecksum: %w", err)
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err = serializeFrame(buf, h.k.cfg.RunData.commonSerializeOp, &eth, s.vlan, s.pcp, network, &udp, gopacket.Payload(payload)); err != nil {
+		return nil, fmt.Errorf("kernel SerializeLayers: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeUDP finds the addresses of a UDP frame, the ports and payload are left in h.udp
+func (h *kernelIO) decodeUDP(data []byte) (src, dst net.IP, ok bool) {
+	h.parser.DecodeLayers(data, &h.decoded)
+	for _, t := range h.decoded {
+		switch t {
+		case layers.LayerTypeIPv4:
+			src, dst = h.ip4.SrcIP, h.ip4.DstIP
+		case layers.LayerTypeIPv6:
+			src, dst = h.ip6.SrcIP, h.ip6.DstIP
+		case layers.LayerTypeUDP:
+			ok = src != nil
+		}
+	}
+	return src, dst, ok
+}
+
+// WritePacket sends the UDP payload of a client frame from that client's socket
+// frames that aren't UDP, ARP / ND answers, are left to the kernel
+func (h *kernelIO) WritePacket(data []byte) error {
+	return h.write(data, nil)
+}
+
+// WritePacketTS is WritePacket with a kernel TX timestamp, the RX workers read it back
+func (h *kernelIO) WritePacketTS(data []byte) error {
+	return h.write(data, h.txTS)
+}
+
+func (h *kernelIO) write(data, oob []byte) error {
+	src, dst, ok := h.decodeUDP(data)
+	if !ok {
+		return nil
+	}
+	s, ok := h.k.byAddr[newKernelSockKey(src, int(h.udp.SrcPort))]
+	if !ok {
+		return fmt.Errorf("kernel no socket for %v port %d", src, h.udp.SrcPort)
+	}
+	_, err := unix.SendmsgN(s.fd, h.udp.Payload, oob, ptp.IPToSockaddr(dst, int(h.udp.DstPort)), 0)
+	return err
+}
+
+// Stats the kernel keeps its drops per socket, nothing to add here
+func (h *kernelIO) Stats() (Stats, error) {
+	return Stats{}, nil
+}
+
+// Close the sockets are shared and closed with the context
+func (h *kernelIO) Close() error {
+	return nil
+}
+
+func (h *kernelIO) noTXTSSocket() {}
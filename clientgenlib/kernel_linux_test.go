@@ -0,0 +1,151 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func Test_kernelSockets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errg, ctx := errgroup.WithContext(ctx)
+	// the sockets are closed once the context is done
+	defer errg.Wait()
+	defer cancel()
+	cfg := &ClientGenConfig{
+		IOBackend:       IOBackendKernel,
+		ServerAddress:   "127.0.0.1",
+		srcMAC:          net.HardwareAddr{2, 0, 0, 0, 0, 1},
+		parsedServerMac: net.HardwareAddr{2, 0, 0, 0, 0, 2},
+		Ctx:             &ctx,
+		Eg:              errg,
+		RunData: &ClientGenData{
+			clients: []SingleClientGen{
+				{ClientIP: net.ParseIP("127.0.1.1"), mac: net.HardwareAddr{2, 0, 0, 0, 1, 1}},
+				{ClientIP: net.ParseIP("127.0.1.2"), mac: net.HardwareAddr{2, 0, 0, 0, 1, 2}},
+			},
+		},
+		NumPacketParsers: 1,
+	}
+	cfg.RunData.commonSerializeOp = gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	cfg.RunData.inPacketPool.New = func() interface{} { return new(inPacket) }
+	cfg.RunData.bytePool.New = func() interface{} { return make([]byte, 300) }
+	rawIn := make(chan *inPacket, 1)
+	cfg.RunData.rawInput = []chan *inPacket{rawIn}
+	cfg.RunData.rawInputStats = []*queueStats{newQueueStats(cfg, QueueRawInput, 0, 1, func() int { return len(rawIn) })}
+
+	// the GM side is a plain socket
+	gm, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: ptp.PortGeneral})
+	if err != nil {
+		t.Skipf("can't bind the PTP general port: %v", err)
+	}
+	defer gm.Close()
+	require.NoError(t, startKernelSockets(cfg))
+	handle, err := openKernel(cfg, PacketIORX, 0)
+	require.NoError(t, err)
+
+	// client 1 to GM, the kernel builds the headers from the crafted frame
+	require.NoError(t, setupTemplates(cfg))
+	cl := &cfg.RunData.clients[1]
+	b, err := ptp.Bytes(reqUnicast(ptp.ClockIdentity(1), time.Minute, ptp.MessageAnnounce))
+	require.NoError(t, err)
+	tmpl, err := newPTPTemplate(reqUnicast(0, time.Minute, ptp.MessageAnnounce), nil)
+	require.NoError(t, err)
+	buf := gopacket.NewSerializeBuffer()
+	out := &outPacket{data: &buf}
+	craftSinglePktToGM(cfg, cl, ptp.PortGeneral, tmpl, ptp.ClockIdentity(1), 0, out)
+	require.NoError(t, handle.WritePacket(buf.Bytes()))
+
+	got := make([]byte, 200)
+	require.NoError(t, gm.SetReadDeadline(time.Now().Add(time.Second)))
+	n, from, err := gm.ReadFromUDP(got)
+	require.NoError(t, err)
+	require.Equal(t, b, got[:n])
+	require.True(t, from.IP.Equal(cl.ClientIP))
+	require.Equal(t, ptp.PortGeneral, from.Port)
+
+	// GM back to client 1 comes out as the frame a raw backend would have read
+	reply := []byte("grant")
+	_, err = gm.WriteToUDP(reply, from)
+	require.NoError(t, err)
+	var data []byte
+	var ts time.Time
+	for i := 0; i < 10 && len(data) == 0; i++ {
+		data, ts, _, err = handle.ReadPacket()
+		require.NoError(t, err)
+	}
+	require.NotEmpty(t, data)
+	require.WithinDuration(t, time.Now(), ts, time.Second)
+	pkt := gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default)
+	eth := pkt.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	require.Equal(t, cl.mac, eth.DstMAC)
+	ip := pkt.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	require.True(t, ip.SrcIP.Equal(net.ParseIP("127.0.0.1")))
+	require.True(t, ip.DstIP.Equal(cl.ClientIP))
+	udp := pkt.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	require.Equal(t, layers.UDPPort(ptp.PortGeneral), udp.DstPort)
+	require.Equal(t, reply, udp.Payload)
+
+	// a frame sent with a TX timestamp comes back to the parsers from the client, at its kernel send time
+	before := time.Now()
+	require.NoError(t, handle.(txTSWriterIO).WritePacketTS(buf.Bytes()))
+	_, _, err = gm.ReadFromUDP(got)
+	require.NoError(t, err)
+	for i := 0; i < 10 && len(rawIn) == 0; i++ {
+		data, _, _, err = handle.ReadPacket()
+		require.NoError(t, err)
+	}
+	require.Len(t, rawIn, 1)
+	in := <-rawIn
+	require.True(t, in.fromTX)
+	require.WithinDuration(t, before, in.ts, time.Second)
+	require.False(t, in.ts.Before(before))
+	require.Equal(t, uint64(1), cfg.Counters.TotalTXTSRead)
+	pkt = gopacket.NewPacket(in.data, layers.LinkTypeEthernet, gopacket.Default)
+	eth = pkt.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	require.Equal(t, cl.mac, eth.SrcMAC)
+	ip = pkt.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	require.True(t, ip.SrcIP.Equal(cl.ClientIP))
+	require.True(t, ip.DstIP.Equal(net.ParseIP("127.0.0.1")))
+	udp = pkt.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	require.Equal(t, layers.UDPPort(ptp.PortGeneral), udp.SrcPort)
+	require.Equal(t, b, udp.Payload)
+
+	// non UDP frames are left to the kernel
+	arp := gopacket.NewSerializeBuffer()
+	require.NoError(t, gopacket.SerializeLayers(arp, cfg.RunData.commonSerializeOp,
+		&layers.Ethernet{SrcMAC: cfg.srcMAC, DstMAC: cfg.parsedServerMac, EthernetType: layers.EthernetTypeARP},
+		&layers.ARP{AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4, HwAddressSize: 6, ProtAddressSize: 4,
+			Operation: 2, SourceHwAddress: cfg.srcMAC, SourceProtAddress: []byte{127, 0, 1, 1},
+			DstHwAddress: cfg.parsedServerMac, DstProtAddress: []byte{127, 0, 0, 1}}))
+	require.NoError(t, handle.WritePacket(arp.Bytes()))
+
+	cancel()
+	for i := 0; i < 10 && err == nil; i++ {
+		_, _, _, err = handle.ReadPacket()
+	}
+	require.Error(t, err)
+}
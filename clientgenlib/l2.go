@@ -0,0 +1,117 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/google/gopacket/layers"
+	log "github.com/sirupsen/logrus"
+)
+
+// ethernetTypePTP is PTP straight over Ethernet, IEEE 1588 annex F
+const ethernetTypePTP layers.EthernetType = 0x88F7
+
+// checkTransport makes sure clients can be told apart with cfg.Transport
+func checkTransport(cfg *ClientGenConfig) error {
+	switch cfg.Transport {
+	case "", TransportUDP:
+		return nil
+	case TransportL2:
+	default:
+		return fmt.Errorf("unknown Transport %q", cfg.Transport)
+	}
+	if cfg.IOBackend == IOBackendKernel {
+		return fmt.Errorf("Transport %q needs raw frames, IOBackend %q only has UDP sockets", cfg.Transport, cfg.IOBackend)
+	}
+	// ServerAddress isn't used, so there's no IP to ARP / NDP resolve the GM's MAC from
+	if cfg.ServerMAC == "" {
+		return fmt.Errorf("Transport %q needs ServerMAC, the GM has no IP address to resolve it from", cfg.Transport)
+	}
+	// the client of a frame is found by its MAC
+	if !cfg.PerClientMAC && len(cfg.RunData.clients) > 1 {
+		return fmt.Errorf("Transport %q with more than one client needs PerClientMAC", cfg.Transport)
+	}
+	cfg.RunData.byMAC = make(map[string]*SingleClientGen, len(cfg.RunData.clients))
+	for i := range cfg.RunData.clients {
+		cl := &cfg.RunData.clients[i]
+		cfg.RunData.byMAC[string(cl.mac)] = cl
+	}
+	return nil
+}
+
+// ptpMessage cuts the Ethernet padding off a PTP message
+func ptpMessage(payload []byte) []byte {
+	if len(payload) < 4 {
+		return payload
+	}
+	length := int(binary.BigEndian.Uint16(payload[2:]))
+	if length < len(payload) {
+		return payload[:length]
+	}
+	return payload
+}
+
+// l2PTP is the PTP message of a decoded PTP over Ethernet frame, nil for anything else
+func (in *PktDecoder) l2PTP() []byte {
+	if len(in.layers) == 0 {
+		return nil
+	}
+	switch in.layers[len(in.layers)-1] {
+	case layers.LayerTypeEthernet:
+		if in.eth.EthernetType == ethernetTypePTP {
+			return ptpMessage(in.eth.Payload)
+		}
+	case layers.LayerTypeDot1Q:
+		if in.dot1q.Type == ethernetTypePTP {
+			return ptpMessage(in.dot1q.Payload)
+		}
+	}
+	return nil
+}
+
+func getClientFromMAC(cfg *ClientGenConfig, vlan uint16, mac net.HardwareAddr) (*SingleClientGen, error) {
+	cl, ok := cfg.RunData.byMAC[string(mac)]
+	if !ok || cl.vlan != vlan {
+		return nil, fmt.Errorf("Could not find client with mac %v vlan %d", mac, vlan)
+	}
+	return cl, nil
+}
+
+// handleL2Incoming runs a PTP over Ethernet frame through the client it's for, or from if it was sent by us
+func handleL2Incoming(cfg *ClientGenConfig, in *PktDecoder, payload []byte) {
+	mac := in.eth.DstMAC
+	if in.fromTX {
+		mac = in.eth.SrcMAC
+	}
+	cl, err := getClientFromMAC(cfg, in.vlan(), mac)
+	if err != nil {
+		if cfg.DebugPrint {
+			log.Errorf("handleL2Incoming not found %v", err)
+		}
+		return
+	}
+	toSend, err := singleClientHandleIncomingPTP(cfg, cl, in, payload)
+	if err != nil {
+		return
+	}
+	if toSend != nil {
+		sendRawOutput(cfg, toSend)
+	}
+}
@@ -0,0 +1,48 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ptpMessage(t *testing.T) {
+	// 44 byte DelayReq padded to the minimum frame
+	msg := make([]byte, 46)
+	msg[3] = 44
+	require.Len(t, ptpMessage(msg), 44)
+	require.Len(t, ptpMessage(msg[:44]), 44)
+	require.Len(t, ptpMessage(msg[:3]), 3)
+}
+
+func Test_checkTransport(t *testing.T) {
+	cfg := loopbackTestConfig("10.0.1.1", "10.0.1.4", "10.0.0.1")
+	cfg.RunData = &ClientGenData{clients: make([]SingleClientGen, 2)}
+	cfg.Transport = "ip"
+	require.Error(t, checkTransport(cfg))
+	cfg.Transport = TransportL2
+	require.Error(t, checkTransport(cfg))
+	cfg.PerClientMAC = true
+	require.NoError(t, checkTransport(cfg))
+	cfg.ServerMAC = ""
+	require.Error(t, checkTransport(cfg))
+	cfg.ServerMAC = "0c:42:a1:80:31:66"
+	cfg.IOBackend = IOBackendKernel
+	require.Error(t, checkTransport(cfg))
+}
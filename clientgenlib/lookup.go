@@ -0,0 +1,165 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// with more arithmetic spans than this every client goes into the lookup map instead of scanning them
+const maxScanSpans = 8
+
+// the most clients a CIDR block may have, it would be more than fits in memory anyway
+const maxCIDRHostBits = 24
+
+var errClientNotFound = errors.New("client not found")
+
+// clientKey is a client address in a VLAN, IPv4 in its IPv4-mapped IPv6 form
+type clientKey struct {
+	vlan uint16
+	addr [net.IPv6len]byte
+}
+
+// makeClientKey is the key of ip in vlan without allocating, false if ip isn't an IP
+func makeClientKey(vlan uint16, ip net.IP) (clientKey, bool) {
+	key := clientKey{vlan: vlan}
+	switch len(ip) {
+	case net.IPv4len:
+		key.addr[10], key.addr[11] = 0xff, 0xff
+		copy(key.addr[12:], ip)
+	case net.IPv6len:
+		copy(key.addr[:], ip)
+	default:
+		return key, false
+	}
+	return key, true
+}
+
+// ipHiLo is the 16 byte form of ip as two integers for the span arithmetic
+func ipHiLo(addr *[net.IPv6len]byte) (uint64, uint64) {
+	return binary.BigEndian.Uint64(addr[:8]), binary.BigEndian.Uint64(addr[8:])
+}
+
+// indexClients builds the lookup map of getClientFromIP for the address lists,
+// and for every client when there are too many spans to scan
+func indexClients(cfg *ClientGenConfig) {
+	rd := cfg.RunData
+	rd.byAddr = nil
+	rd.scanSpans = 0
+	for r := range rd.spans {
+		if !rd.spans[r].list {
+			rd.scanSpans++
+		}
+	}
+	all := rd.scanSpans > maxScanSpans
+	for r := range rd.spans {
+		span := &rd.spans[r]
+		if !span.list && !all {
+			continue
+		}
+		if rd.byAddr == nil {
+			rd.byAddr = make(map[clientKey]int32, len(rd.clients))
+		}
+		for i := span.first; i < span.first+span.count; i++ {
+			if key, ok := makeClientKey(span.vlan, rd.clients[i].ClientIP); ok {
+				rd.byAddr[key] = int32(i)
+			}
+		}
+	}
+	if all {
+		rd.scanSpans = 0
+	}
+}
+
+// getClientFromIP finds the client with ip in VLAN vlan, 0 for untagged
+// a map hit for address lists or many ranges, else subtraction from the start of each range
+func getClientFromIP(cfg *ClientGenConfig, vlan uint16, ip net.IP) (*SingleClientGen, error) {
+	rd := cfg.RunData
+	key, ok := makeClientKey(vlan, ip)
+	if !ok {
+		return nil, errClientNotFound
+	}
+	if rd.byAddr != nil {
+		if i, found := rd.byAddr[key]; found {
+			return &rd.clients[i], nil
+		}
+	}
+	if rd.scanSpans == 0 {
+		return nil, errClientNotFound
+	}
+	hi, lo := ipHiLo(&key.addr)
+	for r := range rd.spans {
+		span := &rd.spans[r]
+		if span.vlan != vlan || span.count == 0 || span.list {
+			continue
+		}
+		// 128 bit subtraction, below the start or past 64 bits is out of range
+		diff := lo - span.lo
+		borrow := uint64(0)
+		if lo < span.lo {
+			borrow = 1
+		}
+		if hi-span.hi != borrow {
+			continue
+		}
+		if diff%span.step == 0 && diff/span.step < uint64(span.count) {
+			return &rd.clients[span.first+int(diff/span.step)], nil
+		}
+	}
+	return nil, errClientNotFound
+}
+
+// cidrRange makes r.CIDR into the first and last host address of the block, step 1
+// IPv4 blocks up to /30 leave out the network and broadcast addresses
+func cidrRange(r ClientRange) (ClientRange, error) {
+	_, block, err := net.ParseCIDR(r.CIDR)
+	if err != nil {
+		return r, fmt.Errorf("client range CIDR: %w", err)
+	}
+	ones, bits := block.Mask.Size()
+	if bits-ones > maxCIDRHostBits {
+		return r, fmt.Errorf("client range CIDR %s has more than 2^%d addresses", r.CIDR, maxCIDRHostBits)
+	}
+	first := block.IP
+	last := make(net.IP, len(first))
+	for i := range first {
+		last[i] = first[i] | ^block.Mask[i]
+	}
+	if bits == 8*net.IPv4len && bits-ones >= 2 {
+		// NextIP wants the 16 byte form
+		first = NextIP(first.To16(), 1)
+		last = prevIP(last)
+	}
+	r.ClientIPStart, r.ClientIPEnd, r.ClientIPStep = first.String(), last.String(), 1
+	return r, nil
+}
+
+// prevIP is the address before ip
+func prevIP(ip net.IP) net.IP {
+	prev := make(net.IP, len(ip))
+	copy(prev, ip)
+	for i := len(prev) - 1; i >= 0; i-- {
+		prev[i]--
+		if prev[i] != 0xff {
+			break
+		}
+	}
+	return prev
+}
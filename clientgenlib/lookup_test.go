@@ -0,0 +1,187 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// lookupTestConfig makes the clients of ranges and indexes them like setupClientGen
+func lookupTestConfig(t testing.TB, ranges []ClientRange) *ClientGenConfig {
+	cfg := &ClientGenConfig{ClientRanges: ranges, RunData: &ClientGenData{}}
+	r, err := clientRanges(cfg)
+	require.NoError(t, err)
+	cfg.RunData.clients, cfg.RunData.spans = makeClients(cfg, r, nil)
+	indexClients(cfg)
+	return cfg
+}
+
+func requireAllFound(t *testing.T, cfg *ClientGenConfig) {
+	for i := range cfg.RunData.clients {
+		cl := &cfg.RunData.clients[i]
+		found, err := getClientFromIP(cfg, cl.vlan, cl.ClientIP)
+		require.NoError(t, err, "client %v vlan %d", cl.ClientIP, cl.vlan)
+		require.Same(t, cl, found)
+		// packets carry 4 byte IPv4 addresses
+		if ip4 := cl.ClientIP.To4(); ip4 != nil {
+			found, err = getClientFromIP(cfg, cl.vlan, ip4)
+			require.NoError(t, err)
+			require.Same(t, cl, found)
+		}
+	}
+}
+
+func Test_getClientFromIP(t *testing.T) {
+	cfg := lookupTestConfig(t, []ClientRange{
+		{ClientIPStart: "10.0.1.1", ClientIPEnd: "10.0.1.9", ClientIPStep: 2},
+		// crosses the low 64 bits
+		{ClientIPStart: "2001:db8::ffff:ffff:ffff:fffe", ClientIPEnd: "2001:db8:0:1::2", ClientIPStep: 1},
+		{CIDR: "10.9.0.0/30", VLAN: 5},
+		{Addresses: []string{"10.7.7.7", "192.168.3.1", "2001:db8::77"}, VLAN: 7},
+	})
+	require.Len(t, cfg.RunData.clients, 5+5+2+3)
+	require.Equal(t, 3, cfg.RunData.scanSpans)
+	require.Len(t, cfg.RunData.byAddr, 3)
+	requireAllFound(t, cfg)
+
+	for _, miss := range []struct {
+		vlan uint16
+		ip   string
+	}{
+		{0, "10.0.1.2"},  // between steps
+		{0, "10.0.1.11"}, // past the end
+		{0, "10.0.0.255"},
+		{5, "10.0.1.1"}, // other VLAN
+		{5, "10.9.0.0"}, // network address of the CIDR
+		{5, "10.9.0.3"},
+		{0, "2001:db8::ffff:ffff:ffff:fffd"},
+		{0, "2001:db8:1::"}, // 64 bits past the start
+		{0, "10.7.7.7"},
+		{7, "10.7.7.8"},
+	} {
+		_, err := getClientFromIP(cfg, miss.vlan, net.ParseIP(miss.ip))
+		require.Error(t, err, "%s vlan %d", miss.ip, miss.vlan)
+	}
+	_, err := getClientFromIP(cfg, 0, nil)
+	require.Error(t, err)
+}
+
+func Test_getClientFromIPManySpans(t *testing.T) {
+	var ranges []ClientRange
+	for i := 0; i < 2*maxScanSpans; i++ {
+		ranges = append(ranges, ClientRange{CIDR: fmt.Sprintf("10.%d.0.0/29", i)})
+	}
+	cfg := lookupTestConfig(t, ranges)
+	require.Zero(t, cfg.RunData.scanSpans)
+	require.Len(t, cfg.RunData.byAddr, 2*maxScanSpans*6)
+	requireAllFound(t, cfg)
+	_, err := getClientFromIP(cfg, 0, net.ParseIP("10.0.0.7"))
+	require.Error(t, err)
+}
+
+func Test_clientRangesCIDR(t *testing.T) {
+	for _, tc := range []struct {
+		cidr  string
+		start string
+		end   string
+	}{
+		{"10.1.2.0/24", "10.1.2.1", "10.1.2.254"},
+		{"10.1.2.4/31", "10.1.2.4", "10.1.2.5"},
+		{"10.1.2.3/32", "10.1.2.3", "10.1.2.3"},
+		{"2001:db8::/126", "2001:db8::", "2001:db8::3"},
+	} {
+		r, err := cidrRange(ClientRange{CIDR: tc.cidr})
+		require.NoError(t, err)
+		require.Equal(t, tc.start, r.ClientIPStart, tc.cidr)
+		require.Equal(t, tc.end, r.ClientIPEnd, tc.cidr)
+		require.Equal(t, uint(1), r.ClientIPStep)
+	}
+	_, err := cidrRange(ClientRange{CIDR: "10.0.0.0/7"})
+	require.Error(t, err)
+	_, err = cidrRange(ClientRange{CIDR: "10.0.0.0"})
+	require.Error(t, err)
+
+	cfg := &ClientGenConfig{ClientRanges: []ClientRange{{CIDR: "10.1.2.0/24", Addresses: []string{"10.0.0.1"}}}}
+	_, err = clientRanges(cfg)
+	require.Error(t, err)
+	cfg.ClientRanges = []ClientRange{{Addresses: []string{"10.0.0.300"}}}
+	_, err = clientRanges(cfg)
+	require.Error(t, err)
+}
+
+// lookupBench is a large client set with the addresses as they come out of the packet decoder
+type lookupBench struct {
+	name string
+	cfg  *ClientGenConfig
+	ips  []net.IP
+}
+
+func lookupBenchConfigs(t testing.TB) []lookupBench {
+	many := make([]ClientRange, 2*maxScanSpans)
+	for i := range many {
+		many[i] = ClientRange{CIDR: fmt.Sprintf("10.%d.0.0/20", i)}
+	}
+	var out []lookupBench
+	for _, bc := range []struct {
+		name   string
+		ranges []ClientRange
+	}{
+		{"ipv4-range", []ClientRange{{ClientIPStart: "10.0.0.1", ClientIPEnd: "10.1.0.0", ClientIPStep: 1}}},
+		{"ipv6-range", []ClientRange{{ClientIPStart: "2001:db8::1", ClientIPEnd: "2001:db8::1:0", ClientIPStep: 1}}},
+		{"ipv4-cidrs", many},
+	} {
+		cfg := lookupTestConfig(t, bc.ranges)
+		ips := make([]net.IP, len(cfg.RunData.clients))
+		for i := range ips {
+			ips[i] = cfg.RunData.clients[i].ClientIP
+			if ip4 := ips[i].To4(); ip4 != nil {
+				ips[i] = ip4
+			}
+		}
+		out = append(out, lookupBench{bc.name, cfg, ips})
+	}
+	return out
+}
+
+func Test_getClientFromIPNoAllocs(t *testing.T) {
+	for _, bc := range lookupBenchConfigs(t) {
+		i := 0
+		allocs := testing.AllocsPerRun(1000, func() {
+			_, err := getClientFromIP(bc.cfg, 0, bc.ips[i%len(bc.ips)])
+			require.NoError(t, err)
+			i += 997
+		})
+		require.Zero(t, allocs, bc.name)
+	}
+}
+
+func Benchmark_getClientFromIP(b *testing.B) {
+	for _, bc := range lookupBenchConfigs(b) {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := getClientFromIP(bc.cfg, 0, bc.ips[i%len(bc.ips)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,637 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	log "github.com/sirupsen/logrus"
+)
+
+// how often the simulated GM checks its grants for messages to send
+const loopbackTick = 5 * time.Millisecond
+
+// sizes of the messages the simulated GM builds
+const (
+	loopbackGrantTLVSize  = ptp.TlvHeadSize + 8
+	loopbackCancelTLVSize = ptp.TlvHeadSize + 2
+	loopbackAnnounceSize  = ptp.HeaderSize + 30
+	loopbackSyncSize      = ptp.HeaderSize + 10
+	loopbackDelayRespSize = ptp.HeaderSize + 20
+	loopbackPDelaySize    = ptp.HeaderSize + 20
+)
+
+// MAC of the simulated GM when ServerMAC is left to be resolved
+var loopbackDefaultMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 0xfe}
+
+// loopbackFrame is a frame from the simulated GM and when the client may see it
+type loopbackFrame struct {
+	data []byte
+	at   time.Time
+}
+
+// loopbackGrantKey is one unicast grant, a message type for a client address in a VLAN
+type loopbackGrantKey struct {
+	vlan    uint16
+	addr    string // client IP, or MAC for the "l2" Transport
+	msgType ptp.MessageType
+}
+
+// loopbackGrant is the state of a granted unicast transmission
+type loopbackGrant struct {
+	mac      net.HardwareAddr
+	ip       net.IP // nil for the "l2" Transport
+	vlan     uint16
+	pcp      uint8
+	clockID  ptp.ClockIdentity
+	interval time.Duration
+	next     time.Time
+	expires  time.Time
+	cancelAt time.Time // zero if the grant is never cancelled
+	seq      uint16
+}
+
+// loopbackGM is a simulated grandmaster behind the "loopback" IOBackend
+// it answers unicast negotiation, sends Announce, Sync/FollowUp at the granted rates and
+// answers DelayReq and PDelayReq, all in memory so the whole client pipeline runs without a NIC
+type loopbackGM struct {
+	cfg     *ClientGenConfig
+	mac     net.HardwareAddr
+	ip      net.IP
+	clockID ptp.ClockIdentity
+	latency time.Duration
+	cancel  time.Duration
+	deny    map[ptp.MessageType]bool
+	auth    *authConfig // the clients' security associations, nil without AuthKeyFile
+
+	rx chan loopbackFrame
+
+	mu     sync.Mutex
+	grants map[loopbackGrantKey]*loopbackGrant
+
+	// what the GM did, for tests and debugging
+	grantsSent     uint64
+	grantsDenied   uint64
+	cancelsSent    uint64
+	announceSent   uint64
+	syncSent       uint64
+	delayRespSent  uint64
+	pdelayRespSent uint64
+	neighborSent   uint64
+	authRejected   uint64
+	dropped        uint64
+}
+
+// startLoopbackGM creates the simulated GM when the "loopback" IOBackend is used
+func startLoopbackGM(cfg *ClientGenConfig) error {
+	if cfg.IOBackend != IOBackendLoopback {
+		return nil
+	}
+	gm, err := newLoopbackGM(cfg)
+	if err != nil {
+		log.Errorf("Failed to start loopback GM: %v", err)
+		return err
+	}
+	cfg.RunData.loopback = gm
+	cfg.Eg.Go(func() error {
+		gm.run()
+		return nil
+	})
+	return nil
+}
+
+func newLoopbackGM(cfg *ClientGenConfig) (*loopbackGM, error) {
+	mac := cfg.parsedServerMac
+	if mac == nil {
+		mac = loopbackDefaultMAC
+	}
+	clockID, err := ptp.NewClockIdentity(mac)
+	if err != nil {
+		return nil, fmt.Errorf("loopback GM clock identity: %w", err)
+	}
+	gm := &loopbackGM{
+		cfg:     cfg,
+		mac:     mac,
+		ip:      net.ParseIP(cfg.ServerAddress),
+		clockID: clockID,
+		latency: time.Duration(cfg.LoopbackLatencySec * float64(time.Second)),
+		cancel:  time.Duration(cfg.LoopbackCancelAfterSec * float64(time.Second)),
+		deny:    map[ptp.MessageType]bool{},
+		auth:    cfg.auth,
+		rx:      make(chan loopbackFrame, rawInQueueSize),
+		grants:  map[loopbackGrantKey]*loopbackGrant{},
+	}
+	if gm.ip == nil && cfg.Transport != TransportL2 {
+		return nil, fmt.Errorf("loopback GM bad ServerAddress %q", cfg.ServerAddress)
+	}
+	for _, name := range cfg.LoopbackDenyGrants {
+		found := false
+		for msgType, s := range ptp.MessageTypeToString {
+			if s == name {
+				gm.deny[msgType] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("loopback GM unknown message type %q in LoopbackDenyGrants", name)
+		}
+	}
+	return gm, nil
+}
+
+// run sends the periodic messages of the active grants until the context is done
+func (gm *loopbackGM) run() {
+	ticker := time.NewTicker(loopbackTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			gm.tick(time.Now())
+		case <-(*gm.cfg.Ctx).Done():
+			return
+		}
+	}
+}
+
+func (gm *loopbackGM) tick(now time.Time) {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	for key, g := range gm.grants {
+		if !g.cancelAt.IsZero() && now.After(g.cancelAt) {
+			gm.sendCancel(g, key.msgType)
+			delete(gm.grants, key)
+			continue
+		}
+		if now.After(g.expires) {
+			delete(gm.grants, key)
+			continue
+		}
+		// DelayResp and PDelayResp only answer requests
+		if key.msgType == ptp.MessageDelayResp || key.msgType == ptp.MessagePDelayResp || now.Before(g.next) {
+			continue
+		}
+		g.next = g.next.Add(g.interval)
+		if key.msgType == ptp.MessageAnnounce {
+			gm.sendAnnounce(g, now)
+		} else if key.msgType == ptp.MessageSync {
+			gm.sendSync(g, now)
+		}
+		g.seq++
+	}
+}
+
+// receive handles one frame a client sent to the GM
+func (gm *loopbackGM) receive(data []byte) {
+	pkt := gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default)
+	eth, _ := pkt.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	if eth == nil {
+		return
+	}
+	// answer in the VLAN and with the priority the client used
+	var vlan uint16
+	var pcp uint8
+	etherType, etherPayload := eth.EthernetType, eth.Payload
+	if tag, ok := pkt.Layer(layers.LayerTypeDot1Q).(*layers.Dot1Q); ok {
+		vlan, pcp = tag.VLANIdentifier, tag.Priority
+		etherType, etherPayload = tag.Type, tag.Payload
+	}
+	var srcIP net.IP
+	var payload []byte
+	if udp, ok := pkt.Layer(layers.LayerTypeUDP).(*layers.UDP); ok {
+		if ip4, ok := pkt.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok {
+			srcIP = ip4.SrcIP
+		} else if ip6, ok := pkt.Layer(layers.LayerTypeIPv6).(*layers.IPv6); ok {
+			srcIP = ip6.SrcIP
+		} else {
+			return
+		}
+		payload = udp.LayerPayload()
+	} else if etherType == ethernetTypePTP {
+		// PTP over Ethernet, the client is only known by its MAC
+		payload = ptpMessage(etherPayload)
+	} else {
+		// the clients resolving the GM's MAC, the rest of ARP and ND is for the clients
+		gm.handleNeighbor(pkt, eth.SrcMAC, vlan, pcp)
+		return
+	}
+	msgType, err := ptp.ProbeMsgType(payload)
+	if err != nil {
+		return
+	}
+	if gm.auth != nil {
+		// a GM requiring authentication drops what doesn't check out
+		if err := ptp.VerifyAuthenticationTLV(payload, gm.auth.lookup); err != nil {
+			atomic.AddUint64(&gm.authRejected, 1)
+			if gm.cfg.DebugPrint {
+				log.Debugf("loopback GM rejected %s: %v", msgType, err)
+			}
+			return
+		}
+	}
+	switch msgType {
+	case ptp.MessageSignaling:
+		signaling := &ptp.Signaling{}
+		if err := ptp.FromBytes(payload, signaling); err != nil {
+			log.Errorf("loopback GM reading signaling msg: %v", err)
+			return
+		}
+		for _, tlv := range signaling.TLVs {
+			if req, ok := tlv.(*ptp.RequestUnicastTransmissionTLV); ok {
+				gm.handleRequest(eth.SrcMAC, vlan, pcp, srcIP, signaling, req)
+			}
+		}
+	case ptp.MessageDelayReq:
+		req := &ptp.SyncDelayReq{}
+		if err := ptp.FromBytes(payload, req); err != nil {
+			log.Errorf("loopback GM reading delay_req msg: %v", err)
+			return
+		}
+		gm.handleDelayReq(vlan, eth.SrcMAC, srcIP, req)
+	case ptp.MessagePDelayReq:
+		req := &ptp.PDelayReq{}
+		if err := ptp.FromBytes(payload, req); err != nil {
+			log.Errorf("loopback GM reading pdelay_req msg: %v", err)
+			return
+		}
+		gm.handlePDelayReq(vlan, eth.SrcMAC, srcIP, req)
+	}
+}
+
+// loopbackAddr is how the grants know a client, its IP or its MAC without one
+func loopbackAddr(mac net.HardwareAddr, ip net.IP) string {
+	if ip == nil {
+		return mac.String()
+	}
+	return ip.String()
+}
+
+func (gm *loopbackGM) handleRequest(mac net.HardwareAddr, vlan uint16, pcp uint8, ip net.IP, signaling *ptp.Signaling, req *ptp.RequestUnicastTransmissionTLV) {
+	msgType := req.MsgTypeAndReserved.MsgType()
+	now := time.Now()
+	duration := req.DurationField
+	if gm.deny[msgType] {
+		duration = 0
+		atomic.AddUint64(&gm.grantsDenied, 1)
+	} else {
+		g := &loopbackGrant{
+			mac:      append(net.HardwareAddr(nil), mac...),
+			ip:       append(net.IP(nil), ip...),
+			vlan:     vlan,
+			pcp:      pcp,
+			clockID:  signaling.SourcePortIdentity.ClockIdentity,
+			interval: req.LogInterMessagePeriod.Duration(),
+			next:     now.Add(gm.latency),
+			expires:  now.Add(time.Duration(duration) * time.Second),
+		}
+		if gm.cancel > 0 {
+			g.cancelAt = now.Add(gm.cancel)
+		}
+		gm.mu.Lock()
+		gm.grants[loopbackGrantKey{vlan: vlan, addr: loopbackAddr(mac, ip), msgType: msgType}] = g
+		gm.mu.Unlock()
+		atomic.AddUint64(&gm.grantsSent, 1)
+	}
+	grant := &ptp.Signaling{
+		Header:             gm.header(ptp.MessageSignaling, ptp.HeaderSize+ptp.PortIdentitySize+loopbackGrantTLVSize, signaling.SequenceID),
+		TargetPortIdentity: signaling.SourcePortIdentity,
+		TLVs: []ptp.TLV{
+			&ptp.GrantUnicastTransmissionTLV{
+				TLVHead: ptp.TLVHead{
+					TLVType:     ptp.TLVGrantUnicastTransmission,
+					LengthField: loopbackGrantTLVSize - ptp.TlvHeadSize,
+				},
+				MsgTypeAndReserved:    req.MsgTypeAndReserved,
+				LogInterMessagePeriod: req.LogInterMessagePeriod,
+				DurationField:         duration,
+			},
+		},
+	}
+	gm.send(mac, vlan, pcp, ip, ptp.PortGeneral, grant)
+}
+
+func (gm *loopbackGM) handleDelayReq(vlan uint16, mac net.HardwareAddr, ip net.IP, req *ptp.SyncDelayReq) {
+	gm.mu.Lock()
+	g, ok := gm.grants[loopbackGrantKey{vlan: vlan, addr: loopbackAddr(mac, ip), msgType: ptp.MessageDelayResp}]
+	gm.mu.Unlock()
+	if !ok {
+		// no DelayResp grant, a real GM wouldn't answer either
+		return
+	}
+	resp := &ptp.DelayResp{
+		Header: gm.header(ptp.MessageDelayResp, loopbackDelayRespSize, req.SequenceID),
+		DelayRespBody: ptp.DelayRespBody{
+			ReceiveTimestamp:       ptp.NewTimestamp(time.Now()),
+			RequestingPortIdentity: req.SourcePortIdentity,
+		},
+	}
+	gm.send(g.mac, g.vlan, g.pcp, g.ip, ptp.PortGeneral, resp)
+	atomic.AddUint64(&gm.delayRespSent, 1)
+}
+
+// handlePDelayReq answers two step, a PDelayResp with the receive time and a PDelayRespFollowUp with the send time
+func (gm *loopbackGM) handlePDelayReq(vlan uint16, mac net.HardwareAddr, ip net.IP, req *ptp.PDelayReq) {
+	received := time.Now()
+	gm.mu.Lock()
+	g, ok := gm.grants[loopbackGrantKey{vlan: vlan, addr: loopbackAddr(mac, ip), msgType: ptp.MessagePDelayResp}]
+	gm.mu.Unlock()
+	if !ok {
+		return
+	}
+	resp := &ptp.PDelayResp{
+		Header: gm.header(ptp.MessagePDelayResp, loopbackPDelaySize, req.SequenceID),
+		PDelayRespBody: ptp.PDelayRespBody{
+			RequestReceiptTimestamp: ptp.NewTimestamp(received),
+			RequestingPortIdentity:  req.SourcePortIdentity,
+		},
+	}
+	resp.FlagField |= ptp.FlagTwoStep
+	sent := time.Now()
+	gm.send(g.mac, g.vlan, g.pcp, g.ip, ptp.PortEvent, resp)
+	followUp := &ptp.PDelayRespFollowUp{
+		Header: gm.header(ptp.MessagePDelayRespFollowUp, loopbackPDelaySize, req.SequenceID),
+		PDelayRespFollowUpBody: ptp.PDelayRespFollowUpBody{
+			ResponseOriginTimestamp: ptp.NewTimestamp(sent),
+			RequestingPortIdentity:  req.SourcePortIdentity,
+		},
+	}
+	gm.send(g.mac, g.vlan, g.pcp, g.ip, ptp.PortGeneral, followUp)
+	atomic.AddUint64(&gm.pdelayRespSent, 1)
+}
+
+func (gm *loopbackGM) sendAnnounce(g *loopbackGrant, now time.Time) {
+	announce := &ptp.Announce{
+		Header: gm.header(ptp.MessageAnnounce, loopbackAnnounceSize, g.seq),
+		AnnounceBody: ptp.AnnounceBody{
+			OriginTimestamp:      ptp.NewTimestamp(now),
+			GrandmasterPriority1: 128,
+			GrandmasterClockQuality: ptp.ClockQuality{
+				ClockClass:    6,
+				ClockAccuracy: 0x21,
+			},
+			GrandmasterPriority2: 128,
+			GrandmasterIdentity:  gm.clockID,
+			TimeSource:           ptp.TimeSourceGNSS,
+		},
+	}
+	gm.send(g.mac, g.vlan, g.pcp, g.ip, ptp.PortGeneral, announce)
+	atomic.AddUint64(&gm.announceSent, 1)
+}
+
+// sendSync is a two step Sync followed by its FollowUp
+func (gm *loopbackGM) sendSync(g *loopbackGrant, now time.Time) {
+	syncMsg := &ptp.SyncDelayReq{
+		Header: gm.header(ptp.MessageSync, loopbackSyncSize, g.seq),
+	}
+	syncMsg.FlagField |= ptp.FlagTwoStep
+	gm.send(g.mac, g.vlan, g.pcp, g.ip, ptp.PortEvent, syncMsg)
+	followUp := &ptp.FollowUp{
+		Header: gm.header(ptp.MessageFollowUp, loopbackSyncSize, g.seq),
+		FollowUpBody: ptp.FollowUpBody{
+			PreciseOriginTimestamp: ptp.NewTimestamp(now),
+		},
+	}
+	gm.send(g.mac, g.vlan, g.pcp, g.ip, ptp.PortGeneral, followUp)
+	atomic.AddUint64(&gm.syncSent, 1)
+}
+
+func (gm *loopbackGM) sendCancel(g *loopbackGrant, msgType ptp.MessageType) {
+	cancel := &ptp.Signaling{
+		Header:             gm.header(ptp.MessageSignaling, ptp.HeaderSize+ptp.PortIdentitySize+loopbackCancelTLVSize, g.seq),
+		TargetPortIdentity: ptp.PortIdentity{PortNumber: 1, ClockIdentity: g.clockID},
+		TLVs: []ptp.TLV{
+			&ptp.CancelUnicastTransmissionTLV{
+				TLVHead: ptp.TLVHead{
+					TLVType:     ptp.TLVCancelUnicastTransmission,
+					LengthField: loopbackCancelTLVSize - ptp.TlvHeadSize,
+				},
+				MsgTypeAndFlags: ptp.NewUnicastMsgTypeAndFlags(msgType, 0),
+			},
+		},
+	}
+	gm.send(g.mac, g.vlan, g.pcp, g.ip, ptp.PortGeneral, cancel)
+	atomic.AddUint64(&gm.cancelsSent, 1)
+}
+
+func (gm *loopbackGM) header(msgType ptp.MessageType, length int, seq uint16) ptp.Header {
+	return ptp.Header{
+		SdoIDAndMsgType: ptp.NewSdoIDAndMsgType(msgType, 0),
+		Version:         ptp.Version,
+		SequenceID:      seq,
+		MessageLength:   uint16(length),
+		FlagField:       ptp.FlagUnicast,
+		SourcePortIdentity: ptp.PortIdentity{
+			PortNumber:    1,
+			ClockIdentity: gm.clockID,
+		},
+		LogMessageInterval: 0x7f,
+	}
+}
+
+// send builds the frame for a message to a client and queues it for the RX side
+func (gm *loopbackGM) send(mac net.HardwareAddr, vlan uint16, pcp uint8, ip net.IP, port uint16, msg ptp.Packet) {
+	b, err := ptp.Bytes(msg)
+	if err != nil {
+		log.Errorf("loopback GM ptp.Bytes error %v", err)
+		return
+	}
+	if gm.auth != nil {
+		// signed with the clients' key, the GM shares their security association
+		if b, err = ptp.AppendAuthenticationTLV(b, gm.auth.spp, gm.auth.sign); err != nil {
+			log.Errorf("loopback GM AUTHENTICATION TLV error %v", err)
+			return
+		}
+	}
+	eth := layers.Ethernet{
+		SrcMAC:       gm.mac,
+		DstMAC:       mac,
+		EthernetType: ethernetTypePTP,
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if ip == nil {
+		// "l2" Transport, the message goes straight into the frame
+		err = serializeFrame(buf, gm.cfg.RunData.commonSerializeOp, &eth, vlan, pcp, gopacket.Payload(b))
+	} else {
+		err = gm.serializeUDP(buf, &eth, vlan, pcp, ip, port, b)
+	}
+	if err != nil {
+		log.Errorf("loopback GM SerializeLayers failed %v", err)
+		return
+	}
+	gm.queue(buf.Bytes())
+}
+
+// queue hands a frame to the RX side once the latency passed
+func (gm *loopbackGM) queue(data []byte) {
+	select {
+	case gm.rx <- loopbackFrame{data: data, at: time.Now().Add(gm.latency)}:
+	default:
+		atomic.AddUint64(&gm.dropped, 1)
+	}
+}
+
+// handleNeighbor answers an ARP request or a Neighbor Solicitation for the GM's IP
+func (gm *loopbackGM) handleNeighbor(pkt gopacket.Packet, mac net.HardwareAddr, vlan uint16, pcp uint8) {
+	if gm.ip == nil {
+		return
+	}
+	eth := layers.Ethernet{
+		SrcMAC: gm.mac,
+		DstMAC: mac,
+	}
+	buf := gopacket.NewSerializeBuffer()
+	var err error
+	if arp, ok := pkt.Layer(layers.LayerTypeARP).(*layers.ARP); ok {
+		if arp.Operation != layers.ARPRequest || !gm.ip.Equal(net.IP(arp.DstProtAddress)) {
+			return
+		}
+		eth.EthernetType = layers.EthernetTypeARP
+		reply := layers.ARP{
+			AddrType:          layers.LinkTypeEthernet,
+			Protocol:          layers.EthernetTypeIPv4,
+			HwAddressSize:     6,
+			ProtAddressSize:   4,
+			Operation:         layers.ARPReply,
+			SourceHwAddress:   gm.mac,
+			SourceProtAddress: gm.ip.To4(),
+			DstHwAddress:      arp.SourceHwAddress,
+			DstProtAddress:    arp.SourceProtAddress,
+		}
+		err = serializeFrame(buf, gm.cfg.RunData.commonSerializeOp, &eth, vlan, pcp, &reply)
+	} else if ns, ok := pkt.Layer(layers.LayerTypeICMPv6NeighborSolicitation).(*layers.ICMPv6NeighborSolicitation); ok {
+		ip6, _ := pkt.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+		if ip6 == nil || !gm.ip.Equal(ns.TargetAddress) {
+			return
+		}
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip := layers.IPv6{
+			SrcIP:      gm.ip,
+			DstIP:      ip6.SrcIP,
+			Version:    6,
+			HopLimit:   255,
+			NextHeader: layers.IPProtocolICMPv6,
+		}
+		icmp := layers.ICMPv6{
+			TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborAdvertisement, 0),
+		}
+		na := layers.ICMPv6NeighborAdvertisement{
+			TargetAddress: gm.ip,
+			Flags:         0x60, // solicited, override
+			Options: []layers.ICMPv6Option{
+				{Type: layers.ICMPv6OptTargetAddress, Data: gm.mac},
+			},
+		}
+		if err = icmp.SetNetworkLayerForChecksum(&ip); err != nil {
+			log.Errorf("loopback GM SetNetworkLayerForChecksum failed %v", err)
+			return
+		}
+		err = serializeFrame(buf, gm.cfg.RunData.commonSerializeOp, &eth, vlan, pcp, &ip, &icmp, &na)
+	} else {
+		return
+	}
+	if err != nil {
+		log.Errorf("loopback GM SerializeLayers failed %v", err)
+		return
+	}
+	atomic.AddUint64(&gm.neighborSent, 1)
+	gm.queue(buf.Bytes())
+}
+
+func (gm *loopbackGM) serializeUDP(buf gopacket.SerializeBuffer, eth *layers.Ethernet, vlan uint16, pcp uint8, ip net.IP, port uint16, b []byte) error {
+	udp := layers.UDP{
+		SrcPort: layers.UDPPort(port),
+		DstPort: layers.UDPPort(port),
+	}
+	var network gopacket.SerializableLayer
+	var err error
+	if ip.To4() == nil {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip6 := &layers.IPv6{
+			SrcIP:      gm.ip,
+			DstIP:      ip,
+			Version:    6,
+			HopLimit:   255,
+			NextHeader: layers.IPProtocolUDP,
+		}
+		err = udp.SetNetworkLayerForChecksum(ip6)
+		network = ip6
+	} else {
+		eth.EthernetType = layers.EthernetTypeIPv4
+		ip4 := &layers.IPv4{
+			SrcIP:    gm.ip,
+			DstIP:    ip,
+			Version:  4,
+			TTL:      255,
+			Protocol: layers.IPProtocolUDP,
+		}
+		err = udp.SetNetworkLayerForChecksum(ip4)
+		network = ip4
+	}
+	if err != nil {
+		return fmt.Errorf("SetNetworkLayerForChecksum: %w", err)
+	}
+	return serializeFrame(buf, gm.cfg.RunData.commonSerializeOp, eth, vlan, pcp, network, &udp, gopacket.Payload(b))
+}
+
+// loopbackIO connects the RX / TX workers to the simulated GM
+type loopbackIO struct {
+	gm *loopbackGM
+}
+
+func openLoopback(cfg *ClientGenConfig, dir PacketIODirection, wkr int) (PacketIO, error) {
+	if cfg.RunData.loopback == nil {
+		return nil, fmt.Errorf("loopback GM not started")
+	}
+	return &loopbackIO{gm: cfg.RunData.loopback}, nil
+}
+
+// ReadPacket returns the next frame from the GM once its latency has passed, the RX timestamp
+// is the wall clock like a kernel timestamp would be
+func (l *loopbackIO) ReadPacket() ([]byte, time.Time, bool, error) {
+	select {
+	case f := <-l.gm.rx:
+		if wait := time.Until(f.at); wait > 0 {
+			time.Sleep(wait)
+		}
+		return f.data, time.Now(), false, nil
+	case <-(*l.gm.cfg.Ctx).Done():
+		return nil, time.Time{}, false, (*l.gm.cfg.Ctx).Err()
+	}
+}
+
+// WritePacket hands a client frame to the GM
+func (l *loopbackIO) WritePacket(data []byte) error {
+	l.gm.receive(data)
+	return nil
+}
+
+// Stats frames the GM couldn't queue are only in gm.dropped, every RX worker would count them again here
+func (l *loopbackIO) Stats() (Stats, error) {
+	return Stats{}, nil
+}
+
+func (l *loopbackIO) Close() error {
+	return nil
+}
+
+func (l *loopbackIO) noTXTSSocket() {}
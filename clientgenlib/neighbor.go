@@ -0,0 +1,180 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	log "github.com/sirupsen/logrus"
+)
+
+// all nodes multicast address and its MAC, where unsolicited NAs go
+var (
+	allNodesIP  = net.ParseIP("ff02::1")
+	allNodesMAC = net.HardwareAddr{0x33, 0x33, 0, 0, 0, 0x01}
+)
+
+// announceClient sends a gratuitous ARP or an unsolicited NA for cl when AnnounceClients is set
+func announceClient(cfg *ClientGenConfig, cl *SingleClientGen) {
+	// the kernel announces the addresses of its sockets itself
+	if !cfg.AnnounceClients || cfg.IOBackend == IOBackendKernel {
+		return
+	}
+	out := cfg.RunData.outPacketPool.Get().(*outPacket)
+	out.cl = nil
+	out.getTS = false
+	out.pktType = pktIgnore
+	var err error
+	var counter *uint64
+	if cl.ClientIP.To4() != nil {
+		err = serializeGratuitousARP(cfg, cl, out)
+		counter = &cfg.Counters.TotalGratuitousARPSent
+	} else {
+		err = serializeUnsolicitedNA(cfg, cl, out)
+		counter = &cfg.Counters.TotalUnsolicitedNASent
+	}
+	if err != nil {
+		log.Errorf("Announce client %v serialize err %v", cl.ClientIP, err)
+		cfg.RunData.outPacketPool.Put(out)
+		return
+	}
+	atomic.AddUint64(counter, 1)
+	sendRawOutput(cfg, out)
+}
+
+// serializeGratuitousARP builds an ARP announcement, a broadcast request for the client's own IP (RFC 5227)
+func serializeGratuitousARP(cfg *ClientGenConfig, cl *SingleClientGen, out *outPacket) error {
+	eth := layers.Ethernet{
+		SrcMAC:       cl.mac,
+		DstMAC:       layers.EthernetBroadcast,
+		EthernetType: layers.EthernetTypeARP,
+	}
+	arp := layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPRequest,
+		SourceHwAddress:   cl.mac,
+		SourceProtAddress: cl.ClientIP.To4(),
+		DstHwAddress:      make([]byte, 6),
+		DstProtAddress:    cl.ClientIP.To4(),
+	}
+	return serializeFrame(*out.data, cfg.RunData.commonSerializeOp, &eth, cl.vlan, cl.pcp, &arp)
+}
+
+// serializeUnsolicitedNA builds a Neighbor Advertisement of the client's IP to all nodes (RFC 4861 7.2.6)
+func serializeUnsolicitedNA(cfg *ClientGenConfig, cl *SingleClientGen, out *outPacket) error {
+	eth := layers.Ethernet{
+		SrcMAC:       cl.mac,
+		DstMAC:       allNodesMAC,
+		EthernetType: layers.EthernetTypeIPv6,
+	}
+	ip := layers.IPv6{
+		SrcIP:      cl.ClientIP,
+		DstIP:      allNodesIP,
+		Version:    6,
+		HopLimit:   255,
+		NextHeader: layers.IPProtocolICMPv6,
+	}
+	icmp := layers.ICMPv6{
+		TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborAdvertisement, 0),
+	}
+	na := layers.ICMPv6NeighborAdvertisement{
+		TargetAddress: cl.ClientIP,
+		Flags:         0x20, // override, not solicited
+		Options: []layers.ICMPv6Option{
+			{Type: layers.ICMPv6OptTargetAddress, Data: cl.mac},
+		},
+	}
+	if err := icmp.SetNetworkLayerForChecksum(&ip); err != nil {
+		return err
+	}
+	return serializeFrame(*out.data, cfg.RunData.commonSerializeOp, &eth, cl.vlan, cl.pcp, &ip, &icmp, &na)
+}
+
+// handleEchoIncoming answers an ICMP (v4) or ICMPv6 echo request to a client IP so the clients can be pinged
+func handleEchoIncoming(cfg *ClientGenConfig, in *PktDecoder, v4 bool) {
+	dstIP := in.ip6.DstIP
+	if v4 {
+		if in.icmp4.TypeCode.Type() != layers.ICMPv4TypeEchoRequest {
+			return
+		}
+		dstIP = in.ip4.DstIP
+	}
+	atomic.AddUint64(&cfg.Counters.TotalEchoRequestsRcvd, 1)
+	cl, err := getClientFromIP(cfg, in.vlan(), dstIP)
+	if err != nil {
+		return
+	}
+
+	eth := layers.Ethernet{
+		SrcMAC: cl.mac,
+		DstMAC: in.eth.SrcMAC,
+	}
+	out := cfg.RunData.outPacketPool.Get().(*outPacket)
+	out.cl = nil
+	out.getTS = false
+	out.pktType = pktIgnore
+	if v4 {
+		eth.EthernetType = layers.EthernetTypeIPv4
+		ip := layers.IPv4{
+			SrcIP:    cl.ClientIP,
+			DstIP:    in.ip4.SrcIP,
+			Version:  4,
+			TTL:      64,
+			Protocol: layers.IPProtocolICMPv4,
+		}
+		icmp := layers.ICMPv4{
+			TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoReply, 0),
+			Id:       in.icmp4.Id,
+			Seq:      in.icmp4.Seq,
+		}
+		err = serializeFrame(*out.data, cfg.RunData.commonSerializeOp, &eth, cl.vlan, cl.pcp,
+			&ip, &icmp, gopacket.Payload(in.icmp4.Payload))
+	} else {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip := layers.IPv6{
+			SrcIP:      cl.ClientIP,
+			DstIP:      in.ip6.SrcIP,
+			Version:    6,
+			HopLimit:   64,
+			NextHeader: layers.IPProtocolICMPv6,
+		}
+		icmp := layers.ICMPv6{
+			TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeEchoReply, 0),
+		}
+		echo := layers.ICMPv6Echo{
+			Identifier: in.icmpv6e.Identifier,
+			SeqNumber:  in.icmpv6e.SeqNumber,
+		}
+		if err = icmp.SetNetworkLayerForChecksum(&ip); err == nil {
+			err = serializeFrame(*out.data, cfg.RunData.commonSerializeOp, &eth, cl.vlan, cl.pcp,
+				&ip, &icmp, &echo, gopacket.Payload(in.icmpv6e.Payload))
+		}
+	}
+	if err != nil {
+		log.Errorf("Echo reply for %v serialize err %v", cl.ClientIP, err)
+		cfg.RunData.outPacketPool.Put(out)
+		return
+	}
+	atomic.AddUint64(&cfg.Counters.TotalEchoRepliesSent, 1)
+	sendRawOutput(cfg, out)
+}
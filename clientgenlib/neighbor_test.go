@@ -0,0 +1,183 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_serializeClientAnnounce(t *testing.T) {
+	cfg := &ClientGenConfig{RunData: &ClientGenData{}}
+	cfg.RunData.commonSerializeOp = gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	mac := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	buf := gopacket.NewSerializeBuffer()
+	out := &outPacket{data: &buf}
+
+	cl := &SingleClientGen{ClientIP: net.ParseIP("10.0.1.1"), mac: mac}
+	require.NoError(t, serializeGratuitousARP(cfg, cl, out))
+	pkt := gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	arp := pkt.Layer(layers.LayerTypeARP).(*layers.ARP)
+	require.Equal(t, uint16(layers.ARPRequest), arp.Operation)
+	require.Equal(t, []byte(mac), arp.SourceHwAddress)
+	require.Equal(t, arp.SourceProtAddress, arp.DstProtAddress)
+
+	cl = &SingleClientGen{ClientIP: net.ParseIP("2001:db8::1:1"), mac: mac, vlan: 100}
+	require.NoError(t, serializeUnsolicitedNA(cfg, cl, out))
+	pkt = gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	require.Equal(t, uint16(100), pkt.Layer(layers.LayerTypeDot1Q).(*layers.Dot1Q).VLANIdentifier)
+	require.True(t, allNodesIP.Equal(pkt.Layer(layers.LayerTypeIPv6).(*layers.IPv6).DstIP))
+	na := pkt.Layer(layers.LayerTypeICMPv6NeighborAdvertisement).(*layers.ICMPv6NeighborAdvertisement)
+	require.True(t, cl.ClientIP.Equal(na.TargetAddress))
+	require.False(t, na.Solicited())
+	require.True(t, na.Override())
+}
+
+// responderTestConfig has IPv4 clients 10.0.1.1-4 and IPv6 clients 2001:db8::1:1-4 to answer for
+func responderTestConfig(t *testing.T) *ClientGenConfig {
+	cfg := loopbackTestConfig("10.0.1.1", "10.0.1.4", "10.0.0.1")
+	cfg.ClientRanges = []ClientRange{{ClientIPStart: "2001:db8::1:1", ClientIPEnd: "2001:db8::1:4", ClientIPStep: 1}}
+	cfg.PerClientMAC = true
+	ranges, err := clientRanges(cfg)
+	require.NoError(t, err)
+	cfg.RunData = &ClientGenData{}
+	cfg.RunData.clients, cfg.RunData.spans = makeClients(cfg, ranges, nil)
+	indexClients(cfg)
+	require.NoError(t, setClientMACs(cfg))
+	cfg.RunData.commonSerializeOp = gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	cfg.RunData.outPacketPool.New = func() interface{} {
+		buf := gopacket.NewSerializeBuffer()
+		return &outPacket{data: &buf}
+	}
+	ch := make(chan *outPacket, 1)
+	cfg.RunData.rawOutput = []chan *outPacket{ch}
+	cfg.RunData.rawOutputStats = []*queueStats{newQueueStats(cfg, QueueRawOutput, 0, 1, func() int { return len(ch) })}
+	return cfg
+}
+
+// respond decodes frame like the packet parser, hands it to handle and returns what was sent, if anything
+func respond(cfg *ClientGenConfig, handle func(*ClientGenConfig, *PktDecoder), layerList ...gopacket.SerializableLayer) gopacket.Packet {
+	buf := gopacket.NewSerializeBuffer()
+	gopacket.SerializeLayers(buf, cfg.RunData.commonSerializeOp, layerList...)
+	in := &PktDecoder{}
+	in.parser = gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet,
+		&in.eth, &in.dot1q, &in.ip4, &in.ip6, &in.arp, &in.udp,
+		&in.icmpv6, &in.icmpv6ns, &in.icmpv6na, &in.icmpv6e, &in.icmp4)
+	in.parser.DecodeLayers(buf.Bytes(), &in.layers)
+	handle(cfg, in)
+	select {
+	case out := <-cfg.RunData.rawOutput[0]:
+		return gopacket.NewPacket((*out.data).Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	default:
+		return nil
+	}
+}
+
+func Test_handleARPIncoming(t *testing.T) {
+	cfg := responderTestConfig(t)
+	peer := net.HardwareAddr{2, 0, 0, 0, 0, 0xaa}
+	request := func(target net.IP) gopacket.Packet {
+		return respond(cfg, handleARPIncoming,
+			&layers.Ethernet{SrcMAC: peer, DstMAC: layers.EthernetBroadcast, EthernetType: layers.EthernetTypeARP},
+			&layers.ARP{AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4, HwAddressSize: 6, ProtAddressSize: 4,
+				Operation: layers.ARPRequest, SourceHwAddress: peer, SourceProtAddress: []byte{10, 0, 0, 9},
+				DstHwAddress: make([]byte, 6), DstProtAddress: target.To4()})
+	}
+
+	pkt := request(net.ParseIP("10.0.1.2"))
+	require.NotNil(t, pkt)
+	arp := pkt.Layer(layers.LayerTypeARP).(*layers.ARP)
+	require.Equal(t, uint16(layers.ARPReply), arp.Operation)
+	require.Equal(t, []byte(cfg.RunData.clients[1].mac), arp.SourceHwAddress)
+	require.Equal(t, []byte(peer), arp.DstHwAddress)
+
+	// someone else's address on a shared network
+	require.Nil(t, request(net.ParseIP("10.0.0.9")))
+	require.Equal(t, uint64(2), cfg.Counters.TotalARPRequestsRcvd)
+	require.Equal(t, uint64(1), cfg.Counters.TotalARPRepliesSent)
+	require.Equal(t, uint64(1), cfg.Counters.TotalARPRequestsIgnored)
+}
+
+func Test_handleNSIncoming(t *testing.T) {
+	cfg := responderTestConfig(t)
+	peer := net.HardwareAddr{2, 0, 0, 0, 0, 0xaa}
+	solicit := func(src, target net.IP) gopacket.Packet {
+		ip := &layers.IPv6{SrcIP: src, DstIP: target, Version: 6, HopLimit: 255, NextHeader: layers.IPProtocolICMPv6}
+		icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborSolicitation, 0)}
+		require.NoError(t, icmp.SetNetworkLayerForChecksum(ip))
+		return respond(cfg, handleICMPv6Incoming,
+			&layers.Ethernet{SrcMAC: peer, DstMAC: layers.EthernetBroadcast, EthernetType: layers.EthernetTypeIPv6},
+			ip, icmp, &layers.ICMPv6NeighborSolicitation{TargetAddress: target})
+	}
+	cl := &cfg.RunData.clients[5]
+
+	// unicast, e.g. neighbor unreachability detection
+	pkt := solicit(net.ParseIP("2001:db8::9"), cl.ClientIP)
+	require.NotNil(t, pkt)
+	na := pkt.Layer(layers.LayerTypeICMPv6NeighborAdvertisement).(*layers.ICMPv6NeighborAdvertisement)
+	require.True(t, na.Solicited())
+	require.Equal(t, peer, pkt.LinkLayer().(*layers.Ethernet).DstMAC)
+	require.Equal(t, cl.mac, pkt.LinkLayer().(*layers.Ethernet).SrcMAC)
+
+	// DAD probe, defended to all nodes
+	pkt = solicit(net.IPv6unspecified, cl.ClientIP)
+	require.NotNil(t, pkt)
+	na = pkt.Layer(layers.LayerTypeICMPv6NeighborAdvertisement).(*layers.ICMPv6NeighborAdvertisement)
+	require.False(t, na.Solicited())
+	require.True(t, allNodesIP.Equal(pkt.NetworkLayer().(*layers.IPv6).DstIP))
+
+	require.Nil(t, solicit(net.ParseIP("2001:db8::9"), net.ParseIP("2001:db8::8")))
+	require.Equal(t, uint64(3), cfg.Counters.TotalNSRcvd)
+	require.Equal(t, uint64(2), cfg.Counters.TotalNASent)
+	require.Equal(t, uint64(1), cfg.Counters.TotalNSIgnored)
+	require.Equal(t, uint64(1), cfg.Counters.TotalDADProbesRcvd)
+}
+
+func Test_handleEchoIncoming(t *testing.T) {
+	cfg := responderTestConfig(t)
+	peer := net.HardwareAddr{2, 0, 0, 0, 0, 0xaa}
+	data := gopacket.Payload([]byte("ping"))
+
+	ip4 := &layers.IPv4{SrcIP: net.IP{10, 0, 0, 9}, DstIP: net.IP{10, 0, 1, 3}, Version: 4, TTL: 64, Protocol: layers.IPProtocolICMPv4}
+	pkt := respond(cfg, func(cfg *ClientGenConfig, in *PktDecoder) { handleEchoIncoming(cfg, in, true) },
+		&layers.Ethernet{SrcMAC: peer, DstMAC: cfg.RunData.clients[2].mac, EthernetType: layers.EthernetTypeIPv4},
+		ip4, &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0), Id: 7, Seq: 9}, data)
+	require.NotNil(t, pkt)
+	icmp4 := pkt.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4)
+	require.Equal(t, uint8(layers.ICMPv4TypeEchoReply), icmp4.TypeCode.Type())
+	require.Equal(t, uint16(9), icmp4.Seq)
+	require.Equal(t, []byte(data), icmp4.Payload)
+
+	cl := &cfg.RunData.clients[6]
+	ip6 := &layers.IPv6{SrcIP: net.ParseIP("2001:db8::9"), DstIP: cl.ClientIP, Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolICMPv6}
+	icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeEchoRequest, 0)}
+	require.NoError(t, icmp.SetNetworkLayerForChecksum(ip6))
+	pkt = respond(cfg, handleICMPv6Incoming,
+		&layers.Ethernet{SrcMAC: peer, DstMAC: cl.mac, EthernetType: layers.EthernetTypeIPv6},
+		ip6, icmp, &layers.ICMPv6Echo{Identifier: 7, SeqNumber: 9}, data)
+	require.NotNil(t, pkt)
+	echo := pkt.Layer(layers.LayerTypeICMPv6Echo).(*layers.ICMPv6Echo)
+	require.Equal(t, uint16(9), echo.SeqNumber)
+	require.True(t, cl.ClientIP.Equal(pkt.NetworkLayer().(*layers.IPv6).SrcIP))
+
+	require.Equal(t, uint64(2), cfg.Counters.TotalEchoRequestsRcvd)
+	require.Equal(t, uint64(2), cfg.Counters.TotalEchoRepliesSent)
+}
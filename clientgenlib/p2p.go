@@ -0,0 +1,156 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/kpango/fastime"
+	log "github.com/sirupsen/logrus"
+)
+
+// pdelayExchange is one PDelayReq of a client and what came back for it, IEEE 1588 11.4
+type pdelayExchange struct {
+	seq         uint16
+	t2          time.Time // the peer received the PDelayReq
+	t3          time.Time // the peer sent the PDelayResp, two step only
+	t4          time.Time // we received the PDelayResp
+	correction  time.Duration
+	twoStep     bool
+	gotResp     bool
+	gotFollowUp bool
+	measured    bool
+}
+
+// checkDelayMechanism makes sure cfg.DelayMechanism is one we run
+func checkDelayMechanism(cfg *ClientGenConfig) error {
+	switch cfg.DelayMechanism {
+	case "", DelayMechanismE2E, DelayMechanismP2P:
+		return nil
+	}
+	return fmt.Errorf("unknown DelayMechanism %q", cfg.DelayMechanism)
+}
+
+// delayRespType is the message type of the third grant, the answers to the delay requests
+func delayRespType(cfg *ClientGenConfig) ptp.MessageType {
+	if cfg.DelayMechanism == DelayMechanismP2P {
+		return ptp.MessagePDelayResp
+	}
+	return ptp.MessageDelayResp
+}
+
+// newPDelayReq starts a new peer delay exchange of cl and returns its PDelayReq to send
+// the caller holds cl.stateSem, the exchange is reset together with the answers the processor matches
+func newPDelayReq(cfg *ClientGenConfig, cl *SingleClientGen) *outPacket {
+	cl.pdelay = pdelayExchange{seq: cl.eventSequence}
+	// the TX worker or the TX timestamp replace this with the real send time
+	cl.SentPDelayReqTime = fastime.Now()
+
+	out := cfg.RunData.outPacketPool.Get().(*outPacket)
+	// the clock identity comes back in RequestingPortIdentity, it tells the answers to the clients apart
+	craftSinglePktToGM(cfg, cl, ptp.PortEvent, &cfg.RunData.payloads.pdelayReq, ptp.ClockIdentity(cl.index), cl.eventSequence, out)
+	out.getTS = true
+	out.pktType = pktPDelayReq
+	out.cl = cl
+	atomic.AddUint64(&cfg.Counters.TotalEventMsgSent, 1)
+	atomic.AddUint64(&cfg.Counters.TotalPDelayReqSent, 1)
+	cl.CountPDelayReq++
+	if cfg.DebugLogClient || cfg.DebugPrint {
+		log.Infof("Client %v reqPDelay seq %v", cl.ClientIP, cl.eventSequence)
+	}
+	return out
+}
+
+// pdelayFor tells if a PDelayResp or PDelayRespFollowUp answers the outstanding PDelayReq of cl
+func pdelayFor(cl *SingleClientGen, seq uint16, requester ptp.PortIdentity) bool {
+	return seq == cl.pdelay.seq && requester.ClockIdentity == ptp.ClockIdentity(cl.index)
+}
+
+// handlePDelayResp takes t2 and t4, and the turnaround time of a one step peer from the correction
+func handlePDelayResp(cfg *ClientGenConfig, cl *SingleClientGen, resp *ptp.PDelayResp, ts time.Time) {
+	if !pdelayFor(cl, resp.SequenceID, resp.RequestingPortIdentity) || cl.pdelay.gotResp {
+		atomic.AddUint64(&cfg.Counters.TotalPDelayRespIgnored, 1)
+		if cfg.DebugLogClient || cfg.DebugPrint {
+			log.Infof("PDelayResp %v seq=%d not for the outstanding seq=%d", cl.ClientIP, resp.SequenceID, cl.pdelay.seq)
+		}
+		return
+	}
+	cl.CountPDelayResp++
+	cl.GotPDelayRespTime = ts
+	// increase eventSequence here so next PDelayReq is one higher
+	cl.eventSequence++
+	p := &cl.pdelay
+	p.gotResp = true
+	p.twoStep = resp.FlagField&ptp.FlagTwoStep != 0
+	p.t2 = resp.RequestReceiptTimestamp.Time()
+	p.t4 = ts
+	p.correction += time.Duration(resp.CorrectionField.Nanoseconds())
+	if cfg.DebugLogClient || cfg.DebugPrint {
+		log.Infof("PDelayResp %v seq=%d, peer RequestReceiptTimestamp=%v our RcvTS=%v",
+			cl.ClientIP, resp.SequenceID, p.t2, ts)
+	}
+	updateMeanLinkDelay(cfg, cl)
+}
+
+// handlePDelayRespFollowUp takes t3 of a two step peer, it may come before the PDelayResp
+func handlePDelayRespFollowUp(cfg *ClientGenConfig, cl *SingleClientGen, fu *ptp.PDelayRespFollowUp) {
+	if !pdelayFor(cl, fu.SequenceID, fu.RequestingPortIdentity) || cl.pdelay.gotFollowUp {
+		atomic.AddUint64(&cfg.Counters.TotalPDelayRespIgnored, 1)
+		return
+	}
+	p := &cl.pdelay
+	p.gotFollowUp = true
+	p.t3 = fu.ResponseOriginTimestamp.Time()
+	p.correction += time.Duration(fu.CorrectionField.Nanoseconds())
+	if cfg.DebugLogClient || cfg.DebugPrint {
+		log.Infof("PDelayRespFollowUp %v seq=%d, peer ResponseOriginTimestamp=%v", cl.ClientIP, fu.SequenceID, p.t3)
+	}
+	updateMeanLinkDelay(cfg, cl)
+}
+
+// handlePDelayReqSent takes the TX timestamp of our PDelayReq as t1, it may come after the answers
+func handlePDelayReqSent(cfg *ClientGenConfig, cl *SingleClientGen, req *ptp.PDelayReq, ts time.Time) {
+	if req.SequenceID != cl.pdelay.seq {
+		return
+	}
+	cl.SentPDelayReqTime = ts
+	updateMeanLinkDelay(cfg, cl)
+}
+
+// updateMeanLinkDelay computes the mean link delay of cl once its exchange is complete, IEEE 1588 11.4.2
+// ((t4 - t1) - (t3 - t2) - corrections) / 2, a one step peer leaves t2 and t3 zero
+func updateMeanLinkDelay(cfg *ClientGenConfig, cl *SingleClientGen) {
+	p := &cl.pdelay
+	if !p.gotResp || (p.twoStep && !p.gotFollowUp) || cl.SentPDelayReqTime.IsZero() {
+		return
+	}
+	turnaround := p.correction
+	if p.twoStep {
+		turnaround += p.t3.Sub(p.t2)
+	}
+	cl.MeanLinkDelay = (p.t4.Sub(cl.SentPDelayReqTime) - turnaround) / 2
+	if !p.measured {
+		p.measured = true
+		atomic.AddUint64(&cfg.Counters.TotalMeanLinkDelays, 1)
+	}
+	if cfg.DebugLogClient || cfg.DebugPrint {
+		log.Infof("Client %v mean link delay %v", cl.ClientIP, cl.MeanLinkDelay)
+	}
+}
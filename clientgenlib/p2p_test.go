@@ -0,0 +1,140 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_checkDelayMechanism(t *testing.T) {
+	cfg := &ClientGenConfig{}
+	require.NoError(t, checkDelayMechanism(cfg))
+	require.Equal(t, ptp.MessageDelayResp, delayRespType(cfg))
+	cfg.DelayMechanism = DelayMechanismP2P
+	require.NoError(t, checkDelayMechanism(cfg))
+	require.Equal(t, ptp.MessagePDelayResp, delayRespType(cfg))
+	cfg.DelayMechanism = "P2P"
+	require.Error(t, checkDelayMechanism(cfg))
+}
+
+func Test_updateMeanLinkDelay(t *testing.T) {
+	t1 := time.Unix(1000, 0)
+	requester := ptp.PortIdentity{PortNumber: 1, ClockIdentity: 3}
+	resp := func(seq uint16, twoStep bool, t2 time.Time, correction time.Duration) *ptp.PDelayResp {
+		r := &ptp.PDelayResp{
+			Header: ptp.Header{SequenceID: seq, CorrectionField: ptp.NewCorrection(float64(correction))},
+			PDelayRespBody: ptp.PDelayRespBody{
+				RequestReceiptTimestamp: ptp.NewTimestamp(t2),
+				RequestingPortIdentity:  requester,
+			},
+		}
+		if twoStep {
+			r.FlagField = ptp.FlagTwoStep
+		}
+		return r
+	}
+	followUp := func(seq uint16, t3 time.Time) *ptp.PDelayRespFollowUp {
+		return &ptp.PDelayRespFollowUp{
+			Header: ptp.Header{SequenceID: seq},
+			PDelayRespFollowUpBody: ptp.PDelayRespFollowUpBody{
+				ResponseOriginTimestamp: ptp.NewTimestamp(t3),
+				RequestingPortIdentity:  requester,
+			},
+		}
+	}
+	newClient := func(seq uint16) *SingleClientGen {
+		return &SingleClientGen{index: 3, eventSequence: seq, SentPDelayReqTime: t1, pdelay: pdelayExchange{seq: seq}}
+	}
+
+	// two step, 100us each way and 50us in the peer, the follow up first
+	cfg := &ClientGenConfig{}
+	cl := newClient(7)
+	t2 := time.Unix(5000, 0)
+	handlePDelayRespFollowUp(cfg, cl, followUp(7, t2.Add(50*time.Microsecond)))
+	require.Zero(t, cl.MeanLinkDelay)
+	handlePDelayResp(cfg, cl, resp(7, true, t2, 0), t1.Add(250*time.Microsecond))
+	require.Equal(t, 100*time.Microsecond, cl.MeanLinkDelay)
+	require.Equal(t, uint16(8), cl.eventSequence)
+	require.Equal(t, uint64(1), cfg.Counters.TotalMeanLinkDelays)
+
+	// the TX timestamp comes last and makes it more precise, still one measurement
+	handlePDelayReqSent(cfg, cl, &ptp.PDelayReq{Header: ptp.Header{SequenceID: 7}}, t1.Add(10*time.Microsecond))
+	require.Equal(t, 95*time.Microsecond, cl.MeanLinkDelay)
+	require.Equal(t, uint64(1), cfg.Counters.TotalMeanLinkDelays)
+
+	// a second answer to the same request is ignored
+	handlePDelayResp(cfg, cl, resp(7, true, t2, 0), t1.Add(time.Second))
+	require.Equal(t, 95*time.Microsecond, cl.MeanLinkDelay)
+	require.Equal(t, uint64(1), cfg.Counters.TotalPDelayRespIgnored)
+
+	// one step, the turnaround is in the correction
+	cfg = &ClientGenConfig{}
+	cl = newClient(2)
+	handlePDelayResp(cfg, cl, resp(2, false, time.Time{}, 50*time.Microsecond), t1.Add(250*time.Microsecond))
+	require.Equal(t, 100*time.Microsecond, cl.MeanLinkDelay)
+
+	// answers to another sequence or another client don't count
+	cfg = &ClientGenConfig{}
+	cl = newClient(2)
+	handlePDelayResp(cfg, cl, resp(1, false, time.Time{}, 0), t1.Add(time.Millisecond))
+	cl.index = 4
+	handlePDelayResp(cfg, cl, resp(2, false, time.Time{}, 0), t1.Add(time.Millisecond))
+	require.Zero(t, cl.MeanLinkDelay)
+	require.Zero(t, cl.CountPDelayResp)
+	require.Equal(t, uint64(2), cfg.Counters.TotalPDelayRespIgnored)
+}
+
+// txTSLoopbackIO is loopbackIO with TX timestamps, the frames sent with WritePacketTS come back
+// to the packet parsers like the kernel backend hands them back
+type txTSLoopbackIO struct {
+	*loopbackIO
+	cfg *ClientGenConfig
+}
+
+func openTXTSLoopback(cfg *ClientGenConfig, dir PacketIODirection, wkr int) (PacketIO, error) {
+	h, err := openLoopback(cfg, dir, wkr)
+	if err != nil {
+		return nil, err
+	}
+	return &txTSLoopbackIO{loopbackIO: h.(*loopbackIO), cfg: cfg}, nil
+}
+
+// useTXTSLoopback makes the loopback backend txTSLoopbackIO until the end of t
+func useTXTSLoopback(t *testing.T) {
+	open := packetIOBackends[IOBackendLoopback]
+	t.Cleanup(func() { packetIOBackends[IOBackendLoopback] = open })
+	packetIOBackends[IOBackendLoopback] = openTXTSLoopback
+}
+
+func (l *txTSLoopbackIO) WritePacketTS(data []byte) error {
+	ts := time.Now()
+	if err := l.WritePacket(data); err != nil {
+		return err
+	}
+	rawIn := l.cfg.RunData.inPacketPool.Get().(*inPacket)
+	rawIn.data = append(l.cfg.RunData.bytePool.Get().([]byte)[:0], data...)
+	rawIn.ts = ts
+	rawIn.fromTX = true
+	sendRawInput(l.cfg, rawIn)
+	atomic.AddUint64(&l.cfg.Counters.TotalTXTSRead, 1)
+	return nil
+}
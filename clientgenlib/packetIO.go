@@ -0,0 +1,131 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"fmt"
+	"time"
+)
+
+// PacketIO is one open handle onto the wire, used by a single RX or TX worker
+type PacketIO interface {
+	// ReadPacket returns the next received frame, its RX timestamp and whether the
+	// timestamp came from the NIC. nil data with nil error means nothing arrived yet
+	ReadPacket() (data []byte, ts time.Time, hwTS bool, err error)
+	// WritePacket transmits one frame
+	WritePacket(data []byte) error
+	// Stats returns running totals since the handle was opened
+	Stats() (Stats, error)
+	Close() error
+}
+
+// BatchWriter is implemented by backends that can transmit several frames with one
+// kernel call, the TX workers use it when TXBatchSize is above 1
+type BatchWriter interface {
+	// WritePackets transmits frames in order, returns how many went out before an error
+	WritePackets(frames [][]byte) (int, error)
+}
+
+// writePackets sends frames through the handle's BatchWriter, or one WritePacket each without one
+func writePackets(handle PacketIO, frames [][]byte) (int, error) {
+	if bw, ok := handle.(BatchWriter); ok {
+		return bw.WritePackets(frames)
+	}
+	for i, data := range frames {
+		if err := handle.WritePacket(data); err != nil {
+			return i, err
+		}
+	}
+	return len(frames), nil
+}
+
+// Stats are the transport level counters of one PacketIO
+type Stats struct {
+	Received uint64
+	Dropped  uint64
+}
+
+// PacketIODirection tells a backend which side of a worker it is opened for
+type PacketIODirection int
+
+const (
+	PacketIORX PacketIODirection = iota
+	PacketIOTX
+)
+
+// PacketIOFactory opens a PacketIO for RX or TX worker number wkr
+type PacketIOFactory func(cfg *ClientGenConfig, dir PacketIODirection, wkr int) (PacketIO, error)
+
+// backends built into clientgen
+var packetIOBackends = map[string]PacketIOFactory{
+	IOBackendPFRing:   openPFRing,
+	IOBackendAFPacket: openAFPacket,
+	IOBackendAFXDP:    openAFXDP,
+	IOBackendPcap:     openPcapReplay,
+	IOBackendNull:     openNull,
+	IOBackendLoopback: openLoopback,
+	IOBackendKernel:   openKernel,
+}
+
+// noTXTSSocketIO is implemented by backends whose frames don't go out raw on Iface,
+// the TX workers don't open a raw TX timestamp socket next to them
+type noTXTSSocketIO interface {
+	noTXTSSocket()
+}
+
+// txTSWriterIO is implemented by backends that timestamp frames themselves, the frames sent with
+// WritePacketTS come back to the packet parsers with fromTX set and their TX timestamp
+type txTSWriterIO interface {
+	WritePacketTS(data []byte) error
+}
+
+// RegisterPacketIO makes a backend selectable by name with ClientGenConfig.IOBackend
+func RegisterPacketIO(name string, factory PacketIOFactory) {
+	packetIOBackends[name] = factory
+}
+
+// openPacketIO opens the backend selected in the config, pfring if none is set
+func openPacketIO(cfg *ClientGenConfig, dir PacketIODirection, wkr int) (PacketIO, error) {
+	name := cfg.IOBackend
+	if name == "" {
+		name = IOBackendPFRing
+	}
+	factory, ok := packetIOBackends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown IOBackend %q", name)
+	}
+	return factory(cfg, dir, wkr)
+}
+
+func openAFPacket(cfg *ClientGenConfig, dir PacketIODirection, wkr int) (PacketIO, error) {
+	var h *afPacketHandle
+	var err error
+	if dir == PacketIOTX {
+		h, err = newAFPacketTX(cfg.Iface)
+	} else {
+		h, err = newAFPacketRX(cfg.Iface, &cfg.RunData.afPacketFanout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// openAFXDP RX and TX worker wkr share the XSK socket of NIC queue wkr
+func openAFXDP(cfg *ClientGenConfig, dir PacketIODirection, wkr int) (PacketIO, error) {
+	return newAFXDP(cfg.Iface, wkr)
+}
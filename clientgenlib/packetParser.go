@@ -23,7 +23,7 @@ import (
 )
 
 func startPacketParser(cfg *ClientGenConfig) {
-	parserStartDone := make(chan bool)
+	parserStartDone := make(chan bool, cfg.NumPacketParsers)
 	for parser := 0; parser < cfg.NumPacketParsers; parser++ {
 		func(i int) {
 			cfg.Eg.Go(func() error {
@@ -39,7 +39,8 @@ func startPacketParser(cfg *ClientGenConfig) {
 					var err error
 					parserStartDone <- true
 					for {
-						for pkt = range cfg.RunData.rawInput[i] {
+						select {
+						case pkt = <-cfg.RunData.rawInput[i]:
 							profiler.Tick()
 							decoder = cfg.RunData.goPacketDecoderPool.Get().(*PktDecoder)
 							err = decoder.parser.DecodeLayers(pkt.data, &decoder.layers)
@@ -49,6 +50,7 @@ func startPacketParser(cfg *ClientGenConfig) {
 							decoder.Timestamp = pkt.ts
 							decoder.fromTX = pkt.fromTX
 							decoder.rawData = pkt.data
+							decoder.hash = pkt.hash
 
 							// return this back to the pool
 							cfg.RunData.inPacketPool.Put(pkt)
@@ -56,14 +58,18 @@ func startPacketParser(cfg *ClientGenConfig) {
 							if cfg.DebugPrint || cfg.DebugIoWkrRX {
 								log.Debugf("PacketParser %d parsed: %+v", i, decoder)
 							}
-							cfg.RunData.pktToProc[getPacketProcChanNumToUse(cfg)] <- decoder
+							sendPktToProc(cfg, decoder)
 							profiler.Tock()
+						case <-(*cfg.Ctx).Done():
+							doneChan <- nil
+							return
 						}
 					}
 				}()
 				select {
 				case <-(*cfg.Ctx).Done():
 					log.Infof("Packet parser %d cancelling", i)
+					<-doneChan
 					return (*cfg.Ctx).Err()
 				case err = <-doneChan:
 					log.Infof("Packet parser %d donechan", i)
@@ -18,6 +18,8 @@ package clientgenlib
 
 import (
 	"fmt"
+	"net"
+	"sync/atomic"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
@@ -25,38 +27,46 @@ import (
 )
 
 func handleICMPv6Incoming(cfg *ClientGenConfig, in *PktDecoder) {
-	ipv6 := &in.ip6
-	icmpv6 := &in.icmpv6
-	// sanity check, make sure it's coming to Broadcast
-	if !(ipv6.DstIP.IsMulticast() ||
-		ipv6.DstIP.IsLinkLocalMulticast() ||
-		ipv6.DstIP.IsInterfaceLocalMulticast()) {
-		log.Infof("Handle ICMPv6 incoming not multicast!")
-		return
+	switch in.icmpv6.TypeCode.Type() {
+	case layers.ICMPv6TypeNeighborSolicitation:
+		handleNSIncoming(cfg, in)
+	case layers.ICMPv6TypeNeighborAdvertisement:
+		atomic.AddUint64(&cfg.Counters.TotalNARcvd, 1)
+		// answer to the server MAC resolver, comes unicast
+		if r := cfg.RunData.resolver; r != nil {
+			mac := in.eth.SrcMAC
+			for _, opt := range in.icmpv6na.Options {
+				if opt.Type == layers.ICMPv6OptTargetAddress {
+					mac = net.HardwareAddr(opt.Data)
+				}
+			}
+			r.answer(cfg, in.icmpv6na.TargetAddress, in.vlan(), mac)
+		}
+	case layers.ICMPv6TypeEchoRequest:
+		handleEchoIncoming(cfg, in, false)
 	}
+}
 
-	// handle neighbor solitication, others maybe later
-	if icmpv6.TypeCode.Type() != layers.ICMPv6TypeNeighborSolicitation {
-		log.Infof("Handle ICMPv6 incoming not Neighbor Solicitation!")
-		return
-	}
-	// need to look at
+func handleNSIncoming(cfg *ClientGenConfig, in *PktDecoder) {
+	ipv6 := &in.ip6
 	icmpv6ns := &in.icmpv6ns
+	atomic.AddUint64(&cfg.Counters.TotalNSRcvd, 1)
 
 	// https://blog.apnic.net/2019/10/18/how-to-ipv6-neighbor-discovery/
-	// make sure this ns is for a client IP I'm running
-	cl, err := getClientFromIP(cfg, icmpv6ns.TargetAddress)
-	if err != nil || cl == nil {
-		log.Infof("Handle ICMPv6 incoming not my client!")
+	// make sure this ns is for a client IP I'm running, RFC 4861 7.1.1 wants hop limit 255
+	cl, err := getClientFromIP(cfg, in.vlan(), icmpv6ns.TargetAddress)
+	if err != nil || ipv6.HopLimit != 255 {
+		atomic.AddUint64(&cfg.Counters.TotalNSIgnored, 1)
+		if cfg.DebugPrint {
+			log.Debugf("Handle NS incoming ignored for %v from %v", icmpv6ns.TargetAddress, ipv6.SrcIP)
+		}
 		return
 	}
 
 	// provide a neighbor announcement response
-	// inefficient , need to cache this but get MAC address of interface here
-	ethLayer := &in.eth
 	eth := layers.Ethernet{
-		SrcMAC:       cfg.srcMAC,
-		DstMAC:       ethLayer.SrcMAC, // could also use ICMPv6OptSourceAddress
+		SrcMAC:       cl.mac,
+		DstMAC:       in.eth.SrcMAC, // could also use ICMPv6OptSourceAddress
 		EthernetType: layers.EthernetTypeIPv6,
 	}
 	ip := layers.IPv6{
@@ -74,10 +84,17 @@ func handleICMPv6Incoming(cfg *ClientGenConfig, in *PktDecoder) {
 		TargetAddress: icmpv6ns.TargetAddress,
 		Options: []layers.ICMPv6Option{
 			{Type: layers.ICMPv6OptTargetAddress,
-				Data: cfg.srcMAC,
+				Data: cl.mac,
 			},
 		},
 	}
+	if ipv6.SrcIP.IsUnspecified() {
+		// DAD probe for a client address, defend it to all nodes and not solicited (RFC 4861 7.2.4)
+		atomic.AddUint64(&cfg.Counters.TotalDADProbesRcvd, 1)
+		eth.DstMAC = allNodesMAC
+		ip.DstIP = allNodesIP
+		icmpna.Flags = 0x20
+	}
 
 	out := cfg.RunData.outPacketPool.Get().(*outPacket)
 	out.cl = nil
@@ -88,12 +105,13 @@ func handleICMPv6Incoming(cfg *ClientGenConfig, in *PktDecoder) {
 	if err != nil {
 		log.Errorf("icmp SetNetworkLayerForChecksum failed! %v", err)
 	}
-	err = gopacket.SerializeLayers(*out.data,
-		cfg.RunData.commonSerializeOp, &eth, &ip, &icmp, &icmpna)
+	err = serializeFrame(*out.data,
+		cfg.RunData.commonSerializeOp, &eth, cl.vlan, cl.pcp, &ip, &icmp, &icmpna)
 	if err != nil {
 		log.Errorf("Serialize failed! %v", err)
 	}
-	cfg.RunData.rawOutput[getTxChanNumToUse(cfg)] <- out
+	atomic.AddUint64(&cfg.Counters.TotalNASent, 1)
+	sendRawOutput(cfg, out)
 
 }
 
@@ -102,27 +120,38 @@ func handleARPIncoming(cfg *ClientGenConfig, in *PktDecoder) {
 	arpLayer := &in.arp
 
 	if arpLayer.Operation == 1 { // only care about requests
+		atomic.AddUint64(&cfg.Counters.TotalARPRequestsRcvd, 1)
+		// only answer for our clients, other hosts may share the network
+		// announcements (sender is target) are someone claiming the address, not asking for it
+		vlan := in.vlan()
+		cl, err := getClientFromIP(cfg, vlan, net.IP(arpLayer.DstProtAddress))
+		if err != nil || net.IP(arpLayer.SourceProtAddress).Equal(net.IP(arpLayer.DstProtAddress)) {
+			atomic.AddUint64(&cfg.Counters.TotalARPRequestsIgnored, 1)
+			if cfg.DebugPrint {
+				log.Debugf("Handle arp incoming ignored for %v", net.IP(arpLayer.DstProtAddress))
+			}
+			return
+		}
+
 		// craft the ARP packet by layer, ethernet then ARP
+		// answer in the VLAN of the request, with the MAC and priority of the client
 		eth := layers.Ethernet{
-			SrcMAC:       cfg.srcMAC,
+			SrcMAC:       cl.mac,
 			DstMAC:       ethLayer.SrcMAC,
 			EthernetType: layers.EthernetTypeARP,
 		}
 		arpResponse := *arpLayer
-		arpResponse.Operation = 2                // sending response
-		arpResponse.SourceHwAddress = cfg.srcMAC // from my interface
-
-		// need to validate if the IP falls in my pseudo client range, but for now just reply
-
+		arpResponse.Operation = 2            // sending response
+		arpResponse.SourceHwAddress = cl.mac // the client's
 		arpResponse.SourceProtAddress = arpLayer.DstProtAddress
 		arpResponse.DstHwAddress = arpLayer.SourceHwAddress
 		arpResponse.DstProtAddress = arpLayer.SourceProtAddress
 
 		out := cfg.RunData.outPacketPool.Get().(*outPacket)
-		err := gopacket.SerializeLayers(*out.data,
-			cfg.RunData.commonSerializeOp, &eth, &arpResponse)
+		err = serializeFrame(*out.data,
+			cfg.RunData.commonSerializeOp, &eth, vlan, cl.pcp, &arpResponse)
 		if err != nil {
-			fmt.Errorf("Handle arp incoming seriallayers err %v", err)
+			log.Errorf("Handle arp incoming seriallayers err %v", err)
 		}
 		out.cl = nil
 		out.pktType = pktIgnore
@@ -132,13 +161,17 @@ func handleARPIncoming(cfg *ClientGenConfig, in *PktDecoder) {
 			debugPacket := gopacket.NewPacket((*out.data).Bytes(), layers.LinkTypeEthernet, gopacket.Default)
 			log.Debugf("handleARPIncoming debug arp response raw %v", debugPacket)
 		}
-		cfg.RunData.rawOutput[getTxChanNumToUse(cfg)] <- out
+		atomic.AddUint64(&cfg.Counters.TotalARPRepliesSent, 1)
+		sendRawOutput(cfg, out)
+	} else if arpLayer.Operation == 2 && cfg.RunData.resolver != nil {
+		// answer to the server MAC resolver
+		cfg.RunData.resolver.answer(cfg, net.IP(arpLayer.SourceProtAddress), in.vlan(), net.HardwareAddr(arpLayer.SourceHwAddress))
 	}
 
 }
 
 func startPacketProcessor(cfg *ClientGenConfig) {
-	packetProcStartDone := make(chan bool)
+	packetProcStartDone := make(chan bool, cfg.NumPacketProcessors)
 	for proc := 0; proc < cfg.NumPacketProcessors; proc++ {
 		func(i int) {
 			cfg.Eg.Go(func() error {
@@ -152,7 +185,12 @@ func startPacketProcessor(cfg *ClientGenConfig) {
 					var index int
 					packetProcStartDone <- true
 					for {
-						data = <-cfg.RunData.pktToProc[i]
+						select {
+						case data = <-cfg.RunData.pktToProc[i]:
+						case <-(*cfg.Ctx).Done():
+							doneChan <- nil
+							return
+						}
 						profiler.Tick()
 						if cfg.DebugPrint {
 							log.Debugf("Packet processor %d got packet %+v", i, data)
@@ -176,6 +214,12 @@ func startPacketProcessor(cfg *ClientGenConfig) {
 									handleICMPv6Incoming(cfg, data)
 								}
 								break
+							} else if data.layers[index] == layers.LayerTypeICMPv4 {
+								// ping of a client
+								if !data.fromTX {
+									handleEchoIncoming(cfg, data, true)
+								}
+								break
 							} else if data.layers[index] == layers.LayerTypeUDP {
 								// could be ipv6 or ipv4
 								if cfg.DebugPrint {
@@ -185,6 +229,11 @@ func startPacketProcessor(cfg *ClientGenConfig) {
 								break
 							}
 						}
+						if cfg.Transport == TransportL2 {
+							if payload := data.l2PTP(); payload != nil {
+								handleL2Incoming(cfg, data, payload)
+							}
+						}
 						if data.fromTX {
 							// need to put back the raw byte slice also
 							cfg.RunData.bytePool.Put(data.rawData)
@@ -196,6 +245,7 @@ func startPacketProcessor(cfg *ClientGenConfig) {
 				select {
 				case <-(*cfg.Ctx).Done():
 					log.Infof("packet Processor %d ending", i)
+					<-doneChan
 					return (*cfg.Ctx).Err()
 				case err = <-doneChan:
 					return err
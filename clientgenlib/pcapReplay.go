@@ -0,0 +1,154 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// how long an idle offline RX handle waits before reporting nothing received
+const offlineIdleWait = 100 * time.Millisecond
+
+// pcapng files start with the section header block type
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+// pcapReplayIO is the RX side of the "pcap" backend, feeds a capture file in as received traffic
+// keeping the capture timestamps, so a recorded GM session can be run through the pipeline again
+type pcapReplayIO struct {
+	path  string
+	file  *os.File
+	src   gopacket.PacketDataSource
+	paced bool
+
+	// capture time of the first packet and when we replayed it
+	firstTS    time.Time
+	firstStart time.Time
+
+	count uint64
+	done  bool
+}
+
+// openPcapReplay only RX worker 0 reads the file so packets keep their order
+// the other RX workers and all TX workers get a null handle
+func openPcapReplay(cfg *ClientGenConfig, dir PacketIODirection, wkr int) (PacketIO, error) {
+	if dir == PacketIOTX || wkr != 0 {
+		return &nullIO{}, nil
+	}
+	return newPcapReplay(cfg.ReplayFile, cfg.ReplayPaced)
+}
+
+func newPcapReplay(path string, paced bool) (*pcapReplayIO, error) {
+	if path == "" {
+		return nil, fmt.Errorf("pcap backend needs a ReplayFile")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("replay open: %w", err)
+	}
+	r := bufio.NewReader(f)
+	magic, err := r.Peek(len(pcapngMagic))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("replay read %s: %w", path, err)
+	}
+	p := &pcapReplayIO{path: path, file: f, paced: paced}
+	if bytes.Equal(magic, pcapngMagic) {
+		p.src, err = pcapgo.NewNgReader(r, pcapgo.DefaultNgReaderOptions)
+	} else {
+		p.src, err = pcapgo.NewReader(r)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("replay %s: %w", path, err)
+	}
+	log.Infof("Replaying %s", path)
+	return p, nil
+}
+
+// ReadPacket returns the next packet of the file with its capture timestamp
+// once the file is done it behaves like a quiet link
+func (p *pcapReplayIO) ReadPacket() ([]byte, time.Time, bool, error) {
+	if p.done {
+		time.Sleep(offlineIdleWait)
+		return nil, time.Time{}, false, nil
+	}
+	data, ci, err := p.src.ReadPacketData()
+	if err == io.EOF {
+		log.Infof("Replay of %s done, %d packets", p.path, p.count)
+		p.done = true
+		return nil, time.Time{}, false, nil
+	} else if err != nil {
+		return nil, time.Time{}, false, fmt.Errorf("replay %s: %w", p.path, err)
+	}
+	if p.paced {
+		if p.count == 0 {
+			p.firstTS = ci.Timestamp
+			p.firstStart = time.Now()
+		} else if wait := ci.Timestamp.Sub(p.firstTS) - time.Since(p.firstStart); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	p.count++
+	return data, ci.Timestamp, false, nil
+}
+
+func (p *pcapReplayIO) WritePacket(data []byte) error {
+	return fmt.Errorf("pcap replay can't transmit")
+}
+
+func (p *pcapReplayIO) Stats() (Stats, error) {
+	return Stats{}, nil
+}
+
+func (p *pcapReplayIO) Close() error {
+	return p.file.Close()
+}
+
+// nullIO never receives anything and drops whatever is sent, the "null" backend
+type nullIO struct{}
+
+func openNull(cfg *ClientGenConfig, dir PacketIODirection, wkr int) (PacketIO, error) {
+	return &nullIO{}, nil
+}
+
+func (n *nullIO) ReadPacket() ([]byte, time.Time, bool, error) {
+	time.Sleep(offlineIdleWait)
+	return nil, time.Time{}, false, nil
+}
+
+func (n *nullIO) WritePacket(data []byte) error {
+	return nil
+}
+
+func (n *nullIO) Stats() (Stats, error) {
+	return Stats{}, nil
+}
+
+func (n *nullIO) Close() error {
+	return nil
+}
+
+func (n *nullIO) noTXTSSocket() {}
@@ -0,0 +1,148 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+// writeReplayPcap writes frames to a pcap file at path with capture timestamps stamps
+func writeReplayPcap(t *testing.T, path string, frames [][]byte, stamps []time.Time) {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	w := pcapgo.NewWriterNanos(f)
+	require.NoError(t, w.WriteFileHeader(65536, layers.LinkTypeEthernet))
+	for i, frame := range frames {
+		ci := gopacket.CaptureInfo{Timestamp: stamps[i], CaptureLength: len(frame), Length: len(frame)}
+		require.NoError(t, w.WritePacket(ci, frame))
+	}
+	require.NoError(t, f.Close())
+}
+
+func Test_pcapReplay(t *testing.T) {
+	dir := t.TempDir()
+	frames := [][]byte{
+		captureTestFrame(t, "10.0.0.1", "10.0.0.2"),
+		captureTestFrame(t, "10.0.0.2", "10.0.0.1"),
+	}
+	start := time.Unix(1600000000, 123456789)
+	stamps := []time.Time{start, start.Add(1500 * time.Microsecond)}
+
+	pcapPath := filepath.Join(dir, "replay.pcap")
+	writeReplayPcap(t, pcapPath, frames, stamps)
+
+	ngPath := filepath.Join(dir, "replay.pcapng")
+	f, err := os.Create(ngPath)
+	require.NoError(t, err)
+	ngw, err := pcapgo.NewNgWriter(f, layers.LinkTypeEthernet)
+	require.NoError(t, err)
+	for i, frame := range frames {
+		ci := gopacket.CaptureInfo{Timestamp: stamps[i], CaptureLength: len(frame), Length: len(frame)}
+		require.NoError(t, ngw.WritePacket(ci, frame))
+	}
+	require.NoError(t, ngw.Flush())
+	require.NoError(t, f.Close())
+
+	for _, path := range []string{pcapPath, ngPath} {
+		p, err := newPcapReplay(path, false)
+		require.NoError(t, err)
+		for i, frame := range frames {
+			data, ts, hwTS, err := p.ReadPacket()
+			require.NoError(t, err)
+			require.Equal(t, frame, data)
+			require.Equal(t, stamps[i].UnixNano(), ts.UnixNano())
+			require.False(t, hwTS)
+		}
+		// end of the file reads as an idle link
+		data, _, _, err := p.ReadPacket()
+		require.NoError(t, err)
+		require.Empty(t, data)
+		require.Error(t, p.WritePacket(frames[0]))
+		require.NoError(t, p.Close())
+	}
+}
+
+func Test_pcapReplayPaced(t *testing.T) {
+	frames := [][]byte{
+		captureTestFrame(t, "10.0.0.1", "10.0.0.2"),
+		captureTestFrame(t, "10.0.0.2", "10.0.0.1"),
+		captureTestFrame(t, "10.0.0.1", "10.0.0.2"),
+	}
+	start := time.Unix(1600000000, 123456789)
+	stamps := []time.Time{start, start.Add(30 * time.Millisecond), start.Add(80 * time.Millisecond)}
+	path := filepath.Join(t.TempDir(), "replay.pcap")
+	writeReplayPcap(t, path, frames, stamps)
+
+	// an RX worker feeding the packet parser queue, nothing else of the pipeline
+	ctx, cancel := context.WithCancel(context.Background())
+	errg, ctx := errgroup.WithContext(ctx)
+	defer errg.Wait()
+	defer cancel()
+	cfg := &ClientGenConfig{
+		IOBackend:        IOBackendPcap,
+		ReplayFile:       path,
+		ReplayPaced:      true,
+		NumRXWorkers:     1,
+		NumTXWorkers:     1,
+		NumPacketParsers: 1,
+		Ctx:              &ctx,
+		Eg:               errg,
+		RunData:          &ClientGenData{},
+	}
+	cfg.perIORX = make([]uint64, 1)
+	cfg.perIOTX = make([]uint64, 1)
+	cfg.RunData.inPacketPool.New = func() interface{} { return new(inPacket) }
+	rawIn := make(chan *inPacket, len(frames))
+	cfg.RunData.rawInput = []chan *inPacket{rawIn}
+	cfg.RunData.rawInputStats = []*queueStats{newQueueStats(cfg, QueueRawInput, 0, len(frames), func() int { return len(rawIn) })}
+	rawOut := make(chan *outPacket, 1)
+	cfg.RunData.rawOutput = []chan *outPacket{rawOut}
+	cfg.RunData.rawOutputStats = []*queueStats{newQueueStats(cfg, QueueRawOutput, 0, 1, func() int { return len(rawOut) })}
+
+	begin := time.Now()
+	startIOWorker(cfg)
+	// packet i comes no earlier than its offset in the capture, and not much later
+	last := begin
+	for i, frame := range frames {
+		var in *inPacket
+		select {
+		case in = <-rawIn:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("packet %d not replayed", i)
+		}
+		now := time.Now()
+		require.Equal(t, frame, in.data)
+		require.False(t, in.fromTX)
+		// the pipeline still sees the capture timestamps, not the replay time
+		require.Equal(t, stamps[i].UnixNano(), in.ts.UnixNano())
+		require.GreaterOrEqual(t, int64(now.Sub(begin)), int64(stamps[i].Sub(start)), "packet %d", i)
+		if i > 0 {
+			require.Less(t, int64(now.Sub(last)), int64(stamps[i].Sub(stamps[i-1])+500*time.Millisecond), "packet %d", i)
+		}
+		last = now
+	}
+}
@@ -59,29 +59,29 @@ func (p *Profiler) Init(eg *errgroup.Group, ctx *context.Context, useTime bool,
 	p.useTimeMode = useTime
 	p.busyValid = false
 	p.eg.Go(func() error {
-		go func() {
-			for {
-				<-ticker.C
-				// calculate 1 second average
-				// make sure array isn't changing
-				p.mu.Lock()
-				// simple average
-				if p.useTimeMode {
-					p.totalTime = fastime.Now().Sub(p.startAccumulation)
-				}
-				p.lastSecondBusy = (float64(p.totalWorkTime) / float64(p.totalTime)) * 100
-				p.totalWorkTime = 0
-				p.totalTime = 0
-				if p.useTimeMode {
-					p.startAccumulation = fastime.Now()
-				}
-				p.busyValid = true
-				p.mu.Unlock()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-(*p.ctx).Done():
+				return nil
 			}
-		}()
-
-		<-(*p.ctx).Done()
-		return nil
+			// calculate 1 second average
+			// make sure array isn't changing
+			p.mu.Lock()
+			// simple average
+			if p.useTimeMode {
+				p.totalTime = fastime.Now().Sub(p.startAccumulation)
+			}
+			p.lastSecondBusy = (float64(p.totalWorkTime) / float64(p.totalTime)) * 100
+			p.totalWorkTime = 0
+			p.totalTime = 0
+			if p.useTimeMode {
+				p.startAccumulation = fastime.Now()
+			}
+			p.busyValid = true
+			p.mu.Unlock()
+		}
 	})
 }
 
@@ -100,23 +100,32 @@ func (p *Profiler) Tick() {
 
 // Tock stores the difference between Tick and Now as how long work took
 func (p *Profiler) Tock() {
-	p.totalWorkTime += fastime.Now().Sub(p.startWork)
+	d := fastime.Now().Sub(p.startWork)
+	p.mu.Lock()
+	p.totalWorkTime += d
+	p.mu.Unlock()
 }
 
 // CountIdle for non-time based , counts when no work was done
 func (p *Profiler) CountIdle() {
 	// add to totalTime
+	p.mu.Lock()
 	p.totalTime += 1
+	p.mu.Unlock()
 }
 
 // CountWork for non-time based, counts when work was done
 func (p *Profiler) CountWork() {
 	// add to totalTime and WorkTime
+	p.mu.Lock()
 	p.totalTime += 1
 	p.totalWorkTime += 1
+	p.mu.Unlock()
 }
 
 // IsValid provides status when the lastSecondBusy is available
 func (p *Profiler) IsValid() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.busyValid
 }
@@ -0,0 +1,268 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	log "github.com/sirupsen/logrus"
+)
+
+// PF_RING stub types for Linux compatibility
+type Ring struct {
+	closed bool
+}
+
+type Flag int
+
+const (
+	FlagPromisc     Flag = 1
+	FlagHWTimestamp Flag = 2
+	FlagLongHeader  Flag = 4
+)
+
+type ClusterType int
+
+const (
+	ClusterPerFlow       ClusterType = 0
+	ClusterRoundRobin    ClusterType = 1
+	ClusterPerFlow2Tuple ClusterType = 2
+)
+
+type Direction int
+
+const (
+	ReceiveOnly Direction = 1
+	ReadOnly    Direction = 2
+)
+
+type ExtendedPacketHeader struct {
+	Timestamp struct {
+		Sec  int64
+		Nsec int64
+	}
+}
+
+func NewRing(iface string, bufferSize int, flags Flag) (*Ring, error) {
+	return &Ring{}, fmt.Errorf("PF_RING not available on this system")
+}
+
+func (r *Ring) Close() error {
+	r.closed = true
+	return nil
+}
+
+func (r *Ring) SetApplicationName(name string) error {
+	return nil
+}
+
+func (r *Ring) SetCluster(id int, clusterType ClusterType) error {
+	return nil
+}
+
+func (r *Ring) SetDirection(direction Direction) error {
+	return nil
+}
+
+func (r *Ring) SetPollWatermark(watermark int) error {
+	return nil
+}
+
+func (r *Ring) SetPollDuration(duration int) error {
+	return nil
+}
+
+func (r *Ring) SetSamplingRate(rate int) error {
+	return nil
+}
+
+func (r *Ring) Enable() error {
+	return fmt.Errorf("PF_RING not available on this system")
+}
+
+func (r *Ring) Disable() error {
+	return nil
+}
+
+func (r *Ring) GetNextPacket() ([]byte, *ExtendedPacketHeader, error) {
+	return nil, nil, fmt.Errorf("PF_RING not available on this system")
+}
+
+func (r *Ring) SendPacket(data []byte) error {
+	return fmt.Errorf("PF_RING not available on this system")
+}
+
+func (r *Ring) Stats() (Stats, error) {
+	return Stats{}, fmt.Errorf("PF_RING not available on this system")
+}
+
+func (r *Ring) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	return nil, gopacket.CaptureInfo{}, fmt.Errorf("PF_RING not available on this system")
+}
+
+func (r *Ring) ReadPacketDataExtended() (ExtendedPacketHeader, error) {
+	return ExtendedPacketHeader{}, fmt.Errorf("PF_RING not available on this system")
+}
+
+func (r *Ring) WritePacketData(data []byte) error {
+	return fmt.Errorf("PF_RING not available on this system")
+}
+
+func (r *Ring) SetSocketMode(mode int) error {
+	return nil
+}
+
+// pfRingIO is the PacketIO for the "pfring" backend
+type pfRingIO struct {
+	ring *Ring
+}
+
+func openPFRing(cfg *ClientGenConfig, dir PacketIODirection, wkr int) (PacketIO, error) {
+	var p *pfRingIO
+	var err error
+	if dir == PacketIOTX {
+		p, err = openPFRingTX(cfg.Iface)
+	} else {
+		p, err = openPFRingRX(cfg.Iface)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// pfRingClusterID is the RX cluster of iface, cluster 1 if the interface can't be looked up
+func pfRingClusterID(iface string) int {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return 1
+	}
+	return ifi.Index
+}
+
+func openPFRingRX(iface string) (*pfRingIO, error) {
+	var ring *Ring
+	var err error
+	// 1<<24 is PF_RING_DISCARD_INJECTED_PKTS , if you transmit a packet via the ring, doesn't read it back
+	// Добавляем флаги для поддержки hardware timestamps
+	flags := (1 << 24) | FlagPromisc | FlagHWTimestamp | FlagLongHeader
+	if ring, err = NewRing(iface, 65536, flags); err != nil {
+		return nil, fmt.Errorf("pfring ring creation error: %w", err)
+	}
+	p := &pfRingIO{ring: ring}
+
+	// Устанавливаем размер буфера для улучшения производительности
+	if err = ring.SetApplicationName("clientgen"); err != nil {
+		log.Warnf("pfring SetApplicationName error: %v", err)
+	}
+
+	// one cluster per interface so several interfaces don't share RX workers. balance by the IP pair only,
+	// the ports differ between Sync and FollowUp and both must reach the same worker
+	if err = ring.SetCluster(pfRingClusterID(iface), ClusterPerFlow2Tuple); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("pfring SetCluster error: %w", err)
+	}
+	if err = ring.SetDirection(ReceiveOnly); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("pfring failed to set direction: %w", err)
+	}
+	// Оптимизируем параметры для низкой задержки
+	if err = ring.SetPollWatermark(1); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("pfring failed to set poll watermark: %w", err)
+	}
+	if err = ring.SetPollDuration(0); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("pfring failed to set poll duration: %w", err)
+	}
+	if err = ring.SetSamplingRate(1); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("pfring failed to set sample rate: %w", err)
+	}
+	// only using read for now
+	if err = ring.SetSocketMode(0); err != nil { // ReadOnly = 0
+		p.Close()
+		return nil, fmt.Errorf("pfring SetSocketMode error: %w", err)
+	} else if err = ring.Enable(); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("pfring Enable error: %w", err)
+	}
+	return p, nil
+}
+
+// PFring doesn't implement TX timestamps actually
+// API documentation lists it, but at a low level, its not actually used
+func openPFRingTX(iface string) (*pfRingIO, error) {
+	var txRing *Ring
+	var err error
+	// Флаги для TX: без DISCARD_INJECTED_PKTS чтобы можно было читать свои пакеты для timestamp
+	txFlags := FlagPromisc | FlagHWTimestamp | FlagLongHeader
+	if txRing, err = NewRing(iface, 65536, txFlags); err != nil {
+		return nil, fmt.Errorf("pfring TX ring creation error: %w", err)
+	}
+	p := &pfRingIO{ring: txRing}
+
+	if err = txRing.SetApplicationName("clientgen-tx"); err != nil {
+		log.Warnf("pfring TX SetApplicationName error: %v", err)
+	}
+
+	if err = txRing.SetDirection(1); err != nil { // TransmitOnly = 1
+		p.Close()
+		return nil, fmt.Errorf("pfring TX failed to set direction: %w", err)
+	}
+
+	if err = txRing.SetSocketMode(1); err != nil { // WriteOnly = 1
+		p.Close()
+		return nil, fmt.Errorf("pfring TX SetSocketMode error: %w", err)
+	}
+
+	if err = txRing.Enable(); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("pfring TX Enable error: %w", err)
+	}
+	return p, nil
+}
+
+// ReadPacket read errors from the ring are treated as nothing received
+func (p *pfRingIO) ReadPacket() ([]byte, time.Time, bool, error) {
+	data, ci, err := p.ring.ReadPacketData()
+	if err != nil || len(data) == 0 {
+		return nil, time.Time{}, false, nil
+	}
+	// Получаем расширенную информацию о пакете для hardware timestamps
+	pktHdr, err := p.ring.ReadPacketDataExtended()
+	if err == nil && pktHdr.Timestamp.Sec > 0 {
+		// Используем hardware timestamp если доступен
+		return data, time.Unix(int64(pktHdr.Timestamp.Sec), int64(pktHdr.Timestamp.Nsec)), true, nil
+	}
+	return data, ci.Timestamp, false, nil
+}
+
+func (p *pfRingIO) WritePacket(data []byte) error {
+	return p.ring.WritePacketData(data)
+}
+
+func (p *pfRingIO) Stats() (Stats, error) {
+	return p.ring.Stats()
+}
+
+func (p *pfRingIO) Close() error {
+	return p.ring.Close()
+}
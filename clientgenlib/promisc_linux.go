@@ -0,0 +1,63 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"fmt"
+	"net"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// startPromisc puts Iface in promiscuous mode while the run lasts when clients have their own MACs
+// the membership belongs to a packet socket, so the kernel drops it when the socket closes
+// PF_RING rings are opened promiscuous already, the other backends don't read from the NIC
+func startPromisc(cfg *ClientGenConfig) error {
+	if !cfg.PerClientMAC || (cfg.IOBackend != IOBackendAFPacket && cfg.IOBackend != IOBackendAFXDP) {
+		return nil
+	}
+	fd, err := openPromisc(cfg.Iface)
+	if err != nil {
+		log.Errorf("Failed to set promiscuous mode: %v", err)
+		return err
+	}
+	cfg.Eg.Go(func() error {
+		<-(*cfg.Ctx).Done()
+		unix.Close(fd)
+		return nil
+	})
+	return nil
+}
+
+func openPromisc(iface string) (int, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return -1, fmt.Errorf("promisc interface %s: %w", iface, err)
+	}
+	// protocol 0 so the socket itself never receives anything
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, 0)
+	if err != nil {
+		return -1, fmt.Errorf("promisc socket: %w", err)
+	}
+	mreq := unix.PacketMreq{Ifindex: int32(ifi.Index), Type: unix.PACKET_MR_PROMISC}
+	if err = unix.SetsockoptPacketMreq(fd, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, &mreq); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("promisc %s PACKET_ADD_MEMBERSHIP: %w", iface, err)
+	}
+	return fd, nil
+}
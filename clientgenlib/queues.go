@@ -0,0 +1,265 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// internal queues, names for QueueOverflow and the queue stats
+const (
+	QueueRawOutput      = "rawOutput"      // packets to the TX workers
+	QueueRawInput       = "rawInput"       // frames from the RX workers and TX timestamp readers to the packet parsers
+	QueuePktToProc      = "pktToProc"      // parsed packets to the packet processors
+	QueueRetransmitHeap = "retransmitHeap" // retransmit timer updates to the client retransmit processors
+	QueueRestartHeap    = "restartHeap"    // restart timer updates to the client restart processors
+)
+
+// what a send to a full queue does
+const (
+	OverflowBlock      = "block"       // wait for room, the default
+	OverflowDropNewest = "drop-newest" // drop what is being sent
+	OverflowDropOldest = "drop-oldest" // drop the oldest queued entry to make room
+)
+
+// queueStats is the telemetry and overflow policy of one queue channel
+type queueStats struct {
+	name     string
+	index    int
+	capacity int
+	policy   string
+	depth    func() int // current length of the channel
+
+	highWater uint64 // deepest it has been
+	blocked   uint64 // sends that found it full
+	blockedNs uint64 // time spent waiting for room
+	dropped   uint64
+}
+
+// queuePolicy checks QueueOverflow and returns the policy of queue name
+func queuePolicy(cfg *ClientGenConfig, name string) (string, error) {
+	policy, ok := cfg.QueueOverflow[name]
+	if !ok || policy == "" {
+		return OverflowBlock, nil
+	}
+	switch policy {
+	case OverflowBlock:
+		return policy, nil
+	case OverflowDropNewest, OverflowDropOldest:
+		// a lost timer update leaves its client stuck forever
+		if name == QueueRetransmitHeap || name == QueueRestartHeap {
+			return "", fmt.Errorf("queue %s can only block, dropping loses clients", name)
+		}
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown overflow policy %q for queue %s", policy, name)
+}
+
+// checkQueueOverflow makes sure every QueueOverflow entry names a queue and a policy
+func checkQueueOverflow(cfg *ClientGenConfig) error {
+	for name := range cfg.QueueOverflow {
+		switch name {
+		case QueueRawOutput, QueueRawInput, QueuePktToProc, QueueRetransmitHeap, QueueRestartHeap:
+		default:
+			return fmt.Errorf("unknown queue %q in QueueOverflow", name)
+		}
+		if _, err := queuePolicy(cfg, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newQueueStats registers the stats of channel index of queue name, checkQueueOverflow must have passed
+func newQueueStats(cfg *ClientGenConfig, name string, index int, capacity int, depth func() int) *queueStats {
+	policy, _ := queuePolicy(cfg, name)
+	q := &queueStats{name: name, index: index, capacity: capacity, policy: policy, depth: depth}
+	cfg.RunData.queues = append(cfg.RunData.queues, q)
+	return q
+}
+
+// sent records a send that went in right away, depth is the length after it
+func (q *queueStats) sent(depth int) {
+	atomicMaxUint64(&q.highWater, uint64(depth))
+}
+
+// full records a send that found the queue full
+func (q *queueStats) full(cfg *ClientGenConfig) {
+	atomicMaxUint64(&q.highWater, uint64(q.capacity))
+	atomic.AddUint64(&q.blocked, 1)
+	atomic.AddUint64(&cfg.Counters.TotalQueueFull, 1)
+}
+
+// waited records the time a blocking send waited for room
+func (q *queueStats) waited(cfg *ClientGenConfig, d time.Duration) {
+	atomic.AddUint64(&q.blockedNs, uint64(d))
+	atomic.AddUint64(&cfg.Counters.TotalQueueBlockedUs, uint64(d/time.Microsecond))
+}
+
+// drop records an entry lost to the overflow policy
+func (q *queueStats) drop(cfg *ClientGenConfig) {
+	atomic.AddUint64(&q.dropped, 1)
+	atomic.AddUint64(&cfg.Counters.TotalQueueDropped, 1)
+}
+
+// sendRawOutput queues out for a TX worker
+func sendRawOutput(cfg *ClientGenConfig, out *outPacket) {
+	i := getTxChanNumToUse(cfg)
+	ch, q := cfg.RunData.rawOutput[i], cfg.RunData.rawOutputStats[i]
+	for {
+		select {
+		case ch <- out:
+			q.sent(len(ch))
+			return
+		default:
+		}
+		q.full(cfg)
+		switch q.policy {
+		case OverflowDropNewest:
+			q.drop(cfg)
+			cfg.RunData.outPacketPool.Put(out)
+			return
+		case OverflowDropOldest:
+			select {
+			case old := <-ch:
+				q.drop(cfg)
+				cfg.RunData.outPacketPool.Put(old)
+			default:
+			}
+		default:
+			start := time.Now()
+			select {
+			case ch <- out:
+			case <-(*cfg.Ctx).Done():
+				// the TX worker is gone
+				cfg.RunData.outPacketPool.Put(out)
+			}
+			q.waited(cfg, time.Since(start))
+			return
+		}
+	}
+}
+
+// releaseInPacket puts in and the frame read back from TX back into their pools
+func releaseInPacket(cfg *ClientGenConfig, in *inPacket) {
+	if in.fromTX {
+		cfg.RunData.bytePool.Put(in.data)
+	}
+	cfg.RunData.inPacketPool.Put(in)
+}
+
+// sendRawInput queues in for a packet parser
+func sendRawInput(cfg *ClientGenConfig, in *inPacket) {
+	i := getRxChanNumToUse(cfg, in)
+	ch, q := cfg.RunData.rawInput[i], cfg.RunData.rawInputStats[i]
+	for {
+		select {
+		case ch <- in:
+			q.sent(len(ch))
+			return
+		default:
+		}
+		q.full(cfg)
+		switch q.policy {
+		case OverflowDropNewest:
+			q.drop(cfg)
+			releaseInPacket(cfg, in)
+			return
+		case OverflowDropOldest:
+			select {
+			case old := <-ch:
+				q.drop(cfg)
+				releaseInPacket(cfg, old)
+			default:
+			}
+		default:
+			start := time.Now()
+			select {
+			case ch <- in:
+			case <-(*cfg.Ctx).Done():
+				releaseInPacket(cfg, in)
+			}
+			q.waited(cfg, time.Since(start))
+			return
+		}
+	}
+}
+
+// releaseDecoder puts in and the frame read back from TX back into their pools
+func releaseDecoder(cfg *ClientGenConfig, in *PktDecoder) {
+	if in.fromTX {
+		cfg.RunData.bytePool.Put(in.rawData)
+	}
+	cfg.RunData.goPacketDecoderPool.Put(in)
+}
+
+// sendPktToProc queues in for a packet processor
+func sendPktToProc(cfg *ClientGenConfig, in *PktDecoder) {
+	i := getPacketProcChanNumToUse(cfg, in)
+	ch, q := cfg.RunData.pktToProc[i], cfg.RunData.pktToProcStats[i]
+	for {
+		select {
+		case ch <- in:
+			q.sent(len(ch))
+			return
+		default:
+		}
+		q.full(cfg)
+		switch q.policy {
+		case OverflowDropNewest:
+			q.drop(cfg)
+			releaseDecoder(cfg, in)
+			return
+		case OverflowDropOldest:
+			select {
+			case old := <-ch:
+				q.drop(cfg)
+				releaseDecoder(cfg, old)
+			default:
+			}
+		default:
+			start := time.Now()
+			select {
+			case ch <- in:
+			case <-(*cfg.Ctx).Done():
+				releaseDecoder(cfg, in)
+			}
+			q.waited(cfg, time.Since(start))
+			return
+		}
+	}
+}
+
+// sendHeapOp queues op for the processor of h, heap queues always block until the run is over
+func sendHeapOp(cfg *ClientGenConfig, h *parallelHeap, op *parallelHeapOp) {
+	select {
+	case h.operationChan <- op:
+		h.stats.sent(len(h.operationChan))
+		return
+	default:
+	}
+	h.stats.full(cfg)
+	start := time.Now()
+	select {
+	case h.operationChan <- op:
+	case <-(*cfg.Ctx).Done():
+		cfg.RunData.heapOpPool.Put(op)
+	}
+	h.stats.waited(cfg, time.Since(start))
+}
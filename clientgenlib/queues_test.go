@@ -0,0 +1,97 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_checkQueueOverflow(t *testing.T) {
+	cfg := &ClientGenConfig{}
+	require.NoError(t, checkQueueOverflow(cfg))
+	cfg.QueueOverflow = map[string]string{QueueRawOutput: OverflowDropNewest, QueuePktToProc: OverflowDropOldest, QueueRestartHeap: OverflowBlock}
+	require.NoError(t, checkQueueOverflow(cfg))
+	cfg.QueueOverflow = map[string]string{"txQueue": OverflowBlock}
+	require.Error(t, checkQueueOverflow(cfg))
+	cfg.QueueOverflow = map[string]string{QueueRawInput: "drop"}
+	require.Error(t, checkQueueOverflow(cfg))
+	cfg.QueueOverflow = map[string]string{QueueRetransmitHeap: OverflowDropNewest}
+	require.Error(t, checkQueueOverflow(cfg))
+}
+
+// queueTestConfig has one rawOutput queue of capacity 1 with policy
+func queueTestConfig(policy string) *ClientGenConfig {
+	ctx := context.Background()
+	cfg := &ClientGenConfig{NumTXWorkers: 1, QueueOverflow: map[string]string{QueueRawOutput: policy}, Ctx: &ctx}
+	cfg.RunData = &ClientGenData{}
+	cfg.RunData.outPacketPool.New = func() interface{} { return new(outPacket) }
+	ch := make(chan *outPacket, 1)
+	cfg.RunData.rawOutput = []chan *outPacket{ch}
+	cfg.RunData.rawOutputStats = []*queueStats{newQueueStats(cfg, QueueRawOutput, 0, 1, func() int { return len(ch) })}
+	return cfg
+}
+
+func Test_sendRawOutputOverflow(t *testing.T) {
+	first, second := &outPacket{getTS: true}, &outPacket{}
+
+	cfg := queueTestConfig(OverflowDropNewest)
+	sendRawOutput(cfg, first)
+	sendRawOutput(cfg, second)
+	require.Same(t, first, <-cfg.RunData.rawOutput[0])
+	q := cfg.RunData.queues[0]
+	require.Equal(t, uint64(1), q.dropped)
+	require.Equal(t, uint64(1), q.blocked)
+	require.Equal(t, uint64(1), q.highWater)
+	require.Equal(t, uint64(1), cfg.Counters.TotalQueueDropped)
+
+	cfg = queueTestConfig(OverflowDropOldest)
+	sendRawOutput(cfg, first)
+	sendRawOutput(cfg, second)
+	require.Same(t, second, <-cfg.RunData.rawOutput[0])
+	require.Equal(t, uint64(1), cfg.RunData.queues[0].dropped)
+
+	cfg = queueTestConfig(OverflowBlock)
+	sendRawOutput(cfg, first)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		<-cfg.RunData.rawOutput[0]
+	}()
+	sendRawOutput(cfg, second)
+	require.Same(t, second, <-cfg.RunData.rawOutput[0])
+	q = cfg.RunData.queues[0]
+	require.Zero(t, q.dropped)
+	require.Equal(t, uint64(1), q.blocked)
+	require.GreaterOrEqual(t, time.Duration(q.blockedNs), 10*time.Millisecond)
+	require.NotZero(t, cfg.Counters.TotalQueueBlockedUs)
+
+	// a blocked send gives up once the run is over, the TX worker is gone
+	cfg = queueTestConfig(OverflowBlock)
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg.Ctx = &ctx
+	sendRawOutput(cfg, first)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	sendRawOutput(cfg, second)
+	require.Same(t, first, <-cfg.RunData.rawOutput[0])
+	require.Equal(t, uint64(1), cfg.RunData.queues[0].blocked)
+}
@@ -106,3 +106,21 @@ func reqDelay(clockID ptp.ClockIdentity) *ptp.SyncDelayReq {
 		},
 	}
 }
+
+// reqPDelay is a helper to build ptp.PDelayReq
+func reqPDelay(clockID ptp.ClockIdentity) *ptp.PDelayReq {
+	return &ptp.PDelayReq{
+		Header: ptp.Header{
+			SdoIDAndMsgType: ptp.NewSdoIDAndMsgType(ptp.MessagePDelayReq, 0),
+			Version:         ptp.Version,
+			SequenceID:      0, // will be populated on sending
+			MessageLength:   uint16(binary.Size(ptp.PDelayReq{})),
+			FlagField:       ptp.FlagUnicast,
+			SourcePortIdentity: ptp.PortIdentity{
+				PortNumber:    1,
+				ClockIdentity: clockID,
+			},
+			LogMessageInterval: 0x7f,
+		},
+	}
+}
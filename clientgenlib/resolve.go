@@ -0,0 +1,224 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	log "github.com/sirupsen/logrus"
+)
+
+const serverMACResolveTimeout = 1 * time.Second
+const serverMACResolveTries = 3
+const defaultServerMACRefreshSec = 60
+
+// macResolver finds the MAC the client packets go to with ARP / NDP when ServerMAC isn't set
+type macResolver struct {
+	target   net.IP           // ServerAddress, or Gateway when the GM is routed
+	cl       *SingleClientGen // the requests come from this client
+	resolved chan struct{}    // closed on the first answer
+	once     sync.Once
+}
+
+// serverMAC is the destination MAC of the client packets
+func serverMAC(cfg *ClientGenConfig) net.HardwareAddr {
+	if mac, ok := cfg.RunData.serverMAC.Load().(net.HardwareAddr); ok {
+		return mac
+	}
+	return cfg.parsedServerMac
+}
+
+// setupServerMACResolver makes the resolver when ServerMAC isn't set, before the workers can see answers
+func setupServerMACResolver(cfg *ClientGenConfig) error {
+	// the kernel does its own ARP / NDP for its sockets
+	if cfg.ServerMAC != "" || cfg.IOBackend == IOBackendKernel {
+		return nil
+	}
+	r, err := newMACResolver(cfg)
+	if err != nil {
+		return err
+	}
+	cfg.RunData.resolver = r
+	return nil
+}
+
+// startServerMACResolver resolves the server MAC before the clients start
+// and keeps resolving it every ServerMACRefreshSec
+func startServerMACResolver(cfg *ClientGenConfig) error {
+	r := cfg.RunData.resolver
+	if r == nil {
+		return nil
+	}
+	var err error
+	resolved := false
+	for try := 0; try < serverMACResolveTries && !resolved; try++ {
+		r.send(cfg)
+		select {
+		case <-r.resolved:
+			resolved = true
+		case <-time.After(serverMACResolveTimeout):
+		case <-(*cfg.Ctx).Done():
+			return (*cfg.Ctx).Err()
+		}
+	}
+	if !resolved {
+		err = fmt.Errorf("no answer for %v from client %v", r.target, r.cl.ClientIP)
+		log.Errorf("Failed to resolve server MAC: %v", err)
+		return err
+	}
+
+	refresh := cfg.ServerMACRefreshSec
+	if refresh == 0 {
+		refresh = defaultServerMACRefreshSec
+	}
+	cfg.Eg.Go(func() error {
+		ticker := time.NewTicker(time.Duration(refresh * float64(time.Second)))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.send(cfg)
+			case <-(*cfg.Ctx).Done():
+				log.Infof("Server MAC resolver done due to context")
+				return (*cfg.Ctx).Err()
+			}
+		}
+	})
+	return nil
+}
+
+func newMACResolver(cfg *ClientGenConfig) (*macResolver, error) {
+	addr := cfg.ServerAddress
+	if cfg.Gateway != "" {
+		addr = cfg.Gateway
+	}
+	target := net.ParseIP(addr)
+	if target == nil {
+		return nil, fmt.Errorf("bad address to resolve %q", addr)
+	}
+	r := &macResolver{target: target, resolved: make(chan struct{})}
+	// ask from a client of the same IP version
+	for i := range cfg.RunData.clients {
+		cl := &cfg.RunData.clients[i]
+		if (cl.ClientIP.To4() == nil) == (target.To4() == nil) {
+			r.cl = cl
+			break
+		}
+	}
+	if r.cl == nil {
+		return nil, fmt.Errorf("no client with the IP version of %v", target)
+	}
+	return r, nil
+}
+
+// send queues an ARP request or a Neighbor Solicitation for the target
+func (r *macResolver) send(cfg *ClientGenConfig) {
+	out := cfg.RunData.outPacketPool.Get().(*outPacket)
+	out.cl = nil
+	out.getTS = false
+	out.pktType = pktIgnore
+	var err error
+	if r.target.To4() != nil {
+		err = serializeARPRequest(cfg, r.cl, r.target, out)
+	} else {
+		err = serializeNeighborSolicitation(cfg, r.cl, r.target, out)
+	}
+	if err != nil {
+		log.Errorf("Server MAC resolver serialize err %v", err)
+		cfg.RunData.outPacketPool.Put(out)
+		return
+	}
+	atomic.AddUint64(&cfg.Counters.ServerMACResolveSent, 1)
+	sendRawOutput(cfg, out)
+}
+
+// answer takes the MAC from an ARP reply or Neighbor Advertisement for ip in the VLAN of the asking
+// client, anything else is ignored
+func (r *macResolver) answer(cfg *ClientGenConfig, ip net.IP, vlan uint16, mac net.HardwareAddr) {
+	if !ip.Equal(r.target) || vlan != r.cl.vlan || len(mac) != 6 {
+		return
+	}
+	atomic.AddUint64(&cfg.Counters.ServerMACResolved, 1)
+	old := serverMAC(cfg)
+	if !bytes.Equal(old, mac) {
+		if old != nil {
+			atomic.AddUint64(&cfg.Counters.ServerMACChanges, 1)
+			log.Warnf("Server MAC of %v changed from %v to %v", r.target, old, mac)
+		} else {
+			log.Infof("Resolved server MAC of %v to %v", r.target, mac)
+		}
+		cfg.RunData.serverMAC.Store(append(net.HardwareAddr(nil), mac...))
+	}
+	r.once.Do(func() { close(r.resolved) })
+}
+
+func serializeARPRequest(cfg *ClientGenConfig, cl *SingleClientGen, target net.IP, out *outPacket) error {
+	eth := layers.Ethernet{
+		SrcMAC:       cl.mac,
+		DstMAC:       layers.EthernetBroadcast,
+		EthernetType: layers.EthernetTypeARP,
+	}
+	arp := layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPRequest,
+		SourceHwAddress:   cl.mac,
+		SourceProtAddress: cl.ClientIP.To4(),
+		DstHwAddress:      make([]byte, 6),
+		DstProtAddress:    target.To4(),
+	}
+	return serializeFrame(*out.data, cfg.RunData.commonSerializeOp, &eth, cl.vlan, cl.pcp, &arp)
+}
+
+func serializeNeighborSolicitation(cfg *ClientGenConfig, cl *SingleClientGen, target net.IP, out *outPacket) error {
+	// solicited-node multicast address of the target and its MAC
+	t := target.To16()
+	dst := net.IP{0xff, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0xff, t[13], t[14], t[15]}
+	eth := layers.Ethernet{
+		SrcMAC:       cl.mac,
+		DstMAC:       net.HardwareAddr{0x33, 0x33, 0xff, t[13], t[14], t[15]},
+		EthernetType: layers.EthernetTypeIPv6,
+	}
+	ip := layers.IPv6{
+		SrcIP:      cl.ClientIP,
+		DstIP:      dst,
+		Version:    6,
+		HopLimit:   255,
+		NextHeader: layers.IPProtocolICMPv6,
+	}
+	icmp := layers.ICMPv6{
+		TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborSolicitation, 0),
+	}
+	ns := layers.ICMPv6NeighborSolicitation{
+		TargetAddress: target,
+		Options: []layers.ICMPv6Option{
+			{Type: layers.ICMPv6OptSourceAddress, Data: cl.mac},
+		},
+	}
+	if err := icmp.SetNetworkLayerForChecksum(&ip); err != nil {
+		return err
+	}
+	return serializeFrame(*out.data, cfg.RunData.commonSerializeOp, &eth, cl.vlan, cl.pcp, &ip, &icmp, &ns)
+}
@@ -0,0 +1,74 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_macResolverAnswer(t *testing.T) {
+	cfg := &ClientGenConfig{RunData: &ClientGenData{}}
+	cl := &SingleClientGen{vlan: 100}
+	r := &macResolver{target: net.ParseIP("10.0.0.1"), cl: cl, resolved: make(chan struct{})}
+	mac1 := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	mac2 := net.HardwareAddr{2, 0, 0, 0, 0, 2}
+
+	// not the target, nothing happens
+	r.answer(cfg, net.ParseIP("10.0.0.2"), 100, mac1)
+	require.Nil(t, serverMAC(cfg))
+	require.Zero(t, cfg.Counters.ServerMACResolved)
+	// nor the target in another VLAN than the asking client's
+	r.answer(cfg, net.ParseIP("10.0.0.1"), 0, mac1)
+	require.Nil(t, serverMAC(cfg))
+	require.Zero(t, cfg.Counters.ServerMACResolved)
+
+	r.answer(cfg, net.ParseIP("10.0.0.1"), 100, mac1)
+	require.Equal(t, mac1, serverMAC(cfg))
+	<-r.resolved
+	r.answer(cfg, net.ParseIP("10.0.0.1"), 100, mac1)
+	require.Zero(t, cfg.Counters.ServerMACChanges)
+	r.answer(cfg, net.ParseIP("10.0.0.1"), 100, mac2)
+	require.Equal(t, mac2, serverMAC(cfg))
+	require.Equal(t, uint64(3), cfg.Counters.ServerMACResolved)
+	require.Equal(t, uint64(1), cfg.Counters.ServerMACChanges)
+}
+
+func Test_newMACResolver(t *testing.T) {
+	cfg := loopbackTestConfig("2001:db8::1:1", "2001:db8::1:4", "10.0.0.1")
+	cfg.RunData = &ClientGenData{clients: []SingleClientGen{
+		{ClientIP: net.ParseIP("2001:db8::1:1")},
+		{ClientIP: net.ParseIP("10.0.1.1")},
+	}}
+	r, err := newMACResolver(cfg)
+	require.NoError(t, err)
+	require.Equal(t, net.ParseIP("10.0.0.1"), r.target)
+	require.Equal(t, &cfg.RunData.clients[1], r.cl)
+
+	// routed, the gateway is resolved instead of the GM
+	cfg.Gateway = "2001:db8::fffe"
+	r, err = newMACResolver(cfg)
+	require.NoError(t, err)
+	require.Equal(t, net.ParseIP("2001:db8::fffe"), r.target)
+	require.Equal(t, &cfg.RunData.clients[0], r.cl)
+
+	cfg.Gateway = "router"
+	_, err = newMACResolver(cfg)
+	require.Error(t, err)
+}
@@ -0,0 +1,309 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// sizes of the frame templates and of the PTP messages the clients send
+const (
+	maxTemplateHeader  = 14 + 4 + 40 + 8 // Ethernet, 802.1Q tag, IPv6, UDP
+	minFrameSize       = 60              // without FCS, shorter frames are padded
+	maxPayloadTemplate = 128             // room for an AUTHENTICATION TLV with a full HMAC-SHA256 ICV
+	ptpClockIDOffset   = 20              // SourcePortIdentity.ClockIdentity in the PTP header
+	ptpSequenceOffset  = 30
+	udpHeaderSize      = 8
+	ipv4HeaderSize     = 20
+	ipv6PayloadLenOffs = 4
+)
+
+// frameTemplate is the prebuilt Ethernet / 802.1Q / IP / UDP header of one client's packets to the GM
+// sending copies it in front of the PTP message and patches the server MAC, lengths, ports and checksums
+type frameTemplate struct {
+	hdr    [maxTemplateHeader]byte
+	hdrLen uint8
+	ipOff  uint8 // 0 for the "l2" Transport, no IP or UDP
+	udpOff uint8
+	v6     bool
+	ipSum  uint32 // IPv4 header sum without the total length
+	udpSum uint32 // pseudo header sum without the UDP length and ports
+}
+
+// ptpTemplate is a PTP message as ptp.Bytes makes it, patched per send where it's copied to
+type ptpTemplate struct {
+	b [maxPayloadTemplate]byte
+	n int
+
+	// with AuthKeyFile the message ends with an AUTHENTICATION TLV, signed again on every patch
+	key    *ptp.AuthKey
+	badICV bool
+}
+
+// payloadTemplates are the PTP messages the clients send, built once for the run
+// only the clock identity, the sequence ID and the ICV change per send
+type payloadTemplates struct {
+	delayReq  ptpTemplate
+	pdelayReq ptpTemplate
+	grantReq  map[ptp.MessageType]*ptpTemplate
+}
+
+// newPTPTemplate makes the template of msg, with an AUTHENTICATION TLV if auth isn't nil
+func newPTPTemplate(msg ptp.Packet, auth *authConfig) (*ptpTemplate, error) {
+	b, err := ptp.Bytes(msg)
+	if err != nil {
+		return nil, err
+	}
+	t := &ptpTemplate{}
+	if auth != nil {
+		if b, err = ptp.AppendAuthenticationTLV(b, auth.spp, auth.sign); err != nil {
+			return nil, err
+		}
+		t.key, t.badICV = auth.sign, auth.badICV
+	}
+	if len(b) > maxPayloadTemplate {
+		return nil, fmt.Errorf("%d bytes, more than %d", len(b), maxPayloadTemplate)
+	}
+	t.n = len(b)
+	copy(t.b[:], b)
+	return t, nil
+}
+
+// put copies the message to dst, sets the clock identity and the sequence ID there and returns it
+// the template itself is shared by all the clients and never changes
+func (t *ptpTemplate) put(dst []byte, clockID ptp.ClockIdentity, seq uint16) []byte {
+	b := dst[:t.n]
+	copy(b, t.b[:t.n])
+	binary.BigEndian.PutUint64(b[ptpClockIDOffset:], uint64(clockID))
+	binary.BigEndian.PutUint16(b[ptpSequenceOffset:], seq)
+	if t.key != nil {
+		// can't fail, the template was signed when it was made
+		_ = ptp.SignAuthenticationTLV(b, t.key)
+		if t.badICV {
+			b[t.n-1] ^= 0xff
+		}
+	}
+	return b
+}
+
+// setupTemplates builds the payload templates of cfg and the frame template of every client
+func setupTemplates(cfg *ClientGenConfig) error {
+	delayReq, err := newPTPTemplate(reqDelay(0), cfg.auth)
+	if err != nil {
+		return fmt.Errorf("DelayReq template: %w", err)
+	}
+	cfg.RunData.payloads.delayReq = *delayReq
+	pdelayReq, err := newPTPTemplate(reqPDelay(0), cfg.auth)
+	if err != nil {
+		return fmt.Errorf("PDelayReq template: %w", err)
+	}
+	cfg.RunData.payloads.pdelayReq = *pdelayReq
+	cfg.RunData.payloads.grantReq = map[ptp.MessageType]*ptpTemplate{}
+	for _, what := range []ptp.MessageType{ptp.MessageAnnounce, ptp.MessageSync, ptp.MessageDelayResp, ptp.MessagePDelayResp} {
+		tmpl, err := newPTPTemplate(reqUnicast(0, time.Duration(float64(time.Second)*cfg.DurationSec), what), cfg.auth)
+		if err != nil {
+			return fmt.Errorf("%s grant request template: %w", what, err)
+		}
+		cfg.RunData.payloads.grantReq[what] = tmpl
+	}
+
+	if cfg.Transport != TransportL2 {
+		cfg.RunData.serverIP = net.ParseIP(cfg.ServerAddress)
+		if cfg.RunData.serverIP == nil {
+			return fmt.Errorf("bad ServerAddress %q", cfg.ServerAddress)
+		}
+	}
+	for i := range cfg.RunData.clients {
+		cl := &cfg.RunData.clients[i]
+		if err = cl.tmpl.build(cfg, cl); err != nil {
+			return fmt.Errorf("client %v frame template: %w", cl.ClientIP, err)
+		}
+	}
+	return nil
+}
+
+// build makes t the header of the packets of cl, the server MAC is filled in on every send
+func (t *frameTemplate) build(cfg *ClientGenConfig, cl *SingleClientGen) error {
+	eth := layers.Ethernet{
+		SrcMAC: cl.mac,
+		DstMAC: make(net.HardwareAddr, 6),
+	}
+	hdrLen := 14
+	if cl.vlan != 0 {
+		hdrLen += 4
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{}
+	var err error
+	if cfg.Transport == TransportL2 {
+		eth.EthernetType = ethernetTypePTP
+		err = serializeFrame(buf, opts, &eth, cl.vlan, cl.pcp)
+	} else {
+		t.ipOff = uint8(hdrLen)
+		server := cfg.RunData.serverIP
+		udp := layers.UDP{}
+		if cl.ClientIP.To4() == nil {
+			if server.To4() != nil {
+				return fmt.Errorf("IPv6 client with IPv4 ServerAddress %v", server)
+			}
+			t.v6 = true
+			hdrLen += 40
+			eth.EthernetType = layers.EthernetTypeIPv6
+			ip := layers.IPv6{
+				SrcIP:      cl.ClientIP,
+				DstIP:      server,
+				Version:    6,
+				HopLimit:   255,
+				NextHeader: layers.IPProtocolUDP,
+			}
+			err = serializeFrame(buf, opts, &eth, cl.vlan, cl.pcp, &ip, &udp)
+		} else {
+			if server.To4() == nil {
+				return fmt.Errorf("IPv4 client with IPv6 ServerAddress %v", server)
+			}
+			t.v6 = false
+			hdrLen += ipv4HeaderSize
+			eth.EthernetType = layers.EthernetTypeIPv4
+			ip := layers.IPv4{
+				SrcIP:    cl.ClientIP,
+				DstIP:    server,
+				Version:  4,
+				IHL:      ipv4HeaderSize / 4,
+				TTL:      255,
+				Protocol: layers.IPProtocolUDP,
+			}
+			err = serializeFrame(buf, opts, &eth, cl.vlan, cl.pcp, &ip, &udp)
+		}
+		t.udpOff = uint8(hdrLen)
+		hdrLen += udpHeaderSize
+	}
+	if err != nil {
+		return err
+	}
+	t.hdrLen = uint8(hdrLen)
+	copy(t.hdr[:], buf.Bytes()[:hdrLen])
+	if t.udpOff == 0 {
+		return nil
+	}
+
+	ip := t.hdr[t.ipOff:t.udpOff]
+	if t.v6 {
+		t.udpSum = checksumAdd(0, ip[8:40]) // source and destination
+	} else {
+		t.ipSum = checksumAdd(0, ip)
+		t.udpSum = checksumAdd(0, ip[12:20])
+	}
+	t.udpSum += uint32(layers.IPProtocolUDP)
+	return nil
+}
+
+// write puts the frame with payload from port to port of the GM at dst into buf
+func (t *frameTemplate) write(buf gopacket.SerializeBuffer, dst net.HardwareAddr, port uint16, payload []byte) error {
+	b, err := t.frame(buf, dst, len(payload))
+	if err != nil {
+		return err
+	}
+	copy(b[t.hdrLen:], payload)
+	t.setUDP(b, port, payload)
+	return nil
+}
+
+// writePTP is write with the message of p, patched in buf rather than in a copy of p
+func (t *frameTemplate) writePTP(buf gopacket.SerializeBuffer, dst net.HardwareAddr, port uint16, p *ptpTemplate, clockID ptp.ClockIdentity, seq uint16) error {
+	b, err := t.frame(buf, dst, p.n)
+	if err != nil {
+		return err
+	}
+	t.setUDP(b, port, p.put(b[t.hdrLen:], clockID, seq))
+	return nil
+}
+
+// frame puts the headers to dst into buf, with room for n bytes of payload
+func (t *frameTemplate) frame(buf gopacket.SerializeBuffer, dst net.HardwareAddr, n int) ([]byte, error) {
+	if err := buf.Clear(); err != nil {
+		return nil, err
+	}
+	n += int(t.hdrLen)
+	size := n
+	if size < minFrameSize {
+		size = minFrameSize
+	}
+	b, err := buf.AppendBytes(size)
+	if err != nil {
+		return nil, err
+	}
+	copy(b, t.hdr[:t.hdrLen])
+	copy(b, dst)
+	for i := n; i < size; i++ {
+		b[i] = 0
+	}
+	return b, nil
+}
+
+// setUDP fills in the lengths, ports and checksums of frame b with payload
+func (t *frameTemplate) setUDP(b []byte, port uint16, payload []byte) {
+	if t.udpOff == 0 {
+		return
+	}
+
+	udpLen := uint32(udpHeaderSize + len(payload))
+	ip := b[t.ipOff:t.udpOff]
+	if t.v6 {
+		binary.BigEndian.PutUint16(ip[ipv6PayloadLenOffs:], uint16(udpLen))
+	} else {
+		binary.BigEndian.PutUint16(ip[2:], uint16(ipv4HeaderSize+udpLen))
+		binary.BigEndian.PutUint16(ip[10:], ^checksumFold(t.ipSum+ipv4HeaderSize+udpLen))
+	}
+	udp := b[t.udpOff:]
+	binary.BigEndian.PutUint16(udp[0:], port)
+	binary.BigEndian.PutUint16(udp[2:], port)
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpLen))
+	// length and ports are in the pseudo header / UDP header twice each
+	sum := checksumAdd(t.udpSum+2*udpLen+2*uint32(port), payload)
+	csum := ^checksumFold(sum)
+	if csum == 0 {
+		csum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:], csum)
+}
+
+// checksumAdd adds data as big endian 16 bit words to the one's complement sum
+func checksumAdd(sum uint32, data []byte) uint32 {
+	n := len(data) &^ 1
+	for i := 0; i < n; i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if n < len(data) {
+		sum += uint32(data[n]) << 8
+	}
+	return sum
+}
+
+// checksumFold folds the carries of sum back into 16 bits
+func checksumFold(sum uint32) uint16 {
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return uint16(sum)
+}
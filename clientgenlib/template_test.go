@@ -0,0 +1,244 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/semaphore"
+)
+
+// serializeToGM is what the clients sent before the templates, every layer through gopacket
+func serializeToGM(t *testing.T, cl *SingleClientGen, dst net.HardwareAddr, server net.IP, port uint16, payload []byte) []byte {
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	buf := gopacket.NewSerializeBuffer()
+	eth := layers.Ethernet{SrcMAC: cl.mac, DstMAC: dst}
+	if server == nil {
+		eth.EthernetType = ethernetTypePTP
+		require.NoError(t, serializeFrame(buf, opts, &eth, cl.vlan, cl.pcp, gopacket.Payload(payload)))
+		return buf.Bytes()
+	}
+	udp := layers.UDP{SrcPort: layers.UDPPort(port), DstPort: layers.UDPPort(port)}
+	var ip gopacket.SerializableLayer
+	if cl.ClientIP.To4() == nil {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip6 := &layers.IPv6{SrcIP: cl.ClientIP, DstIP: server, Version: 6, HopLimit: 255, NextHeader: layers.IPProtocolUDP}
+		require.NoError(t, udp.SetNetworkLayerForChecksum(ip6))
+		ip = ip6
+	} else {
+		eth.EthernetType = layers.EthernetTypeIPv4
+		ip4 := &layers.IPv4{SrcIP: cl.ClientIP, DstIP: server, Version: 4, TTL: 255, Protocol: layers.IPProtocolUDP}
+		require.NoError(t, udp.SetNetworkLayerForChecksum(ip4))
+		ip = ip4
+	}
+	require.NoError(t, serializeFrame(buf, opts, &eth, cl.vlan, cl.pcp, ip, &udp, gopacket.Payload(payload)))
+	return buf.Bytes()
+}
+
+func Test_frameTemplate(t *testing.T) {
+	dst := net.HardwareAddr{0x0c, 0x42, 0xa1, 0x80, 0x31, 0x66}
+	payloads := [][]byte{make([]byte, 44), make([]byte, 56), {0xff, 0xfe, 0x01}}
+	for i := range payloads[1] {
+		payloads[1][i] = byte(i * 37)
+	}
+	for _, tc := range []struct {
+		client    string
+		server    string
+		transport string
+	}{
+		{"10.0.1.1", "10.0.0.1", TransportUDP},
+		{"10.255.255.254", "192.168.254.254", TransportUDP},
+		{"2001:db8::1:1", "2001:db8::1", TransportUDP},
+		{"2001:db8:ffff::ffff", "fe80::1", TransportUDP},
+		{"10.0.1.1", "", TransportL2},
+	} {
+		for _, vlan := range []uint16{0, 100} {
+			cfg := &ClientGenConfig{ServerAddress: tc.server, Transport: tc.transport, RunData: &ClientGenData{}}
+			cl := SingleClientGen{ClientIP: net.ParseIP(tc.client), mac: net.HardwareAddr{2, 0, 0, 0, 0, 9}, vlan: vlan, pcp: 3}
+			cfg.RunData.clients = []SingleClientGen{cl}
+			require.NoError(t, setupTemplates(cfg))
+			tmpl := &cfg.RunData.clients[0].tmpl
+			buf := gopacket.NewSerializeBuffer()
+			for _, port := range []uint16{ptp.PortEvent, ptp.PortGeneral} {
+				for _, payload := range payloads {
+					require.NoError(t, tmpl.write(buf, dst, port, payload))
+					want := serializeToGM(t, &cl, dst, cfg.RunData.serverIP, port, payload)
+					require.Equal(t, want, buf.Bytes(), "client %s vlan %d port %d len %d", tc.client, vlan, port, len(payload))
+				}
+			}
+		}
+	}
+}
+
+func Test_setupTemplatesMismatch(t *testing.T) {
+	cfg := &ClientGenConfig{ServerAddress: "2001:db8::1", RunData: &ClientGenData{}}
+	cfg.RunData.clients = []SingleClientGen{{ClientIP: net.ParseIP("10.0.1.1")}}
+	require.Error(t, setupTemplates(cfg))
+	cfg.ServerAddress = "gm"
+	require.Error(t, setupTemplates(cfg))
+}
+
+func Test_payloadTemplates(t *testing.T) {
+	cfg := &ClientGenConfig{ServerAddress: "10.0.0.1", DurationSec: 300, RunData: &ClientGenData{}}
+	require.NoError(t, setupTemplates(cfg))
+
+	tmpl := cfg.RunData.payloads.grantReq[ptp.MessageSync]
+	want := reqUnicast(ptp.ClockIdentity(42), 300*time.Second, ptp.MessageSync)
+	want.SetSequence(7)
+	wantBytes, err := ptp.Bytes(want)
+	require.NoError(t, err)
+	buf := make([]byte, maxPayloadTemplate)
+	require.Equal(t, wantBytes, tmpl.put(buf, ptp.ClockIdentity(42), 7))
+	// the template is shared, put leaves it alone
+	wantBytes, err = ptp.Bytes(reqUnicast(0, 300*time.Second, ptp.MessageSync))
+	require.NoError(t, err)
+	require.Equal(t, wantBytes, tmpl.b[:tmpl.n])
+
+	wantDelay := reqDelay(0)
+	wantDelay.SetSequence(9)
+	wantBytes, err = ptp.Bytes(wantDelay)
+	require.NoError(t, err)
+	require.Equal(t, wantBytes, cfg.RunData.payloads.delayReq.put(buf, 0, 9))
+}
+
+// retransmitTestConfig has one client with its templates and the queues handleRetransmit sends to,
+// what's sent has to be taken back with takeRetransmit or drainRetransmit
+func retransmitTestConfig(tb testing.TB) (*ClientGenConfig, *SingleClientGen) {
+	ctx := context.Background()
+	cfg := &ClientGenConfig{
+		ServerAddress:                     "10.0.0.1",
+		ServerMAC:                         "0c:42:a1:80:31:66",
+		DurationSec:                       300,
+		TimeBetweenDelayReqSec:            1,
+		ClientRetranTimeWhenNoResponseSec: 1,
+		NumTXWorkers:                      1,
+		NumClientRetransmitProcs:          1,
+		Ctx:                               &ctx,
+		RunData:                           &ClientGenData{},
+	}
+	cfg.parsedServerMac, _ = net.ParseMAC(cfg.ServerMAC)
+	cfg.RunData.clients = []SingleClientGen{{ClientIP: net.ParseIP("10.0.1.1"), mac: net.HardwareAddr{2, 0, 0, 0, 0, 9}, stateSem: semaphore.NewWeighted(1)}}
+	if err := setupTemplates(cfg); err != nil {
+		tb.Fatal(err)
+	}
+	cfg.RunData.outPacketPool.New = func() interface{} {
+		buf := gopacket.NewSerializeBuffer()
+		return &outPacket{data: &buf}
+	}
+	cfg.RunData.heapOpPool.New = func() interface{} { return new(parallelHeapOp) }
+	out := make(chan *outPacket, 1)
+	cfg.RunData.rawOutput = []chan *outPacket{out}
+	cfg.RunData.rawOutputStats = []*queueStats{newQueueStats(cfg, QueueRawOutput, 0, 1, func() int { return len(out) })}
+	// room for the remove and the insert of a grant
+	ops := make(chan *parallelHeapOp, 2)
+	cfg.RunData.retransmitHeap = []parallelHeap{{operationChan: ops}}
+	cfg.RunData.retransmitHeap[0].stats = newQueueStats(cfg, QueueRetransmitHeap, 0, 2, func() int { return len(ops) })
+	return cfg, &cfg.RunData.clients[0]
+}
+
+// takeRetransmit puts what handleRetransmit sent back into the pools
+func takeRetransmit(cfg *ClientGenConfig) *outPacket {
+	out := <-cfg.RunData.rawOutput[0]
+	cfg.RunData.heapOpPool.Put(<-cfg.RunData.retransmitHeap[0].operationChan)
+	cfg.RunData.outPacketPool.Put(out)
+	return out
+}
+
+// drainRetransmit puts whatever was sent back into the pools
+func drainRetransmit(cfg *ClientGenConfig) {
+	for {
+		select {
+		case out := <-cfg.RunData.rawOutput[0]:
+			cfg.RunData.outPacketPool.Put(out)
+		case op := <-cfg.RunData.retransmitHeap[0].operationChan:
+			cfg.RunData.heapOpPool.Put(op)
+		default:
+			return
+		}
+	}
+}
+
+// retransmitStates are the states of the client handleRetransmit sends something in
+var retransmitStates = []struct {
+	name  string
+	state state
+	delay string
+	want  uint8
+}{
+	{"AnnounceGrantReq", stateInit, DelayMechanismE2E, pktAnnounceGrantReq},
+	{"SyncGrantReq", stateGotGrantAnnounce, DelayMechanismE2E, pktSyncGrantReq},
+	{"DelayRespGrantReq", stateGotGrantSync, DelayMechanismE2E, pktDelayRespGrantReq},
+	{"PDelayRespGrantReq", stateGotGrantSync, DelayMechanismP2P, pktDelayRespGrantReq},
+	{"DelayReq", stateDone, DelayMechanismE2E, pktDelayReq},
+	{"PDelayReq", stateDone, DelayMechanismP2P, pktPDelayReq},
+}
+
+func Test_handleRetransmitNoAllocs(t *testing.T) {
+	cfg, cl := retransmitTestConfig(t)
+	for _, tc := range retransmitStates {
+		cfg.DelayMechanism = tc.delay
+		cl.state, cl.timeDoneInit = tc.state, time.Now()
+		handleRetransmit(cfg, cl, true, 0)
+		require.Equal(t, tc.want, takeRetransmit(cfg).pktType, tc.name)
+		if raceEnabled {
+			// the pools drop what's put back
+			continue
+		}
+		allocs := testing.AllocsPerRun(100, func() {
+			handleRetransmit(cfg, cl, true, 0)
+			takeRetransmit(cfg)
+		})
+		require.Zero(t, allocs, tc.name)
+	}
+}
+
+func Benchmark_craftSinglePktToGM(b *testing.B) {
+	cfg, cl := retransmitTestConfig(b)
+	b.Run("DelayReq", func(b *testing.B) {
+		buf := gopacket.NewSerializeBuffer()
+		out := &outPacket{data: &buf}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			craftSinglePktToGM(cfg, cl, ptp.PortEvent, &cfg.RunData.payloads.delayReq, 0, uint16(i), out)
+		}
+	})
+	for _, tc := range retransmitStates {
+		b.Run("handleRetransmit/"+tc.name, func(b *testing.B) {
+			cfg.DelayMechanism = tc.delay
+			cl.state, cl.timeDoneInit = tc.state, time.Now()
+			if allocs := testing.AllocsPerRun(100, func() {
+				handleRetransmit(cfg, cl, true, 0)
+				takeRetransmit(cfg)
+			}); allocs != 0 && !raceEnabled {
+				b.Fatalf("%v allocs/op", allocs)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				handleRetransmit(cfg, cl, true, 0)
+				takeRetransmit(cfg)
+			}
+		})
+	}
+}
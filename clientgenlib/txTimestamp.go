@@ -0,0 +1,50 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"fmt"
+	"time"
+)
+
+// txTSSocket stub, TX timestamps via the error queue only exist on linux
+type txTSSocket struct {
+	tsType string
+}
+
+func newTXTSSocket(iface string) (*txTSSocket, error) {
+	return nil, fmt.Errorf("TX timestamps not available on this system")
+}
+
+func (s *txTSSocket) WritePacket(data []byte) (int64, error) {
+	return 0, fmt.Errorf("TX timestamps not available on this system")
+}
+
+func (s *txTSSocket) WritePackets(frames [][]byte) (int, int64, error) {
+	return 0, 0, fmt.Errorf("TX timestamps not available on this system")
+}
+
+func (s *txTSSocket) ReadTimestamp(buf, oob []byte) (int, time.Time, error) {
+	return 0, time.Time{}, fmt.Errorf("TX timestamps not available on this system")
+}
+
+func (s *txTSSocket) Close() error {
+	return nil
+}
@@ -0,0 +1,141 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"golang.org/x/sys/unix"
+)
+
+// how long a TS read waits on poll before giving up
+const txTSPollTimeoutMs = 100
+
+// txTSSocket is a raw AF_PACKET socket for the packets that need a TX timestamp
+// the kernel hands every sent frame back on the error queue together with its timestamp
+type txTSSocket struct {
+	fd     int
+	tsType string // ptp.HWTIMESTAMP or ptp.SWTIMESTAMP
+
+	// bytes sent and not yet read back from the error queue
+	outstanding int64
+
+	// sendmmsg arguments, reused between batches
+	iovs []unix.Iovec
+	msgs []mmsghdr
+}
+
+func newTXTSSocket(iface string) (*txTSSocket, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("txts interface %s: %w", iface, err)
+	}
+	// protocol 0, never receives anything except the error queue
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, 0)
+	if err != nil {
+		return nil, fmt.Errorf("txts socket: %w", err)
+	}
+	s := &txTSSocket{fd: fd}
+	if s.tsType, err = ptp.EnableTXTimestampsSocketPacket(fd, iface); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("txts enable timestamps on %s: %w", iface, err)
+	}
+	if err = unix.Bind(fd, &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ALL),
+		Ifindex:  ifi.Index,
+	}); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("txts bind %s: %w", iface, err)
+	}
+	return s, nil
+}
+
+// WritePacket sends one frame, returns the bytes now waiting on the error queue
+func (s *txTSSocket) WritePacket(data []byte) (int64, error) {
+	if _, err := unix.Write(s.fd, data); err != nil {
+		return atomic.LoadInt64(&s.outstanding), err
+	}
+	return atomic.AddInt64(&s.outstanding, int64(len(data))), nil
+}
+
+// mmsghdr is struct mmsghdr for sendmmsg, x/sys/unix doesn't have it
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+// WritePackets sends all frames with one sendmmsg, returns how many were sent
+// and the bytes now waiting on the error queue
+func (s *txTSSocket) WritePackets(frames [][]byte) (int, int64, error) {
+	if cap(s.msgs) < len(frames) {
+		s.iovs = make([]unix.Iovec, len(frames))
+		s.msgs = make([]mmsghdr, len(frames))
+	}
+	iovs := s.iovs[:len(frames)]
+	msgs := s.msgs[:len(frames)]
+	for i, data := range frames {
+		iovs[i].Base = &data[0]
+		iovs[i].SetLen(len(data))
+		msgs[i].hdr.Iov = &iovs[i]
+		msgs[i].hdr.SetIovlen(1)
+	}
+	sent := 0
+	for sent < len(msgs) {
+		n, _, errno := unix.Syscall6(unix.SYS_SENDMMSG, uintptr(s.fd), uintptr(unsafe.Pointer(&msgs[sent])),
+			uintptr(len(msgs)-sent), 0, 0, 0)
+		if errno == unix.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return sent, atomic.LoadInt64(&s.outstanding), errno
+		}
+		for _, m := range msgs[sent : sent+int(n)] {
+			atomic.AddInt64(&s.outstanding, int64(m.len))
+		}
+		sent += int(n)
+	}
+	return sent, atomic.LoadInt64(&s.outstanding), nil
+}
+
+// ReadTimestamp copies the next sent frame into buf and returns its length and TX timestamp
+// returns 0 with no error if nothing was looped back within the poll timeout
+func (s *txTSSocket) ReadTimestamp(buf, oob []byte) (int, time.Time, error) {
+	n, ts, err := ptp.ReadTXtimestampPacketBuf(s.fd, buf, oob)
+	if err == unix.EAGAIN {
+		fds := []unix.PollFd{{Fd: int32(s.fd), Events: unix.POLLPRI}}
+		if _, err = unix.Poll(fds, txTSPollTimeoutMs); err != nil && err != unix.EINTR {
+			return 0, time.Time{}, err
+		}
+		n, ts, err = ptp.ReadTXtimestampPacketBuf(s.fd, buf, oob)
+		if err == unix.EAGAIN {
+			return 0, time.Time{}, nil
+		}
+	}
+	if n > 0 {
+		atomic.AddInt64(&s.outstanding, -int64(n))
+	}
+	return n, ts, err
+}
+
+func (s *txTSSocket) Close() error {
+	return unix.Close(s.fd)
+}
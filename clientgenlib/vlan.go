@@ -0,0 +1,122 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+const maxVLAN = 4094
+const maxPCP = 7
+
+// clientSpan is where the clients of one ClientRange are in RunData.clients
+type clientSpan struct {
+	start  net.IP
+	hi, lo uint64 // start as a 128 bit number
+	step   uint64
+	first  int
+	count  int
+	vlan   uint16
+	list   bool // an Addresses list, found through RunData.byAddr
+}
+
+// clientRanges is the top level client range, if set, followed by cfg.ClientRanges
+func clientRanges(cfg *ClientGenConfig) ([]ClientRange, error) {
+	var ranges []ClientRange
+	if cfg.ClientIPStart != "" {
+		ranges = append(ranges, ClientRange{
+			ClientIPStart: cfg.ClientIPStart,
+			ClientIPEnd:   cfg.ClientIPEnd,
+			ClientIPStep:  cfg.ClientIPStep,
+			VLAN:          cfg.VLAN,
+			PCP:           cfg.PCP,
+		})
+	}
+	ranges = append(ranges, cfg.ClientRanges...)
+	for i, r := range ranges {
+		if r.CIDR != "" || len(r.Addresses) > 0 {
+			if r.ClientIPStart != "" || (r.CIDR != "" && len(r.Addresses) > 0) {
+				return nil, fmt.Errorf("client range %d sets more than one of ClientIPStart, CIDR and Addresses", i)
+			}
+		}
+		if r.CIDR != "" {
+			var err error
+			if r, err = cidrRange(r); err != nil {
+				return nil, err
+			}
+			ranges[i] = r
+		}
+		for _, addr := range r.Addresses {
+			if net.ParseIP(addr) == nil {
+				return nil, fmt.Errorf("client range address %q isn't an IP", addr)
+			}
+		}
+		if r.VLAN > maxVLAN {
+			return nil, fmt.Errorf("client range %s VLAN %d over %d", r.ClientIPStart, r.VLAN, maxVLAN)
+		}
+		if r.PCP > maxPCP {
+			return nil, fmt.Errorf("client range %s PCP %d over %d", r.ClientIPStart, r.PCP, maxPCP)
+		}
+		if r.ClientIPStep == 0 && len(r.Addresses) == 0 {
+			return nil, fmt.Errorf("client range %s ClientIPStep is 0", r.ClientIPStart)
+		}
+	}
+	return ranges, nil
+}
+
+// vlan is the 802.1Q VLAN ID of the decoded frame, 0 if it's untagged
+func (in *PktDecoder) vlan() uint16 {
+	for _, t := range in.layers {
+		if t == layers.LayerTypeDot1Q {
+			return in.dot1q.VLANIdentifier
+		}
+	}
+	return 0
+}
+
+// serializeFrame serializes eth and the layers after it, with an 802.1Q tag in between if vlan isn't 0
+func serializeFrame(buf gopacket.SerializeBuffer, opts gopacket.SerializeOptions, eth *layers.Ethernet,
+	vlan uint16, pcp uint8, rest ...gopacket.SerializableLayer) error {
+	if vlan == 0 {
+		return gopacket.SerializeLayers(buf, opts, append([]gopacket.SerializableLayer{eth}, rest...)...)
+	}
+	tag := &layers.Dot1Q{
+		Priority:       pcp,
+		VLANIdentifier: vlan,
+		Type:           eth.EthernetType,
+	}
+	eth.EthernetType = layers.EthernetTypeDot1Q
+	return gopacket.SerializeLayers(buf, opts, append([]gopacket.SerializableLayer{eth, tag}, rest...)...)
+}
+
+// insertVLANTag puts back the tag a NIC with VLAN offload took out of frame
+func insertVLANTag(frame []byte, tpid uint16, tci uint16) []byte {
+	if len(frame) < 12 {
+		return frame
+	}
+	tagged := make([]byte, len(frame)+4)
+	copy(tagged, frame[:12])
+	binary.BigEndian.PutUint16(tagged[12:], tpid)
+	binary.BigEndian.PutUint16(tagged[14:], tci)
+	copy(tagged[16:], frame[12:])
+	return tagged
+}
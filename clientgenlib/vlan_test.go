@@ -0,0 +1,73 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientgenlib
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_serializeFrameTagged(t *testing.T) {
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	for _, vlan := range []uint16{0, 100} {
+		eth := layers.Ethernet{SrcMAC: mac, DstMAC: mac, EthernetType: layers.EthernetTypeIPv4}
+		ip := layers.IPv4{SrcIP: net.IP{10, 0, 1, 1}, DstIP: net.IP{10, 0, 0, 1}, Version: 4, TTL: 255, Protocol: layers.IPProtocolUDP}
+		udp := layers.UDP{SrcPort: 319, DstPort: 319}
+		require.NoError(t, udp.SetNetworkLayerForChecksum(&ip))
+		buf := gopacket.NewSerializeBuffer()
+		require.NoError(t, serializeFrame(buf, opts, &eth, vlan, 5, &ip, &udp, gopacket.Payload([]byte{1, 2, 3})))
+		if vlan != 0 {
+			// TPID, PCP 5 and VID 100, then the EtherType of the frame
+			require.Equal(t, []byte{0x81, 0x00, 0xa0, 0x64, 0x08, 0x00}, buf.Bytes()[12:18])
+		} else {
+			require.Equal(t, []byte{0x08, 0x00}, buf.Bytes()[12:14])
+		}
+
+		in := &PktDecoder{}
+		in.parser = gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet,
+			&in.eth, &in.dot1q, &in.ip4, &in.ip6, &in.arp, &in.udp)
+		// no decoder for the PTP payload, same as the packet parser
+		in.parser.DecodeLayers(buf.Bytes(), &in.layers)
+		require.Equal(t, vlan, in.vlan())
+		require.Equal(t, net.IP{10, 0, 1, 1}, in.ip4.SrcIP.To4())
+		if vlan != 0 {
+			require.Equal(t, uint8(5), in.dot1q.Priority)
+		}
+	}
+}
+
+func Test_insertVLANTag(t *testing.T) {
+	frame := []byte{1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 2, 2, 0x08, 0x00, 0x45}
+	tagged := insertVLANTag(frame, 0x8100, 0xa064)
+	require.Equal(t, []byte{1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 2, 2, 0x81, 0x00, 0xa0, 0x64, 0x08, 0x00, 0x45}, tagged)
+}
+
+func Test_clientRangesInvalid(t *testing.T) {
+	cfg := loopbackTestConfig("10.0.1.1", "10.0.1.4", "10.0.0.1")
+	cfg.VLAN = 4095
+	_, err := clientRanges(cfg)
+	require.Error(t, err)
+	cfg.VLAN = 100
+	cfg.ClientRanges = []ClientRange{{ClientIPStart: "10.0.1.1", ClientIPEnd: "10.0.1.4", ClientIPStep: 1, PCP: 8}}
+	_, err = clientRanges(cfg)
+	require.Error(t, err)
+}
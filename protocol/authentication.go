@@ -0,0 +1,228 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"sync"
+)
+
+// errors of VerifyAuthenticationTLV, so callers can tell why a message was rejected
+var (
+	ErrAuthenticationMissing    = errors.New("no AUTHENTICATION TLV")
+	ErrAuthenticationUnknownKey = errors.New("AUTHENTICATION TLV with unknown SPP or key ID")
+	ErrAuthenticationBadICV     = errors.New("AUTHENTICATION TLV ICV mismatch")
+)
+
+// AuthenticationTLVHeadSize is the size of AUTHENTICATION TLV before the ICV
+const AuthenticationTLVHeadSize = TlvHeadSize + 6
+
+// AuthenticationTLV IEEE 1588-2019 16.14.3 AUTHENTICATION TLV format.
+// Only immediate security processing is supported, so secParamIndicator is 0
+// and there is no disclosedKey, sequenceNo or RES before the ICV.
+type AuthenticationTLV struct {
+	TLVHead
+	SPP               uint8
+	SecParamIndicator uint8
+	KeyID             uint32
+	ICV               []byte
+}
+
+func (t *AuthenticationTLV) MarshalBinaryTo(b []byte) (int, error) {
+	size := AuthenticationTLVHeadSize + len(t.ICV)
+	if len(b) < size {
+		return 0, fmt.Errorf("not enough buffer to write AuthenticationTLV")
+	}
+	tlvHeadMarshalBinaryTo(&t.TLVHead, b)
+	b[TlvHeadSize] = t.SPP
+	b[TlvHeadSize+1] = t.SecParamIndicator
+	binary.BigEndian.PutUint32(b[TlvHeadSize+2:], t.KeyID)
+	copy(b[AuthenticationTLVHeadSize:], t.ICV)
+	return size, nil
+}
+
+// UnmarshalBinary parses []byte and populates struct fields
+func (t *AuthenticationTLV) UnmarshalBinary(b []byte) error {
+	if err := unmarshalTLVHeader(&t.TLVHead, b); err != nil {
+		return err
+	}
+	if t.LengthField < AuthenticationTLVHeadSize-TlvHeadSize || len(b) < TlvHeadSize+int(t.LengthField) {
+		return fmt.Errorf("bad AuthenticationTLV length %d", t.LengthField)
+	}
+	t.SPP = b[TlvHeadSize]
+	t.SecParamIndicator = b[TlvHeadSize+1]
+	if t.SecParamIndicator != 0 {
+		return fmt.Errorf("AuthenticationTLV secParamIndicator %#x, delayed security processing is not supported", t.SecParamIndicator)
+	}
+	t.KeyID = binary.BigEndian.Uint32(b[TlvHeadSize+2:])
+	t.ICV = append(t.ICV[:0], b[AuthenticationTLVHeadSize:TlvHeadSize+int(t.LengthField)]...)
+	return nil
+}
+
+// AuthKey is a key of a security association, the ICV is HMAC-SHA256 truncated to ICVLen bytes
+type AuthKey struct {
+	ID     uint32
+	Key    []byte
+	ICVLen int
+
+	pool sync.Pool // of hash.Hash, HMACs with Key
+}
+
+// NewAuthKey checks the ICV length and returns a key
+func NewAuthKey(id uint32, key []byte, icvLen int) (*AuthKey, error) {
+	if icvLen <= 0 || icvLen > sha256.Size || icvLen%2 != 0 {
+		return nil, fmt.Errorf("ICV length %d, must be even and at most %d", icvLen, sha256.Size)
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("empty key %d", id)
+	}
+	return &AuthKey{ID: id, Key: key, ICVLen: icvLen}, nil
+}
+
+// correctionFieldOffset is where the header correctionField is, transparent clocks change it on the way
+const correctionFieldOffset = 8
+
+// icv puts the ICV of msg into dst. The correctionField counts as zero, like linuxptp does it,
+// so the ICV still holds after a transparent clock
+func (k *AuthKey) icv(dst, msg []byte) {
+	h, ok := k.pool.Get().(hash.Hash)
+	if !ok {
+		h = hmac.New(sha256.New, k.Key)
+	}
+	h.Reset()
+	var zero [8]byte
+	_, _ = h.Write(msg[:correctionFieldOffset])
+	_, _ = h.Write(zero[:])
+	_, _ = h.Write(msg[correctionFieldOffset+len(zero):])
+	var sum [sha256.Size]byte
+	copy(dst, h.Sum(sum[:0])[:k.ICVLen])
+	k.pool.Put(h)
+}
+
+// tlvOffset is where the TLVs start in a message of msgType
+func tlvOffset(msgType MessageType) (int, error) {
+	switch msgType {
+	case MessageSync, MessageDelayReq, MessageFollowUp, MessageSignaling:
+		return HeaderSize + 10, nil
+	case MessageDelayResp, MessagePDelayReq, MessagePDelayResp, MessagePDelayRespFollowUp:
+		return HeaderSize + 20, nil
+	case MessageAnnounce:
+		return HeaderSize + 30, nil
+	case MessageManagement:
+		return HeaderSize + 14, nil
+	}
+	return 0, fmt.Errorf("unknown message type %s", msgType)
+}
+
+// messageLength is the messageLength of PTP message b, checked against the size of b
+func messageLength(b []byte) (int, error) {
+	if len(b) < HeaderSize {
+		return 0, fmt.Errorf("not enough data to decode PTP header")
+	}
+	n := int(binary.BigEndian.Uint16(b[2:]))
+	if n > len(b) || n < HeaderSize {
+		return 0, fmt.Errorf("messageLength %d of %d bytes", n, len(b))
+	}
+	return n, nil
+}
+
+// AppendAuthenticationTLV adds an AUTHENTICATION TLV with the ICV made with key to PTP message b.
+// Anything in b past messageLength is dropped, messageLength is updated to include the TLV.
+func AppendAuthenticationTLV(b []byte, spp uint8, key *AuthKey) ([]byte, error) {
+	n, err := messageLength(b)
+	if err != nil {
+		return nil, err
+	}
+	tlv := AuthenticationTLV{
+		TLVHead: TLVHead{
+			TLVType:     TLVAuthentication,
+			LengthField: uint16(AuthenticationTLVHeadSize - TlvHeadSize + key.ICVLen),
+		},
+		SPP:   spp,
+		KeyID: key.ID,
+		ICV:   make([]byte, key.ICVLen),
+	}
+	out := make([]byte, n+AuthenticationTLVHeadSize+key.ICVLen)
+	copy(out, b[:n])
+	if _, err := tlv.MarshalBinaryTo(out[n:]); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(out[2:], uint16(len(out)))
+	return out, SignAuthenticationTLV(out, key)
+}
+
+// SignAuthenticationTLV recomputes the ICV of PTP message b which ends with an AUTHENTICATION TLV,
+// for when the message changed after AppendAuthenticationTLV, like a new sequence ID
+func SignAuthenticationTLV(b []byte, key *AuthKey) error {
+	n, err := messageLength(b)
+	if err != nil {
+		return err
+	}
+	if n < HeaderSize+AuthenticationTLVHeadSize+key.ICVLen {
+		return fmt.Errorf("message too short for AUTHENTICATION TLV")
+	}
+	key.icv(b[n-key.ICVLen:n], b[:n-key.ICVLen])
+	return nil
+}
+
+// VerifyAuthenticationTLV checks the ICV of the AUTHENTICATION TLV of PTP message b.
+// lookup returns the key for the SPP and key ID of the TLV, nil if there is none.
+// The ICV covers the message from the start of the header to the ICV, with the correctionField as zero.
+func VerifyAuthenticationTLV(b []byte, lookup func(spp uint8, keyID uint32) *AuthKey) error {
+	n, err := messageLength(b)
+	if err != nil {
+		return err
+	}
+	pos, err := tlvOffset(MessageType(b[0] & 0x0f))
+	if err != nil {
+		return err
+	}
+	for pos+TlvHeadSize <= n {
+		tlvType := TLVType(binary.BigEndian.Uint16(b[pos:]))
+		length := TlvHeadSize + int(binary.BigEndian.Uint16(b[pos+2:]))
+		if pos+length > n {
+			return fmt.Errorf("not enough data to decode %s", tlvType)
+		}
+		if tlvType != TLVAuthentication {
+			pos += length
+			continue
+		}
+		if length < AuthenticationTLVHeadSize {
+			return fmt.Errorf("bad AuthenticationTLV length %d", length-TlvHeadSize)
+		}
+		key := lookup(b[pos+TlvHeadSize], binary.BigEndian.Uint32(b[pos+TlvHeadSize+2:]))
+		if key == nil {
+			return ErrAuthenticationUnknownKey
+		}
+		if b[pos+TlvHeadSize+1] != 0 || length != AuthenticationTLVHeadSize+key.ICVLen {
+			return ErrAuthenticationBadICV
+		}
+		var icv [sha256.Size]byte
+		start := pos + AuthenticationTLVHeadSize
+		key.icv(icv[:key.ICVLen], b[:start])
+		if !hmac.Equal(icv[:key.ICVLen], b[start:start+key.ICVLen]) {
+			return ErrAuthenticationBadICV
+		}
+		return nil
+	}
+	return ErrAuthenticationMissing
+}
@@ -0,0 +1,149 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_AuthenticationTLV(t *testing.T) {
+	key, err := NewAuthKey(7, []byte("secret"), 16)
+	require.Nil(t, err)
+	lookup := func(spp uint8, keyID uint32) *AuthKey {
+		if spp == 2 && keyID == 7 {
+			return key
+		}
+		return nil
+	}
+	req := &SyncDelayReq{
+		Header: Header{
+			SdoIDAndMsgType: NewSdoIDAndMsgType(MessageDelayReq, 0),
+			Version:         Version,
+			MessageLength:   HeaderSize + 10,
+			SequenceID:      42,
+		},
+	}
+	raw, err := Bytes(req)
+	require.Nil(t, err)
+	require.ErrorIs(t, VerifyAuthenticationTLV(raw, lookup), ErrAuthenticationMissing)
+
+	b, err := AppendAuthenticationTLV(raw, 2, key)
+	require.Nil(t, err)
+	require.Equal(t, HeaderSize+10+AuthenticationTLVHeadSize+16, len(b))
+	require.Equal(t, []byte{0x80, 0x09, 0x00, 0x16, 0x02, 0x00, 0x00, 0x00, 0x00, 0x07}, b[HeaderSize+10:HeaderSize+10+AuthenticationTLVHeadSize])
+	mac := hmac.New(sha256.New, []byte("secret"))
+	_, _ = mac.Write(b[:len(b)-16])
+	require.Equal(t, mac.Sum(nil)[:16], b[len(b)-16:])
+	require.Nil(t, VerifyAuthenticationTLV(b, lookup))
+
+	// the message still decodes, with the new length
+	got := &SyncDelayReq{}
+	require.Nil(t, FromBytes(b, got))
+	require.Equal(t, uint16(len(b)), got.MessageLength)
+	require.Equal(t, uint16(42), got.SequenceID)
+
+	// changed message, stale ICV until signed again
+	b[30] = 43
+	require.ErrorIs(t, VerifyAuthenticationTLV(b, lookup), ErrAuthenticationBadICV)
+	require.Nil(t, SignAuthenticationTLV(b, key))
+	require.Nil(t, VerifyAuthenticationTLV(b, lookup))
+
+	// a transparent clock changed the correctionField, that's not covered by the ICV
+	binary.BigEndian.PutUint64(b[8:], uint64(NewCorrection(1234.5)))
+	require.Nil(t, VerifyAuthenticationTLV(b, lookup))
+	mac.Reset()
+	_, _ = mac.Write(b[:8])
+	_, _ = mac.Write(make([]byte, 8))
+	_, _ = mac.Write(b[16 : len(b)-16])
+	require.Equal(t, mac.Sum(nil)[:16], b[len(b)-16:])
+	require.Nil(t, SignAuthenticationTLV(b, key))
+	require.Equal(t, mac.Sum(nil)[:16], b[len(b)-16:])
+
+	b[HeaderSize+10+TlvHeadSize] = 3 // SPP
+	require.ErrorIs(t, VerifyAuthenticationTLV(b, lookup), ErrAuthenticationUnknownKey)
+}
+
+func Test_AuthenticationTLVSignaling(t *testing.T) {
+	key, err := NewAuthKey(1, []byte{1, 2, 3, 4}, 32)
+	require.Nil(t, err)
+	signaling := &Signaling{
+		Header: Header{
+			SdoIDAndMsgType: NewSdoIDAndMsgType(MessageSignaling, 0),
+			Version:         Version,
+			MessageLength:   HeaderSize + 10 + RequestUnicastTransmissionTLVSize,
+		},
+		TLVs: []TLV{
+			&RequestUnicastTransmissionTLV{
+				TLVHead: TLVHead{
+					TLVType:     TLVRequestUnicastTransmission,
+					LengthField: uint16(RequestUnicastTransmissionTLVSize - TlvHeadSize),
+				},
+				MsgTypeAndReserved: NewUnicastMsgTypeAndFlags(MessageSync, 0),
+				DurationField:      60,
+			},
+		},
+	}
+	raw, err := Bytes(signaling)
+	require.Nil(t, err)
+	b, err := AppendAuthenticationTLV(raw, 0, key)
+	require.Nil(t, err)
+	require.Nil(t, VerifyAuthenticationTLV(b, func(spp uint8, keyID uint32) *AuthKey { return key }))
+
+	got := &Signaling{}
+	require.Nil(t, FromBytes(b, got))
+	require.Equal(t, 2, len(got.TLVs))
+	auth, ok := got.TLVs[1].(*AuthenticationTLV)
+	require.True(t, ok)
+	require.Equal(t, uint32(1), auth.KeyID)
+	require.Equal(t, b[len(b)-32:], auth.ICV)
+
+	// and back to the same bytes
+	out, err := got.MarshalBinary()
+	require.Nil(t, err)
+	require.Equal(t, b, out)
+}
+
+func Test_NewAuthKey(t *testing.T) {
+	for _, icvLen := range []int{0, 7, 34} {
+		_, err := NewAuthKey(1, []byte("secret"), icvLen)
+		require.Error(t, err)
+	}
+	_, err := NewAuthKey(1, nil, 16)
+	require.Error(t, err)
+	k, err := NewAuthKey(1, []byte("secret"), 32)
+	require.Nil(t, err)
+	assert.Equal(t, 32, k.ICVLen)
+}
+
+func BenchmarkSignAuthenticationTLV(b *testing.B) {
+	key, err := NewAuthKey(1, []byte("secret"), 16)
+	require.Nil(b, err)
+	raw, err := Bytes(&SyncDelayReq{Header: Header{MessageLength: HeaderSize + 10}})
+	require.Nil(b, err)
+	msg, err := AppendAuthenticationTLV(raw, 0, key)
+	require.Nil(b, err)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = SignAuthenticationTLV(msg, key)
+	}
+}
@@ -18,6 +18,7 @@ package protocol
 
 import (
 	"bytes"
+	"encoding"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -131,6 +132,15 @@ func (p *Management) MarshalBinaryTo(bytes io.Writer) error {
 	if err := binary.Write(bytes, binary.BigEndian, p.ManagementMsgHead); err != nil {
 		return err
 	}
+	// TLVs of variable size, like CLOCK_DESCRIPTION, can't go through binary.Write
+	if tlv, ok := p.TLV.(encoding.BinaryMarshaler); ok {
+		b, err := tlv.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		_, err = bytes.Write(b)
+		return err
+	}
 	if err := binary.Write(bytes, binary.BigEndian, p.TLV); err != nil {
 		return err
 	}
@@ -109,3 +109,45 @@ func (c *MgmtClient) CurrentDataSet() (*CurrentDataSetTLV, error) {
 	}
 	return tlv, nil
 }
+
+// TimePropertiesDataSet sends TIME_PROPERTIES_DATA_SET request and returns response
+func (c *MgmtClient) TimePropertiesDataSet() (*TimePropertiesDataSetTLV, error) {
+	req := TimePropertiesDataSetRequest()
+	p, err := c.Communicate(req)
+	if err != nil {
+		return nil, err
+	}
+	tlv, ok := p.TLV.(*TimePropertiesDataSetTLV)
+	if !ok {
+		return nil, fmt.Errorf("got unexpected management TLV %T, wanted %T", p.TLV, tlv)
+	}
+	return tlv, nil
+}
+
+// PortDataSet sends PORT_DATA_SET request and returns response
+func (c *MgmtClient) PortDataSet() (*PortDataSetTLV, error) {
+	req := PortDataSetRequest()
+	p, err := c.Communicate(req)
+	if err != nil {
+		return nil, err
+	}
+	tlv, ok := p.TLV.(*PortDataSetTLV)
+	if !ok {
+		return nil, fmt.Errorf("got unexpected management TLV %T, wanted %T", p.TLV, tlv)
+	}
+	return tlv, nil
+}
+
+// ClockDescription sends CLOCK_DESCRIPTION request and returns response
+func (c *MgmtClient) ClockDescription() (*ClockDescriptionTLV, error) {
+	req := ClockDescriptionRequest()
+	p, err := c.Communicate(req)
+	if err != nil {
+		return nil, err
+	}
+	tlv, ok := p.TLV.(*ClockDescriptionTLV)
+	if !ok {
+		return nil, fmt.Errorf("got unexpected management TLV %T, wanted %T", p.TLV, tlv)
+	}
+	return tlv, nil
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package protocol
 
 import (
+	"bytes"
+	"encoding/binary"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -79,3 +81,229 @@ func Test_parseMsgErrorStatus(t *testing.T) {
 	require.Nil(t, err)
 	assert.Equal(t, &want, pp)
 }
+
+// mgmtTestHead is the header of the management responses below, without messageLength and TLV
+func mgmtTestHead() ManagementMsgHead {
+	return ManagementMsgHead{
+		Header: Header{
+			SdoIDAndMsgType: NewSdoIDAndMsgType(MessageManagement, 0),
+			Version:         2,
+			SourcePortIdentity: PortIdentity{
+				PortNumber:    0,
+				ClockIdentity: 5212879185253000328,
+			},
+			SequenceID:         5,
+			ControlField:       4,
+			LogMessageInterval: 0x7f,
+		},
+		TargetPortIdentity: PortIdentity{
+			PortNumber:    6678,
+			ClockIdentity: 0,
+		},
+		ActionField: RESPONSE,
+	}
+}
+
+func Test_parseTimePropertiesDataSet(t *testing.T) {
+	raw := []uint8{
+		13, 2, 0, 58, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		72, 87, 221, 255, 254, 8, 100, 136, 0, 0, 0, 5, 4, 127, 0, 0, 0, 0, 0, 0, 0, 0,
+		26, 22, 0, 0, 2, 0,
+		0, 1, 0, 6, 0x20, 0x03,
+		0, 37, 0x0d, 0x20,
+		0, 0,
+	}
+	packet := new(Management)
+	err := FromBytes(raw, packet)
+	require.Nil(t, err)
+	want := Management{
+		ManagementMsgHead: mgmtTestHead(),
+		TLV: &TimePropertiesDataSetTLV{
+			ManagementTLVHead: ManagementTLVHead{
+				TLVHead: TLVHead{
+					TLVType:     TLVManagement,
+					LengthField: 6,
+				},
+				ManagementID: IDTimePropertiesDataSet,
+			},
+			CurrentUTCOffset: 37,
+			Flags:            uint8(FlagLeap61 | FlagCurrentUtcOffsetValid | FlagPTPTimescale),
+			TimeSource:       TimeSourceGNSS,
+		},
+	}
+	want.MessageLength = uint16(len(raw) - 2)
+	require.Equal(t, want, *packet)
+	tlv := packet.TLV.(*TimePropertiesDataSetTLV)
+	assert.True(t, tlv.Leap61())
+	assert.False(t, tlv.Leap59())
+	assert.True(t, tlv.CurrentUTCOffsetValid())
+	assert.True(t, tlv.PTPTimescale())
+	assert.False(t, tlv.TimeTraceable())
+	assert.False(t, tlv.FrequencyTraceable())
+	b, err := Bytes(packet)
+	require.Nil(t, err)
+	assert.Equal(t, raw, b)
+}
+
+func Test_parsePortDataSet(t *testing.T) {
+	raw := []uint8{
+		13, 2, 0, 80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		72, 87, 221, 255, 254, 8, 100, 136, 0, 0, 0, 5, 4, 127, 0, 0, 0, 0, 0, 0, 0, 0,
+		26, 22, 0, 0, 2, 0,
+		0, 1, 0, 28, 0x20, 0x04,
+		72, 87, 221, 255, 254, 8, 100, 136, 0, 1,
+		6, 0xfe, 0, 0, 0, 0, 0, 0x01, 0x80, 0x00, 1, 3, 0xfd, 1, 0, 0x12,
+		0, 0,
+	}
+	packet := new(Management)
+	err := FromBytes(raw, packet)
+	require.Nil(t, err)
+	want := Management{
+		ManagementMsgHead: mgmtTestHead(),
+		TLV: &PortDataSetTLV{
+			ManagementTLVHead: ManagementTLVHead{
+				TLVHead: TLVHead{
+					TLVType:     TLVManagement,
+					LengthField: 28,
+				},
+				ManagementID: IDPortDataSet,
+			},
+			PortIdentity: PortIdentity{
+				PortNumber:    1,
+				ClockIdentity: 5212879185253000328,
+			},
+			PortState:               PortStateMaster,
+			LogMinDelayReqInterval:  -2,
+			PeerMeanPathDelay:       0x18000,
+			LogAnnounceInterval:     1,
+			AnnounceReceiptTimeout:  3,
+			LogSyncInterval:         -3,
+			DelayMechanism:          DelayMechanismE2E,
+			LogMinPdelayReqInterval: 0,
+			VersionNumber:           0x12,
+		},
+	}
+	want.MessageLength = uint16(len(raw) - 2)
+	require.Equal(t, want, *packet)
+	assert.Equal(t, "MASTER", packet.TLV.(*PortDataSetTLV).PortState.String())
+	assert.Equal(t, 1.5, packet.TLV.(*PortDataSetTLV).PeerMeanPathDelay.Nanoseconds())
+	b, err := Bytes(packet)
+	require.Nil(t, err)
+	assert.Equal(t, raw, b)
+}
+
+func Test_parseClockDescription(t *testing.T) {
+	raw := []uint8{
+		13, 2, 0, 100, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		72, 87, 221, 255, 254, 8, 100, 136, 0, 0, 0, 5, 4, 127, 0, 0, 0, 0, 0, 0, 0, 0,
+		26, 22, 0, 0, 2, 0,
+		0, 1, 0, 48, 0x00, 0x01,
+		0x80, 0x00, // clockType
+		10, 'I', 'E', 'E', 'E', ' ', '8', '0', '2', '.', '3',
+		0, 6, 0x48, 0x57, 0xdd, 0x08, 0x64, 0x88, // physicalAddress
+		0, 1, 0, 4, 10, 0, 0, 1, // protocolAddress
+		0, 0x1b, 0x19, 0, // manufacturerIdentity and reserved
+		2, ';', ';',
+		2, ';', '1',
+		0,
+		0x00, 0x1b, 0x19, 0x00, 0x01, 0x00, // profileIdentity
+		0, 0,
+	}
+	packet := new(Management)
+	err := FromBytes(raw, packet)
+	require.Nil(t, err)
+	want := Management{
+		ManagementMsgHead: mgmtTestHead(),
+		TLV: &ClockDescriptionTLV{
+			ManagementTLVHead: ManagementTLVHead{
+				TLVHead: TLVHead{
+					TLVType:     TLVManagement,
+					LengthField: 48,
+				},
+				ManagementID: IDClockDescription,
+			},
+			ClockType:             ClockTypeOrdinary,
+			PhysicalLayerProtocol: "IEEE 802.3",
+			PhysicalAddress:       []byte{0x48, 0x57, 0xdd, 0x08, 0x64, 0x88},
+			ProtocolAddress: PortAddress{
+				NetworkProtocol: NetworkProtocolUDPIPv4,
+				AddressField:    []byte{10, 0, 0, 1},
+			},
+			ManufacturerIdentity: [3]uint8{0x00, 0x1b, 0x19},
+			ProductDescription:   ";;",
+			RevisionData:         ";1",
+			ProfileIdentity:      [6]uint8{0x00, 0x1b, 0x19, 0x00, 0x01, 0x00},
+		},
+	}
+	want.MessageLength = uint16(len(raw) - 2)
+	require.Equal(t, want, *packet)
+	b, err := Bytes(packet)
+	require.Nil(t, err)
+	assert.Equal(t, raw, b)
+
+	// odd sized data field gets padded
+	tlv := packet.TLV.(*ClockDescriptionTLV)
+	tlv.UserDescription = "x"
+	b, err = tlv.MarshalBinary()
+	require.Nil(t, err)
+	require.Equal(t, 54, len(b))
+	got := &ClockDescriptionTLV{}
+	require.Nil(t, got.UnmarshalBinary(b))
+	require.Equal(t, PTPText("x"), got.UserDescription)
+	require.Equal(t, uint16(50), got.LengthField)
+
+	// cut short, with a matching length
+	require.Error(t, got.UnmarshalBinary(b[:50]))
+	for _, n := range []int{6, 10, 20, 30, 40, 50} {
+		short := append([]byte{}, b[:n]...)
+		binary.BigEndian.PutUint16(short[2:], uint16(n-TlvHeadSize))
+		require.Error(t, got.UnmarshalBinary(short), n)
+	}
+}
+
+// mgmtTestConn answers every request with response
+type mgmtTestConn struct {
+	bytes.Buffer
+	response []byte
+}
+
+func (c *mgmtTestConn) Read(b []byte) (int, error) {
+	return copy(b, c.response), nil
+}
+
+func Test_MgmtClientDataSets(t *testing.T) {
+	conn := &mgmtTestConn{}
+	c := &MgmtClient{Connection: conn}
+	conn.response = []uint8{
+		13, 2, 0, 58, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		72, 87, 221, 255, 254, 8, 100, 136, 0, 0, 0, 5, 4, 127, 0, 0, 0, 0, 0, 0, 0, 0,
+		26, 22, 0, 0, 2, 0,
+		0, 1, 0, 6, 0x20, 0x03,
+		0, 37, 0x0d, 0x20,
+	}
+	timeProps, err := c.TimePropertiesDataSet()
+	require.Nil(t, err)
+	require.Equal(t, int16(37), timeProps.CurrentUTCOffset)
+	req := conn.Bytes()
+	require.Equal(t, len(req), int(req[3]))
+	require.Equal(t, []byte{0x20, 0x03}, req[52:54])
+
+	// the wrong data set is an error
+	conn.Reset()
+	_, err = c.PortDataSet()
+	require.Error(t, err)
+	req = conn.Bytes()
+	require.Equal(t, len(req), int(req[3]))
+	require.Equal(t, []byte{0x20, 0x04}, req[52:54])
+
+	conn.Reset()
+	_, err = c.ClockDescription()
+	require.Error(t, err)
+	req = conn.Bytes()
+	require.Equal(t, len(req), int(req[3]))
+	require.Equal(t, []byte{0x00, 0x01}, req[52:54])
+	// the empty CLOCK_DESCRIPTION we ask with decodes too
+	desc := &ClockDescriptionTLV{}
+	require.Nil(t, desc.UnmarshalBinary(req[48:]))
+	require.Equal(t, IDClockDescription, desc.ManagementID)
+}
@@ -19,6 +19,7 @@ package protocol
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 )
 
 // ManagementID is type for Management IDs
@@ -78,6 +79,29 @@ var mgmtTLVDecoder = map[ManagementID]MgmtTLVDecoderFunc{
 		}
 		return tlv, nil
 	},
+	IDTimePropertiesDataSet: func(data []byte) (ManagementTLV, error) {
+		r := bytes.NewReader(data)
+		tlv := &TimePropertiesDataSetTLV{}
+		if err := binary.Read(r, binary.BigEndian, tlv); err != nil {
+			return nil, err
+		}
+		return tlv, nil
+	},
+	IDPortDataSet: func(data []byte) (ManagementTLV, error) {
+		r := bytes.NewReader(data)
+		tlv := &PortDataSetTLV{}
+		if err := binary.Read(r, binary.BigEndian, tlv); err != nil {
+			return nil, err
+		}
+		return tlv, nil
+	},
+	IDClockDescription: func(data []byte) (ManagementTLV, error) {
+		tlv := &ClockDescriptionTLV{}
+		if err := tlv.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+		return tlv, nil
+	},
 	IDPortStatsNP: func(data []byte) (ManagementTLV, error) {
 		r := bytes.NewReader(data)
 		tlv := &PortStatsNPTLV{}
@@ -149,6 +173,180 @@ type ParentDataSetTLV struct {
 	GrandmasterIdentity                   ClockIdentity
 }
 
+// TimePropertiesDataSetTLV Spec Table 86 - TIME_PROPERTIES_DATA_SET management TLV data field
+type TimePropertiesDataSetTLV struct {
+	ManagementTLVHead
+
+	CurrentUTCOffset int16
+	Flags            uint8 // same bits as the second octet of the header flagField
+	TimeSource       TimeSource
+}
+
+// Leap61 is true if the last minute of the current UTC day contains 61 seconds
+func (t *TimePropertiesDataSetTLV) Leap61() bool {
+	return uint16(t.Flags)&FlagLeap61 != 0
+}
+
+// Leap59 is true if the last minute of the current UTC day contains 59 seconds
+func (t *TimePropertiesDataSetTLV) Leap59() bool {
+	return uint16(t.Flags)&FlagLeap59 != 0
+}
+
+// CurrentUTCOffsetValid is true if CurrentUTCOffset is known to be correct
+func (t *TimePropertiesDataSetTLV) CurrentUTCOffsetValid() bool {
+	return uint16(t.Flags)&FlagCurrentUtcOffsetValid != 0
+}
+
+// PTPTimescale is true if the timescale of the grandmaster is PTP
+func (t *TimePropertiesDataSetTLV) PTPTimescale() bool {
+	return uint16(t.Flags)&FlagPTPTimescale != 0
+}
+
+// TimeTraceable is true if the timescale is traceable to a primary reference
+func (t *TimePropertiesDataSetTLV) TimeTraceable() bool {
+	return uint16(t.Flags)&FlagTimeTraceable != 0
+}
+
+// FrequencyTraceable is true if the frequency is traceable to a primary reference
+func (t *TimePropertiesDataSetTLV) FrequencyTraceable() bool {
+	return uint16(t.Flags)&FlagFrequencyTraceable != 0
+}
+
+// PortDataSetTLV Spec Table 87 - PORT_DATA_SET management TLV data field
+type PortDataSetTLV struct {
+	ManagementTLVHead
+
+	PortIdentity            PortIdentity
+	PortState               PortState
+	LogMinDelayReqInterval  LogInterval
+	PeerMeanPathDelay       TimeInterval
+	LogAnnounceInterval     LogInterval
+	AnnounceReceiptTimeout  uint8
+	LogSyncInterval         LogInterval
+	DelayMechanism          DelayMechanism
+	LogMinPdelayReqInterval LogInterval
+	VersionNumber           uint8 // minorVersionNumber in the upper 4 bits, versionNumber in the lower
+}
+
+// ClockDescriptionTLV Spec Table 70 - CLOCK_DESCRIPTION management TLV data field
+type ClockDescriptionTLV struct {
+	ManagementTLVHead
+
+	ClockType             ClockType
+	PhysicalLayerProtocol PTPText
+	PhysicalAddress       []byte
+	ProtocolAddress       PortAddress
+	ManufacturerIdentity  [3]uint8
+	Reserved              uint8
+	ProductDescription    PTPText
+	RevisionData          PTPText
+	UserDescription       PTPText
+	ProfileIdentity       [6]uint8
+}
+
+// appendPTPText adds text to b as PTPText, without padding as it's inside a TLV
+func appendPTPText(b []byte, text PTPText) ([]byte, error) {
+	if len(text) > 255 {
+		return nil, fmt.Errorf("text is too long")
+	}
+	b = append(b, uint8(len(text)))
+	return append(b, text...), nil
+}
+
+// readPTPText reads PTPText from the start of b and returns it with the rest of b
+func readPTPText(b []byte) (PTPText, []byte, error) {
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return "", nil, fmt.Errorf("not enough data to decode PTPText")
+	}
+	return PTPText(b[1 : 1+int(b[0])]), b[1+int(b[0]):], nil
+}
+
+// MarshalBinary converts TLV to []bytes, LengthField is what the data field needs
+// with the padding to an even length, whatever the struct says
+func (t *ClockDescriptionTLV) MarshalBinary() ([]byte, error) {
+	var err error
+	b := make([]byte, 6, 64)
+	b = append(b, uint8(t.ClockType>>8), uint8(t.ClockType))
+	if b, err = appendPTPText(b, t.PhysicalLayerProtocol); err != nil {
+		return nil, err
+	}
+	b = append(b, uint8(len(t.PhysicalAddress)>>8), uint8(len(t.PhysicalAddress)))
+	b = append(b, t.PhysicalAddress...)
+	b = append(b, uint8(t.ProtocolAddress.NetworkProtocol>>8), uint8(t.ProtocolAddress.NetworkProtocol))
+	b = append(b, uint8(len(t.ProtocolAddress.AddressField)>>8), uint8(len(t.ProtocolAddress.AddressField)))
+	b = append(b, t.ProtocolAddress.AddressField...)
+	b = append(b, t.ManufacturerIdentity[:]...)
+	b = append(b, t.Reserved)
+	for _, text := range []PTPText{t.ProductDescription, t.RevisionData, t.UserDescription} {
+		if b, err = appendPTPText(b, text); err != nil {
+			return nil, err
+		}
+	}
+	b = append(b, t.ProfileIdentity[:]...)
+	if len(b)%2 != 0 {
+		b = append(b, 0)
+	}
+	if len(b)-TlvHeadSize > 0xffff {
+		return nil, fmt.Errorf("ClockDescriptionTLV is too long")
+	}
+	binary.BigEndian.PutUint16(b[0:], uint16(t.TLVType))
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)-TlvHeadSize))
+	binary.BigEndian.PutUint16(b[4:], uint16(t.ManagementID))
+	return b, nil
+}
+
+// UnmarshalBinary parses []byte and populates struct fields
+func (t *ClockDescriptionTLV) UnmarshalBinary(b []byte) error {
+	if len(b) < TlvHeadSize+2 {
+		return fmt.Errorf("not enough data to decode ClockDescriptionTLV")
+	}
+	t.TLVType = TLVType(binary.BigEndian.Uint16(b[0:]))
+	t.LengthField = binary.BigEndian.Uint16(b[2:])
+	t.ManagementID = ManagementID(binary.BigEndian.Uint16(b[4:]))
+	if len(b) < TlvHeadSize+int(t.LengthField) {
+		return fmt.Errorf("not enough data to decode ClockDescriptionTLV of length %d", t.LengthField)
+	}
+	data := b[6 : TlvHeadSize+int(t.LengthField)]
+	short := fmt.Errorf("ClockDescriptionTLV of length %d is too short", t.LengthField)
+	var err error
+	if len(data) < 2 {
+		return short
+	}
+	t.ClockType = ClockType(binary.BigEndian.Uint16(data))
+	if t.PhysicalLayerProtocol, data, err = readPTPText(data[2:]); err != nil {
+		return err
+	}
+	if len(data) < 2 || len(data) < 2+int(binary.BigEndian.Uint16(data)) {
+		return short
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	t.PhysicalAddress = append([]byte(nil), data[2:2+n]...)
+	data = data[2+n:]
+	if len(data) < 4 || len(data) < 4+int(binary.BigEndian.Uint16(data[2:])) {
+		return short
+	}
+	n = int(binary.BigEndian.Uint16(data[2:]))
+	t.ProtocolAddress.NetworkProtocol = NetworkProtocol(binary.BigEndian.Uint16(data))
+	t.ProtocolAddress.AddressField = append([]byte(nil), data[4:4+n]...)
+	data = data[4+n:]
+	if len(data) < 4 {
+		return short
+	}
+	copy(t.ManufacturerIdentity[:], data)
+	t.Reserved = data[3]
+	data = data[4:]
+	for _, text := range []*PTPText{&t.ProductDescription, &t.RevisionData, &t.UserDescription} {
+		if *text, data, err = readPTPText(data); err != nil {
+			return err
+		}
+	}
+	if len(data) < 6 {
+		return short
+	}
+	copy(t.ProfileIdentity[:], data)
+	return nil
+}
+
 // CurrentDataSetRequest prepares request packet for CURRENT_DATA_SET request
 func CurrentDataSetRequest() *Management {
 	headerSize := uint16(binary.Size(ManagementMsgHead{}))
@@ -241,3 +439,97 @@ func ParentDataSetRequest() *Management {
 		},
 	}
 }
+
+// TimePropertiesDataSetRequest prepares request packet for TIME_PROPERTIES_DATA_SET request
+func TimePropertiesDataSetRequest() *Management {
+	headerSize := uint16(binary.Size(ManagementMsgHead{}))
+	size := uint16(binary.Size(TimePropertiesDataSetTLV{}))
+	tlvHeadSize := uint16(binary.Size(TLVHead{}))
+	return &Management{
+		ManagementMsgHead: ManagementMsgHead{
+			Header: Header{
+				SdoIDAndMsgType:    NewSdoIDAndMsgType(MessageManagement, 0),
+				Version:            Version,
+				MessageLength:      headerSize + size,
+				SourcePortIdentity: identity,
+				LogMessageInterval: MgmtLogMessageInterval,
+			},
+			TargetPortIdentity:   DefaultTargetPortIdentity,
+			StartingBoundaryHops: 0,
+			BoundaryHops:         0,
+			ActionField:          GET,
+		},
+		TLV: &TimePropertiesDataSetTLV{
+			ManagementTLVHead: ManagementTLVHead{
+				TLVHead: TLVHead{
+					TLVType:     TLVManagement,
+					LengthField: size - tlvHeadSize,
+				},
+				ManagementID: IDTimePropertiesDataSet,
+			},
+		},
+	}
+}
+
+// PortDataSetRequest prepares request packet for PORT_DATA_SET request
+func PortDataSetRequest() *Management {
+	headerSize := uint16(binary.Size(ManagementMsgHead{}))
+	size := uint16(binary.Size(PortDataSetTLV{}))
+	tlvHeadSize := uint16(binary.Size(TLVHead{}))
+	return &Management{
+		ManagementMsgHead: ManagementMsgHead{
+			Header: Header{
+				SdoIDAndMsgType:    NewSdoIDAndMsgType(MessageManagement, 0),
+				Version:            Version,
+				MessageLength:      headerSize + size,
+				SourcePortIdentity: identity,
+				LogMessageInterval: MgmtLogMessageInterval,
+			},
+			TargetPortIdentity:   DefaultTargetPortIdentity,
+			StartingBoundaryHops: 0,
+			BoundaryHops:         0,
+			ActionField:          GET,
+		},
+		TLV: &PortDataSetTLV{
+			ManagementTLVHead: ManagementTLVHead{
+				TLVHead: TLVHead{
+					TLVType:     TLVManagement,
+					LengthField: size - tlvHeadSize,
+				},
+				ManagementID: IDPortDataSet,
+			},
+		},
+	}
+}
+
+// ClockDescriptionRequest prepares request packet for CLOCK_DESCRIPTION request
+func ClockDescriptionRequest() *Management {
+	headerSize := uint16(binary.Size(ManagementMsgHead{}))
+	tlv := &ClockDescriptionTLV{
+		ManagementTLVHead: ManagementTLVHead{
+			TLVHead: TLVHead{
+				TLVType: TLVManagement,
+			},
+			ManagementID: IDClockDescription,
+		},
+	}
+	// all fields empty, it can't fail
+	b, _ := tlv.MarshalBinary()
+	tlv.LengthField = uint16(len(b) - TlvHeadSize)
+	return &Management{
+		ManagementMsgHead: ManagementMsgHead{
+			Header: Header{
+				SdoIDAndMsgType:    NewSdoIDAndMsgType(MessageManagement, 0),
+				Version:            Version,
+				MessageLength:      headerSize + uint16(len(b)),
+				SourcePortIdentity: identity,
+				LogMessageInterval: MgmtLogMessageInterval,
+			},
+			TargetPortIdentity:   DefaultTargetPortIdentity,
+			StartingBoundaryHops: 0,
+			BoundaryHops:         0,
+			ActionField:          GET,
+		},
+		TLV: tlv,
+	}
+}
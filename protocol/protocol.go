@@ -188,6 +188,28 @@ func (p *Announce) MarshalBinary() ([]byte, error) {
 	return buf[:n], err
 }
 
+// UnmarshalBinary parses []byte and populates struct fields
+func (p *Announce) UnmarshalBinary(b []byte) error {
+	if len(b) < HeaderSize+30 {
+		return fmt.Errorf("not enough data to decode Announce")
+	}
+	unmarshalHeader(&p.Header, b)
+	n := HeaderSize
+	copy(p.OriginTimestamp.Seconds[:], b[n:]) //uint48
+	p.OriginTimestamp.Nanoseconds = binary.BigEndian.Uint32(b[n+6:])
+	p.CurrentUTCOffset = int16(binary.BigEndian.Uint16(b[n+10:]))
+	p.Reserved = b[n+12]
+	p.GrandmasterPriority1 = b[n+13]
+	p.GrandmasterClockQuality.ClockClass = b[n+14]
+	p.GrandmasterClockQuality.ClockAccuracy = b[n+15]
+	p.GrandmasterClockQuality.OffsetScaledLogVariance = binary.BigEndian.Uint16(b[n+16:])
+	p.GrandmasterPriority2 = b[n+18]
+	p.GrandmasterIdentity = ClockIdentity(binary.BigEndian.Uint64(b[n+19:]))
+	p.StepsRemoved = binary.BigEndian.Uint16(b[n+27:])
+	p.TimeSource = TimeSource(b[n+29])
+	return nil
+}
+
 // SyncDelayReqBody Table 44 Sync and Delay_Req message fields
 type SyncDelayReqBody struct {
 	OriginTimestamp Timestamp
@@ -319,6 +341,36 @@ type PDelayReq struct {
 	PDelayReqBody
 }
 
+func (p *PDelayReq) MarshalBinaryTo(b []byte) (int, error) {
+	if len(b) < HeaderSize+20 {
+		return 0, fmt.Errorf("not enough buffer to write PDelayReq")
+	}
+	n := headerMarshalBinaryTo(&p.Header, b)
+	copy(b[n:], p.OriginTimestamp.Seconds[:]) //uint48
+	binary.BigEndian.PutUint32(b[n+6:], p.OriginTimestamp.Nanoseconds)
+	copy(b[n+10:], p.Reserved[:])
+	return n + 20, nil
+}
+
+// MarshalBinary converts packet to []bytes
+func (p *PDelayReq) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 54)
+	n, err := p.MarshalBinaryTo(buf)
+	return buf[:n], err
+}
+
+// UnmarshalBinary parses []byte and populates struct fields
+func (p *PDelayReq) UnmarshalBinary(b []byte) error {
+	if len(b) < HeaderSize+20 {
+		return fmt.Errorf("not enough data to decode PDelayReq")
+	}
+	unmarshalHeader(&p.Header, b)
+	copy(p.OriginTimestamp.Seconds[:], b[HeaderSize:]) //uint48
+	p.OriginTimestamp.Nanoseconds = binary.BigEndian.Uint32(b[HeaderSize+6:])
+	copy(p.Reserved[:], b[HeaderSize+10:])
+	return nil
+}
+
 // PDelayRespBody Table 48 Pdelay_Resp message fields
 type PDelayRespBody struct {
 	RequestReceiptTimestamp Timestamp
@@ -331,6 +383,38 @@ type PDelayResp struct {
 	PDelayRespBody
 }
 
+func (p *PDelayResp) MarshalBinaryTo(b []byte) (int, error) {
+	if len(b) < HeaderSize+20 {
+		return 0, fmt.Errorf("not enough buffer to write PDelayResp")
+	}
+	n := headerMarshalBinaryTo(&p.Header, b)
+	copy(b[n:], p.RequestReceiptTimestamp.Seconds[:]) //uint48
+	binary.BigEndian.PutUint32(b[n+6:], p.RequestReceiptTimestamp.Nanoseconds)
+	binary.BigEndian.PutUint64(b[n+10:], uint64(p.RequestingPortIdentity.ClockIdentity))
+	binary.BigEndian.PutUint16(b[n+18:], p.RequestingPortIdentity.PortNumber)
+	return n + 20, nil
+}
+
+// MarshalBinary converts packet to []bytes
+func (p *PDelayResp) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 54)
+	n, err := p.MarshalBinaryTo(buf)
+	return buf[:n], err
+}
+
+// UnmarshalBinary parses []byte and populates struct fields
+func (p *PDelayResp) UnmarshalBinary(b []byte) error {
+	if len(b) < HeaderSize+20 {
+		return fmt.Errorf("not enough data to decode PDelayResp")
+	}
+	unmarshalHeader(&p.Header, b)
+	copy(p.RequestReceiptTimestamp.Seconds[:], b[HeaderSize:]) //uint48
+	p.RequestReceiptTimestamp.Nanoseconds = binary.BigEndian.Uint32(b[HeaderSize+6:])
+	p.RequestingPortIdentity.ClockIdentity = ClockIdentity(binary.BigEndian.Uint64(b[HeaderSize+10:]))
+	p.RequestingPortIdentity.PortNumber = binary.BigEndian.Uint16(b[HeaderSize+18:])
+	return nil
+}
+
 // PDelayRespFollowUpBody Table 49 Pdelay_Resp_Follow_Up message fields
 type PDelayRespFollowUpBody struct {
 	ResponseOriginTimestamp Timestamp
@@ -343,6 +427,38 @@ type PDelayRespFollowUp struct {
 	PDelayRespFollowUpBody
 }
 
+func (p *PDelayRespFollowUp) MarshalBinaryTo(b []byte) (int, error) {
+	if len(b) < HeaderSize+20 {
+		return 0, fmt.Errorf("not enough buffer to write PDelayRespFollowUp")
+	}
+	n := headerMarshalBinaryTo(&p.Header, b)
+	copy(b[n:], p.ResponseOriginTimestamp.Seconds[:]) //uint48
+	binary.BigEndian.PutUint32(b[n+6:], p.ResponseOriginTimestamp.Nanoseconds)
+	binary.BigEndian.PutUint64(b[n+10:], uint64(p.RequestingPortIdentity.ClockIdentity))
+	binary.BigEndian.PutUint16(b[n+18:], p.RequestingPortIdentity.PortNumber)
+	return n + 20, nil
+}
+
+// MarshalBinary converts packet to []bytes
+func (p *PDelayRespFollowUp) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 54)
+	n, err := p.MarshalBinaryTo(buf)
+	return buf[:n], err
+}
+
+// UnmarshalBinary parses []byte and populates struct fields
+func (p *PDelayRespFollowUp) UnmarshalBinary(b []byte) error {
+	if len(b) < HeaderSize+20 {
+		return fmt.Errorf("not enough data to decode PDelayRespFollowUp")
+	}
+	unmarshalHeader(&p.Header, b)
+	copy(p.ResponseOriginTimestamp.Seconds[:], b[HeaderSize:]) //uint48
+	p.ResponseOriginTimestamp.Nanoseconds = binary.BigEndian.Uint32(b[HeaderSize+6:])
+	p.RequestingPortIdentity.ClockIdentity = ClockIdentity(binary.BigEndian.Uint64(b[HeaderSize+10:]))
+	p.RequestingPortIdentity.PortNumber = binary.BigEndian.Uint16(b[HeaderSize+18:])
+	return nil
+}
+
 // Packet is an iterface to abstract all different packets
 type Packet interface {
 	MessageType() MessageType
@@ -17,6 +17,8 @@ limitations under the License.
 package protocol
 
 import (
+	"bytes"
+	"encoding"
 	"encoding/binary"
 	"testing"
 
@@ -282,6 +284,7 @@ func BenchmarkReadSyncDelay(b *testing.B) {
 		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
 	}
 	p := &SyncDelayReq{}
+	b.ReportAllocs()
 	for n := 0; n < b.N; n++ {
 		_ = p.UnmarshalBinary(raw)
 	}
@@ -347,3 +350,87 @@ func BenchmarkWriteFollowup(b *testing.B) {
 		_, _ = BytesTo(p, buf)
 	}
 }
+
+func Test_unmarshalMatchesBinaryRead(t *testing.T) {
+	// every byte set, so a field read from the wrong offset shows
+	raw := make([]byte, 64)
+	for i := range raw {
+		raw[i] = byte(i + 1)
+	}
+	for _, tc := range []struct {
+		fast Packet
+		slow interface{}
+	}{
+		{&Announce{}, &Announce{}},
+		{&SyncDelayReq{}, &SyncDelayReq{}},
+		{&FollowUp{}, &FollowUp{}},
+		{&DelayResp{}, &DelayResp{}},
+		{&PDelayReq{}, &PDelayReq{}},
+		{&PDelayResp{}, &PDelayResp{}},
+		{&PDelayRespFollowUp{}, &PDelayRespFollowUp{}},
+	} {
+		require.NoError(t, FromBytes(raw, tc.fast))
+		require.NoError(t, binary.Read(bytes.NewReader(raw), binary.BigEndian, tc.slow))
+		require.Equal(t, tc.slow, tc.fast)
+
+		size := binary.Size(tc.slow)
+		require.Error(t, FromBytes(raw[:size-1], tc.fast))
+	}
+}
+
+func Test_marshalPDelay(t *testing.T) {
+	head := Header{
+		Version:            Version,
+		MessageLength:      54,
+		FlagField:          FlagUnicast | FlagTwoStep,
+		CorrectionField:    NewCorrection(2.5),
+		SourcePortIdentity: PortIdentity{PortNumber: 1, ClockIdentity: 36138748164966842},
+		SequenceID:         7,
+		LogMessageInterval: 0x7f,
+	}
+	ts := Timestamp{Seconds: [6]byte{0x0, 0x00, 0x45, 0xb1, 0x11, 0x5e}, Nanoseconds: 73257582}
+	requester := PortIdentity{PortNumber: 1, ClockIdentity: 13283824497738493774}
+	for _, tc := range []struct {
+		in  Packet
+		out Packet
+	}{
+		{&PDelayReq{Header: head, PDelayReqBody: PDelayReqBody{OriginTimestamp: ts}}, &PDelayReq{}},
+		{&PDelayResp{Header: head, PDelayRespBody: PDelayRespBody{RequestReceiptTimestamp: ts, RequestingPortIdentity: requester}}, &PDelayResp{}},
+		{&PDelayRespFollowUp{Header: head, PDelayRespFollowUpBody: PDelayRespFollowUpBody{ResponseOriginTimestamp: ts, RequestingPortIdentity: requester}}, &PDelayRespFollowUp{}},
+	} {
+		b, err := Bytes(tc.in)
+		require.NoError(t, err)
+		require.Len(t, b, 56)
+		// same bytes as the reflection based encoding
+		var slow bytes.Buffer
+		require.NoError(t, binary.Write(&slow, binary.BigEndian, tc.in))
+		require.Equal(t, slow.Bytes(), b[:54])
+
+		require.NoError(t, FromBytes(b, tc.out))
+		require.Equal(t, tc.in, tc.out)
+		_, err = tc.in.(BinaryMarshalerTo).MarshalBinaryTo(make([]byte, 53))
+		require.Error(t, err)
+	}
+}
+
+func Test_unmarshalNoAllocs(t *testing.T) {
+	raw := make([]byte, 64)
+	for _, p := range []encoding.BinaryUnmarshaler{
+		&Announce{}, &SyncDelayReq{}, &FollowUp{}, &DelayResp{},
+		&PDelayReq{}, &PDelayResp{}, &PDelayRespFollowUp{},
+	} {
+		allocs := testing.AllocsPerRun(100, func() {
+			_ = p.UnmarshalBinary(raw)
+		})
+		require.Zero(t, allocs, "%T", p)
+	}
+}
+
+func BenchmarkReadAnnounce(b *testing.B) {
+	raw := make([]byte, 66)
+	p := &Announce{}
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		_ = p.UnmarshalBinary(raw)
+	}
+}
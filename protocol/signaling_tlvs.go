@@ -0,0 +1,216 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// SignalingTLVDecoderFunc is the function we use to decode a Signaling TLV from bytes,
+// data is the whole TLV, TLV head included, and nothing after it
+type SignalingTLVDecoderFunc func(data []byte) (TLV, error)
+
+// default decoders for the Signaling TLVs other than the unicast negotiation ones,
+// those are decoded by Signaling.UnmarshalBinary itself so it doesn't allocate
+var signalingTLVDecoder = map[TLVType]SignalingTLVDecoderFunc{
+	TLVPathTrace: func(data []byte) (TLV, error) {
+		tlv := &PathTraceTLV{}
+		return tlv, tlv.UnmarshalBinary(data)
+	},
+	TLVAlternateTimeOffsetIndicator: func(data []byte) (TLV, error) {
+		tlv := &AlternateTimeOffsetIndicatorTLV{}
+		return tlv, tlv.UnmarshalBinary(data)
+	},
+	TLVOrganizationExtension: func(data []byte) (TLV, error) {
+		tlv := &OrganizationExtensionTLV{}
+		return tlv, tlv.UnmarshalBinary(data)
+	},
+	TLVAuthentication: func(data []byte) (TLV, error) {
+		tlv := &AuthenticationTLV{}
+		return tlv, tlv.UnmarshalBinary(data)
+	},
+}
+
+// RegisterSignalingTLVDecoder registers function we'll use to decode particular Signaling TLV.
+// The unicast negotiation TLVs are always decoded by Signaling itself, TLVs without a decoder
+// end up in a RawTLV.
+func RegisterSignalingTLVDecoder(tlvType TLVType, decoder SignalingTLVDecoderFunc) {
+	signalingTLVDecoder[tlvType] = decoder
+}
+
+// decodeSignalingTLV decodes a TLV Signaling has no built in support for
+func decodeSignalingTLV(tlvType TLVType, data []byte) (TLV, error) {
+	if decoder, found := signalingTLVDecoder[tlvType]; found {
+		return decoder(data)
+	}
+	tlv := &RawTLV{}
+	return tlv, tlv.UnmarshalBinary(data)
+}
+
+// RawTLV is a TLV we have no decoder for, kept as is so it can be passed on
+type RawTLV struct {
+	TLVHead
+	Value []byte
+}
+
+func (t *RawTLV) MarshalBinaryTo(b []byte) (int, error) {
+	size := TlvHeadSize + len(t.Value)
+	if len(b) < size {
+		return 0, fmt.Errorf("not enough buffer to write %s TLV", t.TLVType)
+	}
+	tlvHeadMarshalBinaryTo(&t.TLVHead, b)
+	copy(b[TlvHeadSize:], t.Value)
+	return size, nil
+}
+
+// UnmarshalBinary parses []byte and populates struct fields
+func (t *RawTLV) UnmarshalBinary(b []byte) error {
+	if err := unmarshalTLVHeader(&t.TLVHead, b); err != nil {
+		return err
+	}
+	if len(b) < TlvHeadSize+int(t.LengthField) {
+		return fmt.Errorf("not enough data to decode %s TLV", t.TLVType)
+	}
+	t.Value = append([]byte(nil), b[TlvHeadSize:TlvHeadSize+int(t.LengthField)]...)
+	return nil
+}
+
+// PathTraceTLV Table 115 PATH_TRACE TLV format
+type PathTraceTLV struct {
+	TLVHead
+	PathSequence []ClockIdentity
+}
+
+func (t *PathTraceTLV) MarshalBinaryTo(b []byte) (int, error) {
+	size := TlvHeadSize + 8*len(t.PathSequence)
+	if len(b) < size {
+		return 0, fmt.Errorf("not enough buffer to write PathTraceTLV")
+	}
+	tlvHeadMarshalBinaryTo(&t.TLVHead, b)
+	for i, id := range t.PathSequence {
+		binary.BigEndian.PutUint64(b[TlvHeadSize+8*i:], uint64(id))
+	}
+	return size, nil
+}
+
+// UnmarshalBinary parses []byte and populates struct fields
+func (t *PathTraceTLV) UnmarshalBinary(b []byte) error {
+	if err := unmarshalTLVHeader(&t.TLVHead, b); err != nil {
+		return err
+	}
+	if t.LengthField%8 != 0 || len(b) < TlvHeadSize+int(t.LengthField) {
+		return fmt.Errorf("bad PathTraceTLV length %d", t.LengthField)
+	}
+	t.PathSequence = make([]ClockIdentity, t.LengthField/8)
+	for i := range t.PathSequence {
+		t.PathSequence[i] = ClockIdentity(binary.BigEndian.Uint64(b[TlvHeadSize+8*i:]))
+	}
+	return nil
+}
+
+// AlternateTimeOffsetIndicatorTLV Table 116 ALTERNATE_TIME_OFFSET_INDICATOR TLV format
+type AlternateTimeOffsetIndicatorTLV struct {
+	TLVHead
+	KeyField       uint8
+	CurrentOffset  int32
+	JumpSeconds    int32
+	TimeOfNextJump [6]uint8 //uint48
+	DisplayName    PTPText
+}
+
+// size of the fixed fields of AlternateTimeOffsetIndicatorTLV before DisplayName
+const alternateTimeOffsetIndicatorSize = 15
+
+func (t *AlternateTimeOffsetIndicatorTLV) MarshalBinaryTo(b []byte) (int, error) {
+	if len(t.DisplayName) > 255 {
+		return 0, fmt.Errorf("AlternateTimeOffsetIndicatorTLV display name is too long")
+	}
+	unpadded := TlvHeadSize + alternateTimeOffsetIndicatorSize + 1 + len(t.DisplayName)
+	// the length of all TLVs shall be an even number of octets
+	size := unpadded + unpadded%2
+	if len(b) < size {
+		return 0, fmt.Errorf("not enough buffer to write AlternateTimeOffsetIndicatorTLV")
+	}
+	tlvHeadMarshalBinaryTo(&t.TLVHead, b)
+	n := TlvHeadSize
+	b[n] = t.KeyField
+	binary.BigEndian.PutUint32(b[n+1:], uint32(t.CurrentOffset))
+	binary.BigEndian.PutUint32(b[n+5:], uint32(t.JumpSeconds))
+	copy(b[n+9:], t.TimeOfNextJump[:])
+	b[n+15] = uint8(len(t.DisplayName))
+	copy(b[n+16:], t.DisplayName)
+	if size > unpadded {
+		b[unpadded] = 0
+	}
+	return size, nil
+}
+
+// UnmarshalBinary parses []byte and populates struct fields
+func (t *AlternateTimeOffsetIndicatorTLV) UnmarshalBinary(b []byte) error {
+	if err := unmarshalTLVHeader(&t.TLVHead, b); err != nil {
+		return err
+	}
+	if t.LengthField < alternateTimeOffsetIndicatorSize+1 || len(b) < TlvHeadSize+int(t.LengthField) {
+		return fmt.Errorf("bad AlternateTimeOffsetIndicatorTLV length %d", t.LengthField)
+	}
+	n := TlvHeadSize
+	t.KeyField = b[n]
+	t.CurrentOffset = int32(binary.BigEndian.Uint32(b[n+1:]))
+	t.JumpSeconds = int32(binary.BigEndian.Uint32(b[n+5:]))
+	copy(t.TimeOfNextJump[:], b[n+9:])
+	nameLen := int(b[n+15])
+	if alternateTimeOffsetIndicatorSize+1+nameLen > int(t.LengthField) {
+		return fmt.Errorf("AlternateTimeOffsetIndicatorTLV display name of %d bytes doesn't fit", nameLen)
+	}
+	t.DisplayName = PTPText(b[n+16 : n+16+nameLen])
+	return nil
+}
+
+// OrganizationExtensionTLV Table 53 ORGANIZATION_EXTENSION TLV format
+type OrganizationExtensionTLV struct {
+	TLVHead
+	OrganizationID      [3]uint8
+	OrganizationSubType [3]uint8
+	DataField           []byte
+}
+
+func (t *OrganizationExtensionTLV) MarshalBinaryTo(b []byte) (int, error) {
+	size := TlvHeadSize + 6 + len(t.DataField)
+	if len(b) < size {
+		return 0, fmt.Errorf("not enough buffer to write OrganizationExtensionTLV")
+	}
+	tlvHeadMarshalBinaryTo(&t.TLVHead, b)
+	copy(b[TlvHeadSize:], t.OrganizationID[:])
+	copy(b[TlvHeadSize+3:], t.OrganizationSubType[:])
+	copy(b[TlvHeadSize+6:], t.DataField)
+	return size, nil
+}
+
+// UnmarshalBinary parses []byte and populates struct fields
+func (t *OrganizationExtensionTLV) UnmarshalBinary(b []byte) error {
+	if err := unmarshalTLVHeader(&t.TLVHead, b); err != nil {
+		return err
+	}
+	if t.LengthField < 6 || len(b) < TlvHeadSize+int(t.LengthField) {
+		return fmt.Errorf("bad OrganizationExtensionTLV length %d", t.LengthField)
+	}
+	copy(t.OrganizationID[:], b[TlvHeadSize:])
+	copy(t.OrganizationSubType[:], b[TlvHeadSize+3:])
+	t.DataField = append([]byte(nil), b[TlvHeadSize+6:TlvHeadSize+int(t.LengthField)]...)
+	return nil
+}
@@ -0,0 +1,164 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseGrantWithSignalingTLVs(t *testing.T) {
+	raw := []uint8{0x0c, 0x02, 0x00, 0x5e, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0xe4, 0x1d, 0x2d, 0xff, 0xfe, 0xbb, 0x64, 0x60, 0x00,
+		0x01, 0x1d, 0xc4, 0x05, 0x7f, 0x48, 0x57, 0xdd, 0xff,
+		0xfe, 0x08, 0x64, 0x88, 0x00, 0x01,
+		// GRANT_UNICAST_TRANSMISSION
+		0x00, 0x05, 0x00, 0x08, 0xb0, 0x01, 0x00, 0x00, 0x00, 0x3c, 0x00, 0x01,
+		// PATH_TRACE with two clock identities
+		0x00, 0x08, 0x00, 0x10,
+		0xe4, 0x1d, 0x2d, 0xff, 0xfe, 0xbb, 0x64, 0x60,
+		0x48, 0x57, 0xdd, 0xff, 0xfe, 0x08, 0x64, 0x88,
+		// ORGANIZATION_EXTENSION
+		0x00, 0x03, 0x00, 0x08, 0x00, 0x1b, 0x19, 0x00, 0x00, 0x01, 0xca, 0xfe,
+		// unknown TLV
+		0x7f, 0xf0, 0x00, 0x02, 0xbe, 0xef,
+		0x00, 0x00,
+	}
+	packet := new(Signaling)
+	err := FromBytes(raw, packet)
+	require.Nil(t, err)
+	wantTLVs := []TLV{
+		&GrantUnicastTransmissionTLV{
+			TLVHead: TLVHead{
+				TLVType:     TLVGrantUnicastTransmission,
+				LengthField: 8,
+			},
+			MsgTypeAndReserved:    NewUnicastMsgTypeAndFlags(MessageAnnounce, 0),
+			LogInterMessagePeriod: 1,
+			DurationField:         60,
+			Renewal:               1,
+		},
+		&PathTraceTLV{
+			TLVHead: TLVHead{
+				TLVType:     TLVPathTrace,
+				LengthField: 16,
+			},
+			PathSequence: []ClockIdentity{16437344792485782624, 5212879185253000328},
+		},
+		&OrganizationExtensionTLV{
+			TLVHead: TLVHead{
+				TLVType:     TLVOrganizationExtension,
+				LengthField: 8,
+			},
+			OrganizationID:      [3]uint8{0x00, 0x1b, 0x19},
+			OrganizationSubType: [3]uint8{0x00, 0x00, 0x01},
+			DataField:           []byte{0xca, 0xfe},
+		},
+		&RawTLV{
+			TLVHead: TLVHead{
+				TLVType:     0x7ff0,
+				LengthField: 2,
+			},
+			Value: []byte{0xbe, 0xef},
+		},
+	}
+	require.Equal(t, wantTLVs, packet.TLVs)
+	b, err := Bytes(packet)
+	require.Nil(t, err)
+	assert.Equal(t, raw, b)
+
+	// decoding into the same packet again keeps all the TLVs
+	err = FromBytes(raw, packet)
+	require.Nil(t, err)
+	require.Equal(t, wantTLVs, packet.TLVs)
+}
+
+func Test_parseSignalingTLVTooShort(t *testing.T) {
+	raw := []uint8{0x0c, 0x02, 0x00, 0x32, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0xe4, 0x1d, 0x2d, 0xff, 0xfe, 0xbb, 0x64, 0x60, 0x00,
+		0x01, 0x1d, 0xc4, 0x05, 0x7f, 0x48, 0x57, 0xdd, 0xff,
+		0xfe, 0x08, 0x64, 0x88, 0x00, 0x01,
+		// PATH_TRACE claiming more than there is
+		0x00, 0x08, 0x00, 0x10,
+		0xe4, 0x1d, 0x2d, 0xff, 0xfe, 0xbb, 0x64, 0x60,
+	}
+	packet := new(Signaling)
+	err := FromBytes(raw, packet)
+	require.Error(t, err)
+
+	// PATH_TRACE of a size that isn't a number of clock identities
+	raw[2], raw[47] = 0x31, 0x07
+	err = FromBytes(raw[:len(raw)-1], packet)
+	require.Error(t, err)
+}
+
+func Test_AlternateTimeOffsetIndicatorTLV(t *testing.T) {
+	for _, name := range []PTPText{"", "UTC", "CEST"} {
+		want := &AlternateTimeOffsetIndicatorTLV{
+			TLVHead: TLVHead{
+				TLVType:     TLVAlternateTimeOffsetIndicator,
+				LengthField: uint16(16 + len(name) + (16+len(name))%2),
+			},
+			KeyField:       1,
+			CurrentOffset:  7200,
+			JumpSeconds:    -3600,
+			TimeOfNextJump: [6]uint8{0x00, 0x00, 0x65, 0x3b, 0x4c, 0x10},
+			DisplayName:    name,
+		}
+		b := make([]byte, 64)
+		for i := range b {
+			b[i] = 0xff
+		}
+		n, err := want.MarshalBinaryTo(b)
+		require.Nil(t, err)
+		require.Equal(t, TlvHeadSize+int(want.LengthField), n)
+		require.Equal(t, 0, n%2)
+		got, err := decodeSignalingTLV(TLVAlternateTimeOffsetIndicator, b[:n])
+		require.Nil(t, err)
+		require.Equal(t, want, got)
+	}
+}
+
+func Test_RegisterSignalingTLVDecoder(t *testing.T) {
+	custom := TLVType(0x7ff1)
+	defer delete(signalingTLVDecoder, custom)
+	RegisterSignalingTLVDecoder(custom, func(data []byte) (TLV, error) {
+		tlv := &OrganizationExtensionTLV{}
+		return tlv, tlv.UnmarshalBinary(data)
+	})
+	data := []byte{0x7f, 0xf1, 0x00, 0x06, 0x00, 0x1b, 0x19, 0x00, 0x00, 0x02}
+	got, err := decodeSignalingTLV(custom, data)
+	require.Nil(t, err)
+	require.Equal(t, &OrganizationExtensionTLV{
+		TLVHead:             TLVHead{TLVType: custom, LengthField: 6},
+		OrganizationID:      [3]uint8{0x00, 0x1b, 0x19},
+		OrganizationSubType: [3]uint8{0x00, 0x00, 0x02},
+	}, got)
+
+	// without decoder it's kept raw
+	delete(signalingTLVDecoder, custom)
+	got, err = decodeSignalingTLV(custom, data)
+	require.Nil(t, err)
+	require.Equal(t, &RawTLV{
+		TLVHead: TLVHead{TLVType: custom, LengthField: 6},
+		Value:   data[TlvHeadSize:],
+	}, got)
+}
@@ -1,4 +1,7 @@
-﻿/*
+//go:build !linux
+// +build !linux
+
+/*
 Copyright (c) Facebook, Inc. and its affiliates.
 
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -72,6 +75,11 @@ func EnableSWTimestampsSocket(connFd int) error {
 	return fmt.Errorf("software timestamping not supported on this platform")
 }
 
+// EnableTXTimestampsSocketPacket enables TX timestamps on the socket, returning the sent packet on the error queue
+func EnableTXTimestampsSocketPacket(connFd int, iface string) (string, error) {
+	return "", fmt.Errorf("timestamping not supported on this platform")
+}
+
 // SocketControlMessageTimestamp is a very optimised version of ParseSocketControlMessage
 func SocketControlMessageTimestamp(b []byte) (time.Time, error) {
 	return time.Time{}, fmt.Errorf("timestamping not supported on this platform")
@@ -82,6 +90,11 @@ func ReadTXtimestampBuf(connFd int, oob, toob []byte) (time.Time, int, error) {
 	return time.Time{}, 0, fmt.Errorf("timestamping not supported on this platform")
 }
 
+// ReadTXtimestampPacketBuf reads one sent packet back from the error queue into buf together with its TX timestamp
+func ReadTXtimestampPacketBuf(connFd int, buf, oob []byte) (int, time.Time, error) {
+	return 0, time.Time{}, fmt.Errorf("timestamping not supported on this platform")
+}
+
 // ReadTXtimestamp returns HW TX timestamp
 func ReadTXtimestamp(connFd int) (time.Time, int, error) {
 	return time.Time{}, 0, fmt.Errorf("timestamping not supported on this platform")
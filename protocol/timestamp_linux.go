@@ -151,6 +151,25 @@ func EnableSWTimestampsSocket(connFd int) error {
 	return nil
 }
 
+// EnableTXTimestampsSocketPacket enables TX timestamps on the socket, HW if the NIC supports them and SW otherwise.
+// Unlike EnableHWTimestampsSocket it doesn't set OPT_TSONLY, so the error queue returns a copy of the sent packet
+// along with its timestamp. Returns HWTIMESTAMP or SWTIMESTAMP
+func EnableTXTimestampsSocketPacket(connFd int, iface string) (string, error) {
+	flags := unix.SOF_TIMESTAMPING_TX_HARDWARE | unix.SOF_TIMESTAMPING_RAW_HARDWARE
+	tsType := HWTIMESTAMP
+	if err := IoctlTimestamp(connFd, iface); err != nil {
+		flags = unix.SOF_TIMESTAMPING_TX_SOFTWARE | unix.SOF_TIMESTAMPING_SOFTWARE
+		tsType = SWTIMESTAMP
+	}
+	if err := unix.SetsockoptInt(connFd, unix.SOL_SOCKET, timestamping, flags); err != nil {
+		return "", err
+	}
+	if err := unix.SetsockoptInt(connFd, unix.SOL_SOCKET, unix.SO_SELECT_ERR_QUEUE, 1); err != nil {
+		return "", err
+	}
+	return tsType, nil
+}
+
 // byteToTime converts LittleEndian bytes into a timestamp
 func byteToTime(data []byte) (time.Time, error) {
 	// __kernel_timespec from linux/time_types.h
@@ -266,6 +285,18 @@ func ReadTXtimestampBuf(connFd int, oob, toob []byte) (time.Time, int, error) {
 	return timestamp, attempts, err
 }
 
+// ReadTXtimestampPacketBuf reads one sent packet back from the error queue into buf without blocking.
+// Returns number of bytes copied to buf and the TX timestamp, oob can be reused after the call.
+// Socket needs TX timestamps enabled with EnableTXTimestampsSocketPacket
+func ReadTXtimestampPacketBuf(connFd int, buf, oob []byte) (int, time.Time, error) {
+	bbuf, boob, _, _, err := unix.Recvmsg(connFd, buf, oob, unix.MSG_ERRQUEUE|unix.MSG_DONTWAIT)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	timestamp, err := SocketControlMessageTimestamp(oob[:boob])
+	return bbuf, timestamp, err
+}
+
 // ReadTXtimestamp returns HW TX timestamp
 func ReadTXtimestamp(connFd int) (time.Time, int, error) {
 	// Accessing hw timestamp
@@ -291,7 +322,7 @@ func ReadPacketWithRXTimestamp(connFd int) ([]byte, unix.Sockaddr, time.Time, er
 func ReadPacketWithRXTimestampBuf(connFd int, buf, oob []byte) (int, unix.Sockaddr, time.Time, error) {
 	bbuf, boob, _, saddr, err := unix.Recvmsg(connFd, buf, oob, 0)
 	if err != nil {
-		return 0, nil, time.Time{}, fmt.Errorf("failed to read timestamp: %v", err)
+		return 0, nil, time.Time{}, fmt.Errorf("failed to read timestamp: %w", err)
 	}
 
 	timestamp, err := SocketControlMessageTimestamp(oob[:boob])
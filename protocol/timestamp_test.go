@@ -62,6 +62,44 @@ func Test_ReadTXtimestamp(t *testing.T) {
 
 }
 
+func Test_ReadTXtimestampPacketBuf(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+
+	buf := make([]byte, PayloadSizeBytes)
+	oob := make([]byte, ControlSizeBytes)
+	_, _, err = ReadTXtimestampPacketBuf(connFd, buf, oob)
+	require.Equal(t, unix.EAGAIN, err)
+
+	// no HW timestamps on loopback
+	tsType, err := EnableTXTimestampsSocketPacket(connFd, "lo")
+	require.Nil(t, err)
+	require.Equal(t, SWTIMESTAMP, tsType)
+
+	payload := []byte{1, 2, 3, 4}
+	addr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 12345}
+	_, err = conn.WriteTo(payload, addr)
+	require.Nil(t, err)
+
+	// packet comes back with its headers
+	var n int
+	var txts time.Time
+	for i := 0; i < 100; i++ {
+		n, txts, err = ReadTXtimestampPacketBuf(connFd, buf, oob)
+		if err != unix.EAGAIN {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	require.Nil(t, err)
+	require.NotEqual(t, time.Time{}, txts)
+	require.Equal(t, payload, buf[n-len(payload):n])
+}
+
 func Test_scmDataToTime(t *testing.T) {
 	hwData := []byte{
 		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
@@ -121,6 +121,7 @@ const (
 	TLVAcknowledgeCancelUnicastTransmission TLVType = 0x0007
 	TLVPathTrace                            TLVType = 0x0008
 	TLVAlternateTimeOffsetIndicator         TLVType = 0x0009
+	TLVAuthentication                       TLVType = 0x8009
 	// Remaining 52tlvType TLVs not implemented
 )
 
@@ -135,6 +136,7 @@ var TLVTypeToString = map[TLVType]string{
 	TLVAcknowledgeCancelUnicastTransmission: "ACKNOWLEDGE_CANCEL_UNICAST_TRANSMISSION",
 	TLVPathTrace:                            "PATH_TRACE",
 	TLVAlternateTimeOffsetIndicator:         "ALTERNATE_TIME_OFFSET_INDICATOR",
+	TLVAuthentication:                       "AUTHENTICATION",
 }
 
 func (t TLVType) String() string {
@@ -326,6 +328,95 @@ func (t TimeSource) String() string {
 	return TimeSourceToString[t]
 }
 
+// PortState is the state of a PTP Port
+type PortState uint8
+
+// PortState values, Table 20 PTP state enumeration
+const (
+	PortStateInitializing PortState = 0x01
+	PortStateFaulty       PortState = 0x02
+	PortStateDisabled     PortState = 0x03
+	PortStateListening    PortState = 0x04
+	PortStatePreMaster    PortState = 0x05
+	PortStateMaster       PortState = 0x06
+	PortStatePassive      PortState = 0x07
+	PortStateUncalibrated PortState = 0x08
+	PortStateSlave        PortState = 0x09
+)
+
+// PortStateToString is a map from PortState to string
+var PortStateToString = map[PortState]string{
+	PortStateInitializing: "INITIALIZING",
+	PortStateFaulty:       "FAULTY",
+	PortStateDisabled:     "DISABLED",
+	PortStateListening:    "LISTENING",
+	PortStatePreMaster:    "PRE_MASTER",
+	PortStateMaster:       "MASTER",
+	PortStatePassive:      "PASSIVE",
+	PortStateUncalibrated: "UNCALIBRATED",
+	PortStateSlave:        "SLAVE",
+}
+
+func (s PortState) String() string {
+	return PortStateToString[s]
+}
+
+// DelayMechanism is how a PTP Port measures the path delay
+type DelayMechanism uint8
+
+// DelayMechanism values, Table 21 Values of the delayMechanism
+const (
+	DelayMechanismE2E         DelayMechanism = 0x01
+	DelayMechanismP2P         DelayMechanism = 0x02
+	DelayMechanismCommonP2P   DelayMechanism = 0x03
+	DelayMechanismSpecial     DelayMechanism = 0x04
+	DelayMechanismNoMechanism DelayMechanism = 0xFE
+)
+
+// DelayMechanismToString is a map from DelayMechanism to string
+var DelayMechanismToString = map[DelayMechanism]string{
+	DelayMechanismE2E:         "E2E",
+	DelayMechanismP2P:         "P2P",
+	DelayMechanismCommonP2P:   "COMMON_P2P",
+	DelayMechanismSpecial:     "SPECIAL",
+	DelayMechanismNoMechanism: "NO_MECHANISM",
+}
+
+func (m DelayMechanism) String() string {
+	return DelayMechanismToString[m]
+}
+
+// ClockType is a bit mask of what kinds of clock a PTP Instance is, Table 71 clockType specification
+type ClockType uint16
+
+// ClockType bits
+const (
+	ClockTypeOrdinary   ClockType = 1 << 15
+	ClockTypeBoundary   ClockType = 1 << 14
+	ClockTypeP2PTC      ClockType = 1 << 13
+	ClockTypeE2ETC      ClockType = 1 << 12
+	ClockTypeManagement ClockType = 1 << 11
+)
+
+// NetworkProtocol is the transport of a PortAddress
+type NetworkProtocol uint16
+
+// NetworkProtocol values, Table 3 networkProtocol enumeration
+const (
+	NetworkProtocolUDPIPv4    NetworkProtocol = 0x0001
+	NetworkProtocolUDPIPv6    NetworkProtocol = 0x0002
+	NetworkProtocolIEEE8023   NetworkProtocol = 0x0003
+	NetworkProtocolDeviceNet  NetworkProtocol = 0x0004
+	NetworkProtocolControlNet NetworkProtocol = 0x0005
+	NetworkProtocolPROFINET   NetworkProtocol = 0x0006
+)
+
+// PortAddress represents the protocol address of a PTP port, 5.3.6 PortAddress
+type PortAddress struct {
+	NetworkProtocol NetworkProtocol
+	AddressField    []byte
+}
+
 // LogInterval shall be the logarithm, to base 2, of the requested period in seconds.
 // In layman's terms, it's specified as a power of two in seconds.
 type LogInterval int8
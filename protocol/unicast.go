@@ -90,6 +90,8 @@ func unmarshalTLVHeader(p *TLVHead, b []byte) error {
 }
 
 // UnmarshalBinary parses []byte and populates struct fields
+// TLVs from the previous call are reused when their types match,
+// so decoding into the same Signaling again doesn't allocate
 func (p *Signaling) UnmarshalBinary(b []byte) error {
 	if len(b) < HeaderSize+10+TlvHeadSize {
 		return fmt.Errorf("not enough data to decode Signaling")
@@ -101,50 +103,73 @@ func (p *Signaling) UnmarshalBinary(b []byte) error {
 	p.TargetPortIdentity.ClockIdentity = ClockIdentity(binary.BigEndian.Uint64(b[HeaderSize:]))
 	p.TargetPortIdentity.PortNumber = binary.BigEndian.Uint16(b[HeaderSize+8:])
 
+	prev := p.TLVs[:cap(p.TLVs)]
+	p.TLVs = p.TLVs[:0]
+	// packet can have trailing bytes, let's make sure we don't try to read past given length
+	end := int(p.MessageLength)
+	if end > len(b) {
+		end = len(b)
+	}
 	pos := HeaderSize + 10
-	var tlvType TLVType
-	for {
-		head := TLVHead{}
-		// packet can have trailing bytes, let's make sure we don't try to read past given length
-		if pos+TlvHeadSize > int(p.MessageLength) {
-			break
+	for pos+TlvHeadSize <= end {
+		tlvType := TLVType(binary.BigEndian.Uint16(b[pos:]))
+		var old TLV
+		if len(p.TLVs) < len(prev) {
+			old = prev[len(p.TLVs)]
 		}
-		tlvType = TLVType(binary.BigEndian.Uint16(b[pos:]))
 
+		var tlv interface {
+			TLV
+			UnmarshalBinary([]byte) error
+		}
+		var size int
 		switch tlvType {
 		case TLVAcknowledgeCancelUnicastTransmission:
-			tlv := &AcknowledgeCancelUnicastTransmissionTLV{}
-			if err := tlv.UnmarshalBinary(b[pos:]); err != nil {
-				return err
+			t, ok := old.(*AcknowledgeCancelUnicastTransmissionTLV)
+			if !ok {
+				t = &AcknowledgeCancelUnicastTransmissionTLV{}
 			}
-			p.TLVs = append(p.TLVs, tlv)
-			pos += TlvHeadSize + int(tlv.LengthField)
-
+			tlv, size = t, 2
 		case TLVGrantUnicastTransmission:
-			tlv := &GrantUnicastTransmissionTLV{}
-			if err := tlv.UnmarshalBinary(b[pos:]); err != nil {
-				return err
+			t, ok := old.(*GrantUnicastTransmissionTLV)
+			if !ok {
+				t = &GrantUnicastTransmissionTLV{}
 			}
-			p.TLVs = append(p.TLVs, tlv)
-			pos += TlvHeadSize + int(tlv.LengthField)
-
+			tlv, size = t, 8
 		case TLVRequestUnicastTransmission:
-			tlv := &RequestUnicastTransmissionTLV{}
-			if err := tlv.UnmarshalBinary(b[pos:]); err != nil {
-				return err
+			t, ok := old.(*RequestUnicastTransmissionTLV)
+			if !ok {
+				t = &RequestUnicastTransmissionTLV{}
 			}
-			p.TLVs = append(p.TLVs, tlv)
-			pos += TlvHeadSize + int(tlv.LengthField)
+			tlv, size = t, 6
 		case TLVCancelUnicastTransmission:
-			tlv := &CancelUnicastTransmissionTLV{}
-			if err := tlv.UnmarshalBinary(b[pos:]); err != nil {
-				return err
+			t, ok := old.(*CancelUnicastTransmissionTLV)
+			if !ok {
+				t = &CancelUnicastTransmissionTLV{}
 			}
-			p.TLVs = append(p.TLVs, tlv)
-			pos += TlvHeadSize + int(tlv.LengthField)
+			tlv, size = t, 2
 		default:
-			return fmt.Errorf("reading TLV %s (%d) is not yet implemented", head.TLVType, head.TLVType)
+			// everything else goes through the registered decoders, or is kept raw
+			length := TlvHeadSize + int(binary.BigEndian.Uint16(b[pos+2:]))
+			if pos+length > end {
+				return fmt.Errorf("not enough data to decode %s", tlvType)
+			}
+			other, err := decodeSignalingTLV(tlvType, b[pos:pos+length])
+			if err != nil {
+				return fmt.Errorf("reading TLV %s (%d): %w", tlvType, tlvType, err)
+			}
+			p.TLVs = append(p.TLVs, other)
+			pos += length
+			continue
+		}
+		if pos+TlvHeadSize+size > end {
+			return fmt.Errorf("not enough data to decode %s", tlvType)
+		}
+		if err := tlv.UnmarshalBinary(b[pos:]); err != nil {
+			return err
 		}
+		p.TLVs = append(p.TLVs, tlv)
+		pos += TlvHeadSize + int(binary.BigEndian.Uint16(b[pos+2:]))
 	}
 	if len(p.TLVs) == 0 {
 		return fmt.Errorf("no TLVs read for Signaling message, at least one required")
@@ -97,6 +97,49 @@ func Test_parseRequestUnicastTransmissionMultiTLV(t *testing.T) {
 	assert.Equal(t, &want, pp)
 }
 
+func Test_parseSignalingReuse(t *testing.T) {
+	multi := []uint8{0x0c, 0x02, 0x00, 0x4a, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xb8, 0x59, 0x9f, 0xff, 0xfe, 0x55, 0xaf, 0x4e, 0x00, 0x01, 0x00, 0x00, 0x05, 0x7f, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0x00, 0x04, 0x00, 0x06, 0xb0, 0x01, 0x00, 0x00, 0x00, 0x3c,
+		0x00, 0x04, 0x00, 0x06, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3c,
+		0x00, 0x04, 0x00, 0x06, 0x90, 0x01, 0x00, 0x00, 0x00, 0x3c,
+		0x00, 0x00,
+	}
+	grant := []uint8{0x0c, 0x02, 0x00, 0x38, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0xe4, 0x1d, 0x2d, 0xff, 0xfe, 0xbb, 0x64, 0x60, 0x00,
+		0x01, 0x1d, 0xc4, 0x05, 0x7f, 0x48, 0x57, 0xdd, 0xff,
+		0xfe, 0x08, 0x64, 0x88, 0x00, 0x01, 0x00, 0x05, 0x00,
+		0x08, 0xb0, 0x01, 0x00, 0x00, 0x00, 0x3c, 0x00, 0x01,
+		0x00, 0x00,
+	}
+	p := &Signaling{}
+	require.NoError(t, p.UnmarshalBinary(multi))
+	require.Len(t, p.TLVs, 3)
+	first := p.TLVs[0]
+
+	// the previous TLVs are dropped, not appended to, and a different TLV type is decoded fresh
+	require.NoError(t, p.UnmarshalBinary(grant))
+	require.Len(t, p.TLVs, 1)
+	g, ok := p.TLVs[0].(*GrantUnicastTransmissionTLV)
+	require.True(t, ok)
+	require.Equal(t, uint32(60), g.DurationField)
+
+	require.NoError(t, p.UnmarshalBinary(multi))
+	require.Len(t, p.TLVs, 3)
+	require.NotSame(t, first, p.TLVs[0])
+	second := p.TLVs[1]
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = p.UnmarshalBinary(multi)
+	})
+	require.Zero(t, allocs)
+	require.Same(t, second, p.TLVs[1])
+
+	// length says more than there is
+	short := append([]uint8{}, multi[:len(multi)-6]...)
+	require.Error(t, p.UnmarshalBinary(short))
+}
+
 func Test_parseRequestUnicastTransmissionExtraBytes(t *testing.T) {
 	raw := []uint8{0x0c, 0x02, 0x00, 0x40, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xb8, 0x59, 0x9f, 0xff, 0xfe, 0x55, 0xaf, 0x4e, 0x00, 0x01, 0x00, 0x00, 0x05, 0x7f, 0xff, 0xff,
 		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
@@ -275,6 +318,7 @@ func BenchmarkReadSignaling(b *testing.B) {
 		0x00, 0x00,
 	}
 	p := &Signaling{}
+	b.ReportAllocs()
 	for n := 0; n < b.N; n++ {
 		_ = p.UnmarshalBinary(raw)
 	}
@@ -1,3 +1,8 @@
+//go:build ignore
+// +build ignore
+
+// webserver.go is built on its own with "go build webserver.go", see Makefile.
+
 package main
 
 import (